@@ -0,0 +1,353 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/tls"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cmodel "github.com/open-falcon/falcon-plus/common/model"
+	cutils "github.com/open-falcon/falcon-plus/common/utils"
+	"github.com/open-falcon/falcon-plus/g"
+	"github.com/spf13/cobra"
+	"github.com/toolkits/consistent/rings"
+)
+
+var Backfill = &cobra.Command{
+	Use:   "backfill [File ...]",
+	Short: "Import historical data into graph",
+	Long: `
+Import historical data from JSON or CSV files into graph.
+JSON files hold an array of objects with the same fields as /v1/push:
+  endpoint, metric, tags, timestamp, value, counterType, step
+CSV files hold the same fields in that order, one point per line;
+tags are written as "k1=v1,k2=v2", counterType and step are optional.
+Points are routed to graph nodes with the cluster of the transfer
+configuration file, in the same way transfer does, and connect with its
+graph.tls unless the --tls flags are given.
+Points older than the last update of an existing rrd file are skipped,
+--merge rebuilds gauge rrd files to take them, the MIN and MAX archives
+of the rebuilt range then only keep averages.
+`,
+	RunE: backfill,
+}
+
+var (
+	BackfillFormat      string
+	BackfillConfig      string
+	BackfillBatch       int
+	BackfillStep        int64
+	BackfillType        string
+	BackfillDryRun      bool
+	BackfillMerge       bool
+	BackfillTls         cutils.TlsConfig
+	BackfillMinStep     int64 = 30
+	backfillTimeout           = 5 * time.Minute
+	backfillDialTimeout       = 10 * time.Second
+)
+
+type backfillGraphConfig struct {
+	Graph struct {
+		Replicas int               `json:"replicas"`
+		Cluster  map[string]string `json:"cluster"`
+		Tls      *cutils.TlsConfig `json:"tls"`
+	} `json:"graph"`
+	MinStep int64 `json:"minStep"`
+}
+
+func backfill(c *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return c.Usage()
+	}
+
+	cfgFile := BackfillConfig
+	if cfgFile == "" {
+		cfgFile = g.Cfg("transfer")
+	}
+	bs, err := ioutil.ReadFile(cfgFile)
+	if err != nil {
+		return err
+	}
+	var cfg backfillGraphConfig
+	if err := json.Unmarshal(bs, &cfg); err != nil {
+		return fmt.Errorf("parse %s fail: %v", cfgFile, err)
+	}
+	if len(cfg.Graph.Cluster) == 0 {
+		return fmt.Errorf("no graph cluster in %s", cfgFile)
+	}
+	if cfg.MinStep > 0 {
+		BackfillMinStep = cfg.MinStep
+	}
+	ring := rings.NewConsistentHashNodesRing(int32(cfg.Graph.Replicas), cutils.KeysOfMap(cfg.Graph.Cluster))
+
+	tlsCfg := cfg.Graph.Tls
+	if BackfillTls.Enabled {
+		tlsCfg = &BackfillTls
+	}
+	tlsConfig, err := tlsCfg.ClientConfig()
+	if err != nil {
+		return fmt.Errorf("load tls config fail: %v", err)
+	}
+
+	total := &cmodel.GraphBackfillResp{}
+	for _, fname := range args {
+		points, err := readBackfillFile(fname)
+		if err != nil {
+			return fmt.Errorf("read %s fail: %v", fname, err)
+		}
+
+		nodes := make(map[string][]*cmodel.GraphItem)
+		for _, p := range points {
+			item, err := backfillGraphItem(p)
+			if err != nil {
+				total.Total++
+				total.Skipped++
+				fmt.Printf("skip %s: %v\n", p, err)
+				continue
+			}
+			node, err := ring.GetNode(item.PrimaryKey())
+			if err != nil {
+				return err
+			}
+			nodes[node] = append(nodes[node], item)
+		}
+
+		for node, items := range nodes {
+			sortBackfillItems(items)
+			for _, addr := range strings.Split(cfg.Graph.Cluster[node], ",") {
+				addr = strings.TrimSpace(addr)
+				if addr == "" {
+					continue
+				}
+				resp, err := sendBackfill(addr, items, tlsConfig)
+				if err != nil {
+					return fmt.Errorf("backfill %s fail: %v", addr, err)
+				}
+				total.Total += resp.Total
+				total.Written += resp.Written
+				total.Skipped += resp.Skipped
+			}
+		}
+		fmt.Printf("%s: %d points\n", fname, len(points))
+	}
+	fmt.Printf("backfill done %s\n", total)
+	return nil
+}
+
+// sortBackfillItems orders the points by series and timestamp. graph sorts
+// every call on its own, points older than what an earlier call wrote to
+// the rrd file are skipped
+func sortBackfillItems(items []*cmodel.GraphItem) {
+	keys := make(map[*cmodel.GraphItem]string, len(items))
+	for _, item := range items {
+		keys[item] = item.PrimaryKey()
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		ki, kj := keys[items[i]], keys[items[j]]
+		if ki != kj {
+			return ki < kj
+		}
+		return items[i].Timestamp < items[j].Timestamp
+	})
+}
+
+func sendBackfill(addr string, items []*cmodel.GraphItem, tlsConfig *tls.Config) (*cmodel.GraphBackfillResp, error) {
+	resp := &cmodel.GraphBackfillResp{}
+	if BackfillDryRun {
+		resp.Total = len(items)
+		fmt.Printf("dry run, %d points to %s\n", len(items), addr)
+		return resp, nil
+	}
+
+	conn, err := cutils.DialTimeout("tcp", addr, backfillDialTimeout, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	client := rpc.NewClient(conn)
+	defer client.Close()
+
+	batch := BackfillBatch
+	if batch <= 0 {
+		batch = len(items)
+	}
+	for start := 0; start < len(items); start += batch {
+		end := start + batch
+		if end > len(items) {
+			end = len(items)
+		}
+		r := &cmodel.GraphBackfillResp{}
+		req := cmodel.GraphBackfillRequest{Items: items[start:end], Merge: BackfillMerge}
+		call := client.Go("Graph.Backfill", req, r, nil)
+		select {
+		case <-call.Done:
+			if call.Error != nil {
+				return resp, call.Error
+			}
+		case <-time.After(backfillTimeout):
+			return resp, fmt.Errorf("call timeout")
+		}
+		resp.Total += r.Total
+		resp.Written += r.Written
+		resp.Skipped += r.Skipped
+	}
+	return resp, nil
+}
+
+func readBackfillFile(fname string) ([]*cmodel.JsonMetaData, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	format := BackfillFormat
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(fname)), ".")
+	}
+	switch format {
+	case "json":
+		points := []*cmodel.JsonMetaData{}
+		err = json.NewDecoder(f).Decode(&points)
+		return points, err
+	case "csv":
+		return readBackfillCsv(f)
+	}
+	return nil, fmt.Errorf("unknown format %q, use --format json|csv", format)
+}
+
+func readBackfillCsv(r io.Reader) ([]*cmodel.JsonMetaData, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	points := []*cmodel.JsonMetaData{}
+	line := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line++
+		if len(record) < 5 {
+			return nil, fmt.Errorf("line %d: expect at least 5 fields, got %d", line, len(record))
+		}
+		// header
+		if line == 1 && record[3] == "timestamp" {
+			continue
+		}
+
+		ts, err := strconv.ParseInt(record[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad timestamp %q", line, record[3])
+		}
+		value, err := strconv.ParseFloat(record[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad value %q", line, record[4])
+		}
+		p := &cmodel.JsonMetaData{
+			Endpoint:  record[0],
+			Metric:    record[1],
+			Tags:      record[2],
+			Timestamp: ts,
+			Value:     value,
+		}
+		if len(record) > 5 {
+			p.CounterType = record[5]
+		}
+		if len(record) > 6 && record[6] != "" {
+			if p.Step, err = strconv.ParseInt(record[6], 10, 64); err != nil {
+				return nil, fmt.Errorf("line %d: bad step %q", line, record[6])
+			}
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+// backfillGraphItem converts one point the same way transfer does
+func backfillGraphItem(p *cmodel.JsonMetaData) (*cmodel.GraphItem, error) {
+	if p.Endpoint == "" || p.Metric == "" || p.Timestamp <= 0 {
+		return nil, fmt.Errorf("endpoint, metric and timestamp are required")
+	}
+
+	var value float64
+	switch v := p.Value.(type) {
+	case float64:
+		value = v
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad value %q", v)
+		}
+		value = f
+	default:
+		return nil, fmt.Errorf("bad value %v", p.Value)
+	}
+
+	err, tags := cutils.SplitTagsString(p.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &cmodel.GraphItem{
+		Endpoint:  p.Endpoint,
+		Metric:    p.Metric,
+		Tags:      tags,
+		Value:     value,
+		Timestamp: p.Timestamp,
+		Step:      int(p.Step),
+	}
+	if item.Step <= 0 {
+		item.Step = int(BackfillStep)
+	}
+	if item.Step < int(BackfillMinStep) {
+		item.Step = int(BackfillMinStep)
+	}
+	item.Heartbeat = item.Step * 2
+
+	counterType := p.CounterType
+	if counterType == "" {
+		counterType = BackfillType
+	}
+	switch counterType {
+	case "GAUGE":
+		item.DsType = "GAUGE"
+		item.Min = "U"
+		item.Max = "U"
+	case "COUNTER", "DERIVE":
+		item.DsType = "DERIVE"
+		item.Min = "0"
+		item.Max = "U"
+	default:
+		return nil, fmt.Errorf("not_supported_counter_type")
+	}
+	item.Timestamp = item.Timestamp - item.Timestamp%int64(item.Step)
+	return item, nil
+}
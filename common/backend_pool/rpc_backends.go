@@ -15,6 +15,7 @@
 package backend_pool
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/rpc"
@@ -22,6 +23,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/open-falcon/falcon-plus/common/utils"
 	connp "github.com/toolkits/conn_pool"
 	rpcpool "github.com/toolkits/conn_pool/rpc_conn_pool"
 )
@@ -37,6 +39,11 @@ type SafeRpcConnPools struct {
 }
 
 func CreateSafeRpcConnPools(maxConns, maxIdle, connTimeout, callTimeout int, cluster []string) *SafeRpcConnPools {
+	return CreateSafeRpcConnPoolsWithTls(maxConns, maxIdle, connTimeout, callTimeout, cluster, nil)
+}
+
+// CreateSafeRpcConnPoolsWithTls 与CreateSafeRpcConnPools相同, tlsConfig不为nil时通过tls连接后端
+func CreateSafeRpcConnPoolsWithTls(maxConns, maxIdle, connTimeout, callTimeout int, cluster []string, tlsConfig *tls.Config) *SafeRpcConnPools {
 	cp := &SafeRpcConnPools{M: make(map[string]*connp.ConnPool), MaxConns: maxConns, MaxIdle: maxIdle,
 		ConnTimeout: connTimeout, CallTimeout: callTimeout}
 
@@ -45,13 +52,18 @@ func CreateSafeRpcConnPools(maxConns, maxIdle, connTimeout, callTimeout int, clu
 		if _, exist := cp.M[address]; exist {
 			continue
 		}
-		cp.M[address] = createOneRpcPool(address, address, ct, maxConns, maxIdle)
+		cp.M[address] = createOneRpcPool(address, address, ct, maxConns, maxIdle, tlsConfig)
 	}
 
 	return cp
 }
 
 func CreateSafeJsonrpcConnPools(maxConns, maxIdle, connTimeout, callTimeout int, cluster []string) *SafeRpcConnPools {
+	return CreateSafeJsonrpcConnPoolsWithTls(maxConns, maxIdle, connTimeout, callTimeout, cluster, nil)
+}
+
+// CreateSafeJsonrpcConnPoolsWithTls 与CreateSafeJsonrpcConnPools相同, tlsConfig不为nil时通过tls连接后端
+func CreateSafeJsonrpcConnPoolsWithTls(maxConns, maxIdle, connTimeout, callTimeout int, cluster []string, tlsConfig *tls.Config) *SafeRpcConnPools {
 	cp := &SafeRpcConnPools{M: make(map[string]*connp.ConnPool), MaxConns: maxConns, MaxIdle: maxIdle,
 		ConnTimeout: connTimeout, CallTimeout: callTimeout}
 
@@ -60,7 +72,7 @@ func CreateSafeJsonrpcConnPools(maxConns, maxIdle, connTimeout, callTimeout int,
 		if _, exist := cp.M[address]; exist {
 			continue
 		}
-		cp.M[address] = createOneJsonrpcPool(address, address, ct, maxConns, maxIdle)
+		cp.M[address] = createOneJsonrpcPool(address, address, ct, maxConns, maxIdle, tlsConfig)
 	}
 
 	return cp
@@ -130,7 +142,7 @@ func (this *SafeRpcConnPools) Proc() []string {
 	return procs
 }
 
-func createOneRpcPool(name string, address string, connTimeout time.Duration, maxConns int, maxIdle int) *connp.ConnPool {
+func createOneRpcPool(name string, address string, connTimeout time.Duration, maxConns int, maxIdle int, tlsConfig *tls.Config) *connp.ConnPool {
 	p := connp.NewConnPool(name, address, int32(maxConns), int32(maxIdle))
 	p.New = func(connName string) (connp.NConn, error) {
 		_, err := net.ResolveTCPAddr("tcp", p.Address)
@@ -138,7 +150,7 @@ func createOneRpcPool(name string, address string, connTimeout time.Duration, ma
 			return nil, err
 		}
 
-		conn, err := net.DialTimeout("tcp", p.Address, connTimeout)
+		conn, err := utils.DialTimeout("tcp", p.Address, connTimeout, tlsConfig)
 		if err != nil {
 			return nil, err
 		}
@@ -149,7 +161,7 @@ func createOneRpcPool(name string, address string, connTimeout time.Duration, ma
 	return p
 }
 
-func createOneJsonrpcPool(name string, address string, connTimeout time.Duration, maxConns int, maxIdle int) *connp.ConnPool {
+func createOneJsonrpcPool(name string, address string, connTimeout time.Duration, maxConns int, maxIdle int, tlsConfig *tls.Config) *connp.ConnPool {
 	p := connp.NewConnPool(name, address, int32(maxConns), int32(maxIdle))
 	p.New = func(connName string) (connp.NConn, error) {
 		_, err := net.ResolveTCPAddr("tcp", p.Address)
@@ -157,7 +169,7 @@ func createOneJsonrpcPool(name string, address string, connTimeout time.Duration
 			return nil, err
 		}
 
-		conn, err := net.DialTimeout("tcp", p.Address, connTimeout)
+		conn, err := utils.DialTimeout("tcp", p.Address, connTimeout, tlsConfig)
 		if err != nil {
 			return nil, err
 		}
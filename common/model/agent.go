@@ -23,18 +23,32 @@ type AgentReportRequest struct {
 	IP            string
 	AgentVersion  string
 	PluginVersion string
+	// agent发现的监听服务, 为nil表示agent没有开启服务发现
+	Services []*AgentService
 }
 
 func (this *AgentReportRequest) String() string {
 	return fmt.Sprintf(
-		"<Hostname:%s, IP:%s, AgentVersion:%s, PluginVersion:%s>",
+		"<Hostname:%s, IP:%s, AgentVersion:%s, PluginVersion:%s, Services:%v>",
 		this.Hostname,
 		this.IP,
 		this.AgentVersion,
 		this.PluginVersion,
+		this.Services,
 	)
 }
 
+// 机器上监听的服务, Name为监听端口的进程名
+type AgentService struct {
+	Proto string
+	Port  int
+	Name  string
+}
+
+func (this *AgentService) String() string {
+	return fmt.Sprintf("%s/%d(%s)", this.Proto, this.Port, this.Name)
+}
+
 type AgentUpdateInfo struct {
 	LastUpdate    int64
 	ReportRequest *AgentReportRequest
@@ -54,15 +68,62 @@ func (this *AgentHeartbeatRequest) String() string {
 }
 
 type AgentPluginsResponse struct {
-	Plugins   []string
-	Timestamp int64
+	Plugins        []string
+	Timestamp      int64
+	BundleVersion  string // hbs分发的插件包的版本, 为空表示hbs没有分发插件包
+	BundleChecksum string // 插件包的sha256
+	BundleUrl      string // 插件包的下载地址, 带有hbs的签名, 有效期10分钟
 }
 
 func (this *AgentPluginsResponse) String() string {
 	return fmt.Sprintf(
-		"<Plugins:%v, Timestamp:%v>",
+		"<Plugins:%v, Timestamp:%v, BundleVersion:%s, BundleChecksum:%s>",
 		this.Plugins,
 		this.Timestamp,
+		this.BundleVersion,
+		this.BundleChecksum,
+	)
+}
+
+// hbs为agent所在的机器组指定的agent版本, Version为空表示不需要升级
+type AgentUpgradeResponse struct {
+	Version   string
+	Checksum  string // sha256
+	Signature string // base64编码的ed25519签名, 没有签名时为空
+	Url       string // 二进制文件的下载地址, 带有hbs的签名, 有效期10分钟
+}
+
+func (this *AgentUpgradeResponse) String() string {
+	return fmt.Sprintf(
+		"<Version:%s, Checksum:%s>",
+		this.Version,
+		this.Checksum,
+	)
+}
+
+// agent升级的结果
+const (
+	AGENT_UPGRADE_SUCCESS  = "success"
+	AGENT_UPGRADE_ROLLBACK = "rollback"
+	AGENT_UPGRADE_FAILED   = "failed"
+)
+
+type AgentUpgradeReport struct {
+	Hostname    string
+	FromVersion string
+	ToVersion   string
+	Status      string
+	Message     string
+}
+
+func (this *AgentUpgradeReport) String() string {
+	return fmt.Sprintf(
+		"<Hostname:%s, FromVersion:%s, ToVersion:%s, Status:%s, Message:%s>",
+		this.Hostname,
+		this.FromVersion,
+		this.ToVersion,
+		this.Status,
+		this.Message,
 	)
 }
 
@@ -75,6 +75,24 @@ type GraphDeleteParam struct {
 type GraphDeleteResp struct {
 }
 
+// 历史数据回灌的请求, Merge为true时允许重建已有更新数据的GAUGE类型rrd,
+// 重建范围内超出最细粒度归档时长的MIN、MAX归档会变为平均值
+type GraphBackfillRequest struct {
+	Items []*GraphItem `json:"items"`
+	Merge bool         `json:"merge"`
+}
+
+// 历史数据回灌的结果, Skipped包括非法的以及早于rrd最后更新时间的数据
+type GraphBackfillResp struct {
+	Total   int `json:"total"`
+	Written int `json:"written"`
+	Skipped int `json:"skipped"`
+}
+
+func (this *GraphBackfillResp) String() string {
+	return fmt.Sprintf("<Total:%d, Written:%d, Skipped:%d>", this.Total, this.Written, this.Skipped)
+}
+
 // ConsolFun 是RRD中的概念，比如：MIN|MAX|AVERAGE
 type GraphQueryParam struct {
 	Start     int64  `json:"start"`
@@ -0,0 +1,373 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/golang/snappy"
+)
+
+const (
+	BATCH_CODEC_NONE   = "none"
+	BATCH_CODEC_SNAPPY = "snappy"
+	BATCH_CODEC_GZIP   = "gzip"
+
+	batchVersion = 1
+	// 解压后的数据上限, 防止异常的请求占用过多内存
+	batchMaxDataSize = 64 * 1024 * 1024
+)
+
+// TransferBatch 是Transfer.UpdateBatch的参数.
+// 一批数据共用Endpoint、Tags和Timestamp, 每条数据中的endpoint、metric、tags和counterType
+// 以字典下标的方式编码, 编码后的数据按Codec压缩后放在Data中
+type TransferBatch struct {
+	Endpoint  string
+	Tags      string // 所有数据共有的tag, 解码时附加到每条数据的tags上
+	Timestamp int64
+	Codec     string
+	Count     int
+	Data      []byte
+}
+
+func (this *TransferBatch) String() string {
+	return fmt.Sprintf(
+		"<Endpoint:%s, Tags:%s, Time:%d, Codec:%s, Count:%d, Size:%d>",
+		this.Endpoint,
+		this.Tags,
+		this.Timestamp,
+		this.Codec,
+		this.Count,
+		len(this.Data),
+	)
+}
+
+// EncodeTransferBatch 将一批数据编码为TransferBatch.
+// 不能转换为数字的value编码为NaN, 解码后为空字符串, 由transfer计入Invalid
+func EncodeTransferBatch(metrics []*MetricValue, codec string) (*TransferBatch, error) {
+	batch := &TransferBatch{Codec: codec, Count: len(metrics)}
+	if len(metrics) > 0 {
+		batch.Endpoint = metrics[0].Endpoint
+		batch.Timestamp = metrics[0].Timestamp
+		batch.Tags = commonTags(metrics)
+	}
+
+	var common map[string]bool
+	if batch.Tags != "" {
+		common = make(map[string]bool)
+		for _, tag := range strings.Split(batch.Tags, ",") {
+			common[tag] = true
+		}
+	}
+
+	dict := &batchDict{index: make(map[string]uint64)}
+	var points bytes.Buffer
+	buf := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(x uint64) {
+		points.Write(buf[:binary.PutUvarint(buf, x)])
+	}
+	putVarint := func(x int64) {
+		points.Write(buf[:binary.PutVarint(buf, x)])
+	}
+
+	for _, mv := range metrics {
+		if mv.Endpoint == batch.Endpoint {
+			putUvarint(0)
+		} else {
+			putUvarint(dict.id(mv.Endpoint) + 1)
+		}
+		putUvarint(dict.id(mv.Metric))
+		tags := stripTags(mv.Tags, common)
+		if tags == "" {
+			putUvarint(0)
+		} else {
+			putUvarint(dict.id(tags) + 1)
+		}
+		putUvarint(dict.id(mv.Type))
+		putVarint(mv.Step)
+		putVarint(mv.Timestamp - batch.Timestamp)
+		binary.LittleEndian.PutUint64(buf, math.Float64bits(batchValue(mv.Value)))
+		points.Write(buf[:8])
+	}
+
+	var data bytes.Buffer
+	data.WriteByte(batchVersion)
+	data.Write(buf[:binary.PutUvarint(buf, uint64(len(dict.values)))])
+	for _, s := range dict.values {
+		data.Write(buf[:binary.PutUvarint(buf, uint64(len(s)))])
+		data.WriteString(s)
+	}
+	data.Write(buf[:binary.PutUvarint(buf, uint64(len(metrics)))])
+	data.Write(points.Bytes())
+
+	var err error
+	batch.Data, err = compressBatch(codec, data.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// DecodeTransferBatch 将TransferBatch还原为MetricValue
+func DecodeTransferBatch(batch *TransferBatch) ([]*MetricValue, error) {
+	data, err := decompressBatch(batch.Codec, batch.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(data)
+	version, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if version != batchVersion {
+		return nil, fmt.Errorf("unsupported batch version %d", version)
+	}
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, errors.New("batch dict size overflow")
+	}
+	dict := make([]string, n)
+	for i := range dict {
+		l, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if l > uint64(r.Len()) {
+			return nil, errors.New("batch dict entry overflow")
+		}
+		b := make([]byte, l)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		dict[i] = string(b)
+	}
+	lookup := func(id uint64) (string, error) {
+		if id >= uint64(len(dict)) {
+			return "", fmt.Errorf("batch dict id %d out of range", id)
+		}
+		return dict[id], nil
+	}
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	// 每条数据至少14个字节
+	if count > uint64(r.Len()/14) {
+		return nil, errors.New("batch count overflow")
+	}
+
+	metrics := make([]*MetricValue, 0, count)
+	var bits [8]byte
+	for i := uint64(0); i < count; i++ {
+		mv := &MetricValue{Endpoint: batch.Endpoint}
+		var ids [4]uint64
+		for j := range ids {
+			if ids[j], err = binary.ReadUvarint(r); err != nil {
+				return nil, err
+			}
+		}
+		if ids[0] > 0 {
+			if mv.Endpoint, err = lookup(ids[0] - 1); err != nil {
+				return nil, err
+			}
+		}
+		if mv.Metric, err = lookup(ids[1]); err != nil {
+			return nil, err
+		}
+		if ids[2] > 0 {
+			if mv.Tags, err = lookup(ids[2] - 1); err != nil {
+				return nil, err
+			}
+		}
+		if mv.Type, err = lookup(ids[3]); err != nil {
+			return nil, err
+		}
+		if mv.Step, err = binary.ReadVarint(r); err != nil {
+			return nil, err
+		}
+		delta, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		mv.Timestamp = batch.Timestamp + delta
+		if _, err := io.ReadFull(r, bits[:]); err != nil {
+			return nil, err
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(bits[:]))
+		if math.IsNaN(v) {
+			mv.Value = ""
+		} else {
+			mv.Value = v
+		}
+
+		if batch.Tags != "" {
+			if mv.Tags == "" {
+				mv.Tags = batch.Tags
+			} else {
+				mv.Tags = mv.Tags + "," + batch.Tags
+			}
+		}
+		metrics = append(metrics, mv)
+	}
+	return metrics, nil
+}
+
+type batchDict struct {
+	index  map[string]uint64
+	values []string
+}
+
+func (d *batchDict) id(s string) uint64 {
+	if id, ok := d.index[s]; ok {
+		return id
+	}
+	id := uint64(len(d.values))
+	d.index[s] = id
+	d.values = append(d.values, s)
+	return id
+}
+
+// commonTags 返回所有数据都带有的tag, 如agent的default_tags
+func commonTags(metrics []*MetricValue) string {
+	var common []string
+	for i, mv := range metrics {
+		if mv.Tags == "" {
+			return ""
+		}
+		tags := strings.Split(mv.Tags, ",")
+		if i == 0 {
+			common = tags
+			continue
+		}
+		kept := common[:0]
+		for _, c := range common {
+			for _, t := range tags {
+				if c == t {
+					kept = append(kept, c)
+					break
+				}
+			}
+		}
+		common = kept
+		if len(common) == 0 {
+			return ""
+		}
+	}
+	return strings.Join(common, ",")
+}
+
+func stripTags(tags string, common map[string]bool) string {
+	if len(common) == 0 || tags == "" {
+		return tags
+	}
+	var kept []string
+	for _, t := range strings.Split(tags, ",") {
+		if !common[t] {
+			kept = append(kept, t)
+		}
+	}
+	return strings.Join(kept, ",")
+}
+
+func batchValue(v interface{}) float64 {
+	switch x := v.(type) {
+	case float64:
+		return x
+	case float32:
+		return float64(x)
+	case int:
+		return float64(x)
+	case int64:
+		return float64(x)
+	case uint64:
+		return float64(x)
+	case string:
+		if f, err := strconv.ParseFloat(x, 64); err == nil {
+			return f
+		}
+		return math.NaN()
+	}
+	f, err := strconv.ParseFloat(fmt.Sprint(v), 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+func compressBatch(codec string, data []byte) ([]byte, error) {
+	switch codec {
+	case BATCH_CODEC_NONE, "":
+		return data, nil
+	case BATCH_CODEC_SNAPPY:
+		return snappy.Encode(nil, data), nil
+	case BATCH_CODEC_GZIP:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unsupported batch codec %s", codec)
+}
+
+func decompressBatch(codec string, data []byte) ([]byte, error) {
+	switch codec {
+	case BATCH_CODEC_NONE, "":
+		return data, nil
+	case BATCH_CODEC_SNAPPY:
+		n, err := snappy.DecodedLen(data)
+		if err != nil {
+			return nil, err
+		}
+		if n > batchMaxDataSize {
+			return nil, errors.New("batch data too large")
+		}
+		return snappy.Decode(nil, data)
+	case BATCH_CODEC_GZIP:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		b, err := ioutil.ReadAll(io.LimitReader(r, batchMaxDataSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > batchMaxDataSize {
+			return nil, errors.New("batch data too large")
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("unsupported batch codec %s", codec)
+}
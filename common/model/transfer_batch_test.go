@@ -0,0 +1,85 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTransferBatch(t *testing.T) {
+	metrics := []*MetricValue{
+		{Endpoint: "host1", Metric: "cpu.idle", Value: 95.5, Step: 60, Type: "GAUGE", Tags: "idc=bj,env=prod", Timestamp: 1500000000},
+		{Endpoint: "host1", Metric: "net.if.in.bytes", Value: int64(1024), Step: 60, Type: "COUNTER", Tags: "iface=eth0,idc=bj,env=prod", Timestamp: 1500000001},
+		{Endpoint: "host2", Metric: "cpu.idle", Value: "12", Step: 30, Type: "GAUGE", Tags: "env=prod,idc=bj", Timestamp: 1499999990},
+		{Endpoint: "host1", Metric: "kernel.hostname", Value: "host1", Step: 60, Type: "GAUGE", Tags: "idc=bj,env=prod", Timestamp: 1500000000},
+	}
+	expect := []*MetricValue{
+		{Endpoint: "host1", Metric: "cpu.idle", Value: 95.5, Step: 60, Type: "GAUGE", Tags: "idc=bj,env=prod", Timestamp: 1500000000},
+		{Endpoint: "host1", Metric: "net.if.in.bytes", Value: float64(1024), Step: 60, Type: "COUNTER", Tags: "iface=eth0,idc=bj,env=prod", Timestamp: 1500000001},
+		{Endpoint: "host2", Metric: "cpu.idle", Value: float64(12), Step: 30, Type: "GAUGE", Tags: "idc=bj,env=prod", Timestamp: 1499999990},
+		{Endpoint: "host1", Metric: "kernel.hostname", Value: "", Step: 60, Type: "GAUGE", Tags: "idc=bj,env=prod", Timestamp: 1500000000},
+	}
+
+	for _, codec := range []string{BATCH_CODEC_NONE, BATCH_CODEC_SNAPPY, BATCH_CODEC_GZIP} {
+		batch, err := EncodeTransferBatch(metrics, codec)
+		if err != nil {
+			t.Fatalf("%s: %v", codec, err)
+		}
+		if batch.Endpoint != "host1" || batch.Tags != "idc=bj,env=prod" || batch.Count != 4 {
+			t.Errorf("%s: unexpected batch header %v", codec, batch)
+		}
+		got, err := DecodeTransferBatch(batch)
+		if err != nil {
+			t.Fatalf("%s: %v", codec, err)
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Errorf("%s: expect %v, got %v", codec, expect, got)
+		}
+	}
+
+	if _, err := EncodeTransferBatch(metrics, "lz4"); err == nil {
+		t.Error("expect error for unsupported codec")
+	}
+}
+
+func TestDecodeTransferBatchMalformed(t *testing.T) {
+	metrics := []*MetricValue{
+		{Endpoint: "host1", Metric: "cpu.idle", Value: 95.5, Step: 60, Type: "GAUGE", Timestamp: 1500000000},
+	}
+	batch, err := EncodeTransferBatch(metrics, BATCH_CODEC_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := batch.Data
+	for i := 0; i < len(data); i++ {
+		batch.Data = data[:i]
+		if _, err := DecodeTransferBatch(batch); err == nil {
+			t.Errorf("expect error for truncated data of %d bytes", i)
+		}
+	}
+
+	batch.Data = []byte{batchVersion, 0, 0xff, 0xff, 0xff, 0xff, 0x0f}
+	if _, err := DecodeTransferBatch(batch); err == nil {
+		t.Error("expect error for overflowed count")
+	}
+
+	batch.Codec = BATCH_CODEC_SNAPPY
+	batch.Data = []byte("not snappy")
+	if _, err := DecodeTransferBatch(batch); err == nil {
+		t.Error("expect error for invalid snappy data")
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"net/http"
 
 	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/common/utils"
 )
 
 func PostPush(L []*model.JsonMetaData) error {
@@ -31,9 +32,16 @@ func PostPush(L []*model.JsonMetaData) error {
 		return err
 	}
 
-	bf := bytes.NewBuffer(bs)
+	req, err := http.NewRequest("POST", PostPushUrl, bytes.NewBuffer(bs))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if PostPushToken != "" {
+		req.Header.Set(utils.TOKEN_HEADER, PostPushToken)
+	}
 
-	resp, err := http.Post(PostPushUrl, "application/json", bf)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
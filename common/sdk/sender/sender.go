@@ -23,6 +23,9 @@ const LIMIT = 200
 
 var MetaDataQueue = NewSafeLinkedList()
 var PostPushUrl string
+
+// PostPushToken 不为空时放在X-Falcon-Token header中, 对应agent或transfer的http.token
+var PostPushToken string
 var Debug bool
 
 func StartSender() {
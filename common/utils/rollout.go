@@ -0,0 +1,31 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"hash/crc32"
+)
+
+// InRollout 灰度发布时机器是否在percent%的范围内.
+// 同一个key下每台机器的位置是固定的, 调大percent时已经选中的机器仍然被选中
+func InRollout(key, hostname string, percent int) bool {
+	if percent >= 100 {
+		return true
+	}
+	if percent <= 0 {
+		return false
+	}
+	return int(crc32.ChecksumIEEE([]byte(key+"/"+hostname))%100) < percent
+}
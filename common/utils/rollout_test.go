@@ -0,0 +1,56 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestInRollout(t *testing.T) {
+	hosts := make([]string, 1000)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("host-%d", i)
+	}
+
+	selected := map[string]bool{}
+	n := 0
+	for _, h := range hosts {
+		if InRollout("6.0.1", h, 10) {
+			selected[h] = true
+			n++
+		}
+	}
+	if n < 50 || n > 150 {
+		t.Errorf("expect about 100 hosts in 10%% rollout, got %d", n)
+	}
+
+	// 调大比例时已经选中的机器仍然被选中
+	n = 0
+	for _, h := range hosts {
+		if InRollout("6.0.1", h, 50) {
+			n++
+		} else if selected[h] {
+			t.Fatalf("%s left the rollout when percent increased", h)
+		}
+	}
+	if n < 400 || n > 600 {
+		t.Errorf("expect about 500 hosts in 50%% rollout, got %d", n)
+	}
+
+	if !InRollout("6.0.1", "host-1", 100) || InRollout("6.0.1", "host-1", 0) {
+		t.Error("expect all hosts in 100% and none in 0%")
+	}
+}
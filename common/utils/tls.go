@@ -0,0 +1,122 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"time"
+)
+
+// TlsConfig 是rpc监听端口和客户端共用的tls配置.
+// 服务端配置了Ca时要求客户端提供由Ca签发的证书(双向认证), 客户端使用Ca校验服务端的证书
+type TlsConfig struct {
+	Enabled    bool   `json:"enabled"`
+	Cert       string `json:"cert"`       // 本端证书
+	Key        string `json:"key"`        // 本端证书的私钥
+	Ca         string `json:"ca"`         // 校验对端证书的CA, 客户端为空时使用系统的CA
+	ServerName string `json:"serverName"` // 客户端校验服务端证书时使用的名字, 为空时使用连接地址中的host
+}
+
+// ServerConfig 返回监听端口使用的tls配置, 没有开启时返回nil
+func (this *TlsConfig) ServerConfig() (*tls.Config, error) {
+	if this == nil || !this.Enabled {
+		return nil, nil
+	}
+	if this.Cert == "" || this.Key == "" {
+		return nil, errors.New("tls cert and key are required")
+	}
+
+	cert, err := tls.LoadX509KeyPair(this.Cert, this.Key)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if this.Ca != "" {
+		pool, err := loadCertPool(this.Ca)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// ClientConfig 返回客户端使用的tls配置, 没有开启时返回nil
+func (this *TlsConfig) ClientConfig() (*tls.Config, error) {
+	if this == nil || !this.Enabled {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		ServerName: this.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if this.Cert != "" || this.Key != "" {
+		cert, err := tls.LoadX509KeyPair(this.Cert, this.Key)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if this.Ca != "" {
+		pool, err := loadCertPool(this.Ca)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", file)
+	}
+	return pool, nil
+}
+
+// DialTimeout 建立tcp连接, tlsConfig不为nil时完成tls握手后返回, 握手同样受timeout限制
+func DialTimeout(network, address string, timeout time.Duration, tlsConfig *tls.Config) (net.Conn, error) {
+	d := &net.Dialer{Timeout: timeout}
+	if tlsConfig == nil {
+		return d.Dial(network, address)
+	}
+	return tls.DialWithDialer(d, network, address, tlsConfig)
+}
+
+// JsonRpcClient 与toolkits/net.JsonRpcClient相同, tlsConfig不为nil时使用tls连接
+func JsonRpcClient(network, address string, timeout time.Duration, tlsConfig *tls.Config) (*rpc.Client, error) {
+	conn, err := DialTimeout(network, address, timeout, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return jsonrpc.NewClient(conn), nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type pingService int
+
+func (this *pingService) Ping(args string, reply *string) error {
+	*reply = "pong " + args
+	return nil
+}
+
+// writeCert 生成由parent签发的证书, parent为nil时生成自签名的CA
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, serial int64) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestTlsConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "falcon-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := writeCert(t, dir, "ca", nil, nil, 1)
+	writeCert(t, dir, "server", ca, caKey, 2)
+	writeCert(t, dir, "client", ca, caKey, 3)
+	writeCert(t, dir, "other", nil, nil, 4)
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	var disabled *TlsConfig
+	if c, err := disabled.ServerConfig(); c != nil || err != nil {
+		t.Errorf("expect nil config for nil TlsConfig, got %v %v", c, err)
+	}
+	if c, err := (&TlsConfig{Cert: file("server.crt")}).ClientConfig(); c != nil || err != nil {
+		t.Errorf("expect nil config when not enabled, got %v %v", c, err)
+	}
+	if _, err := (&TlsConfig{Enabled: true}).ServerConfig(); err == nil {
+		t.Error("expect error without server cert")
+	}
+	if _, err := (&TlsConfig{Enabled: true, Ca: file("server.key")}).ClientConfig(); err == nil {
+		t.Error("expect error for ca without certificate")
+	}
+
+	serverConfig, err := (&TlsConfig{Enabled: true, Cert: file("server.crt"), Key: file("server.key"), Ca: file("ca.crt")}).ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	server := rpc.NewServer()
+	server.RegisterName("Ping", new(pingService))
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeCodec(jsonrpc.NewServerCodec(tls.Server(conn, serverConfig)))
+		}
+	}()
+	addr := ln.Addr().String()
+
+	call := func(cfg *TlsConfig) error {
+		clientConfig, err := cfg.ClientConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := JsonRpcClient("tcp", addr, time.Second, clientConfig)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		var reply string
+		if err := client.Call("Ping.Ping", "falcon", &reply); err != nil {
+			return err
+		}
+		if reply != "pong falcon" {
+			t.Errorf("unexpected reply %s", reply)
+		}
+		return nil
+	}
+
+	if err := call(&TlsConfig{Enabled: true, Cert: file("client.crt"), Key: file("client.key"), Ca: file("ca.crt")}); err != nil {
+		t.Errorf("expect mutual tls call ok, got %v", err)
+	}
+	if err := call(&TlsConfig{Enabled: true, Ca: file("ca.crt")}); err == nil {
+		t.Error("expect error without client certificate")
+	}
+	if err := call(&TlsConfig{Enabled: true, Cert: file("other.crt"), Key: file("other.key"), Ca: file("ca.crt")}); err == nil {
+		t.Error("expect error for client certificate from another ca")
+	}
+	if err := call(&TlsConfig{Enabled: true, Cert: file("client.crt"), Key: file("client.key"), Ca: file("other.crt")}); err == nil {
+		t.Error("expect error for untrusted server certificate")
+	}
+	if err := call(nil); err == nil {
+		t.Error("expect error for plain connection")
+	}
+}
+
+func TestCheckToken(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/v1/push", nil)
+	if !CheckToken(req, "") {
+		t.Error("expect no check for empty token")
+	}
+	if CheckToken(req, "secret") {
+		t.Error("expect missing token rejected")
+	}
+	req.Header.Set(TOKEN_HEADER, "wrong")
+	if CheckToken(req, "secret") {
+		t.Error("expect wrong token rejected")
+	}
+	req.Header.Set(TOKEN_HEADER, "secret")
+	if !CheckToken(req, "secret") {
+		t.Error("expect token accepted")
+	}
+}
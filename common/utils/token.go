@@ -0,0 +1,31 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// http接口校验token时使用的header
+const TOKEN_HEADER = "X-Falcon-Token"
+
+// CheckToken 校验请求中的token, token为空时不校验
+func CheckToken(req *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(req.Header.Get(TOKEN_HEADER)), []byte(token)) == 1
+}
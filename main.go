@@ -42,10 +42,23 @@ func init() {
 	RootCmd.AddCommand(cmd.Check)
 	RootCmd.AddCommand(cmd.Monitor)
 	RootCmd.AddCommand(cmd.Reload)
+	RootCmd.AddCommand(cmd.Backfill)
 
 	RootCmd.Flags().BoolVarP(&versionFlag, "version", "v", false, "show version")
 	cmd.Start.Flags().BoolVar(&cmd.PreqOrderFlag, "preq-order", false, "start modules in the order of prerequisites")
 	cmd.Start.Flags().BoolVar(&cmd.ConsoleOutputFlag, "console-output", false, "print the module's output to the console")
+	cmd.Backfill.Flags().StringVar(&cmd.BackfillFormat, "format", "", "input format, json or csv (default: by file extension)")
+	cmd.Backfill.Flags().StringVar(&cmd.BackfillConfig, "config", "", "transfer configuration file holding the graph cluster")
+	cmd.Backfill.Flags().IntVar(&cmd.BackfillBatch, "batch", 10000, "points per rpc call")
+	cmd.Backfill.Flags().Int64Var(&cmd.BackfillStep, "step", 60, "default step of points without one")
+	cmd.Backfill.Flags().StringVar(&cmd.BackfillType, "type", "GAUGE", "default counterType of points without one")
+	cmd.Backfill.Flags().BoolVar(&cmd.BackfillDryRun, "dry-run", false, "parse and route the points without sending them")
+	cmd.Backfill.Flags().BoolVar(&cmd.BackfillMerge, "merge", false, "rebuild gauge rrd files holding newer data, MIN/MAX archives of the rebuilt range keep only averages")
+	cmd.Backfill.Flags().BoolVar(&cmd.BackfillTls.Enabled, "tls", false, "connect to graph over tls (default: graph.tls of the transfer configuration)")
+	cmd.Backfill.Flags().StringVar(&cmd.BackfillTls.Cert, "tls-cert", "", "client certificate for --tls")
+	cmd.Backfill.Flags().StringVar(&cmd.BackfillTls.Key, "tls-key", "", "client certificate key for --tls")
+	cmd.Backfill.Flags().StringVar(&cmd.BackfillTls.Ca, "tls-ca", "", "ca verifying the graph certificate for --tls (default: system cas)")
+	cmd.Backfill.Flags().StringVar(&cmd.BackfillTls.ServerName, "tls-server-name", "", "name verified in the graph certificate for --tls (default: host of the address)")
 }
 
 func main() {
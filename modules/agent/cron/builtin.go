@@ -17,6 +17,7 @@ package cron
 import (
 	"github.com/open-falcon/falcon-plus/common/model"
 	"github.com/open-falcon/falcon-plus/modules/agent/g"
+	"github.com/toolkits/slice"
 	"log"
 	"strconv"
 	"strings"
@@ -42,7 +43,10 @@ func syncBuiltinMetrics() {
 		var ports = []int64{}
 		var paths = []string{}
 		var procs = make(map[string]map[int]string)
-		var urls = make(map[string]string)
+		var probes = []*g.Probe{}
+		var logs = []*g.LogRule{}
+		var promTargets = []*g.PromTarget{}
+		var units = []string{}
 
 		hostname, err := g.Hostname()
 		if err != nil {
@@ -74,24 +78,51 @@ func syncBuiltinMetrics() {
 
 		for _, metric := range resp.Metrics {
 
-			if metric.Metric == g.URL_CHECK_HEALTH {
-				arr := strings.Split(metric.Tags, ",")
-				if len(arr) != 2 {
-					continue
+			if metric.Metric == g.URL_CHECK_HEALTH || metric.Metric == g.TCP_CHECK_HEALTH || metric.Metric == g.DNS_CHECK_HEALTH {
+				params := make(map[string]string)
+				for _, kv := range strings.Split(metric.Tags, ",") {
+					arr := strings.SplitN(kv, "=", 2)
+					if len(arr) == 2 {
+						params[strings.TrimSpace(arr[0])] = strings.TrimSpace(arr[1])
+					}
 				}
-				url := strings.Split(arr[0], "=")
-				if len(url) != 2 {
+				if _, err := strconv.ParseInt(params["timeout"], 10, 64); err != nil {
+					log.Println("metric ParseInt timeout failed:", err)
 					continue
 				}
-				stime := strings.Split(arr[1], "=")
-				if len(stime) != 2 {
-					continue
+				probes = append(probes, &g.Probe{Metric: metric.Metric, Tags: metric.Tags, Params: params})
+				continue
+			}
+
+			// log.match.count path=/var/log/app/*.log,pattern=ERROR
+			if metric.Metric == g.LOG_MATCH_COUNT {
+				rule := parseLogRule(metric.Tags)
+				if rule.Path != "" && rule.Pattern != "" {
+					logs = append(logs, rule)
 				}
-				if _, err := strconv.ParseInt(stime[1], 10, 64); err == nil {
-					urls[url[1]] = stime[1]
-				} else {
-					log.Println("metric ParseInt timeout failed:", err)
+				continue
+			}
+
+			// prom.scrape url=http://127.0.0.1:9100/metrics,interval=30,service=node
+			// 除url和interval外的tags作为附加的tag
+			if metric.Metric == g.PROM_SCRAPE {
+				target := &g.PromTarget{}
+				var tags []string
+				for _, kv := range strings.Split(metric.Tags, ",") {
+					kv = strings.TrimSpace(kv)
+					if strings.HasPrefix(kv, "url=") {
+						target.Url = strings.TrimSpace(kv[4:])
+					} else if strings.HasPrefix(kv, "interval=") {
+						target.Interval, _ = strconv.Atoi(strings.TrimSpace(kv[9:]))
+					} else if kv != "" {
+						tags = append(tags, kv)
+					}
+				}
+				if target.Url != "" {
+					target.Tags = strings.Join(tags, ",")
+					promTargets = append(promTargets, target)
 				}
+				continue
 			}
 
 			if metric.Metric == g.NET_PORT_LISTEN {
@@ -119,7 +150,19 @@ func syncBuiltinMetrics() {
 				continue
 			}
 
-			if metric.Metric == g.PROC_NUM {
+			// systemd.unit.active unit=nginx.service, 同一个unit的多个指标只采集一次
+			if strings.HasPrefix(metric.Metric, g.SYSTEMD_UNIT_PREFIX) {
+				for _, kv := range strings.Split(metric.Tags, ",") {
+					kv = strings.TrimSpace(kv)
+					if strings.HasPrefix(kv, "unit=") && !slice.ContainsString(units, kv[5:]) {
+						units = append(units, kv[5:])
+					}
+				}
+				continue
+			}
+
+			// proc.num、proc.mem.rss等进程指标共用同一组name/cmdline匹配规则
+			if strings.HasPrefix(metric.Metric, g.PROC_METRIC_PREFIX) {
 				arr := strings.Split(metric.Tags, ",")
 
 				tmpMap := make(map[int]string)
@@ -136,10 +179,32 @@ func syncBuiltinMetrics() {
 			}
 		}
 
-		g.SetReportUrls(urls)
+		g.SetReportProbes(probes)
 		g.SetReportPorts(ports)
 		g.SetReportProcs(procs)
+		g.SetReportLogs(logs)
+		g.SetReportPromTargets(promTargets)
+		g.SetReportUnits(units)
 		g.SetDuPaths(paths)
 
 	}
 }
+
+// 正则中可能含有逗号, pattern必须是最后一个tag, 其后的内容全部作为正则
+func parseLogRule(tags string) *g.LogRule {
+	rule := &g.LogRule{}
+	if strings.HasPrefix(tags, "pattern=") {
+		rule.Pattern = strings.TrimSpace(tags[8:])
+		return rule
+	}
+	if idx := strings.Index(tags, ",pattern="); idx >= 0 {
+		rule.Pattern = strings.TrimSpace(tags[idx+9:])
+		tags = tags[:idx]
+	}
+	for _, kv := range strings.Split(tags, ",") {
+		if strings.HasPrefix(kv, "path=") {
+			rule.Path = strings.TrimSpace(kv[5:])
+		}
+	}
+	return rule
+}
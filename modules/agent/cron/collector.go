@@ -20,6 +20,7 @@ import (
 	"github.com/open-falcon/falcon-plus/common/model"
 	"github.com/open-falcon/falcon-plus/modules/agent/funcs"
 	"github.com/open-falcon/falcon-plus/modules/agent/g"
+	"github.com/toolkits/slice"
 )
 
 func InitDataHistory() {
@@ -30,6 +31,16 @@ func InitDataHistory() {
 	}
 }
 
+// 日志监控: 每秒读取一次日志文件的新增内容, 统计结果由funcs.LogMetrics定期上报
+func TailLogs() {
+	go func() {
+		for {
+			funcs.TailLogs()
+			time.Sleep(g.COLLECT_INTERVAL)
+		}
+	}()
+}
+
 func Collect() {
 
 	if !g.Config().Transfer.Enabled {
@@ -41,51 +52,101 @@ func Collect() {
 	}
 
 	for _, v := range funcs.Mappers {
+		if sample := rollupSampleInterval(v); sample > 0 {
+			go collectRollup(int64(v.Interval), sample, v.Fs)
+			continue
+		}
 		go collect(int64(v.Interval), v.Fs)
 	}
 }
 
+// 配置了collector.rollup的采集项按采样周期采集, 采样周期不小于上报周期时按普通方式采集
+func rollupSampleInterval(v funcs.FuncsAndInterval) int64 {
+	cfg := g.Config().Collector.Rollup
+	if cfg == nil || !cfg.Enabled || !slice.ContainsString(cfg.Collectors, v.Name) {
+		return 0
+	}
+	sample := int64(cfg.SampleInterval)
+	if sample <= 0 {
+		sample = 1
+	}
+	if sample >= int64(v.Interval) {
+		return 0
+	}
+	return sample
+}
+
 func collect(sec int64, fns []func() []*model.MetricValue) {
 	t := time.NewTicker(time.Second * time.Duration(sec))
 	defer t.Stop()
 	for {
 		<-t.C
+		send(sec, collectOnce(fns))
+	}
+}
 
-		hostname, err := g.Hostname()
-		if err != nil {
-			continue
+func collectRollup(sec, sample int64, fns []func() []*model.MetricValue) {
+	percentiles := g.Config().Collector.Rollup.Percentiles
+	if len(percentiles) == 0 {
+		percentiles = []float64{99}
+	}
+	rollup := funcs.NewRollup(percentiles)
+
+	st := time.NewTicker(time.Second * time.Duration(sample))
+	defer st.Stop()
+	t := time.NewTicker(time.Second * time.Duration(sec))
+	defer t.Stop()
+	for {
+		select {
+		case <-st.C:
+			rollup.Add(collectOnce(fns))
+		case <-t.C:
+			send(sec, rollup.Flush())
 		}
+	}
+}
 
-		mvs := []*model.MetricValue{}
-		ignoreMetrics := g.Config().IgnoreMetrics
+func collectOnce(fns []func() []*model.MetricValue) []*model.MetricValue {
+	mvs := []*model.MetricValue{}
+	ignoreMetrics := g.Config().IgnoreMetrics
 
-		for _, fn := range fns {
-			items := fn()
-			if items == nil {
-				continue
-			}
+	for _, fn := range fns {
+		items := fn()
+		if items == nil {
+			continue
+		}
 
-			if len(items) == 0 {
-				continue
-			}
+		if len(items) == 0 {
+			continue
+		}
 
-			for _, mv := range items {
-				if b, ok := ignoreMetrics[mv.Metric]; ok && b {
-					continue
-				} else {
-					mvs = append(mvs, mv)
-				}
+		for _, mv := range items {
+			if b, ok := ignoreMetrics[mv.Metric]; ok && b {
+				continue
+			} else {
+				mvs = append(mvs, mv)
 			}
 		}
+	}
+	return mvs
+}
 
-		now := time.Now().Unix()
-		for j := 0; j < len(mvs); j++ {
-			mvs[j].Step = sec
-			mvs[j].Endpoint = hostname
-			mvs[j].Timestamp = now
-		}
+func send(sec int64, mvs []*model.MetricValue) {
+	if len(mvs) == 0 {
+		return
+	}
 
-		g.SendToTransfer(mvs)
+	hostname, err := g.Hostname()
+	if err != nil {
+		return
+	}
 
+	now := time.Now().Unix()
+	for j := 0; j < len(mvs); j++ {
+		mvs[j].Step = sec
+		mvs[j].Endpoint = hostname
+		mvs[j].Timestamp = now
 	}
+
+	g.SendToTransfer(mvs)
 }
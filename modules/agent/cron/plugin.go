@@ -83,6 +83,12 @@ func syncMinePlugins() {
 			log.Printf("call Agent.MinePlugin:%v\n", resp)
 		}
 
+		if g.Config().Plugin.Source == g.PLUGIN_SOURCE_HBS {
+			if err := plugins.SyncBundle(resp.BundleVersion, resp.BundleChecksum, resp.BundleUrl); err != nil {
+				log.Println("sync plugin bundle fail:", err)
+			}
+		}
+
 		if len(pluginDirs) == 0 {
 			plugins.ClearAllPlugins()
 			continue
@@ -17,6 +17,7 @@ package cron
 import (
 	"fmt"
 	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/agent/funcs"
 	"github.com/open-falcon/falcon-plus/modules/agent/g"
 	"log"
 	"time"
@@ -41,6 +42,9 @@ func reportAgentStatus(interval time.Duration) {
 			AgentVersion:  g.VersionMsg(),
 			PluginVersion: g.GetCurrPluginVersion(),
 		}
+		if g.Config().Heartbeat.Discovery {
+			req.Services = funcs.DiscoverServices()
+		}
 
 		var resp model.SimpleRpcResponse
 		err = g.HbsClient.Call("Agent.ReportStatus", req, &resp)
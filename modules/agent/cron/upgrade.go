@@ -0,0 +1,343 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cron
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+)
+
+func SyncAgentUpgrade() {
+	cfg := g.Config().Upgrade
+	if cfg == nil || !cfg.Enabled {
+		return
+	}
+
+	if !g.Config().Heartbeat.Enabled || g.Config().Heartbeat.Addr == "" {
+		return
+	}
+
+	go syncAgentUpgrade(cfg)
+}
+
+func upgradeStateFile(cfg *g.UpgradeConfig) string {
+	if cfg.StateFile == "" {
+		return "./var/upgrade.json"
+	}
+	return cfg.StateFile
+}
+
+func agentExecutable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(exe)
+}
+
+func syncAgentUpgrade(cfg *g.UpgradeConfig) {
+	stateFile := upgradeStateFile(cfg)
+	state, err := g.LoadUpgradeState(stateFile)
+	if err != nil {
+		log.Println("load upgrade state fail:", err)
+	}
+	confirmUpgrade(cfg, stateFile, state)
+
+	duration := time.Duration(g.Config().Heartbeat.Interval) * time.Second
+	for {
+		time.Sleep(duration)
+
+		hostname, err := g.Hostname()
+		if err != nil {
+			continue
+		}
+
+		var resp model.AgentUpgradeResponse
+		err = g.HbsClient.Call("Agent.UpgradeInfo", model.AgentHeartbeatRequest{Hostname: hostname}, &resp)
+		if err != nil {
+			log.Println("call Agent.UpgradeInfo fail:", err)
+			continue
+		}
+
+		if resp.Version == "" || resp.Version == g.Version || state.IsFailed(resp.Version) {
+			continue
+		}
+
+		log.Printf("upgrade agent from %s to %s", g.Version, resp.Version)
+		upgradeAgent(cfg, stateFile, state, hostname, &resp)
+	}
+}
+
+// 下载、校验并替换agent的二进制文件, 然后退出由supervisor启动新版本. 新版本不可用时记录在state中, 不再尝试
+func upgradeAgent(cfg *g.UpgradeConfig, stateFile string, state *g.UpgradeState, hostname string, info *model.AgentUpgradeResponse) {
+	fail := func(err error) {
+		log.Printf("upgrade agent to %s fail: %v", info.Version, err)
+		state.AddFailed(info.Version)
+		if err := g.SaveUpgradeState(stateFile, state); err != nil {
+			log.Println("save upgrade state fail:", err)
+		}
+		go reportUpgrade(hostname, g.Version, info.Version, model.AGENT_UPGRADE_FAILED, err.Error())
+	}
+
+	exe, err := agentExecutable()
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	// 下载失败或者下载期间hbs上的文件发生变化时, 下次心跳时重试
+	newExe := exe + ".new"
+	if err := g.DownloadFile(info.Url, newExe, info.Checksum); err != nil {
+		log.Println("download agent binary fail:", err)
+		return
+	}
+	data, err := ioutil.ReadFile(newExe)
+	if err == nil {
+		err = g.VerifyAgentBinary(data, info.Checksum, info.Signature, cfg.PublicKey)
+	}
+	if err != nil {
+		os.Remove(newExe)
+		fail(err)
+		return
+	}
+
+	if err := installAgentBinary(exe, newExe, info.Version); err != nil {
+		fail(err)
+		return
+	}
+
+	*state = g.UpgradeState{
+		From:   g.Version,
+		To:     info.Version,
+		Status: g.UPGRADE_UPGRADING,
+		Time:   time.Now().Unix(),
+		Failed: state.Failed,
+	}
+	if err := g.SaveUpgradeState(stateFile, state); err != nil {
+		os.Rename(exe+".old", exe)
+		state.Status = ""
+		fail(err)
+		return
+	}
+
+	log.Printf("agent %s installed, restart %s", info.Version, exe)
+	os.Exit(g.UPGRADE_RESTART_CODE)
+}
+
+// 确认下载的新版本可以执行后, 将当前版本重命名为 <exe>.old, 新版本重命名为 exe
+func installAgentBinary(exe, newExe, version string) error {
+	if err := os.Chmod(newExe, 0755); err != nil {
+		os.Remove(newExe)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, newExe, "-v").Output()
+	if err != nil || !strings.Contains(string(out), "version "+version+",") {
+		os.Remove(newExe)
+		return fmt.Errorf("new agent binary check fail: %v %s", err, strings.TrimSpace(string(out)))
+	}
+
+	if err := os.Rename(exe, exe+".old"); err != nil {
+		os.Remove(newExe)
+		return err
+	}
+	if err := os.Rename(newExe, exe); err != nil {
+		os.Rename(exe+".old", exe)
+		return err
+	}
+	return nil
+}
+
+// agent启动时根据保存的状态确认上一次升级的结果, 确认之前不会开始新的升级:
+// 新版本在healthTimeout内可以向hbs报告成功则升级完成, 否则换回旧版本, 由supervisor重新启动
+func confirmUpgrade(cfg *g.UpgradeConfig, stateFile string, state *g.UpgradeState) {
+	hostname, _ := g.Hostname()
+
+	switch state.Status {
+	case g.UPGRADE_UPGRADING:
+		if g.Version != state.To {
+			// 新版本没有运行起来, 当前运行的是旧版本
+			state.Status = g.UPGRADE_ROLLBACK
+			state.Message = fmt.Sprintf("agent %s is running instead of %s", g.Version, state.To)
+			state.AddFailed(state.To)
+			g.SaveUpgradeState(stateFile, state)
+			confirmUpgrade(cfg, stateFile, state)
+			return
+		}
+
+		timeout := cfg.HealthTimeout
+		if timeout <= 0 {
+			timeout = 300
+		}
+		deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+		for time.Now().Before(deadline) {
+			if err := reportUpgrade(hostname, state.From, state.To, model.AGENT_UPGRADE_SUCCESS, ""); err == nil {
+				log.Printf("agent upgraded from %s to %s", state.From, state.To)
+				if exe, err := agentExecutable(); err == nil {
+					os.Remove(exe + ".old")
+				}
+				state.Status = ""
+				g.SaveUpgradeState(stateFile, state)
+				return
+			}
+			time.Sleep(10 * time.Second)
+		}
+		rollbackAgent(stateFile, state, fmt.Sprintf("cannot report to hbs in %d seconds", timeout))
+
+	case g.UPGRADE_ROLLBACK:
+		for {
+			if err := reportUpgrade(hostname, state.From, state.To, model.AGENT_UPGRADE_ROLLBACK, state.Message); err == nil {
+				log.Printf("agent rolled back from %s to %s: %s", state.To, state.From, state.Message)
+				state.Status = ""
+				g.SaveUpgradeState(stateFile, state)
+				return
+			}
+			time.Sleep(10 * time.Second)
+		}
+	}
+}
+
+// 换回 <exe>.old 并退出, 由supervisor启动旧版本
+func rollbackAgent(stateFile string, state *g.UpgradeState, message string) {
+	exe, err := agentExecutable()
+	if err == nil {
+		err = os.Rename(exe+".old", exe)
+	}
+	if err != nil {
+		log.Println("rollback agent fail:", err)
+		return
+	}
+
+	state.Status = g.UPGRADE_ROLLBACK
+	state.Message = message
+	state.AddFailed(state.To)
+	if err := g.SaveUpgradeState(stateFile, state); err != nil {
+		log.Println("save upgrade state fail:", err)
+	}
+
+	log.Printf("rollback agent to %s: %s", state.From, message)
+	os.Exit(g.UPGRADE_RESTART_CODE)
+}
+
+// SuperviseAgent 开启自动升级时, 启动的agent进程作为supervisor在子进程中运行agent并转发退出信号.
+// 子进程安装新版本或者回滚后退出, supervisor立即重新启动; 子进程异常退出时5秒后重新启动,
+// 新版本在确认升级成功之前退出maxBoots次后换回旧版本. supervisor一直运行最初启动的版本, 不会被升级
+func SuperviseAgent(cfg *g.UpgradeConfig) {
+	// 升级时exe会被重命名, 必须在启动时确定路径
+	exe, err := agentExecutable()
+	if err != nil {
+		log.Fatalln("get agent executable fail:", err)
+	}
+	stateFile := upgradeStateFile(cfg)
+	maxBoots := cfg.MaxBoots
+	if maxBoots <= 0 {
+		maxBoots = 3
+	}
+
+	var (
+		lock     sync.Mutex
+		child    *os.Process
+		stopping bool
+	)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for sig := range sigs {
+			lock.Lock()
+			stopping = true
+			if child != nil {
+				child.Signal(sig)
+			}
+			lock.Unlock()
+		}
+	}()
+
+	for {
+		cmd := exec.Command(exe, os.Args[1:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(), g.UPGRADE_SUPERVISED_ENV+"=1")
+
+		lock.Lock()
+		if stopping {
+			lock.Unlock()
+			os.Exit(0)
+		}
+		err := cmd.Start()
+		if err == nil {
+			child = cmd.Process
+		}
+		lock.Unlock()
+		if err == nil {
+			err = cmd.Wait()
+		}
+
+		lock.Lock()
+		child = nil
+		stop := stopping
+		lock.Unlock()
+		if stop {
+			os.Exit(0)
+		}
+
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == g.UPGRADE_RESTART_CODE {
+			continue
+		}
+		log.Println("agent exited:", err)
+		if rolledBack, err := g.GuardUpgrade(exe, stateFile, maxBoots); err != nil {
+			log.Println("check upgrade state fail:", err)
+		} else if rolledBack {
+			log.Println("new agent keeps exiting, rolled back to", exe+".old")
+			continue
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func reportUpgrade(hostname, from, to, status, message string) error {
+	req := model.AgentUpgradeReport{
+		Hostname:    hostname,
+		FromVersion: from,
+		ToVersion:   to,
+		Status:      status,
+		Message:     message,
+	}
+	var resp model.SimpleRpcResponse
+	if err := g.HbsClient.Call("Agent.ReportUpgrade", req, &resp); err != nil {
+		log.Println("call Agent.ReportUpgrade fail:", err)
+		return err
+	}
+	if resp.Code != 0 {
+		return errors.New("report upgrade fail")
+	}
+	return nil
+}
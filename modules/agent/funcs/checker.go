@@ -30,6 +30,7 @@ func CheckCollector() {
 	procs, psErr := nux.AllProcs()
 
 	_, duErr := sys.CmdOut("du", "--help")
+	_, systemctlErr := sys.CmdOut("systemctl", "--version")
 
 	output["kernel  "] = len(KernelMetrics()) > 0
 	output["df.bytes"] = DeviceMetricsCheck()
@@ -43,6 +44,7 @@ func CheckCollector() {
 	output["ss -tln "] = listeningPortsErr == nil && len(ports) > 0
 	output["ps aux  "] = psErr == nil && len(procs) > 0
 	output["du -bs  "] = duErr == nil
+	output["systemd "] = systemctlErr == nil
 
 	for k, v := range output {
 		status := "fail"
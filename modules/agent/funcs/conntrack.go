@@ -0,0 +1,92 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+	"github.com/toolkits/nux"
+)
+
+// /proc/net/stat/nf_conntrack中上报的列, 每行是一个cpu, 值为16进制
+var conntrackStatColumns = []string{"insert_failed", "drop", "early_drop", "invalid"}
+
+func ConntrackMetrics() []*model.MetricValue {
+	if !g.Config().Collector.Conntrack {
+		return nil
+	}
+	return collectConntrackMetrics(nux.Root() + "/proc")
+}
+
+func collectConntrackMetrics(procRoot string) (L []*model.MetricValue) {
+	dir := filepath.Join(procRoot, "sys/net/netfilter")
+	count, err := readUint(filepath.Join(dir, "nf_conntrack_count"))
+	if err != nil {
+		// 没有加载nf_conntrack模块
+		return
+	}
+	L = append(L, GaugeValue("conntrack.count", count))
+	if max, err := readUint(filepath.Join(dir, "nf_conntrack_max")); err == nil && max > 0 {
+		L = append(L, GaugeValue("conntrack.max", max))
+		L = append(L, GaugeValue("conntrack.used.percent", float64(count)*100/float64(max)))
+	}
+
+	stats, err := readConntrackStat(filepath.Join(procRoot, "net/stat/nf_conntrack"))
+	if err != nil {
+		return
+	}
+	for _, col := range conntrackStatColumns {
+		if v, ok := stats[col]; ok {
+			L = append(L, CounterValue("conntrack."+col, v))
+		}
+	}
+	return
+}
+
+// readConntrackStat 按表头的列名汇总所有cpu的统计
+func readConntrackStat(filename string) (map[string]uint64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ret := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return ret, scanner.Err()
+	}
+	header := strings.Fields(scanner.Text())
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		for i, v := range fields {
+			if i >= len(header) {
+				break
+			}
+			n, err := strconv.ParseUint(v, 16, 64)
+			if err != nil {
+				continue
+			}
+			ret[header[i]] += n
+		}
+	}
+	return ret, scanner.Err()
+}
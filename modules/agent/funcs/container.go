@@ -0,0 +1,591 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+)
+
+// 容器指标: cpu、内存、blkio从cgroup v1/v2文件系统读取,
+// 网络从容器内进程的/proc/<pid>/net/dev读取, 容器名和label从docker的本地socket获取,
+// containerd(包括k8s的cri-containerd和nerdctl)的容器名和annotation从runtime v2的task目录读取
+
+const (
+	defaultCgroupRoot   = "/sys/fs/cgroup"
+	defaultDockerSocket = "/var/run/docker.sock"
+	// containerd runtime v2的task目录, 结构为 <namespace>/<id>/config.json
+	defaultContainerdState = "/run/containerd/io.containerd.runtime.v2.task"
+	// 大于此值的memory limit认为没有限制
+	cgroupUnlimited = uint64(1) << 62
+	// cpuacct.stat的单位是USER_HZ, 一般为100
+	userHZ = 100
+)
+
+// cgroup目录名中的容器id, 如 docker/<id>, docker-<id>.scope, cri-containerd-<id>.scope, crio-<id>.scope
+var containerIdPattern = regexp.MustCompile(`(?:^|[-/])([0-9a-f]{64})(?:\.scope)?$`)
+
+type cgroupContainer struct {
+	Id   string
+	Path string // 相对于cgroup挂载点的路径
+}
+
+type containerInfo struct {
+	Name   string
+	Labels map[string]string
+}
+
+type containerCpuSample struct {
+	ts     time.Time
+	usage  uint64 // 纳秒
+	user   uint64
+	system uint64
+}
+
+var (
+	containerCpuHistory = make(map[string]*containerCpuSample)
+	containerCpuLock    = new(sync.Mutex)
+)
+
+func ContainerMetrics() []*model.MetricValue {
+	cfg := g.Config().Collector.Container
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+
+	root := cfg.CgroupRoot
+	if root == "" {
+		root = defaultCgroupRoot
+	}
+	socket := cfg.DockerSocket
+	if socket == "" {
+		socket = defaultDockerSocket
+	}
+
+	state := cfg.ContainerdState
+	if state == "" {
+		state = defaultContainerdState
+	}
+
+	infos, err := dockerContainers(socket)
+	if err != nil && g.Config().Debug {
+		log.Println("list docker containers fail:", err)
+	}
+	ctrInfos, err := containerdContainers(state)
+	if err != nil && g.Config().Debug {
+		log.Println("list containerd containers fail:", err)
+	}
+	// docker本身也跑在containerd上, 以docker的信息为准
+	for id, info := range ctrInfos {
+		if _, ok := infos[id]; !ok {
+			infos[id] = info
+		}
+	}
+	return collectContainerMetrics(root, "/proc", infos, cfg.Labels, time.Now())
+}
+
+func collectContainerMetrics(root, procRoot string, infos map[string]*containerInfo, labels []string, now time.Time) (L []*model.MetricValue) {
+	v2 := isCgroupV2(root)
+	walkRoot := root
+	if !v2 {
+		walkRoot = filepath.Join(root, "memory")
+	}
+
+	containers, err := findContainers(walkRoot)
+	if err != nil {
+		log.Println("find containers fail:", err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range containers {
+		seen[c.Id] = true
+		tags := containerTags(c.Id, infos[c.Id], labels)
+		var cgroupProcs string
+		if v2 {
+			L = append(L, cgroupV2Metrics(filepath.Join(root, c.Path), c.Id, tags, now)...)
+			cgroupProcs = filepath.Join(root, c.Path, "cgroup.procs")
+		} else {
+			L = append(L, cgroupV1Metrics(root, c, tags, now)...)
+			cgroupProcs = filepath.Join(root, "memory", c.Path, "cgroup.procs")
+		}
+		L = append(L, containerNetMetrics(procRoot, cgroupProcs, tags)...)
+	}
+
+	// 清理已经退出的容器
+	containerCpuLock.Lock()
+	for id := range containerCpuHistory {
+		if !seen[id] {
+			delete(containerCpuHistory, id)
+		}
+	}
+	containerCpuLock.Unlock()
+
+	L = append(L, GaugeValue("container.count", len(containers)))
+	return
+}
+
+func isCgroupV2(root string) bool {
+	_, err := os.Stat(filepath.Join(root, "cgroup.controllers"))
+	return err == nil
+}
+
+// 遍历cgroup目录, 找到所有容器对应的子目录
+func findContainers(walkRoot string) ([]*cgroupContainer, error) {
+	containers := []*cgroupContainer{}
+	err := filepath.Walk(walkRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// 容器退出时目录会被删除
+			if os.IsNotExist(err) && path != walkRoot {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() || path == walkRoot {
+			return nil
+		}
+		m := containerIdPattern.FindStringSubmatch(info.Name())
+		// crio-conmon-<id>.scope是cri-o的监控进程
+		if m == nil || strings.Contains(info.Name(), "conmon") {
+			return nil
+		}
+		rel, err := filepath.Rel(walkRoot, path)
+		if err != nil {
+			return err
+		}
+		containers = append(containers, &cgroupContainer{Id: m[1], Path: rel})
+		return filepath.SkipDir
+	})
+	return containers, err
+}
+
+func containerTags(id string, info *containerInfo, labels []string) []string {
+	tags := []string{"container=" + id[:12]}
+	if info == nil {
+		return tags
+	}
+	if info.Name != "" {
+		tags = append(tags, "name="+sanitizeTagValue(info.Name))
+	}
+	for _, label := range labels {
+		if v, ok := info.Labels[label]; ok && v != "" {
+			tags = append(tags, sanitizeTagValue(label)+"="+sanitizeTagValue(v))
+		}
+	}
+	return tags
+}
+
+// tag中不能包含 , = 和空白字符
+func sanitizeTagValue(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ',' || r == '=' || r == ' ' || r == '\t' || r == '\n' {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+func cgroupV1Metrics(root string, c *cgroupContainer, tags []string, now time.Time) (L []*model.MetricValue) {
+	// cpu
+	cpuacct := filepath.Join(root, "cpuacct", c.Path)
+	if usage, err := readUint(filepath.Join(cpuacct, "cpuacct.usage")); err == nil {
+		stat, _ := readKeyValues(filepath.Join(cpuacct, "cpuacct.stat"))
+		sample := &containerCpuSample{
+			ts:     now,
+			usage:  usage,
+			user:   stat["user"] * (1e9 / userHZ),
+			system: stat["system"] * (1e9 / userHZ),
+		}
+		L = append(L, containerCpuMetrics(c.Id, sample, tags)...)
+	}
+	if stat, err := readKeyValues(filepath.Join(root, "cpu", c.Path, "cpu.stat")); err == nil {
+		L = append(L, CounterValue("container.cpu.throttled.periods", stat["nr_throttled"], tags...))
+		L = append(L, CounterValue("container.cpu.throttled.time", float64(stat["throttled_time"])/1e9, tags...))
+	}
+
+	// memory
+	memory := filepath.Join(root, "memory", c.Path)
+	if usage, err := readUint(filepath.Join(memory, "memory.usage_in_bytes")); err == nil {
+		stat, _ := readKeyValues(filepath.Join(memory, "memory.stat"))
+		inactiveFile, ok := stat["total_inactive_file"]
+		if !ok {
+			inactiveFile = stat["inactive_file"]
+		}
+		limit, _ := readUint(filepath.Join(memory, "memory.limit_in_bytes"))
+		L = append(L, containerMemMetrics(usage, stat["rss"], stat["cache"], inactiveFile, limit, tags)...)
+	}
+	if oom, err := readKeyValues(filepath.Join(memory, "memory.oom_control")); err == nil {
+		if v, ok := oom["oom_kill"]; ok {
+			L = append(L, CounterValue("container.mem.oom.kill", v, tags...))
+		}
+	}
+
+	// blkio
+	if rb, wb, err := readBlkioV1(filepath.Join(root, "blkio", c.Path, "blkio.throttle.io_service_bytes")); err == nil {
+		L = append(L, CounterValue("container.disk.read.bytes", rb, tags...))
+		L = append(L, CounterValue("container.disk.write.bytes", wb, tags...))
+	}
+	if rio, wio, err := readBlkioV1(filepath.Join(root, "blkio", c.Path, "blkio.throttle.io_serviced")); err == nil {
+		L = append(L, CounterValue("container.disk.read.ios", rio, tags...))
+		L = append(L, CounterValue("container.disk.write.ios", wio, tags...))
+	}
+	return
+}
+
+func cgroupV2Metrics(dir string, id string, tags []string, now time.Time) (L []*model.MetricValue) {
+	// cpu
+	if stat, err := readKeyValues(filepath.Join(dir, "cpu.stat")); err == nil {
+		sample := &containerCpuSample{
+			ts:     now,
+			usage:  stat["usage_usec"] * 1000,
+			user:   stat["user_usec"] * 1000,
+			system: stat["system_usec"] * 1000,
+		}
+		L = append(L, containerCpuMetrics(id, sample, tags)...)
+		L = append(L, CounterValue("container.cpu.throttled.periods", stat["nr_throttled"], tags...))
+		L = append(L, CounterValue("container.cpu.throttled.time", float64(stat["throttled_usec"])/1e6, tags...))
+	}
+
+	// memory
+	if usage, err := readUint(filepath.Join(dir, "memory.current")); err == nil {
+		stat, _ := readKeyValues(filepath.Join(dir, "memory.stat"))
+		// memory.max为max时没有限制
+		limit, _ := readUint(filepath.Join(dir, "memory.max"))
+		L = append(L, containerMemMetrics(usage, stat["anon"], stat["file"], stat["inactive_file"], limit, tags)...)
+	}
+	if events, err := readKeyValues(filepath.Join(dir, "memory.events")); err == nil {
+		L = append(L, CounterValue("container.mem.oom.kill", events["oom_kill"], tags...))
+	}
+
+	// io
+	if rb, wb, rio, wio, err := readIoStatV2(filepath.Join(dir, "io.stat")); err == nil {
+		L = append(L, CounterValue("container.disk.read.bytes", rb, tags...))
+		L = append(L, CounterValue("container.disk.write.bytes", wb, tags...))
+		L = append(L, CounterValue("container.disk.read.ios", rio, tags...))
+		L = append(L, CounterValue("container.disk.write.ios", wio, tags...))
+	}
+	return
+}
+
+// cpu使用率需要两次采集的差值, 100表示占满一个核
+func containerCpuMetrics(id string, sample *containerCpuSample, tags []string) (L []*model.MetricValue) {
+	containerCpuLock.Lock()
+	last := containerCpuHistory[id]
+	containerCpuHistory[id] = sample
+	containerCpuLock.Unlock()
+
+	if last == nil || !sample.ts.After(last.ts) || sample.usage < last.usage {
+		return
+	}
+	elapsed := float64(sample.ts.Sub(last.ts).Nanoseconds())
+	percent := func(cur, prev uint64) float64 {
+		if cur < prev {
+			return 0
+		}
+		return float64(cur-prev) * 100 / elapsed
+	}
+	L = append(L, GaugeValue("container.cpu.used.percent", percent(sample.usage, last.usage), tags...))
+	L = append(L, GaugeValue("container.cpu.user.percent", percent(sample.user, last.user), tags...))
+	L = append(L, GaugeValue("container.cpu.system.percent", percent(sample.system, last.system), tags...))
+	return
+}
+
+// workingset = usage - inactive_file, 与kubelet判断内存压力的口径一致
+func containerMemMetrics(usage, rss, cache, inactiveFile, limit uint64, tags []string) (L []*model.MetricValue) {
+	workingSet := usage
+	if inactiveFile < workingSet {
+		workingSet -= inactiveFile
+	} else {
+		workingSet = 0
+	}
+	L = append(L, GaugeValue("container.mem.usage", usage, tags...))
+	L = append(L, GaugeValue("container.mem.workingset", workingSet, tags...))
+	L = append(L, GaugeValue("container.mem.rss", rss, tags...))
+	L = append(L, GaugeValue("container.mem.cache", cache, tags...))
+	if limit > 0 && limit < cgroupUnlimited {
+		L = append(L, GaugeValue("container.mem.limit", limit, tags...))
+		L = append(L, GaugeValue("container.mem.used.percent", float64(workingSet)*100/float64(limit), tags...))
+	}
+	return
+}
+
+// 网络数据按网络namespace统计, 取容器内任一进程的/proc/<pid>/net/dev;
+// 使用host网络的容器不上报
+func containerNetMetrics(procRoot, cgroupProcs string, tags []string) (L []*model.MetricValue) {
+	pid, err := firstPid(cgroupProcs)
+	if err != nil {
+		return
+	}
+
+	netns, err := os.Readlink(filepath.Join(procRoot, pid, "ns", "net"))
+	if err == nil {
+		if hostns, err := os.Readlink(filepath.Join(procRoot, "1", "ns", "net")); err == nil && hostns == netns {
+			return
+		}
+	}
+
+	f, err := os.Open(filepath.Join(procRoot, pid, "net", "dev"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	// Inter-|   Receive                                                |  Transmit
+	//  face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+	var stat [16]uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		idx := strings.IndexByte(line, ':')
+		if idx < 0 {
+			continue
+		}
+		if strings.TrimSpace(line[:idx]) == "lo" {
+			continue
+		}
+		fields := strings.Fields(line[idx+1:])
+		if len(fields) < 16 {
+			continue
+		}
+		for i := 0; i < 16; i++ {
+			v, _ := strconv.ParseUint(fields[i], 10, 64)
+			stat[i] += v
+		}
+	}
+
+	L = append(L, CounterValue("container.net.in.bytes", stat[0], tags...))
+	L = append(L, CounterValue("container.net.in.packets", stat[1], tags...))
+	L = append(L, CounterValue("container.net.in.errors", stat[2], tags...))
+	L = append(L, CounterValue("container.net.in.dropped", stat[3], tags...))
+	L = append(L, CounterValue("container.net.out.bytes", stat[8], tags...))
+	L = append(L, CounterValue("container.net.out.packets", stat[9], tags...))
+	L = append(L, CounterValue("container.net.out.errors", stat[10], tags...))
+	L = append(L, CounterValue("container.net.out.dropped", stat[11], tags...))
+	return
+}
+
+func firstPid(cgroupProcs string) (string, error) {
+	bs, err := ioutil.ReadFile(cgroupProcs)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(bs), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("no process in %s", cgroupProcs)
+}
+
+func readUint(filename string) (uint64, error) {
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(bs))
+	if s == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// 读取 "key value" 格式的文件, 如memory.stat、cpu.stat
+func readKeyValues(filename string) (map[string]uint64, error) {
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]uint64)
+	for _, line := range strings.Split(string(bs), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			ret[fields[0]] = v
+		}
+	}
+	return ret, nil
+}
+
+// blkio.throttle.io_service_bytes / io_serviced:
+// 8:0 Read 4096
+// 8:0 Write 8192
+// Total 12288
+func readBlkioV1(filename string) (read, write uint64, err error) {
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(bs), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		v, e := strconv.ParseUint(fields[2], 10, 64)
+		if e != nil {
+			continue
+		}
+		switch fields[1] {
+		case "Read":
+			read += v
+		case "Write":
+			write += v
+		}
+	}
+	return
+}
+
+// io.stat:
+// 8:0 rbytes=4096 wbytes=8192 rios=1 wios=2 dbytes=0 dios=0
+func readIoStatV2(filename string) (rbytes, wbytes, rios, wios uint64, err error) {
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(bs), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			v, e := strconv.ParseUint(kv[1], 10, 64)
+			if e != nil {
+				continue
+			}
+			switch kv[0] {
+			case "rbytes":
+				rbytes += v
+			case "wbytes":
+				wbytes += v
+			case "rios":
+				rios += v
+			case "wios":
+				wios += v
+			}
+		}
+	}
+	return
+}
+
+// 通过docker的本地socket获取容器名和label, docker未运行时返回空
+func dockerContainers(socket string) (map[string]*containerInfo, error) {
+	infos := make(map[string]*containerInfo)
+	if _, err := os.Stat(socket); err != nil {
+		return infos, nil
+	}
+
+	client := &http.Client{
+		Timeout: 3 * time.Second,
+		Transport: &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.DialTimeout("unix", socket, 3*time.Second)
+			},
+		},
+	}
+	resp, err := client.Get("http://docker/containers/json")
+	if err != nil {
+		return infos, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return infos, fmt.Errorf("docker api status %s", resp.Status)
+	}
+
+	var containers []struct {
+		Id     string            `json:"Id"`
+		Names  []string          `json:"Names"`
+		Labels map[string]string `json:"Labels"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return infos, err
+	}
+	for _, c := range containers {
+		info := &containerInfo{Labels: c.Labels}
+		if len(c.Names) > 0 {
+			info.Name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		infos[c.Id] = info
+	}
+	return infos, nil
+}
+
+// containerd的API是grpc, 这里不走socket, 直接读取runtime v2为每个运行中的task保存的OCI配置.
+// 容器名取自cri或nerdctl写入的annotation, label即OCI annotations,
+// 用containerd API设置的容器label不会出现在这里
+func containerdContainers(stateDir string) (map[string]*containerInfo, error) {
+	infos := make(map[string]*containerInfo)
+	namespaces, err := readDirNames(stateDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return infos, nil
+		}
+		return infos, err
+	}
+
+	for _, ns := range namespaces {
+		ids, err := readDirNames(filepath.Join(stateDir, ns))
+		if err != nil {
+			continue
+		}
+		for _, id := range ids {
+			bs, err := ioutil.ReadFile(filepath.Join(stateDir, ns, id, "config.json"))
+			if err != nil {
+				continue
+			}
+			var spec struct {
+				Annotations map[string]string `json:"annotations"`
+			}
+			if err := json.Unmarshal(bs, &spec); err != nil {
+				continue
+			}
+			infos[id] = &containerInfo{
+				Name:   containerdName(spec.Annotations),
+				Labels: spec.Annotations,
+			}
+		}
+	}
+	return infos, nil
+}
+
+func containerdName(annotations map[string]string) string {
+	// k8s的pause容器用pod名
+	if annotations["io.kubernetes.cri.container-type"] == "sandbox" {
+		return annotations["io.kubernetes.cri.sandbox-name"]
+	}
+	if name := annotations["io.kubernetes.cri.container-name"]; name != "" {
+		return name
+	}
+	return annotations["nerdctl/name"]
+}
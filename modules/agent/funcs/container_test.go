@@ -0,0 +1,141 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+)
+
+const (
+	testContainerV1 = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	testContainerV2 = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+// metricsByKey 按 metric/tags 索引采集结果
+func metricsByKey(L []*model.MetricValue) map[string]*model.MetricValue {
+	ret := make(map[string]*model.MetricValue)
+	for _, mv := range L {
+		ret[mv.Metric+"/"+mv.Tags] = mv
+	}
+	return ret
+}
+
+func checkMetric(t *testing.T, metrics map[string]*model.MetricValue, key string, expect float64) {
+	mv, ok := metrics[key]
+	if !ok {
+		t.Errorf("metric %s not found", key)
+		return
+	}
+	v, err := strconv.ParseFloat(fmt.Sprint(mv.Value), 64)
+	if err != nil || v != expect {
+		t.Errorf("metric %s expect %v, got %v", key, expect, mv.Value)
+	}
+}
+
+func TestContainerMetricsCgroupV1(t *testing.T) {
+	now := time.Now()
+	containerCpuHistory[testContainerV1] = &containerCpuSample{
+		ts:     now.Add(-10 * time.Second),
+		usage:  1000000000,
+		user:   1000000000,
+		system: 300000000,
+	}
+	infos := map[string]*containerInfo{
+		testContainerV1: {Name: "web", Labels: map[string]string{"app": "nginx,proxy", "env": "prod"}},
+	}
+
+	L := collectContainerMetrics("testdata/container/v1", "testdata/container/proc", infos, []string{"app"}, now)
+	metrics := metricsByKey(L)
+	tags := "container=aaaaaaaaaaaa,name=web,app=nginx_proxy"
+
+	checkMetric(t, metrics, "container.count/", 1)
+	checkMetric(t, metrics, "container.cpu.used.percent/"+tags, 10)
+	checkMetric(t, metrics, "container.cpu.user.percent/"+tags, 5)
+	checkMetric(t, metrics, "container.cpu.system.percent/"+tags, 2)
+	checkMetric(t, metrics, "container.cpu.throttled.periods/"+tags, 5)
+	checkMetric(t, metrics, "container.cpu.throttled.time/"+tags, 2.5)
+	checkMetric(t, metrics, "container.mem.usage/"+tags, 104857600)
+	checkMetric(t, metrics, "container.mem.workingset/"+tags, 94371840)
+	checkMetric(t, metrics, "container.mem.rss/"+tags, 73400320)
+	checkMetric(t, metrics, "container.mem.limit/"+tags, 209715200)
+	checkMetric(t, metrics, "container.mem.used.percent/"+tags, 45)
+	checkMetric(t, metrics, "container.mem.oom.kill/"+tags, 1)
+	checkMetric(t, metrics, "container.disk.read.bytes/"+tags, 5120)
+	checkMetric(t, metrics, "container.disk.write.bytes/"+tags, 8192)
+	checkMetric(t, metrics, "container.disk.read.ios/"+tags, 4)
+	checkMetric(t, metrics, "container.disk.write.ios/"+tags, 2)
+	checkMetric(t, metrics, "container.net.in.bytes/"+tags, 1000)
+	checkMetric(t, metrics, "container.net.out.dropped/"+tags, 4)
+}
+
+func TestContainerMetricsCgroupV2(t *testing.T) {
+	L := collectContainerMetrics("testdata/container/v2", "testdata/container/proc", nil, nil, time.Now())
+	metrics := metricsByKey(L)
+	tags := "container=" + testContainerV2[:12]
+
+	// crio-conmon不是容器
+	checkMetric(t, metrics, "container.count/", 1)
+	// 第一次采集没有cpu使用率
+	if _, ok := metrics["container.cpu.used.percent/"+tags]; ok {
+		t.Error("expect no cpu percent on first collection")
+	}
+	checkMetric(t, metrics, "container.cpu.throttled.periods/"+tags, 2)
+	checkMetric(t, metrics, "container.cpu.throttled.time/"+tags, 0.5)
+	checkMetric(t, metrics, "container.mem.usage/"+tags, 52428800)
+	checkMetric(t, metrics, "container.mem.workingset/"+tags, 47185920)
+	checkMetric(t, metrics, "container.mem.rss/"+tags, 31457280)
+	checkMetric(t, metrics, "container.mem.cache/"+tags, 20971520)
+	if _, ok := metrics["container.mem.limit/"+tags]; ok {
+		t.Error("expect no memory limit when memory.max is max")
+	}
+	checkMetric(t, metrics, "container.disk.read.bytes/"+tags, 5120)
+	checkMetric(t, metrics, "container.disk.write.ios/"+tags, 2)
+	checkMetric(t, metrics, "container.net.out.bytes/"+tags, 2000)
+}
+
+func TestContainerdContainers(t *testing.T) {
+	infos, err := containerdContainers("testdata/container/containerd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 没有config.json的task被忽略
+	if len(infos) != 2 {
+		t.Fatalf("expect 2 containers, got %d", len(infos))
+	}
+
+	info := infos[strings.Repeat("c", 64)]
+	if info == nil || info.Name != "nginx" {
+		t.Fatalf("unexpected container %+v", info)
+	}
+	tags := containerTags(strings.Repeat("c", 64), info, []string{"io.kubernetes.cri.sandbox-namespace"})
+	if strings.Join(tags, ",") != "container=cccccccccccc,name=nginx,io.kubernetes.cri.sandbox-namespace=default" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+
+	if info := infos[strings.Repeat("f", 64)]; info == nil || info.Name != "web-7d4b9c" {
+		t.Errorf("expect sandbox named after its pod, got %+v", info)
+	}
+
+	infos, err = containerdContainers("testdata/container/nonexistent")
+	if err != nil || len(infos) != 0 {
+		t.Errorf("expect no containers without containerd, got %v %v", infos, err)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/toolkits/nux"
+)
+
+// DiscoverServices 找出机器上监听的tcp端口及其进程, 随心跳上报给hbs
+func DiscoverServices() []*model.AgentService {
+	services, err := discoverServices(nux.Root() + "/proc")
+	if err != nil {
+		// 返回nil, hbs不会清掉之前上报的服务
+		log.Println("discover services fail:", err)
+		return nil
+	}
+	return services
+}
+
+func discoverServices(procRoot string) ([]*model.AgentService, error) {
+	sockets, err := readProcNetTcp(procRoot+"/net/tcp", procRoot+"/net/tcp6")
+	if err != nil {
+		return nil, err
+	}
+
+	inodes := make(map[uint64]bool)
+	for _, s := range sockets {
+		if s.State == tcpListen && s.Inode > 0 {
+			inodes[s.Inode] = true
+		}
+	}
+	owners := socketOwners(procRoot, inodes)
+
+	// ipv4和ipv6、SO_REUSEPORT会有多个监听的socket, 按端口和进程去重
+	seen := make(map[string]bool)
+	services := []*model.AgentService{}
+	for _, s := range sockets {
+		if s.State != tcpListen {
+			continue
+		}
+		name := owners[s.Inode]
+		key := strconv.Itoa(int(s.LocalPort)) + "/" + name
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		services = append(services, &model.AgentService{Proto: "tcp", Port: int(s.LocalPort), Name: name})
+	}
+
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Port != services[j].Port {
+			return services[i].Port < services[j].Port
+		}
+		return services[i].Name < services[j].Name
+	})
+	return services, nil
+}
+
+// socketOwners 遍历/proc/<pid>/fd, 返回socket inode => 进程名
+// 没有权限读取的进程会被跳过, 其监听的端口进程名为空
+func socketOwners(procRoot string, inodes map[uint64]bool) map[uint64]string {
+	owners := make(map[uint64]string)
+	if len(inodes) == 0 {
+		return owners
+	}
+
+	pids, err := readDirNames(procRoot)
+	if err != nil {
+		return owners
+	}
+	for _, pid := range pids {
+		if _, err := strconv.Atoi(pid); err != nil {
+			continue
+		}
+		fdDir := filepath.Join(procRoot, pid, "fd")
+		fds, err := readDirNames(fdDir)
+		if err != nil {
+			continue
+		}
+
+		name := ""
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(link[len("socket:["):], "]"), 10, 64)
+			if err != nil || !inodes[inode] {
+				continue
+			}
+			if _, ok := owners[inode]; ok {
+				continue
+			}
+			if name == "" {
+				comm, err := ioutil.ReadFile(filepath.Join(procRoot, pid, "comm"))
+				if err != nil {
+					break
+				}
+				name = strings.TrimSpace(string(comm))
+			}
+			owners[inode] = name
+		}
+
+		if len(owners) == len(inodes) {
+			break
+		}
+	}
+	return owners
+}
+
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
@@ -0,0 +1,47 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"testing"
+)
+
+func TestDiscoverServices(t *testing.T) {
+	services, err := discoverServices("testdata/net/proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 8080在ipv4和ipv6上都有监听, 只上报一次
+	expect := []string{"tcp/3306(mysqld)", "tcp/8080(nginx)"}
+	if len(services) != len(expect) {
+		t.Fatalf("expect %v, got %v", expect, services)
+	}
+	for i, s := range services {
+		if s.String() != expect[i] {
+			t.Errorf("expect %s, got %s", expect[i], s)
+		}
+	}
+
+	if _, err := discoverServices("testdata/net/missing"); err == nil {
+		t.Error("expect error when /proc/net/tcp not found")
+	}
+}
+
+func TestSocketOwnersNotFound(t *testing.T) {
+	owners := socketOwners("testdata/net/proc", map[uint64]bool{1004: true, 9999: true})
+	if len(owners) != 1 || owners[1004] != "mysqld" {
+		t.Errorf("unexpected owners: %v", owners)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+	"github.com/toolkits/nux"
+)
+
+// 每个内存控制器自加载驱动以来的可纠正(ce)和不可纠正(ue)错误数, 以GAUGE上报, 告警时使用diff
+func EdacMetrics() []*model.MetricValue {
+	if !g.Config().Collector.Edac {
+		return nil
+	}
+	return collectEdacMetrics(nux.Root() + "/sys/devices/system/edac/mc")
+}
+
+func collectEdacMetrics(root string) (L []*model.MetricValue) {
+	dirs, err := filepath.Glob(filepath.Join(root, "mc[0-9]*"))
+	if err != nil {
+		return
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		tags := "mc=" + filepath.Base(dir)
+		if ce, err := readUint(filepath.Join(dir, "ce_count")); err == nil {
+			L = append(L, GaugeValue("edac.ce.count", ce, tags))
+		}
+		if ue, err := readUint(filepath.Join(dir, "ue_count")); err == nil {
+			L = append(L, GaugeValue("edac.ue.count", ue, tags))
+		}
+	}
+	return
+}
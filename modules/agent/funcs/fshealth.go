@@ -0,0 +1,158 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+	"github.com/toolkits/nux"
+)
+
+// 文件系统出错时内核会将其重新挂载为只读, 这里上报挂载为只读但是fstab中没有声明ro的文件系统,
+// 以及ext4记录的错误数(/sys/fs/ext4/<dev>/errors_count)
+
+type mountEntry struct {
+	Spec    string
+	File    string
+	Vfstype string
+	Options []string
+}
+
+func FsHealthMetrics() []*model.MetricValue {
+	if !g.Config().Collector.FsHealth {
+		return nil
+	}
+	root := nux.Root()
+	return collectFsHealthMetrics(root+"/proc/mounts", root+"/etc/fstab", root+"/sys/fs/ext4")
+}
+
+// unescapeMount 还原/proc/mounts中转义的空白字符, 如 \040
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func readMounts(filename string) ([]*mountEntry, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ret []*mountEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		ret = append(ret, &mountEntry{
+			Spec:    unescapeMount(fields[0]),
+			File:    unescapeMount(fields[1]),
+			Vfstype: fields[2],
+			Options: strings.Split(fields[3], ","),
+		})
+	}
+	return ret, scanner.Err()
+}
+
+func (m *mountEntry) readonly() bool {
+	for _, opt := range m.Options {
+		if opt == "ro" {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoreMount 与DeviceMetrics使用相同的规则忽略虚拟文件系统
+func ignoreMount(m *mountEntry) bool {
+	if _, ok := nux.FSSPEC_IGNORE[m.Spec]; ok {
+		return true
+	}
+	if _, ok := nux.FSTYPE_IGNORE[m.Vfstype]; ok {
+		return true
+	}
+	return nux.IgnoreFsFile(m.File)
+}
+
+func collectFsHealthMetrics(mountsFile, fstabFile, ext4Root string) (L []*model.MetricValue) {
+	mounts, err := readMounts(mountsFile)
+	if err != nil {
+		return
+	}
+
+	// fstab中声明为ro的挂载点, fstab不存在时所有只读的挂载都上报
+	declaredRo := make(map[string]bool)
+	if fstab, err := readMounts(fstabFile); err == nil {
+		for _, m := range fstab {
+			if m.readonly() {
+				declaredRo[m.File] = true
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, m := range mounts {
+		if ignoreMount(m) || seen[m.File] {
+			continue
+		}
+		seen[m.File] = true
+
+		tags := "mount=" + sanitizeTagValue(m.File) + ",fstype=" + m.Vfstype
+		if m.readonly() && !declaredRo[m.File] {
+			L = append(L, GaugeValue("df.readonly", 1, tags))
+		} else {
+			L = append(L, GaugeValue("df.readonly", 0, tags))
+		}
+
+		if m.Vfstype != "ext4" {
+			continue
+		}
+		// /dev/mapper/vg-root 对应 /sys/fs/ext4/dm-0
+		dev := m.Spec
+		if resolved, err := filepath.EvalSymlinks(dev); err == nil {
+			dev = resolved
+		}
+		if n, err := readUint(filepath.Join(ext4Root, filepath.Base(dev), "errors_count")); err == nil {
+			L = append(L, GaugeValue("df.errors", n, tags))
+		}
+	}
+	return
+}
@@ -20,6 +20,7 @@ import (
 )
 
 type FuncsAndInterval struct {
+	Name     string // 采集项的名字, 用于配置collector.intervals和collector.rollup
 	Fs       []func() []*model.MetricValue
 	Interval int
 }
@@ -30,6 +31,7 @@ func BuildMappers() {
 	interval := g.Config().Transfer.Interval
 	Mappers = []FuncsAndInterval{
 		{
+			Name: "base",
 			Fs: []func() []*model.MetricValue{
 				AgentMetrics,
 				CpuMetrics,
@@ -42,39 +44,85 @@ func BuildMappers() {
 				NetstatMetrics,
 				ProcMetrics,
 				UdpMetrics,
+				TcpMetrics,
 			},
 			Interval: interval,
 		},
 		{
+			Name: "device",
 			Fs: []func() []*model.MetricValue{
 				DeviceMetrics,
 			},
 			Interval: interval,
 		},
 		{
+			Name: "port",
 			Fs: []func() []*model.MetricValue{
 				PortMetrics,
 				SocketStatSummaryMetrics,
+				TcpPortMetrics,
+				ConntrackMetrics,
 			},
 			Interval: interval,
 		},
 		{
+			Name: "du",
 			Fs: []func() []*model.MetricValue{
 				DuMetrics,
 			},
 			Interval: interval,
 		},
 		{
+			Name: "url",
 			Fs: []func() []*model.MetricValue{
 				UrlMetrics,
 			},
 			Interval: interval,
 		},
 		{
+			Name: "gpu",
 			Fs: []func() []*model.MetricValue{
 				GpuMetrics,
 			},
 			Interval: interval,
 		},
+		{
+			Name: "container",
+			Fs: []func() []*model.MetricValue{
+				ContainerMetrics,
+			},
+			Interval: interval,
+		},
+		{
+			Name: "log",
+			Fs: []func() []*model.MetricValue{
+				LogMetrics,
+			},
+			Interval: interval,
+		},
+		{
+			Name: "systemd",
+			Fs: []func() []*model.MetricValue{
+				SystemdMetrics,
+			},
+			Interval: interval,
+		},
+		{
+			Name: "hwhealth",
+			Fs: []func() []*model.MetricValue{
+				HwmonMetrics,
+				EdacMetrics,
+				FsHealthMetrics,
+				NtpMetrics,
+			},
+			Interval: interval,
+		},
+	}
+
+	intervals := g.Config().Collector.Intervals
+	for i := range Mappers {
+		if sec, ok := intervals[Mappers[i].Name]; ok && sec > 0 {
+			Mappers[i].Interval = sec
+		}
 	}
 }
@@ -0,0 +1,97 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestCollectHwmonMetrics(t *testing.T) {
+	metrics := metricsByKey(collectHwmonMetrics("testdata/hwhealth/sys/class/hwmon"))
+	if len(metrics) != 8 {
+		t.Errorf("expect 8 metrics, got %d: %v", len(metrics), metrics)
+	}
+	checkMetric(t, metrics, "hw.temp/chip=coretemp,device=coretemp.0,sensor=Package_id_0", 45)
+	checkMetric(t, metrics, "hw.temp.alarm/chip=coretemp,device=coretemp.0,sensor=Package_id_0", 0)
+	checkMetric(t, metrics, "hw.temp/chip=coretemp,device=coretemp.0,sensor=Core_0", 52.5)
+	checkMetric(t, metrics, "hw.temp.alarm/chip=coretemp,device=coretemp.0,sensor=Core_0", 1)
+	checkMetric(t, metrics, "hw.fan.speed/chip=nct6775,sensor=fan1", 1200)
+	checkMetric(t, metrics, "hw.temp/chip=nct6775,sensor=temp1", 38)
+	checkMetric(t, metrics, "hw.temp.alarm/chip=nct6775,sensor=temp1", 0)
+	checkMetric(t, metrics, "hw.fan.speed/chip=w83627hf,sensor=fan2", 2400)
+}
+
+func TestCollectEdacMetrics(t *testing.T) {
+	metrics := metricsByKey(collectEdacMetrics("testdata/hwhealth/sys/devices/system/edac/mc"))
+	checkMetric(t, metrics, "edac.ce.count/mc=mc0", 3)
+	checkMetric(t, metrics, "edac.ue.count/mc=mc0", 0)
+	checkMetric(t, metrics, "edac.ue.count/mc=mc1", 1)
+}
+
+func TestCollectFsHealthMetrics(t *testing.T) {
+	metrics := metricsByKey(collectFsHealthMetrics(
+		"testdata/hwhealth/proc/mounts",
+		"testdata/hwhealth/etc/fstab",
+		"testdata/hwhealth/sys/fs/ext4",
+	))
+	if len(metrics) != 6 {
+		t.Errorf("expect 6 metrics, got %d: %v", len(metrics), metrics)
+	}
+	checkMetric(t, metrics, "df.readonly/mount=/,fstype=ext4", 0)
+	checkMetric(t, metrics, "df.errors/mount=/,fstype=ext4", 0)
+	// 被重新挂载为只读
+	checkMetric(t, metrics, "df.readonly/mount=/data,fstype=ext4", 1)
+	checkMetric(t, metrics, "df.errors/mount=/data,fstype=ext4", 7)
+	// fstab中声明为只读
+	checkMetric(t, metrics, "df.readonly/mount=/mnt/backup_disk,fstype=xfs", 0)
+	checkMetric(t, metrics, "df.readonly/mount=/boot,fstype=xfs", 0)
+}
+
+func TestParseNtpStatus(t *testing.T) {
+	out, err := ioutil.ReadFile("testdata/hwhealth/chrony_tracking.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := parseChronyTracking(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Synced || st.Stratum != 3 || st.Offset > -0.0123 || st.Offset < -0.0124 {
+		t.Errorf("unexpected chrony status %+v", st)
+	}
+
+	st, err = parseChronyTracking([]byte("00000000,,0,0.000000000,0.000000000,0.000000000,0.000000000,0.000,0.000,0.000,1.000000000,1.000000000,0.0,Not synchronised\n"))
+	if err != nil || st.Synced {
+		t.Errorf("unexpected unsynchronised chrony status %+v %v", st, err)
+	}
+
+	out, err = ioutil.ReadFile("testdata/hwhealth/ntpq.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err = parseNtpq(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Synced || st.Stratum != 2 || st.Offset != -1.25 {
+		t.Errorf("unexpected ntpq status %+v", st)
+	}
+
+	st, _ = parseNtpq([]byte("     remote           refid      st t when poll reach   delay   offset  jitter\n"))
+	if st.Synced {
+		t.Errorf("no system peer should not be synced")
+	}
+}
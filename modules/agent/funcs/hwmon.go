@@ -0,0 +1,119 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+	"github.com/toolkits/nux"
+)
+
+// 温度的单位为毫摄氏度, 风扇转速的单位为rpm, 见内核文档 hwmon/sysfs-interface
+var hwmonInputPattern = regexp.MustCompile(`^(temp|fan)(\d+)_input$`)
+
+func HwmonMetrics() []*model.MetricValue {
+	if !g.Config().Collector.Hwmon {
+		return nil
+	}
+	return collectHwmonMetrics(nux.Root() + "/sys/class/hwmon")
+}
+
+func readSysfsString(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func readSysfsInt(path string) (int64, error) {
+	s, err := readSysfsString(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+func collectHwmonMetrics(root string) (L []*model.MetricValue) {
+	dirs, err := filepath.Glob(filepath.Join(root, "hwmon*"))
+	if err != nil {
+		return
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		// 部分旧的驱动把属性放在device目录下
+		attrDir := dir
+		name, err := readSysfsString(filepath.Join(dir, "name"))
+		if err != nil {
+			attrDir = filepath.Join(dir, "device")
+			if name, err = readSysfsString(filepath.Join(attrDir, "name")); err != nil {
+				continue
+			}
+		}
+
+		chipTags := "chip=" + sanitizeTagValue(name)
+		// 多个相同型号的芯片(如每个cpu一个coretemp)以device区分
+		if link, err := os.Readlink(filepath.Join(dir, "device")); err == nil {
+			chipTags += ",device=" + sanitizeTagValue(filepath.Base(link))
+		}
+
+		files, err := ioutil.ReadDir(attrDir)
+		if err != nil {
+			continue
+		}
+		for _, fi := range files {
+			m := hwmonInputPattern.FindStringSubmatch(fi.Name())
+			if m == nil {
+				continue
+			}
+			prefix := m[1] + m[2]
+			value, err := readSysfsInt(filepath.Join(attrDir, fi.Name()))
+			if err != nil {
+				// 传感器不可用时读取返回错误
+				continue
+			}
+
+			sensor := prefix
+			if label, err := readSysfsString(filepath.Join(attrDir, prefix+"_label")); err == nil && label != "" {
+				sensor = label
+			}
+			tags := chipTags + ",sensor=" + sanitizeTagValue(sensor)
+
+			if m[1] == "fan" {
+				L = append(L, GaugeValue("hw.fan.speed", value, tags))
+				continue
+			}
+
+			L = append(L, GaugeValue("hw.temp", float64(value)/1000, tags))
+			alarm := int64(0)
+			for _, suffix := range []string{"_alarm", "_crit_alarm", "_max_alarm"} {
+				if v, err := readSysfsInt(filepath.Join(attrDir, prefix+suffix)); err == nil && v > alarm {
+					alarm = v
+				}
+			}
+			L = append(L, GaugeValue("hw.temp.alarm", alarm, tags))
+		}
+	}
+	return
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"errors"
+	"syscall"
+)
+
+const (
+	sockDiagByFamily = 20
+	// inet_diag_req_v2之后没有属性
+	inetDiagReqSize = 56
+	// 等待内核响应的超时时间
+	inetDiagTimeout = 5
+)
+
+// inetDiagTcpSockets 通过netlink的sock_diag获取所有tcp socket及其tcp_info
+func inetDiagTcpSockets() ([]*tcpSocket, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, syscall.NETLINK_INET_DIAG)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+
+	tv := syscall.Timeval{Sec: inetDiagTimeout}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return nil, err
+	}
+	sa := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}
+
+	var ret []*tcpSocket
+	for seq, family := range []uint8{syscall.AF_INET, syscall.AF_INET6} {
+		if err := syscall.Sendto(fd, inetDiagRequest(family, uint32(seq+1)), 0, sa); err != nil {
+			return nil, err
+		}
+		sockets, err := inetDiagReceive(fd, uint32(seq+1))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, sockets...)
+	}
+	return ret, nil
+}
+
+func inetDiagRequest(family uint8, seq uint32) []byte {
+	b := make([]byte, syscall.NLMSG_HDRLEN+inetDiagReqSize)
+	// struct nlmsghdr
+	nativeEndian.PutUint32(b[0:4], uint32(len(b)))
+	nativeEndian.PutUint16(b[4:6], sockDiagByFamily)
+	nativeEndian.PutUint16(b[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_DUMP)
+	nativeEndian.PutUint32(b[8:12], seq)
+	// struct inet_diag_req_v2
+	req := b[syscall.NLMSG_HDRLEN:]
+	req[0] = family
+	req[1] = syscall.IPPROTO_TCP
+	req[2] = 1 << (inetDiagInfo - 1)
+	// 所有状态
+	nativeEndian.PutUint32(req[4:8], 0xffffffff)
+	return b
+}
+
+func inetDiagReceive(fd int, seq uint32) ([]*tcpSocket, error) {
+	var ret []*tcpSocket
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != seq {
+				continue
+			}
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return ret, nil
+			case syscall.NLMSG_ERROR:
+				return nil, errors.New("inet_diag: netlink error")
+			}
+			s, err := parseInetDiagMsg(m.Data)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, s)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+)
+
+// 单行日志的最大长度, 超过的部分被丢弃
+const maxLogLineSize = 64 * 1024
+
+type logOffset struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+type logFile struct {
+	path    string
+	f       *os.File
+	inode   uint64
+	offset  int64
+	partial []byte // 还没有读到换行符的内容
+}
+
+type logValueStat struct {
+	sum   float64
+	max   float64
+	count int
+}
+
+type logRuleStat struct {
+	added  bool // 本次Poll新增的规则
+	rule   *g.LogRule
+	re     *regexp.Regexp
+	tags   string
+	count  int
+	values map[string]*logValueStat // 命名分组 => 统计值
+}
+
+// LogTailer 跟踪日志文件的新增内容, 统计各个规则的匹配次数及提取出的数值.
+// 文件被轮转(inode变化)时读完旧文件后从头读取新文件, 被截断时从头读取;
+// 各个文件的读取位置保存在offsetFile中, 重启后从上次的位置继续读取
+type LogTailer struct {
+	sync.Mutex
+	offsetFile string
+	started    bool
+	saved      []byte
+	files      map[string]*logFile
+	rules      map[string]*logRuleStat // key = path + pattern
+	offsets    map[string]*logOffset
+}
+
+func NewLogTailer(offsetFile string) *LogTailer {
+	t := &LogTailer{
+		offsetFile: offsetFile,
+		files:      make(map[string]*logFile),
+		rules:      make(map[string]*logRuleStat),
+		offsets:    make(map[string]*logOffset),
+	}
+	if offsetFile != "" {
+		if bs, err := ioutil.ReadFile(offsetFile); err == nil {
+			if err := json.Unmarshal(bs, &t.offsets); err != nil {
+				log.Println("parse log offset file fail:", err)
+			}
+		}
+	}
+	return t
+}
+
+var (
+	logTailer     *LogTailer
+	logTailerLock = new(sync.Mutex)
+)
+
+// 配置文件和hbs下发的日志监控规则
+func LogRules() []*g.LogRule {
+	rules := []*g.LogRule{}
+	if cfg := g.Config().Log; cfg != nil && cfg.Enabled {
+		rules = append(rules, cfg.Rules...)
+	}
+	return append(rules, g.ReportLogs()...)
+}
+
+// 每秒读取一次日志文件的新增内容, 由cron调用
+func TailLogs() {
+	rules := LogRules()
+
+	logTailerLock.Lock()
+	if logTailer == nil {
+		if len(rules) == 0 {
+			logTailerLock.Unlock()
+			return
+		}
+		offsetFile := ""
+		if cfg := g.Config().Log; cfg != nil {
+			offsetFile = cfg.OffsetFile
+		}
+		logTailer = NewLogTailer(offsetFile)
+	}
+	t := logTailer
+	logTailerLock.Unlock()
+
+	t.Poll(rules)
+	if err := t.SaveOffsets(); err != nil {
+		log.Println("save log offsets fail:", err)
+	}
+}
+
+func LogMetrics() []*model.MetricValue {
+	logTailerLock.Lock()
+	t := logTailer
+	logTailerLock.Unlock()
+	if t == nil {
+		return nil
+	}
+	return t.Collect()
+}
+
+func logRuleKey(rule *g.LogRule) string {
+	return rule.Path + "\x00" + rule.Pattern
+}
+
+func (t *LogTailer) updateRules(rules []*g.LogRule) {
+	keep := make(map[string]bool)
+	for _, rs := range t.rules {
+		rs.added = false
+	}
+	for _, rule := range rules {
+		key := logRuleKey(rule)
+		keep[key] = true
+		if _, ok := t.rules[key]; ok {
+			continue
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			log.Printf("bad log pattern %q: %v", rule.Pattern, err)
+			continue
+		}
+		name := rule.Name
+		if name == "" {
+			name = rule.Pattern
+		}
+		tags := "path=" + sanitizeTagValue(rule.Path) + ",pattern=" + sanitizeTagValue(name)
+		if rule.Tags != "" {
+			tags += "," + rule.Tags
+		}
+		t.rules[key] = &logRuleStat{
+			added:  true,
+			rule:   rule,
+			re:     re,
+			tags:   tags,
+			values: make(map[string]*logValueStat),
+		}
+	}
+	for key := range t.rules {
+		if !keep[key] {
+			delete(t.rules, key)
+		}
+	}
+}
+
+// Poll 读取所有匹配的日志文件的新增内容
+func (t *LogTailer) Poll(rules []*g.LogRule) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.updateRules(rules)
+
+	pathRules := make(map[string][]*logRuleStat)
+	for _, rs := range t.rules {
+		matches, err := filepath.Glob(rs.rule.Path)
+		if err != nil {
+			continue
+		}
+		for _, path := range matches {
+			pathRules[path] = append(pathRules[path], rs)
+		}
+	}
+
+	for path, lf := range t.files {
+		if _, ok := pathRules[path]; !ok {
+			lf.f.Close()
+			delete(t.files, path)
+			delete(t.offsets, path)
+		}
+	}
+
+	for path, rs := range pathRules {
+		lf, ok := t.files[path]
+		if !ok {
+			// agent启动时或者新增规则时已经存在的文件从末尾开始读,
+			// 之后新出现的文件(如轮转后新建的文件)从头开始读
+			fromStart := t.started
+			for _, r := range rs {
+				fromStart = fromStart && !r.added
+			}
+			var err error
+			if lf, err = t.open(path, fromStart); err != nil {
+				continue
+			}
+			t.files[path] = lf
+		}
+		t.read(lf, rs)
+		t.checkRotate(lf, rs)
+	}
+	t.started = true
+}
+
+func fileInode(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
+
+// 打开文件, 有保存的读取位置时从该位置继续读取
+func (t *LogTailer) open(path string, fromStart bool) (*logFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	lf := &logFile{path: path, f: f, inode: fileInode(fi)}
+	if saved, ok := t.offsets[path]; ok && saved.Inode == lf.inode && saved.Offset <= fi.Size() {
+		lf.offset = saved.Offset
+	} else if !fromStart {
+		lf.offset = fi.Size()
+	}
+	if _, err := f.Seek(lf.offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	t.offsets[path] = &logOffset{Inode: lf.inode, Offset: lf.offset}
+	return lf, nil
+}
+
+func (t *LogTailer) read(lf *logFile, rules []*logRuleStat) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := lf.f.Read(buf)
+		if n > 0 {
+			lf.offset += int64(n)
+			data := buf[:n]
+			for {
+				idx := bytes.IndexByte(data, '\n')
+				if idx < 0 {
+					if len(lf.partial)+len(data) <= maxLogLineSize {
+						lf.partial = append(lf.partial, data...)
+					}
+					break
+				}
+				line := data[:idx]
+				if len(lf.partial) > 0 {
+					line = append(lf.partial, line...)
+					lf.partial = lf.partial[:0]
+				}
+				t.match(line, rules)
+				data = data[idx+1:]
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	t.offsets[lf.path] = &logOffset{Inode: lf.inode, Offset: lf.offset}
+}
+
+// 文件被删除、轮转或截断时, 关闭旧文件或者从头读取
+func (t *LogTailer) checkRotate(lf *logFile, rules []*logRuleStat) {
+	fi, err := os.Stat(lf.path)
+	if err != nil || fileInode(fi) != lf.inode {
+		// 轮转前最后写入的内容还在旧文件里, 读完再关闭
+		t.read(lf, rules)
+		lf.f.Close()
+		delete(t.files, lf.path)
+		delete(t.offsets, lf.path)
+		if err == nil {
+			// 立即读取轮转后新建的文件
+			if nf, err := t.open(lf.path, true); err == nil {
+				t.files[lf.path] = nf
+				t.read(nf, rules)
+			}
+		}
+		return
+	}
+	if fi.Size() < lf.offset {
+		lf.offset = 0
+		lf.partial = lf.partial[:0]
+		lf.f.Seek(0, io.SeekStart)
+		t.read(lf, rules)
+	}
+}
+
+func (t *LogTailer) match(line []byte, rules []*logRuleStat) {
+	for _, rs := range rules {
+		m := rs.re.FindSubmatch(line)
+		if m == nil {
+			continue
+		}
+		rs.count++
+		for i, name := range rs.re.SubexpNames() {
+			if name == "" || m[i] == nil {
+				continue
+			}
+			v, err := strconv.ParseFloat(string(m[i]), 64)
+			if err != nil {
+				continue
+			}
+			stat, ok := rs.values[name]
+			if !ok {
+				stat = &logValueStat{}
+				rs.values[name] = stat
+			}
+			if stat.count == 0 || v > stat.max {
+				stat.max = v
+			}
+			stat.sum += v
+			stat.count++
+		}
+	}
+}
+
+// Collect 返回上次调用以来各个规则的匹配次数, 以及提取出的数值的平均值和最大值
+func (t *LogTailer) Collect() (L []*model.MetricValue) {
+	t.Lock()
+	defer t.Unlock()
+	for _, rs := range t.rules {
+		L = append(L, GaugeValue(g.LOG_MATCH_COUNT, rs.count, rs.tags))
+		rs.count = 0
+		for name, stat := range rs.values {
+			if stat.count == 0 {
+				continue
+			}
+			tags := rs.tags + ",field=" + name
+			L = append(L, GaugeValue(g.LOG_MATCH_VALUE, stat.sum/float64(stat.count), tags))
+			L = append(L, GaugeValue(g.LOG_MATCH_VALUE+".max", stat.max, tags))
+			rs.values[name] = &logValueStat{}
+		}
+	}
+	return
+}
+
+// SaveOffsets 将各个文件的读取位置写入offsetFile
+func (t *LogTailer) SaveOffsets() error {
+	if t.offsetFile == "" {
+		return nil
+	}
+	t.Lock()
+	defer t.Unlock()
+	bs, err := json.Marshal(t.offsets)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(bs, t.saved) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(t.offsetFile), 0755); err != nil {
+		return err
+	}
+	tmp := t.offsetFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, bs, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, t.offsetFile); err != nil {
+		return err
+	}
+	t.saved = bs
+	return nil
+}
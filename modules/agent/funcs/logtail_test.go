@@ -0,0 +1,124 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+)
+
+func appendLog(t *testing.T, path string, lines ...string) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, line := range lines {
+		fmt.Fprintln(f, line)
+	}
+}
+
+func TestLogTailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logtail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	offsetFile := filepath.Join(dir, "var", "offsets.json")
+	appendLog(t, path, "ERROR before start")
+
+	rules := []*g.LogRule{
+		{Name: "error", Path: filepath.Join(dir, "*.log"), Pattern: `ERROR`},
+		{Name: "latency", Path: path, Pattern: `cost=(?P<cost>[0-9.]+)ms`},
+	}
+	errorTags := "path=" + filepath.Join(dir, "*.log") + ",pattern=error"
+	costTags := "path=" + path + ",pattern=latency"
+
+	tailer := NewLogTailer(offsetFile)
+	tailer.Poll(rules)
+
+	// 启动时已经存在的内容不统计
+	appendLog(t, path, "ERROR one", "INFO cost=10ms", "ERROR two cost=30ms")
+	tailer.Poll(rules)
+	metrics := metricsByKey(tailer.Collect())
+	checkMetric(t, metrics, "log.match.count/"+errorTags, 2)
+	checkMetric(t, metrics, "log.match.count/"+costTags, 2)
+	checkMetric(t, metrics, "log.match.value/"+costTags+",field=cost", 20)
+	checkMetric(t, metrics, "log.match.value.max/"+costTags+",field=cost", 30)
+
+	// Collect之后计数清零
+	metrics = metricsByKey(tailer.Collect())
+	checkMetric(t, metrics, "log.match.count/"+errorTags, 0)
+	if _, ok := metrics["log.match.value/"+costTags+",field=cost"]; ok {
+		t.Error("expect no value without new matches")
+	}
+
+	// 没有换行符的行等写完后再统计
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString("ERR")
+	tailer.Poll(rules)
+	f.WriteString("OR partial\n")
+	f.Close()
+	tailer.Poll(rules)
+	checkMetric(t, metricsByKey(tailer.Collect()), "log.match.count/"+errorTags, 1)
+
+	// 轮转: 旧文件中未读的内容和新文件的内容都要统计
+	appendLog(t, path, "ERROR before rotate")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLog(t, path, "ERROR after rotate")
+	tailer.Poll(rules)
+	checkMetric(t, metricsByKey(tailer.Collect()), "log.match.count/"+errorTags, 2)
+
+	// 在读取和检查轮转之间写入旧文件的内容也要统计
+	appendLog(t, path, "ERROR before rotate")
+	if err := os.Rename(path, path+".2"); err != nil {
+		t.Fatal(err)
+	}
+	appendLog(t, path+".2", "ERROR late write")
+	appendLog(t, path, "ERROR after rotate")
+	var rs []*logRuleStat
+	for _, r := range tailer.rules {
+		rs = append(rs, r)
+	}
+	tailer.checkRotate(tailer.files[path], rs)
+	checkMetric(t, metricsByKey(tailer.Collect()), "log.match.count/"+errorTags, 3)
+
+	// 截断
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	tailer.Poll(rules)
+	appendLog(t, path, "ERROR after truncate")
+	tailer.Poll(rules)
+	checkMetric(t, metricsByKey(tailer.Collect()), "log.match.count/"+errorTags, 1)
+
+	// 重启后从保存的位置继续读取
+	if err := tailer.SaveOffsets(); err != nil {
+		t.Fatal(err)
+	}
+	appendLog(t, path, "ERROR while stopped")
+	tailer = NewLogTailer(offsetFile)
+	tailer.Poll(rules)
+	checkMetric(t, metricsByKey(tailer.Collect()), "log.match.count/"+errorTags, 1)
+}
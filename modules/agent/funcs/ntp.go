@@ -0,0 +1,139 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+	"github.com/toolkits/sys"
+)
+
+// 本机时钟与ntp服务器的偏移, 优先使用chronyc, 没有chrony时使用ntpq
+
+const ntpCmdTimeout = 5 * time.Second
+
+type ntpStatus struct {
+	Offset  float64 // 毫秒, 正数表示本机时钟快
+	Stratum int
+	Synced  bool
+}
+
+func NtpMetrics() (L []*model.MetricValue) {
+	if !g.Config().Collector.Ntp {
+		return
+	}
+
+	var st *ntpStatus
+	out, err := ntpCmd("chronyc", "-c", "tracking")
+	if err == nil {
+		st, err = parseChronyTracking(out)
+	} else if out, err = ntpCmd("ntpq", "-pn"); err == nil {
+		st, err = parseNtpq(out)
+	}
+	if err != nil {
+		if g.Config().Debug {
+			log.Println("collect ntp status fail:", err)
+		}
+		return
+	}
+
+	if st.Synced {
+		L = append(L, GaugeValue("ntp.synced", 1))
+	} else {
+		L = append(L, GaugeValue("ntp.synced", 0))
+		return
+	}
+	L = append(L, GaugeValue("ntp.offset", st.Offset))
+	L = append(L, GaugeValue("ntp.stratum", st.Stratum))
+	return
+}
+
+func ntpCmd(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	err, isTimeout := sys.CmdRunWithTimeout(cmd, ntpCmdTimeout)
+	if isTimeout {
+		return nil, fmt.Errorf("%s timeout", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// parseChronyTracking 解析 chronyc -c tracking 的输出:
+// ref id,ref name,stratum,ref time,system time,last offset,rms offset,frequency,...,leap status
+// system time为chronyd正在校正的偏移(秒), 正数表示本机时钟慢
+func parseChronyTracking(out []byte) (*ntpStatus, error) {
+	fields := strings.Split(strings.TrimSpace(string(out)), ",")
+	if len(fields) < 14 {
+		return nil, errors.New("unexpected chronyc tracking output")
+	}
+	stratum, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	offset, err := strconv.ParseFloat(fields[4], 64)
+	if err != nil {
+		return nil, err
+	}
+	leap := fields[len(fields)-1]
+	return &ntpStatus{
+		Offset:  -offset * 1000,
+		Stratum: stratum,
+		Synced:  leap != "Not synchronised" && stratum > 0 && stratum < 16,
+	}, nil
+}
+
+// parseNtpq 解析 ntpq -pn 的输出, 以*开头的行是当前同步的服务器, offset的单位为毫秒,
+// 为服务器时间减去本机时间, 即正数表示本机时钟慢
+func parseNtpq(out []byte) (*ntpStatus, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "*") {
+			continue
+		}
+		// remote refid st t when poll reach delay offset jitter
+		fields := strings.Fields(line[1:])
+		if len(fields) < 10 {
+			return nil, errors.New("unexpected ntpq output")
+		}
+		stratum, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		offset, err := strconv.ParseFloat(fields[8], 64)
+		if err != nil {
+			return nil, err
+		}
+		return &ntpStatus{Offset: -offset, Stratum: stratum, Synced: true}, nil
+	}
+	return &ntpStatus{}, scanner.Err()
+}
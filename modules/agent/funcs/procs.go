@@ -15,11 +15,45 @@
 package funcs
 
 import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/open-falcon/falcon-plus/common/model"
 	"github.com/open-falcon/falcon-plus/modules/agent/g"
 	"github.com/toolkits/nux"
-	"log"
-	"strings"
+)
+
+// /proc/<pid>/stat中时间的单位, 即USER_HZ
+const clockTicks = 100
+
+// 进程的资源使用情况
+type procDetail struct {
+	Pid        int
+	StartTime  uint64 // 进程启动时间, 系统启动后的ticks
+	CpuTicks   uint64 // utime + stime
+	Threads    uint64
+	Rss        uint64 // 字节
+	Fds        uint64
+	ReadBytes  uint64
+	WriteBytes uint64
+}
+
+type procCpuSample struct {
+	startTime uint64
+	cpuTicks  uint64
+	ts        time.Time
+}
+
+var (
+	procCpuHistory = make(map[int]*procCpuSample)
+	procCpuLock    = new(sync.Mutex)
 )
 
 func ProcMetrics() (L []*model.MetricValue) {
@@ -36,22 +70,177 @@ func ProcMetrics() (L []*model.MetricValue) {
 		return
 	}
 
+	return collectProcMetrics(nux.Root()+"/proc", ps, reportProcs, time.Now())
+}
+
+func collectProcMetrics(procRoot string, ps []*nux.Proc, reportProcs map[string]map[int]string, now time.Time) (L []*model.MetricValue) {
+	btime, err := readBootTime(procRoot)
+	if err != nil {
+		log.Println(err)
+	}
+
+	// 同一个进程可能属于多个进程组, 只读取一次
+	details := make(map[int]*procDetail)
 	pslen := len(ps)
 
 	for tags, m := range reportProcs {
 		cnt := 0
+		group := []*procDetail{}
 		for i := 0; i < pslen; i++ {
-			if is_a(ps[i], m) {
-				cnt++
+			if !is_a(ps[i], m) {
+				continue
+			}
+			cnt++
+
+			d, ok := details[ps[i].Pid]
+			if !ok {
+				d, err = readProcDetail(procRoot, ps[i].Pid)
+				if err != nil {
+					// 进程已经退出
+					continue
+				}
+				details[ps[i].Pid] = d
 			}
+			group = append(group, d)
 		}
 
 		L = append(L, GaugeValue(g.PROC_NUM, cnt, tags))
+		if len(group) > 0 {
+			L = append(L, procGroupMetrics(group, btime, now, tags)...)
+		}
 	}
 
+	updateProcCpuHistory(details, now)
 	return
 }
 
+func procGroupMetrics(group []*procDetail, btime int64, now time.Time, tags string) (L []*model.MetricValue) {
+	var rss, fds, threads, readBytes, writeBytes uint64
+	var minStart uint64
+	var cpuTicks float64
+	var elapsed float64
+
+	procCpuLock.Lock()
+	for i, d := range group {
+		rss += d.Rss
+		fds += d.Fds
+		threads += d.Threads
+		readBytes += d.ReadBytes
+		writeBytes += d.WriteBytes
+		if i == 0 || d.StartTime < minStart {
+			minStart = d.StartTime
+		}
+
+		// 只统计上次采集时已经存在的进程
+		last, ok := procCpuHistory[d.Pid]
+		if ok && last.startTime == d.StartTime && d.CpuTicks >= last.cpuTicks && now.After(last.ts) {
+			cpuTicks += float64(d.CpuTicks - last.cpuTicks)
+			elapsed = now.Sub(last.ts).Seconds()
+		}
+	}
+	procCpuLock.Unlock()
+
+	if elapsed > 0 {
+		// 100表示占满一个核
+		L = append(L, GaugeValue(g.PROC_CPU_PERCENT, cpuTicks/clockTicks/elapsed*100, tags))
+	}
+	L = append(L, GaugeValue(g.PROC_MEM_RSS, rss, tags))
+	L = append(L, GaugeValue(g.PROC_FD_NUM, fds, tags))
+	L = append(L, GaugeValue(g.PROC_THREAD_NUM, threads, tags))
+	L = append(L, CounterValue(g.PROC_IO_READ, readBytes, tags))
+	L = append(L, CounterValue(g.PROC_IO_WRITE, writeBytes, tags))
+	if btime > 0 {
+		// 进程组中最早启动的进程
+		uptime := now.Unix() - btime - int64(minStart/clockTicks)
+		if uptime < 0 {
+			uptime = 0
+		}
+		L = append(L, GaugeValue(g.PROC_UPTIME, uptime, tags))
+	}
+	return
+}
+
+func updateProcCpuHistory(details map[int]*procDetail, now time.Time) {
+	procCpuLock.Lock()
+	defer procCpuLock.Unlock()
+	for pid := range procCpuHistory {
+		if _, ok := details[pid]; !ok {
+			delete(procCpuHistory, pid)
+		}
+	}
+	for pid, d := range details {
+		procCpuHistory[pid] = &procCpuSample{startTime: d.StartTime, cpuTicks: d.CpuTicks, ts: now}
+	}
+}
+
+// 从/proc/<pid>下读取进程的资源使用情况, fd和io需要有权限才能读取, 读取失败时为0
+func readProcDetail(procRoot string, pid int) (*procDetail, error) {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	// pid (comm) state ppid ..., comm中可能包含空格和括号
+	content := string(bs)
+	idx := strings.LastIndexByte(content, ')')
+	if idx < 0 {
+		return nil, fmt.Errorf("bad stat file of pid %d", pid)
+	}
+	fields := strings.Fields(content[idx+1:])
+	// fields[0]是第3列state, utime/stime/num_threads/starttime/rss分别是第14/15/20/22/24列
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("bad stat file of pid %d", pid)
+	}
+	field := func(n int) uint64 {
+		v, _ := strconv.ParseUint(fields[n-3], 10, 64)
+		return v
+	}
+
+	d := &procDetail{
+		Pid:       pid,
+		CpuTicks:  field(14) + field(15),
+		Threads:   field(20),
+		StartTime: field(22),
+		Rss:       field(24) * uint64(os.Getpagesize()),
+	}
+
+	if fds, err := ioutil.ReadDir(filepath.Join(dir, "fd")); err == nil {
+		d.Fds = uint64(len(fds))
+	}
+
+	if bs, err := ioutil.ReadFile(filepath.Join(dir, "io")); err == nil {
+		for _, line := range strings.Split(string(bs), "\n") {
+			kv := strings.SplitN(line, ":", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			v, _ := strconv.ParseUint(strings.TrimSpace(kv[1]), 10, 64)
+			switch kv[0] {
+			case "read_bytes":
+				d.ReadBytes = v
+			case "write_bytes":
+				d.WriteBytes = v
+			}
+		}
+	}
+	return d, nil
+}
+
+// 系统启动时间, /proc/stat中的btime
+func readBootTime(procRoot string) (int64, error) {
+	bs, err := ioutil.ReadFile(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(bs), "\n") {
+		if strings.HasPrefix(line, "btime ") {
+			return strconv.ParseInt(strings.TrimSpace(line[6:]), 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("no btime in %s/stat", procRoot)
+}
+
 func is_a(p *nux.Proc, m map[int]string) bool {
 	// only one kv pair
 	for key, val := range m {
@@ -0,0 +1,74 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/toolkits/nux"
+)
+
+func TestCollectProcMetrics(t *testing.T) {
+	now := time.Unix(1600001000, 0)
+	ps := []*nux.Proc{
+		{Pid: 10, Name: "nginx", Cmdline: "nginx: master process"},
+		{Pid: 11, Name: "nginx", Cmdline: "nginx: worker process"},
+		{Pid: 12, Name: "redis-server", Cmdline: "redis-server *:6379"},
+		{Pid: 13, Name: "nginx", Cmdline: "nginx: exited"},
+	}
+	reportProcs := map[string]map[int]string{
+		"name=nginx": {1: "nginx"},
+		"name=java":  {1: "java"},
+	}
+	procCpuHistory = map[int]*procCpuSample{
+		10: {startTime: 1000, cpuTicks: 200, ts: now.Add(-10 * time.Second)},
+		// pid被重用, 不参与cpu计算
+		11: {startTime: 999, cpuTicks: 10, ts: now.Add(-10 * time.Second)},
+	}
+
+	L := collectProcMetrics("testdata/proc", ps, reportProcs, now)
+
+	nginx := map[string]*model.MetricValue{}
+	java := map[string]*model.MetricValue{}
+	for _, mv := range L {
+		switch mv.Tags {
+		case "name=nginx":
+			nginx[mv.Metric] = mv
+		case "name=java":
+			java[mv.Metric] = mv
+		}
+	}
+
+	checkMetric(t, nginx, "proc.num", 3)
+	checkMetric(t, nginx, "proc.cpu.percent", 20)
+	checkMetric(t, nginx, "proc.mem.rss", float64((2560+1024)*os.Getpagesize()))
+	checkMetric(t, nginx, "proc.fd.num", 5)
+	checkMetric(t, nginx, "proc.thread.num", 5)
+	checkMetric(t, nginx, "proc.io.read.bytes", 5120)
+	checkMetric(t, nginx, "proc.io.write.bytes", 2048)
+	checkMetric(t, nginx, "proc.uptime", 990)
+
+	checkMetric(t, java, "proc.num", 0)
+	if len(java) != 1 {
+		t.Errorf("expect only proc.num for unmatched group, got %v", java)
+	}
+
+	if len(procCpuHistory) != 2 || procCpuHistory[11].startTime != 2000 {
+		t.Errorf("unexpected cpu history %v", procCpuHistory)
+	}
+}
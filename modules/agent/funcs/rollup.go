@@ -0,0 +1,140 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+)
+
+// Rollup 汇总一个上报周期内多次采样的结果.
+// GAUGE类型上报平均值(沿用原来的metric名, 已有的策略和图表不受影响), 以及 .min/.max 和各个分位值 .p<N>;
+// COUNTER等其他类型的采样只保留最新值, 由transfer按上报周期计算速率
+type Rollup struct {
+	sync.Mutex
+	percentiles []float64
+	keys        []string
+	series      map[string]*rollupSeries
+}
+
+type rollupSeries struct {
+	last   *model.MetricValue
+	values []float64
+}
+
+func NewRollup(percentiles []float64) *Rollup {
+	return &Rollup{
+		percentiles: percentiles,
+		series:      make(map[string]*rollupSeries),
+	}
+}
+
+func (r *Rollup) Add(mvs []*model.MetricValue) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, mv := range mvs {
+		key := mv.Metric + "/" + mv.Tags + "/" + mv.Type
+		s, ok := r.series[key]
+		if !ok {
+			s = &rollupSeries{}
+			r.series[key] = s
+			r.keys = append(r.keys, key)
+		}
+		s.last = mv
+		if mv.Type != "GAUGE" {
+			continue
+		}
+		if v, ok := rollupFloat(mv.Value); ok && !math.IsNaN(v) && !math.IsInf(v, 0) {
+			s.values = append(s.values, v)
+		}
+	}
+}
+
+// Flush 返回本周期的汇总结果并清空, 按metric第一次出现的顺序排列
+func (r *Rollup) Flush() (L []*model.MetricValue) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, key := range r.keys {
+		s := r.series[key]
+		if len(s.values) == 0 {
+			L = append(L, s.last)
+			continue
+		}
+
+		sort.Float64s(s.values)
+		sum := 0.0
+		for _, v := range s.values {
+			sum += v
+		}
+		L = append(L, s.derive("", sum/float64(len(s.values))))
+		L = append(L, s.derive(".min", s.values[0]))
+		L = append(L, s.derive(".max", s.values[len(s.values)-1]))
+		for _, p := range r.percentiles {
+			suffix := ".p" + strings.Replace(strconv.FormatFloat(p, 'f', -1, 64), ".", "_", -1)
+			L = append(L, s.derive(suffix, rollupPercentile(s.values, p)))
+		}
+	}
+
+	r.keys = nil
+	r.series = make(map[string]*rollupSeries)
+	return
+}
+
+func (s *rollupSeries) derive(suffix string, val float64) *model.MetricValue {
+	return &model.MetricValue{
+		Metric: s.last.Metric + suffix,
+		Value:  val,
+		Type:   s.last.Type,
+		Tags:   s.last.Tags,
+	}
+}
+
+func rollupFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case float32:
+		return float64(x), true
+	case int:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	}
+	f, err := strconv.ParseFloat(fmt.Sprint(v), 64)
+	return f, err == nil
+}
+
+// 取排序后样本的分位值(nearest-rank)
+func rollupPercentile(sorted []float64, p float64) float64 {
+	n := len(sorted)
+	idx := int(math.Ceil(p/100*float64(n))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= n {
+		idx = n - 1
+	}
+	return sorted[idx]
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"testing"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+)
+
+func TestRollup(t *testing.T) {
+	r := NewRollup([]float64{50, 99.9})
+	for i := 1; i <= 10; i++ {
+		r.Add([]*model.MetricValue{
+			GaugeValue("cpu.idle", float64(100-i*5)),
+			GaugeValue("load.1min", i, "core=all"),
+			CounterValue("net.if.in.bytes", uint64(i*1000), "iface=eth0"),
+		})
+	}
+	r.Add([]*model.MetricValue{GaugeValue("agent.version", "5.1.2")})
+
+	L := r.Flush()
+	metrics := metricsByKey(L)
+	if len(L) != 12 {
+		t.Errorf("expect 12 metrics, got %d: %v", len(L), L)
+	}
+	if L[0].Metric != "cpu.idle" {
+		t.Errorf("expect cpu.idle first, got %s", L[0].Metric)
+	}
+	checkMetric(t, metrics, "cpu.idle/", 72.5)
+	checkMetric(t, metrics, "cpu.idle.min/", 50)
+	checkMetric(t, metrics, "cpu.idle.max/", 95)
+	checkMetric(t, metrics, "cpu.idle.p50/", 70)
+	checkMetric(t, metrics, "cpu.idle.p99_9/", 95)
+	checkMetric(t, metrics, "load.1min/core=all", 5.5)
+	checkMetric(t, metrics, "load.1min.max/core=all", 10)
+	checkMetric(t, metrics, "net.if.in.bytes/iface=eth0", 10000)
+	if mv := metrics["net.if.in.bytes/iface=eth0"]; mv == nil || mv.Type != "COUNTER" {
+		t.Errorf("expect counter to keep its type, got %v", mv)
+	}
+	if mv := metrics["agent.version/"]; mv == nil || mv.Value != "5.1.2" {
+		t.Errorf("expect non-numeric gauge to keep last value, got %v", mv)
+	}
+
+	if L := r.Flush(); len(L) != 0 {
+		t.Errorf("expect empty after flush, got %v", L)
+	}
+}
@@ -37,3 +37,23 @@ func UdpMetrics() []*model.MetricValue {
 
 	return ret
 }
+
+var tcpSnmpCounters = []string{"ActiveOpens", "PassiveOpens", "AttemptFails", "EstabResets", "InSegs", "OutSegs", "RetransSegs", "InErrs", "OutRsts"}
+
+// TcpMetrics 上报/proc/net/snmp中的tcp计数器, 重传率为RetransSegs/OutSegs
+func TcpMetrics() (L []*model.MetricValue) {
+	tcp, err := nux.Snmp("Tcp")
+	if err != nil {
+		log.Println("read snmp fail", err)
+		return
+	}
+	for _, key := range tcpSnmpCounters {
+		if v, ok := tcp[key]; ok {
+			L = append(L, CounterValue("snmp.Tcp."+key, v))
+		}
+	}
+	if v, ok := tcp["CurrEstab"]; ok {
+		L = append(L, GaugeValue("snmp.Tcp.CurrEstab", v))
+	}
+	return
+}
@@ -0,0 +1,283 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+	"github.com/toolkits/sys"
+)
+
+// 通过systemctl获取unit的状态, 内存、cpu和tasks需要开启对应的accounting
+
+const systemctlTimeout = 10 * time.Second
+
+// systemctl show中未设置的值
+const systemdUnset = "18446744073709551615"
+
+const systemdShowProperties = "Id,LoadState,ActiveState,SubState,NRestarts,MemoryCurrent,CPUUsageNSec,TasksCurrent"
+
+// systemd.unit.active.state的值
+var systemdActiveStates = map[string]int{
+	"active":       0,
+	"reloading":    1,
+	"inactive":     2,
+	"failed":       3,
+	"activating":   4,
+	"deactivating": 5,
+	"maintenance":  6,
+}
+
+// systemd.unit.sub.state的值, start*和stop*分别归为一类, 其他状态为99
+var systemdSubStates = map[string]int{
+	"running":      0,
+	"exited":       1,
+	"dead":         2,
+	"failed":       3,
+	"auto-restart": 4,
+	"start":        5,
+	"reload":       6,
+	"stop":         7,
+	"listening":    8,
+	"waiting":      9,
+	"mounted":      10,
+	"plugged":      11,
+	"elapsed":      12,
+}
+
+type systemdCpuSample struct {
+	nsec uint64
+	ts   time.Time
+}
+
+var (
+	systemdCpuHistory = make(map[string]*systemdCpuSample)
+	systemdCpuLock    = new(sync.Mutex)
+)
+
+func systemdUnitPatterns() []string {
+	var patterns []string
+	if g.Config().Collector != nil {
+		patterns = append(patterns, g.Config().Collector.SystemdUnits...)
+	}
+	return append(patterns, g.ReportUnits()...)
+}
+
+func SystemdMetrics() (L []*model.MetricValue) {
+	patterns := systemdUnitPatterns()
+	if len(patterns) == 0 {
+		return
+	}
+
+	units, err := listSystemdUnits(patterns)
+	if err != nil {
+		log.Println("list systemd units fail:", err)
+		return
+	}
+	if len(units) == 0 {
+		return
+	}
+
+	args := append([]string{"show", "--no-pager", "-p", systemdShowProperties}, units...)
+	out, err := systemctl(args...)
+	if err != nil {
+		log.Println("systemctl show fail:", err)
+		return
+	}
+	return collectSystemdMetrics(parseSystemctlShow(out), time.Now())
+}
+
+func systemctl(args ...string) ([]byte, error) {
+	cmd := exec.Command("systemctl", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	err, isTimeout := sys.CmdRunWithTimeout(cmd, systemctlTimeout)
+	if isTimeout {
+		return nil, fmt.Errorf("systemctl %s timeout", args[0])
+	}
+	// 部分unit不存在时list-units也会返回非0, 以输出为准
+	if err != nil && stdout.Len() == 0 {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// listSystemdUnits 展开glob, 不含glob的unit即使没有加载也保留, 以便上报为未运行
+func listSystemdUnits(patterns []string) ([]string, error) {
+	var units, globs []string
+	seen := make(map[string]bool)
+	for _, p := range patterns {
+		if isGlob(p) {
+			globs = append(globs, p)
+		} else if !seen[p] {
+			seen[p] = true
+			units = append(units, p)
+		}
+	}
+	if len(globs) == 0 {
+		return units, nil
+	}
+
+	args := append([]string{"list-units", "--all", "--plain", "--no-legend", "--no-pager", "--full"}, globs...)
+	out, err := systemctl(args...)
+	if err != nil {
+		return nil, err
+	}
+	for _, unit := range parseSystemctlList(out) {
+		if !seen[unit] {
+			seen[unit] = true
+			units = append(units, unit)
+		}
+	}
+	return units, nil
+}
+
+// parseSystemctlList 解析list-units的输出, 第一列为unit名称, 失败的unit前面可能有●
+func parseSystemctlList(out []byte) []string {
+	var units []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(strings.TrimLeft(scanner.Text(), " ●*"))
+		if len(fields) > 0 {
+			units = append(units, fields[0])
+		}
+	}
+	return units
+}
+
+// parseSystemctlShow 解析systemctl show的输出, 每个unit的属性之间以空行分隔
+func parseSystemctlShow(out []byte) []map[string]string {
+	var ret []map[string]string
+	var cur map[string]string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			cur = nil
+			continue
+		}
+		arr := strings.SplitN(line, "=", 2)
+		if len(arr) != 2 {
+			continue
+		}
+		if cur == nil {
+			cur = make(map[string]string)
+			ret = append(ret, cur)
+		}
+		cur[arr[0]] = arr[1]
+	}
+	return ret
+}
+
+func systemdSubState(s string) int {
+	if v, ok := systemdSubStates[s]; ok {
+		return v
+	}
+	if strings.HasPrefix(s, "start") {
+		return systemdSubStates["start"]
+	}
+	if strings.HasPrefix(s, "stop") || strings.HasPrefix(s, "final") {
+		return systemdSubStates["stop"]
+	}
+	return 99
+}
+
+// systemdUint 解析数值属性, 未设置或者没有开启accounting时返回false
+func systemdUint(props map[string]string, key string) (uint64, bool) {
+	s, ok := props[key]
+	if !ok || s == "" || s == "[not set]" || s == systemdUnset {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	return v, err == nil
+}
+
+func collectSystemdMetrics(units []map[string]string, now time.Time) (L []*model.MetricValue) {
+	history := make(map[string]*systemdCpuSample)
+
+	for _, props := range units {
+		id := props["Id"]
+		if id == "" {
+			continue
+		}
+		tags := "unit=" + id
+		active := props["ActiveState"]
+
+		if active == "active" || active == "reloading" {
+			L = append(L, GaugeValue(g.SYSTEMD_UNIT_ACTIVE, 1, tags))
+		} else {
+			L = append(L, GaugeValue(g.SYSTEMD_UNIT_ACTIVE, 0, tags))
+		}
+		if active == "failed" {
+			L = append(L, GaugeValue(g.SYSTEMD_UNIT_FAILED, 1, tags))
+		} else {
+			L = append(L, GaugeValue(g.SYSTEMD_UNIT_FAILED, 0, tags))
+		}
+		if v, ok := systemdActiveStates[active]; ok {
+			L = append(L, GaugeValue(g.SYSTEMD_UNIT_ACTIVE_STATE, v, tags))
+		}
+		L = append(L, GaugeValue(g.SYSTEMD_UNIT_SUB_STATE, systemdSubState(props["SubState"]), tags))
+
+		// 没有加载的unit只上报状态
+		if props["LoadState"] == "not-found" {
+			continue
+		}
+
+		if v, ok := systemdUint(props, "NRestarts"); ok {
+			L = append(L, GaugeValue(g.SYSTEMD_UNIT_RESTARTS, v, tags))
+		}
+		if v, ok := systemdUint(props, "MemoryCurrent"); ok {
+			L = append(L, GaugeValue(g.SYSTEMD_UNIT_MEM, v, tags))
+		}
+		if v, ok := systemdUint(props, "TasksCurrent"); ok {
+			L = append(L, GaugeValue(g.SYSTEMD_UNIT_TASKS, v, tags))
+		}
+		if nsec, ok := systemdUint(props, "CPUUsageNSec"); ok {
+			history[id] = &systemdCpuSample{nsec: nsec, ts: now}
+			systemdCpuLock.Lock()
+			last, ok := systemdCpuHistory[id]
+			systemdCpuLock.Unlock()
+			// unit重启后cpu时间重新计数
+			if ok && nsec >= last.nsec && now.After(last.ts) {
+				// 100表示占满一个核
+				percent := float64(nsec-last.nsec) / float64(now.Sub(last.ts).Nanoseconds()) * 100
+				L = append(L, GaugeValue(g.SYSTEMD_UNIT_CPU_PERCENT, percent, tags))
+			}
+		}
+	}
+
+	systemdCpuLock.Lock()
+	systemdCpuHistory = history
+	systemdCpuLock.Unlock()
+	return
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+)
+
+const systemctlShowOutput = `Id=nginx.service
+LoadState=loaded
+ActiveState=active
+SubState=running
+NRestarts=3
+MemoryCurrent=10485760
+CPUUsageNSec=2000000000
+TasksCurrent=5
+
+Id=php-fpm.service
+LoadState=loaded
+ActiveState=failed
+SubState=failed
+NRestarts=0
+MemoryCurrent=[not set]
+CPUUsageNSec=18446744073709551615
+TasksCurrent=18446744073709551615
+
+Id=redis.service
+LoadState=not-found
+ActiveState=inactive
+SubState=dead
+NRestarts=0
+MemoryCurrent=[not set]
+CPUUsageNSec=[not set]
+TasksCurrent=[not set]
+`
+
+func TestParseSystemctlList(t *testing.T) {
+	out := []byte("nginx.service     loaded active running A high performance web server\n" +
+		"● php-fpm.service loaded failed failed  The PHP FastCGI Process Manager\n")
+	units := parseSystemctlList(out)
+	if !reflect.DeepEqual(units, []string{"nginx.service", "php-fpm.service"}) {
+		t.Errorf("unexpected units %v", units)
+	}
+}
+
+func TestCollectSystemdMetrics(t *testing.T) {
+	now := time.Unix(1600001000, 0)
+	systemdCpuHistory = map[string]*systemdCpuSample{
+		"nginx.service": {nsec: 1000000000, ts: now.Add(-10 * time.Second)},
+	}
+
+	units := parseSystemctlShow([]byte(systemctlShowOutput))
+	if len(units) != 3 {
+		t.Fatalf("expect 3 units, got %d", len(units))
+	}
+
+	byUnit := map[string]map[string]*model.MetricValue{}
+	for _, mv := range collectSystemdMetrics(units, now) {
+		if byUnit[mv.Tags] == nil {
+			byUnit[mv.Tags] = map[string]*model.MetricValue{}
+		}
+		byUnit[mv.Tags][mv.Metric] = mv
+	}
+
+	nginx := byUnit["unit=nginx.service"]
+	checkMetric(t, nginx, "systemd.unit.active", 1)
+	checkMetric(t, nginx, "systemd.unit.failed", 0)
+	checkMetric(t, nginx, "systemd.unit.active.state", 0)
+	checkMetric(t, nginx, "systemd.unit.sub.state", 0)
+	checkMetric(t, nginx, "systemd.unit.restarts", 3)
+	checkMetric(t, nginx, "systemd.unit.mem.bytes", 10485760)
+	checkMetric(t, nginx, "systemd.unit.tasks", 5)
+	checkMetric(t, nginx, "systemd.unit.cpu.percent", 10)
+
+	php := byUnit["unit=php-fpm.service"]
+	checkMetric(t, php, "systemd.unit.active", 0)
+	checkMetric(t, php, "systemd.unit.failed", 1)
+	checkMetric(t, php, "systemd.unit.active.state", 3)
+	if _, ok := php["systemd.unit.mem.bytes"]; ok {
+		t.Errorf("unset memory should not be reported")
+	}
+
+	redis := byUnit["unit=redis.service"]
+	checkMetric(t, redis, "systemd.unit.active", 0)
+	checkMetric(t, redis, "systemd.unit.sub.state", 2)
+	if len(redis) != 4 {
+		t.Errorf("not-found unit should only report states, got %d metrics", len(redis))
+	}
+
+	if _, ok := systemdCpuHistory["nginx.service"]; !ok || len(systemdCpuHistory) != 1 {
+		t.Errorf("unexpected cpu history %v", systemdCpuHistory)
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+	"github.com/toolkits/nux"
+)
+
+// tcp连接的状态, 与内核include/net/tcp_states.h一致
+const (
+	tcpEstablished = 1
+	tcpListen      = 10
+)
+
+var tcpStates = map[uint8]string{
+	1:  "ESTABLISHED",
+	2:  "SYN_SENT",
+	3:  "SYN_RECV",
+	4:  "FIN_WAIT1",
+	5:  "FIN_WAIT2",
+	6:  "TIME_WAIT",
+	7:  "CLOSE",
+	8:  "CLOSE_WAIT",
+	9:  "LAST_ACK",
+	11: "CLOSING",
+}
+
+// 按端口上报的连接状态, 作为服务端时不会出现SYN_SENT和CLOSE, 没有连接时上报0
+var tcpPortStates = []uint8{1, 3, 4, 5, 6, 8, 9, 11}
+
+type tcpSocket struct {
+	State     uint8
+	LocalPort uint16
+	// 对于LISTEN状态的socket, RxQueue为accept队列中的连接数, TxQueue为backlog(/proc/net/tcp中没有, 为0)
+	RxQueue uint32
+	TxQueue uint32
+	// socket的inode, 用于找到监听端口的进程, 只有读/proc/net/tcp时才有
+	Inode uint64
+	// 以下字段只有通过inet_diag获取时才有
+	HasInfo      bool
+	Rtt          uint32 // 微秒
+	TotalRetrans uint32
+}
+
+func tcpReportPorts() []int64 {
+	ports := append([]int64{}, g.Config().Collector.TcpPorts...)
+	for _, p := range g.ReportPorts() {
+		dup := false
+		for _, x := range ports {
+			if x == p {
+				dup = true
+			}
+		}
+		if !dup {
+			ports = append(ports, p)
+		}
+	}
+	return ports
+}
+
+func TcpPortMetrics() []*model.MetricValue {
+	ports := tcpReportPorts()
+	if len(ports) == 0 {
+		return nil
+	}
+
+	sockets, err := inetDiagTcpSockets()
+	if err != nil {
+		if g.Config().Debug {
+			log.Println("inet_diag fail, fallback to /proc/net/tcp:", err)
+		}
+		root := nux.Root()
+		sockets, err = readProcNetTcp(root+"/proc/net/tcp", root+"/proc/net/tcp6")
+		if err != nil {
+			log.Println("read /proc/net/tcp fail:", err)
+			return nil
+		}
+	}
+	return collectTcpPortMetrics(sockets, ports)
+}
+
+type tcpPortStat struct {
+	listening    bool
+	acceptQueue  uint64
+	backlog      uint64
+	states       map[uint8]int
+	rttCount     int
+	rttSum       uint64
+	rttMax       uint32
+	totalRetrans uint64
+}
+
+func collectTcpPortMetrics(sockets []*tcpSocket, ports []int64) (L []*model.MetricValue) {
+	stats := make(map[uint16]*tcpPortStat)
+	for _, p := range ports {
+		if p > 0 && p < 65536 {
+			stats[uint16(p)] = &tcpPortStat{states: make(map[uint8]int)}
+		}
+	}
+
+	hasInfo := false
+	for _, s := range sockets {
+		st, ok := stats[s.LocalPort]
+		if !ok {
+			continue
+		}
+		if s.State == tcpListen {
+			// ipv4和ipv6、SO_REUSEPORT会有多个监听的socket
+			st.listening = true
+			st.acceptQueue += uint64(s.RxQueue)
+			st.backlog += uint64(s.TxQueue)
+			continue
+		}
+		st.states[s.State]++
+		if s.HasInfo {
+			hasInfo = true
+			st.totalRetrans += uint64(s.TotalRetrans)
+			if s.State == tcpEstablished && s.Rtt > 0 {
+				st.rttCount++
+				st.rttSum += uint64(s.Rtt)
+				if s.Rtt > st.rttMax {
+					st.rttMax = s.Rtt
+				}
+			}
+		}
+	}
+
+	for port, st := range stats {
+		tags := fmt.Sprintf("port=%d", port)
+		for _, state := range tcpPortStates {
+			L = append(L, GaugeValue("tcp.port.conn", st.states[state], tags+",state="+tcpStates[state]))
+		}
+		if st.listening {
+			L = append(L, GaugeValue("tcp.port.accept.queue", st.acceptQueue, tags))
+			if st.backlog > 0 {
+				L = append(L, GaugeValue("tcp.port.accept.backlog", st.backlog, tags))
+				L = append(L, GaugeValue("tcp.port.accept.queue.percent", float64(st.acceptQueue)*100/float64(st.backlog), tags))
+			}
+		}
+		if hasInfo {
+			L = append(L, GaugeValue("tcp.port.retrans", st.totalRetrans, tags))
+			if st.rttCount > 0 {
+				L = append(L, GaugeValue("tcp.port.rtt.avg", float64(st.rttSum)/float64(st.rttCount)/1000, tags))
+				L = append(L, GaugeValue("tcp.port.rtt.max", float64(st.rttMax)/1000, tags))
+			}
+		}
+	}
+	return
+}
+
+// readProcNetTcp 解析/proc/net/tcp和tcp6, 如
+// sl local_address rem_address st tx_queue:rx_queue ...
+// 0: 0100007F:1F90 00000000:0000 0A 00000000:00000080 ...
+func readProcNetTcp(files ...string) ([]*tcpSocket, error) {
+	var ret []*tcpSocket
+	found := false
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		found = true
+
+		scanner := bufio.NewScanner(f)
+		scanner.Scan()
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 5 {
+				continue
+			}
+			i := strings.LastIndex(fields[1], ":")
+			if i < 0 {
+				continue
+			}
+			port, err := strconv.ParseUint(fields[1][i+1:], 16, 16)
+			if err != nil {
+				continue
+			}
+			state, err := strconv.ParseUint(fields[3], 16, 8)
+			if err != nil {
+				continue
+			}
+			queues := strings.SplitN(fields[4], ":", 2)
+			if len(queues) != 2 {
+				continue
+			}
+			tx, _ := strconv.ParseUint(queues[0], 16, 32)
+			rx, _ := strconv.ParseUint(queues[1], 16, 32)
+			if state == tcpListen {
+				tx = 0
+			}
+			var inode uint64
+			if len(fields) > 9 {
+				inode, _ = strconv.ParseUint(fields[9], 10, 64)
+			}
+			ret = append(ret, &tcpSocket{
+				State:     uint8(state),
+				LocalPort: uint16(port),
+				TxQueue:   uint32(tx),
+				RxQueue:   uint32(rx),
+				Inode:     inode,
+			})
+		}
+		f.Close()
+	}
+	if !found {
+		return nil, fmt.Errorf("%v not found", files)
+	}
+	return ret, nil
+}
+
+// netlink消息使用本机字节序
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+const (
+	// sizeof(struct inet_diag_msg)
+	inetDiagMsgSize = 72
+	// INET_DIAG_INFO属性中为struct tcp_info
+	inetDiagInfo = 2
+	// tcp_info中tcpi_rtt和tcpi_total_retrans的偏移
+	tcpInfoRttOffset          = 68
+	tcpInfoTotalRetransOffset = 100
+)
+
+// parseInetDiagMsg 解析struct inet_diag_msg及其后的属性, 见 linux/inet_diag.h
+func parseInetDiagMsg(b []byte) (*tcpSocket, error) {
+	if len(b) < inetDiagMsgSize {
+		return nil, errors.New("inet_diag_msg too short")
+	}
+	s := &tcpSocket{
+		State: b[1],
+		// inet_diag_sockid中的端口为网络字节序
+		LocalPort: binary.BigEndian.Uint16(b[4:6]),
+		RxQueue:   nativeEndian.Uint32(b[56:60]),
+		TxQueue:   nativeEndian.Uint32(b[60:64]),
+	}
+
+	attrs := b[inetDiagMsgSize:]
+	for len(attrs) >= 4 {
+		l := int(nativeEndian.Uint16(attrs[0:2]))
+		typ := nativeEndian.Uint16(attrs[2:4])
+		if l < 4 || l > len(attrs) {
+			break
+		}
+		if typ == inetDiagInfo && l-4 >= tcpInfoTotalRetransOffset+4 {
+			info := attrs[4:l]
+			s.HasInfo = true
+			s.Rtt = nativeEndian.Uint32(info[tcpInfoRttOffset:])
+			s.TotalRetrans = nativeEndian.Uint32(info[tcpInfoTotalRetransOffset:])
+		}
+		// 属性按4字节对齐
+		l = (l + 3) &^ 3
+		if l > len(attrs) {
+			break
+		}
+		attrs = attrs[l:]
+	}
+	return s, nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"testing"
+)
+
+func TestReadProcNetTcp(t *testing.T) {
+	sockets, err := readProcNetTcp("testdata/net/proc/net/tcp", "testdata/net/proc/net/tcp6", "testdata/net/proc/net/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sockets) != 7 {
+		t.Fatalf("expect 7 sockets, got %d", len(sockets))
+	}
+	if s := sockets[0]; s.State != tcpListen || s.LocalPort != 8080 || s.RxQueue != 3 || s.TxQueue != 0 || s.Inode != 1001 {
+		t.Errorf("unexpected listen socket: %+v", s)
+	}
+	if s := sockets[6]; s.State != 8 || s.LocalPort != 8080 {
+		t.Errorf("unexpected tcp6 socket: %+v", s)
+	}
+
+	if _, err := readProcNetTcp("testdata/net/proc/net/missing"); err == nil {
+		t.Error("expect error when no file found")
+	}
+}
+
+func TestCollectTcpPortMetrics(t *testing.T) {
+	sockets, err := readProcNetTcp("testdata/net/proc/net/tcp", "testdata/net/proc/net/tcp6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := metricsByKey(collectTcpPortMetrics(sockets, []int64{8080, 3306, 70000}))
+	// 2个端口 * 8个状态 + 2个accept.queue
+	if len(metrics) != 18 {
+		t.Errorf("expect 18 metrics, got %d: %v", len(metrics), metrics)
+	}
+	checkMetric(t, metrics, "tcp.port.conn/port=8080,state=ESTABLISHED", 1)
+	checkMetric(t, metrics, "tcp.port.conn/port=8080,state=TIME_WAIT", 1)
+	checkMetric(t, metrics, "tcp.port.conn/port=8080,state=CLOSE_WAIT", 1)
+	checkMetric(t, metrics, "tcp.port.conn/port=8080,state=SYN_RECV", 0)
+	checkMetric(t, metrics, "tcp.port.accept.queue/port=8080", 4)
+	checkMetric(t, metrics, "tcp.port.conn/port=3306,state=ESTABLISHED", 0)
+	checkMetric(t, metrics, "tcp.port.accept.queue/port=3306", 0)
+}
+
+func TestCollectTcpPortMetricsWithInfo(t *testing.T) {
+	sockets := []*tcpSocket{
+		{State: tcpListen, LocalPort: 80, RxQueue: 32, TxQueue: 128},
+		{State: tcpEstablished, LocalPort: 80, HasInfo: true, Rtt: 1000, TotalRetrans: 2},
+		{State: tcpEstablished, LocalPort: 80, HasInfo: true, Rtt: 3000, TotalRetrans: 1},
+		{State: 6, LocalPort: 80, HasInfo: true},
+	}
+	metrics := metricsByKey(collectTcpPortMetrics(sockets, []int64{80}))
+	checkMetric(t, metrics, "tcp.port.conn/port=80,state=ESTABLISHED", 2)
+	checkMetric(t, metrics, "tcp.port.accept.queue/port=80", 32)
+	checkMetric(t, metrics, "tcp.port.accept.backlog/port=80", 128)
+	checkMetric(t, metrics, "tcp.port.accept.queue.percent/port=80", 25)
+	checkMetric(t, metrics, "tcp.port.retrans/port=80", 3)
+	checkMetric(t, metrics, "tcp.port.rtt.avg/port=80", 2)
+	checkMetric(t, metrics, "tcp.port.rtt.max/port=80", 3)
+}
+
+func TestParseInetDiagMsg(t *testing.T) {
+	info := make([]byte, tcpInfoTotalRetransOffset+4)
+	nativeEndian.PutUint32(info[tcpInfoRttOffset:], 1500)
+	nativeEndian.PutUint32(info[tcpInfoTotalRetransOffset:], 7)
+
+	b := make([]byte, inetDiagMsgSize)
+	b[1] = tcpEstablished
+	b[4], b[5] = 0x1F, 0x90
+	nativeEndian.PutUint32(b[56:60], 5)
+	nativeEndian.PutUint32(b[60:64], 9)
+
+	// 其他属性应被跳过, 长度不是4的倍数时需要对齐
+	other := []byte{0, 0, 0, 0, 1, 0, 0, 0}
+	nativeEndian.PutUint16(other[0:2], 5)
+	nativeEndian.PutUint16(other[2:4], 1)
+	b = append(b, other...)
+
+	attr := make([]byte, 4)
+	nativeEndian.PutUint16(attr[0:2], uint16(4+len(info)))
+	nativeEndian.PutUint16(attr[2:4], inetDiagInfo)
+	b = append(b, attr...)
+	b = append(b, info...)
+
+	s, err := parseInetDiagMsg(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.State != tcpEstablished || s.LocalPort != 8080 || s.RxQueue != 5 || s.TxQueue != 9 {
+		t.Errorf("unexpected socket: %+v", s)
+	}
+	if !s.HasInfo || s.Rtt != 1500 || s.TotalRetrans != 7 {
+		t.Errorf("unexpected tcp info: %+v", s)
+	}
+
+	if _, err := parseInetDiagMsg(b[:10]); err == nil {
+		t.Error("expect error for short message")
+	}
+}
+
+func TestCollectConntrackMetrics(t *testing.T) {
+	metrics := metricsByKey(collectConntrackMetrics("testdata/net/proc"))
+	if len(metrics) != 7 {
+		t.Errorf("expect 7 metrics, got %d: %v", len(metrics), metrics)
+	}
+	checkMetric(t, metrics, "conntrack.count/", 1500)
+	checkMetric(t, metrics, "conntrack.max/", 65536)
+	checkMetric(t, metrics, "conntrack.used.percent/", float64(1500)*100/65536)
+	checkMetric(t, metrics, "conntrack.insert_failed/", 1)
+	checkMetric(t, metrics, "conntrack.drop/", 12)
+	checkMetric(t, metrics, "conntrack.early_drop/", 0)
+	checkMetric(t, metrics, "conntrack.invalid/", 8)
+
+	if L := collectConntrackMetrics("testdata/net/missing"); len(L) != 0 {
+		t.Errorf("expect no metrics without nf_conntrack, got %v", L)
+	}
+}
@@ -15,21 +15,35 @@
 package funcs
 
 import (
-	"bufio"
-	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/open-falcon/falcon-plus/common/model"
 	"github.com/open-falcon/falcon-plus/modules/agent/g"
-	"github.com/toolkits/file"
-	"github.com/toolkits/sys"
 )
 
+const (
+	defaultProbeTimeout = 5 * time.Second
+	// 校验body时最多读取的字节数
+	maxProbeBodySize = 102400
+)
+
+// 拨测任务由hbs下发, 上报的tags为strategy中的tags加上src=hostname, 各阶段耗时的单位为毫秒
 func UrlMetrics() (L []*model.MetricValue) {
-	reportUrls := g.ReportUrls()
-	sz := len(reportUrls)
+	probes := g.ReportProbes()
+	sz := len(probes)
 	if sz == 0 {
 		return
 	}
@@ -37,32 +51,259 @@ func UrlMetrics() (L []*model.MetricValue) {
 	if err != nil {
 		hostname = "None"
 	}
-	for furl, timeout := range reportUrls {
-		tags := fmt.Sprintf("url=%v,timeout=%v,src=%v", furl, timeout, hostname)
-		if ok, _ := probeUrl(furl, timeout); !ok {
-			L = append(L, GaugeValue(g.URL_CHECK_HEALTH, 0, tags))
+
+	// 并发拨测, 避免超时的任务拖慢其他任务
+	results := make([][]*model.MetricValue, sz)
+	var wg sync.WaitGroup
+	for i, p := range probes {
+		wg.Add(1)
+		go func(i int, p *g.Probe) {
+			defer wg.Done()
+			results[i] = probe(p, fmt.Sprintf("%s,src=%s", p.Tags, hostname))
+		}(i, p)
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		L = append(L, r...)
+	}
+	return
+}
+
+func probe(p *g.Probe, tags string) []*model.MetricValue {
+	timeout := defaultProbeTimeout
+	if sec, err := strconv.Atoi(p.Params["timeout"]); err == nil && sec > 0 {
+		timeout = time.Duration(sec) * time.Second
+	}
+
+	switch p.Metric {
+	case g.URL_CHECK_HEALTH:
+		return probeHttp(p.Params, timeout, tags)
+	case g.TCP_CHECK_HEALTH:
+		return probeTcp(p.Params, timeout, tags)
+	case g.DNS_CHECK_HEALTH:
+		return probeDns(p.Params, timeout, tags)
+	}
+	return nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Nanoseconds()) / 1e6
+}
+
+func healthValue(ok bool) int {
+	if ok {
+		return 1
+	}
+	return 0
+}
+
+// status为空时要求返回200, 支持 301、2xx、200|301 这样的写法
+func matchStatus(expect string, code int) bool {
+	if expect == "" {
+		return code == http.StatusOK
+	}
+	for _, s := range strings.Split(expect, "|") {
+		s = strings.TrimSpace(s)
+		if len(s) == 3 && strings.HasSuffix(strings.ToLower(s), "xx") {
+			if int(s[0]-'0') == code/100 {
+				return true
+			}
 			continue
 		}
-		L = append(L, GaugeValue(g.URL_CHECK_HEALTH, 1, tags))
+		if s == strconv.Itoa(code) {
+			return true
+		}
 	}
-	return
+	return false
 }
 
-func probeUrl(furl string, timeout string) (bool, error) {
-	bs, err := sys.CmdOutBytes("curl", "--max-filesize", "102400", "-I", "-m", timeout, "-o", "/dev/null", "-s", "-w", "%{http_code}", furl)
+// url.check.health url=,timeout=[,status=][,body=][,insecure=true]
+// 没有body参数时发送HEAD请求, 不跟随跳转; insecure=true时不校验证书, 用于自签名证书的服务
+func probeHttp(params map[string]string, timeout time.Duration, tags string) (L []*model.MetricValue) {
+	furl := params["url"]
+	var bodyRe *regexp.Regexp
+	if params["body"] != "" {
+		re, err := regexp.Compile(params["body"])
+		if err != nil {
+			log.Printf("probe url [%v] bad body regexp: %v\n", furl, err)
+			return []*model.MetricValue{GaugeValue(g.URL_CHECK_HEALTH, 0, tags)}
+		}
+		bodyRe = re
+	}
+
+	method := "HEAD"
+	if bodyRe != nil {
+		method = "GET"
+	}
+	req, err := http.NewRequest(method, furl, nil)
 	if err != nil {
 		log.Printf("probe url [%v] failed.the err is: [%v]\n", furl, err)
-		return false, err
+		return []*model.MetricValue{GaugeValue(g.URL_CHECK_HEALTH, 0, tags)}
 	}
-	reader := bufio.NewReader(bytes.NewBuffer(bs))
-	retcode, err := file.ReadLine(reader)
+
+	var dnsStart, dnsDone, connStart, connDone, tlsStart, tlsDone, firstByte time.Time
+	// 同时解析出多个地址时, 可能并发建立多个连接
+	var connLock sync.Mutex
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:  func(httptrace.DNSDoneInfo) { dnsDone = time.Now() },
+		ConnectStart: func(_, _ string) {
+			connLock.Lock()
+			defer connLock.Unlock()
+			if connStart.IsZero() {
+				connStart = time.Now()
+			}
+		},
+		ConnectDone: func(_, _ string, err error) {
+			connLock.Lock()
+			defer connLock.Unlock()
+			if err == nil && connDone.IsZero() {
+				connDone = time.Now()
+			}
+		},
+		TLSHandshakeStart:    func() { tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { tlsDone = time.Now() },
+		GotFirstResponseByte: func() { firstByte = time.Now() },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: params["insecure"] == "true"},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
 	if err != nil {
-		log.Println("read retcode failed.err is:", err)
-		return false, err
+		log.Printf("probe url [%v] failed.the err is: [%v]\n", furl, err)
+		return []*model.MetricValue{GaugeValue(g.URL_CHECK_HEALTH, 0, tags)}
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxProbeBodySize))
+	resp.Body.Close()
+	total := time.Since(start)
+
+	healthy := err == nil && matchStatus(params["status"], resp.StatusCode)
+	if !healthy {
+		log.Printf("return code [%v] is not expected.query url is [%v]", resp.StatusCode, furl)
+	} else if bodyRe != nil && !bodyRe.Match(body) {
+		log.Printf("body does not match [%v].query url is [%v]", params["body"], furl)
+		healthy = false
 	}
-	if strings.TrimSpace(string(retcode)) != "200" {
-		log.Printf("return code [%v] is not 200.query url is [%v]", string(retcode), furl)
-		return false, err
+
+	L = append(L, GaugeValue(g.URL_CHECK_HEALTH, healthValue(healthy), tags))
+	L = append(L, GaugeValue("url.check.status", resp.StatusCode, tags))
+	if !dnsDone.IsZero() {
+		L = append(L, GaugeValue("url.check.time.dns", milliseconds(dnsDone.Sub(dnsStart)), tags))
 	}
-	return true, err
+	connLock.Lock()
+	connected := !connDone.IsZero()
+	connLock.Unlock()
+	if connected {
+		L = append(L, GaugeValue("url.check.time.connect", milliseconds(connDone.Sub(connStart)), tags))
+	}
+	if !tlsDone.IsZero() {
+		L = append(L, GaugeValue("url.check.time.tls", milliseconds(tlsDone.Sub(tlsStart)), tags))
+	}
+	if !firstByte.IsZero() {
+		L = append(L, GaugeValue("url.check.time.ttfb", milliseconds(firstByte.Sub(start)), tags))
+	}
+	L = append(L, GaugeValue("url.check.time.total", milliseconds(total), tags))
+
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		days := resp.TLS.PeerCertificates[0].NotAfter.Sub(time.Now()).Hours() / 24
+		L = append(L, GaugeValue("url.check.cert.expire.days", days, tags))
+	}
+	return
+}
+
+// tcp.check.health addr=host:port,timeout=
+func probeTcp(params map[string]string, timeout time.Duration, tags string) (L []*model.MetricValue) {
+	addr := params["addr"]
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		log.Printf("probe tcp [%v] failed.the err is: [%v]\n", addr, err)
+		return []*model.MetricValue{GaugeValue(g.TCP_CHECK_HEALTH, 0, tags)}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if net.ParseIP(host) == nil {
+		start := time.Now()
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil || len(addrs) == 0 {
+			log.Printf("probe tcp [%v] resolve failed.the err is: [%v]\n", addr, err)
+			return []*model.MetricValue{GaugeValue(g.TCP_CHECK_HEALTH, 0, tags)}
+		}
+		L = append(L, GaugeValue("tcp.check.time.dns", milliseconds(time.Since(start)), tags))
+		host = addrs[0]
+	}
+
+	start := time.Now()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		log.Printf("probe tcp [%v] failed.the err is: [%v]\n", addr, err)
+		return append(L, GaugeValue(g.TCP_CHECK_HEALTH, 0, tags))
+	}
+	conn.Close()
+
+	L = append(L, GaugeValue(g.TCP_CHECK_HEALTH, 1, tags))
+	L = append(L, GaugeValue("tcp.check.time.connect", milliseconds(time.Since(start)), tags))
+	return
+}
+
+// dns.check.health domain=,timeout=[,server=ip:port][,expect=ip]
+func probeDns(params map[string]string, timeout time.Duration, tags string) (L []*model.MetricValue) {
+	domain := params["domain"]
+	resolver := net.DefaultResolver
+	if server := params["server"]; server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	addrs, err := resolver.LookupHost(ctx, domain)
+	elapsed := time.Since(start)
+	if err != nil {
+		log.Printf("probe dns [%v] failed.the err is: [%v]\n", domain, err)
+		return []*model.MetricValue{GaugeValue(g.DNS_CHECK_HEALTH, 0, tags)}
+	}
+
+	healthy := len(addrs) > 0
+	if expect := params["expect"]; expect != "" {
+		healthy = false
+		for _, a := range addrs {
+			if a == expect {
+				healthy = true
+				break
+			}
+		}
+		if !healthy {
+			log.Printf("probe dns [%v] got %v, expect %v", domain, addrs, expect)
+		}
+	}
+
+	L = append(L, GaugeValue(g.DNS_CHECK_HEALTH, healthValue(healthy), tags))
+	L = append(L, GaugeValue("dns.check.time", milliseconds(elapsed), tags))
+	L = append(L, GaugeValue("dns.check.records", len(addrs), tags))
+	return
 }
@@ -0,0 +1,121 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package funcs
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMatchStatus(t *testing.T) {
+	cases := []struct {
+		expect string
+		code   int
+		ok     bool
+	}{
+		{"", 200, true},
+		{"", 204, false},
+		{"301", 301, true},
+		{"2xx", 204, true},
+		{"2xx", 302, false},
+		{"200|301", 301, true},
+		{"200|301", 404, false},
+	}
+	for _, c := range cases {
+		if matchStatus(c.expect, c.code) != c.ok {
+			t.Errorf("matchStatus(%q, %d) expect %v", c.expect, c.code, c.ok)
+		}
+	}
+}
+
+func TestProbeHttp(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, `{"status":"ok"}`)
+	}))
+	defer ts.Close()
+
+	L := probeHttp(map[string]string{"url": ts.URL}, time.Second, "")
+	metrics := metricsByKey(L)
+	checkMetric(t, metrics, "url.check.health/", 1)
+	checkMetric(t, metrics, "url.check.status/", 200)
+	for _, name := range []string{"url.check.time.connect", "url.check.time.ttfb", "url.check.time.total"} {
+		if _, ok := metrics[name+"/"]; !ok {
+			t.Errorf("metric %s not found", name)
+		}
+	}
+
+	metrics = metricsByKey(probeHttp(map[string]string{"url": ts.URL, "body": `"status":"ok"`}, time.Second, ""))
+	checkMetric(t, metrics, "url.check.health/", 1)
+	metrics = metricsByKey(probeHttp(map[string]string{"url": ts.URL, "body": "error"}, time.Second, ""))
+	checkMetric(t, metrics, "url.check.health/", 0)
+
+	// 不跟随跳转
+	metrics = metricsByKey(probeHttp(map[string]string{"url": ts.URL + "/redirect"}, time.Second, ""))
+	checkMetric(t, metrics, "url.check.health/", 0)
+	checkMetric(t, metrics, "url.check.status/", 302)
+	metrics = metricsByKey(probeHttp(map[string]string{"url": ts.URL + "/redirect", "status": "3xx"}, time.Second, ""))
+	checkMetric(t, metrics, "url.check.health/", 1)
+}
+
+func TestProbeHttps(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	// httptest的证书不受信任
+	metrics := metricsByKey(probeHttp(map[string]string{"url": ts.URL}, time.Second, ""))
+	checkMetric(t, metrics, "url.check.health/", 0)
+
+	metrics = metricsByKey(probeHttp(map[string]string{"url": ts.URL, "insecure": "true"}, time.Second, ""))
+	checkMetric(t, metrics, "url.check.health/", 1)
+	if _, ok := metrics["url.check.time.tls/"]; !ok {
+		t.Error("metric url.check.time.tls not found")
+	}
+	if days, ok := metrics["url.check.cert.expire.days/"]; !ok || days.Value.(float64) <= 0 {
+		t.Errorf("unexpected cert expire days %v", days)
+	}
+}
+
+func TestProbeTcp(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	metrics := metricsByKey(probeTcp(map[string]string{"addr": addr}, time.Second, ""))
+	checkMetric(t, metrics, "tcp.check.health/", 1)
+	if _, ok := metrics["tcp.check.time.connect/"]; !ok {
+		t.Error("metric tcp.check.time.connect not found")
+	}
+
+	ln.Close()
+	metrics = metricsByKey(probeTcp(map[string]string{"addr": addr}, time.Second, ""))
+	checkMetric(t, metrics, "tcp.check.health/", 0)
+}
+
+func TestProbeDns(t *testing.T) {
+	metrics := metricsByKey(probeDns(map[string]string{"domain": "localhost", "expect": "127.0.0.1"}, time.Second, ""))
+	checkMetric(t, metrics, "dns.check.health/", 1)
+	metrics = metricsByKey(probeDns(map[string]string{"domain": "localhost", "expect": "10.0.0.1"}, time.Second, ""))
+	checkMetric(t, metrics, "dns.check.health/", 0)
+}
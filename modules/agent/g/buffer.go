@@ -0,0 +1,234 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package g
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+)
+
+type bufferedBatch struct {
+	seq     uint64
+	metrics []*model.MetricValue
+}
+
+type diskSegment struct {
+	seq   uint64
+	path  string
+	count int
+	bytes int64
+}
+
+// BufferStat 缓存深度, 在agent的http页面上展示
+type BufferStat struct {
+	MemoryPoints int    `json:"memoryPoints"`
+	DiskPoints   int    `json:"diskPoints"`
+	DiskBytes    int64  `json:"diskBytes"`
+	Dropped      uint64 `json:"dropped"`
+	Discarded    uint64 `json:"discarded"` // 多次补发失败后丢弃的数据点数
+}
+
+// TransferBuffer 发送失败的数据的缓存, 先进先出.
+// 内存中的数据超过maxSize时, 最旧的数据写入dir下的文件(没有配置dir时直接丢弃),
+// 磁盘上的数据总是比内存中的旧, 所以补发时先读磁盘再读内存
+type TransferBuffer struct {
+	sync.Mutex
+	maxSize      int
+	dir          string
+	maxDiskBytes int64
+	seq          uint64
+	mem          []*bufferedBatch
+	memPoints    int
+	disk         []*diskSegment
+	diskPoints   int
+	diskBytes    int64
+	dropped      uint64
+	discarded    uint64
+}
+
+// NewTransferBuffer 创建缓存, 并加载dir下上次退出时没有补发的数据
+func NewTransferBuffer(maxSize int, dir string, maxDiskBytes int64) *TransferBuffer {
+	b := &TransferBuffer{maxSize: maxSize, dir: dir, maxDiskBytes: maxDiskBytes}
+	if dir == "" {
+		return b
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Println("create buffer dir fail:", err)
+		b.dir = ""
+		return b
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	for _, path := range files {
+		var seg diskSegment
+		if _, err := fmt.Sscanf(filepath.Base(path), "%d_%d.json", &seg.seq, &seg.count); err != nil {
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		seg.path = path
+		seg.bytes = fi.Size()
+		b.disk = append(b.disk, &seg)
+		b.diskPoints += seg.count
+		b.diskBytes += seg.bytes
+		if seg.seq > b.seq {
+			b.seq = seg.seq
+		}
+	}
+	sort.Slice(b.disk, func(i, j int) bool { return b.disk[i].seq < b.disk[j].seq })
+	if len(b.disk) > 0 {
+		log.Printf("load %d buffered metrics from %s", b.diskPoints, dir)
+	}
+	return b
+}
+
+func (b *TransferBuffer) Len() int {
+	b.Lock()
+	defer b.Unlock()
+	return len(b.mem) + len(b.disk)
+}
+
+func (b *TransferBuffer) Stat() BufferStat {
+	b.Lock()
+	defer b.Unlock()
+	return BufferStat{
+		MemoryPoints: b.memPoints,
+		DiskPoints:   b.diskPoints,
+		DiskBytes:    b.diskBytes,
+		Dropped:      b.dropped,
+		Discarded:    b.discarded,
+	}
+}
+
+// Push 将一批数据放入缓存末尾
+func (b *TransferBuffer) Push(metrics []*model.MetricValue) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.seq++
+	b.mem = append(b.mem, &bufferedBatch{seq: b.seq, metrics: metrics})
+	b.memPoints += len(metrics)
+
+	for b.memPoints > b.maxSize && len(b.mem) > 1 {
+		oldest := b.mem[0]
+		b.mem = b.mem[1:]
+		b.memPoints -= len(oldest.metrics)
+		if b.dir == "" || !b.spill(oldest) {
+			b.dropped += uint64(len(oldest.metrics))
+		}
+	}
+}
+
+// 将内存中最旧的一批数据写入磁盘, 磁盘空间不够时丢弃磁盘上最旧的数据
+func (b *TransferBuffer) spill(batch *bufferedBatch) bool {
+	bs, err := json.Marshal(batch.metrics)
+	if err != nil {
+		log.Println("marshal buffered metrics fail:", err)
+		return false
+	}
+	for len(b.disk) > 0 && b.diskBytes+int64(len(bs)) > b.maxDiskBytes {
+		b.removeDisk(b.disk[0])
+		b.dropped += uint64(b.disk[0].count)
+		b.disk = b.disk[1:]
+	}
+
+	path := filepath.Join(b.dir, fmt.Sprintf("%020d_%d.json", batch.seq, len(batch.metrics)))
+	if err := ioutil.WriteFile(path, bs, 0644); err != nil {
+		log.Println("write buffer file fail:", err)
+		return false
+	}
+	b.disk = append(b.disk, &diskSegment{seq: batch.seq, path: path, count: len(batch.metrics), bytes: int64(len(bs))})
+	b.diskPoints += len(batch.metrics)
+	b.diskBytes += int64(len(bs))
+	return true
+}
+
+func (b *TransferBuffer) removeDisk(seg *diskSegment) {
+	if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+		log.Println("remove buffer file fail:", err)
+	}
+	b.diskPoints -= seg.count
+	b.diskBytes -= seg.bytes
+}
+
+// Peek 返回最旧的一批数据及其序号, 发送成功后用该序号调用Pop
+func (b *TransferBuffer) Peek() ([]*model.MetricValue, uint64, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	for len(b.disk) > 0 {
+		seg := b.disk[0]
+		bs, err := ioutil.ReadFile(seg.path)
+		var metrics []*model.MetricValue
+		if err == nil {
+			err = json.Unmarshal(bs, &metrics)
+		}
+		if err == nil {
+			return metrics, seg.seq, true
+		}
+		log.Println("read buffer file fail:", err)
+		b.removeDisk(seg)
+		b.dropped += uint64(seg.count)
+		b.disk = b.disk[1:]
+	}
+	if len(b.mem) > 0 {
+		return b.mem[0].metrics, b.mem[0].seq, true
+	}
+	return nil, 0, false
+}
+
+// Pop 删除序号为seq的最旧的一批数据, 它已经被丢弃时什么也不做
+func (b *TransferBuffer) Pop(seq uint64) {
+	b.Lock()
+	defer b.Unlock()
+	b.pop(seq)
+}
+
+// Discard 删除多次补发失败的序号为seq的最旧的一批数据, 并计入discarded
+func (b *TransferBuffer) Discard(seq uint64) {
+	b.Lock()
+	defer b.Unlock()
+	b.discarded += uint64(b.pop(seq))
+}
+
+// 返回删除的数据点数
+func (b *TransferBuffer) pop(seq uint64) int {
+	if len(b.disk) > 0 {
+		if b.disk[0].seq != seq {
+			return 0
+		}
+		seg := b.disk[0]
+		b.removeDisk(seg)
+		b.disk = b.disk[1:]
+		return seg.count
+	}
+	if len(b.mem) > 0 && b.mem[0].seq == seq {
+		n := len(b.mem[0].metrics)
+		b.memPoints -= n
+		b.mem = b.mem[1:]
+		return n
+	}
+	return 0
+}
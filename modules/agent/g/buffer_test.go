@@ -0,0 +1,219 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package g
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+)
+
+func bufferBatch(ts ...int64) []*model.MetricValue {
+	L := []*model.MetricValue{}
+	for _, t := range ts {
+		L = append(L, &model.MetricValue{Endpoint: "host", Metric: "m", Value: 1, Step: 60, Type: "GAUGE", Timestamp: t})
+	}
+	return L
+}
+
+func drainBuffer(t *testing.T, b *TransferBuffer) []int64 {
+	ret := []int64{}
+	for {
+		metrics, seq, ok := b.Peek()
+		if !ok {
+			return ret
+		}
+		for _, mv := range metrics {
+			ret = append(ret, mv.Timestamp)
+		}
+		b.Pop(seq)
+	}
+}
+
+func checkTimestamps(t *testing.T, got []int64, expect ...int64) {
+	t.Helper()
+	if len(got) != len(expect) {
+		t.Fatalf("expect %v, got %v", expect, got)
+	}
+	for i := range got {
+		if got[i] != expect[i] {
+			t.Fatalf("expect %v, got %v", expect, got)
+		}
+	}
+}
+
+func TestTransferBufferDropOldest(t *testing.T) {
+	b := NewTransferBuffer(4, "", 0)
+	b.Push(bufferBatch(1, 2))
+	b.Push(bufferBatch(3, 4))
+	b.Push(bufferBatch(5))
+
+	stat := b.Stat()
+	if stat.MemoryPoints != 3 || stat.Dropped != 2 {
+		t.Errorf("unexpected stat %+v", stat)
+	}
+	checkTimestamps(t, drainBuffer(t, b), 3, 4, 5)
+}
+
+func TestTransferBufferDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent-buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b := NewTransferBuffer(2, dir, 1<<20)
+	b.Push(bufferBatch(1, 2))
+	b.Push(bufferBatch(3))
+	b.Push(bufferBatch(4))
+	b.Push(bufferBatch(5))
+
+	stat := b.Stat()
+	if stat.MemoryPoints != 2 || stat.DiskPoints != 3 || stat.Dropped != 0 {
+		t.Errorf("unexpected stat %+v", stat)
+	}
+
+	// 重启后加载磁盘上的数据, 新数据排在后面
+	b = NewTransferBuffer(2, dir, 1<<20)
+	if stat := b.Stat(); stat.DiskPoints != 3 {
+		t.Errorf("expect 3 points loaded from disk, got %+v", stat)
+	}
+	b.Push(bufferBatch(6))
+	checkTimestamps(t, drainBuffer(t, b), 1, 2, 3, 6)
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("expect buffer files removed, got %d", len(files))
+	}
+}
+
+func TestTransferBufferDiskLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent-buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b := NewTransferBuffer(1, dir, 150)
+	for i := int64(1); i <= 4; i++ {
+		b.Push(bufferBatch(i))
+	}
+	// 每个文件约100字节, 磁盘上只保留最新的一个
+	stat := b.Stat()
+	if stat.DiskPoints != 1 || stat.Dropped != 2 {
+		t.Errorf("unexpected stat %+v", stat)
+	}
+	checkTimestamps(t, drainBuffer(t, b), 3, 4)
+}
+
+func TestBufferDrainerDiscard(t *testing.T) {
+	lock.Lock()
+	old := config
+	config = &GlobalConfig{}
+	lock.Unlock()
+	defer func() {
+		lock.Lock()
+		config = old
+		lock.Unlock()
+	}()
+
+	b := NewTransferBuffer(100, "", 0)
+	b.Push(bufferBatch(1))
+	b.Push(bufferBatch(2))
+
+	sent := []int64{}
+	transferOk := false
+	d := &bufferDrainer{
+		buffer:     b,
+		maxRetries: 2,
+		send: func(metrics []*model.MetricValue, resp *model.TransferResponse) bool {
+			// 第一批数据总是发送失败
+			if !transferOk || metrics[0].Timestamp == 1 {
+				return false
+			}
+			sent = append(sent, metrics[0].Timestamp)
+			return true
+		},
+		ping: func() bool { return transferOk },
+	}
+
+	// transfer不可用时一直重试
+	for i := 0; i < 5; i++ {
+		if d.drainOnce() {
+			t.Fatal("expect drain to fail")
+		}
+	}
+	if stat := b.Stat(); stat.MemoryPoints != 2 || stat.Discarded != 0 {
+		t.Fatalf("unexpected stat %+v", stat)
+	}
+
+	// transfer可用后, 第一批数据失败2次后丢弃
+	transferOk = true
+	if d.drainOnce() {
+		t.Fatal("expect drain to fail")
+	}
+	if !d.drainOnce() {
+		t.Fatal("expect the failing batch discarded")
+	}
+	if !d.drainOnce() {
+		t.Fatal("expect the next batch sent")
+	}
+	checkTimestamps(t, sent, 2)
+	if stat := b.Stat(); stat.MemoryPoints != 0 || stat.Discarded != 1 {
+		t.Errorf("unexpected stat %+v", stat)
+	}
+}
+
+// 缓存不为空时实时数据排在缓存的数据之后发送
+func TestSendToTransferOrdered(t *testing.T) {
+	lock.Lock()
+	old := config
+	config = &GlobalConfig{Transfer: &TransferConfig{}}
+	lock.Unlock()
+	oldBuffer := transferBuffer
+	defer func() {
+		lock.Lock()
+		config = old
+		lock.Unlock()
+		transferBuffer = oldBuffer
+	}()
+
+	transferBuffer = NewTransferBuffer(100, "", 0)
+	transferBuffer.Push(bufferBatch(1, 2))
+	transferBuffer.Push(bufferBatch(3))
+	SendToTransfer(bufferBatch(4))
+
+	sent := []int64{}
+	d := &bufferDrainer{
+		buffer:     transferBuffer,
+		maxRetries: 2,
+		send: func(metrics []*model.MetricValue, resp *model.TransferResponse) bool {
+			for _, mv := range metrics {
+				sent = append(sent, mv.Timestamp)
+			}
+			return true
+		},
+		ping: func() bool { return true },
+	}
+	for d.drainOnce() {
+		if len(sent) == 3 {
+			// 补发期间到达的实时数据
+			SendToTransfer(bufferBatch(5))
+		}
+	}
+	checkTimestamps(t, sent, 1, 2, 3, 4, 5)
+}
@@ -20,39 +20,230 @@ import (
 	"os"
 	"sync"
 
+	"github.com/open-falcon/falcon-plus/common/utils"
 	"github.com/toolkits/file"
 )
 
+// 插件进程的资源限制, 通过ulimit设置, 0表示不限制
+type PluginLimit struct {
+	CpuSeconds int `json:"cpuSeconds"` // 单次运行最多使用的cpu时间
+	MemoryMB   int `json:"memoryMB"`   // 虚拟内存上限
+	OpenFiles  int `json:"openFiles"`  // 打开文件数上限
+}
+
 type PluginConfig struct {
-	Enabled bool   `json:"enabled"`
-	Dir     string `json:"dir"`
-	Git     string `json:"git"`
-	LogDir  string `json:"logs"`
+	Enabled       bool                    `json:"enabled"`
+	Dir           string                  `json:"dir"`
+	Git           string                  `json:"git"`
+	LogDir        string                  `json:"logs"`
+	Source        string                  `json:"source"`        // 插件的来源, git(默认): 通过/plugin/update拉取; hbs: 从hbs下载插件包
+	User          string                  `json:"user"`          // 以该用户的身份运行插件, 需要agent以root运行
+	MaxConcurrent int                     `json:"maxConcurrent"` // 同时运行的插件数上限, 0表示不限制
+	Limit         *PluginLimit            `json:"limit"`         // 所有插件默认的资源限制
+	Limits        map[string]*PluginLimit `json:"limits"`        // 单个插件的资源限制, key为插件路径, 如 "sys/ntp/60_ntp.py"
 }
 
 type HeartbeatConfig struct {
-	Enabled  bool   `json:"enabled"`
-	Addr     string `json:"addr"`
-	Interval int    `json:"interval"`
-	Timeout  int    `json:"timeout"`
+	Enabled  bool             `json:"enabled"`
+	Addr     string           `json:"addr"`
+	Interval int              `json:"interval"`
+	Timeout  int              `json:"timeout"`
+	Tls      *utils.TlsConfig `json:"tls"`
+	// 随心跳上报监听的端口及进程, hbs据此维护机器的服务清单
+	Discovery bool `json:"discovery"`
+}
+
+type TransferBufferConfig struct {
+	Enabled    bool   `json:"enabled"`
+	MaxSize    int    `json:"maxSize"`    // 内存中最多缓存的数据点数, 默认100000
+	Dir        string `json:"dir"`        // 内存缓存满后写入磁盘的目录, 为空时丢弃最旧的数据
+	MaxDiskMB  int    `json:"maxDiskMB"`  // 磁盘缓存的最大空间, 默认1024
+	MaxBackoff int    `json:"maxBackoff"` // 重试的最大间隔, 单位秒, 默认60
+	MaxRetries int    `json:"maxRetries"` // transfer可用时一批数据补发失败的最大次数, 超过后丢弃, 默认5
 }
 
 type TransferConfig struct {
-	Enabled  bool     `json:"enabled"`
-	Addrs    []string `json:"addrs"`
-	Interval int      `json:"interval"`
-	Timeout  int      `json:"timeout"`
+	Enabled  bool                  `json:"enabled"`
+	Addrs    []string              `json:"addrs"`
+	Interval int                   `json:"interval"`
+	Timeout  int                   `json:"timeout"`
+	Buffer   *TransferBufferConfig `json:"buffer"` // transfer不可用时在本地缓存数据, 恢复后按时间顺序补发
+	// 使用Transfer.UpdateBatch批量发送时的压缩算法: snappy, gzip, none. 为空或transfer不支持时使用Transfer.Update
+	Codec string           `json:"codec"`
+	Tls   *utils.TlsConfig `json:"tls"`
 }
 
 type HttpConfig struct {
 	Enabled  bool   `json:"enabled"`
 	Listen   string `json:"listen"`
 	Backdoor bool   `json:"backdoor"`
+	Token    string `json:"token"` // 不为空时/v1/push需要在X-Falcon-Token header中带上该token
+}
+
+type ContainerConfig struct {
+	Enabled         bool     `json:"enabled"`
+	CgroupRoot      string   `json:"cgroupRoot"`
+	DockerSocket    string   `json:"dockerSocket"`
+	ContainerdState string   `json:"containerdState"` // containerd runtime v2的task目录
+	Labels          []string `json:"labels"`          // 作为tag上报的容器label或containerd annotation
 }
 
 type CollectorConfig struct {
-	IfacePrefix []string `json:"ifacePrefix"`
-	MountPoint  []string `json:"mountPoint"`
+	IfacePrefix []string         `json:"ifacePrefix"`
+	MountPoint  []string         `json:"mountPoint"`
+	Container   *ContainerConfig `json:"container"`
+	// 采集状态的systemd unit, 支持glob, 如 "nginx.service", "php*-fpm.service"
+	SystemdUnits []string `json:"systemdUnits"`
+	Hwmon        bool     `json:"hwmon"`    // 温度和风扇转速, 读取/sys/class/hwmon
+	Ntp          bool     `json:"ntp"`      // 时钟偏移, 通过chronyc或ntpq获取
+	FsHealth     bool     `json:"fsHealth"` // 被重新挂载为只读的文件系统和ext4的错误数
+	Edac         bool     `json:"edac"`     // 内存的ECC错误数
+	// 按端口上报连接状态、accept队列、rtt和重传的tcp端口, hbs下发的net.port.listen端口也会上报
+	TcpPorts  []int64 `json:"tcpPorts"`
+	Conntrack bool    `json:"conntrack"` // nf_conntrack表的使用量和丢弃数
+	// 各组采集项的采集周期, 单位秒, 如 {"base": 10, "du": 600}, 未配置的使用transfer.interval
+	// 采集周期即上报的step, graph按step区分rrd文件, 修改已在采集的周期后之前的历史数据不再显示
+	Intervals map[string]int `json:"intervals"`
+	Rollup    *RollupConfig  `json:"rollup"`
+}
+
+// 在上报周期内高频采样, 上报平均值、最小值、最大值和分位值, 用于发现cpu等指标的短时尖峰
+type RollupConfig struct {
+	Enabled        bool      `json:"enabled"`
+	Collectors     []string  `json:"collectors"`     // 高频采样的采集项, 如 ["base"]
+	SampleInterval int       `json:"sampleInterval"` // 采样周期, 单位秒, 默认1
+	Percentiles    []float64 `json:"percentiles"`    // 上报的分位值, 默认 [99]
+}
+
+type LogRule struct {
+	Name    string `json:"name"`    // 上报时pattern tag的值, 为空时使用Pattern
+	Path    string `json:"path"`    // 日志文件路径, 支持glob
+	Pattern string `json:"pattern"` // 正则表达式, 命名分组中的数值作为log.match.value上报
+	Tags    string `json:"tags"`    // 附加的tag, 如 "service=nginx"
+}
+
+type LogConfig struct {
+	Enabled    bool       `json:"enabled"`
+	OffsetFile string     `json:"offsetFile"` // 保存各个文件读取位置的文件, 重启后从上次的位置继续读取
+	Rules      []*LogRule `json:"rules"`
+}
+
+type StatsdConfig struct {
+	Enabled     bool      `json:"enabled"`
+	Listen      string    `json:"listen"`      // 同时监听udp和tcp, 如 ":8125"
+	Interval    int       `json:"interval"`    // 聚合周期, 单位秒, 默认60
+	Prefix      string    `json:"prefix"`      // 上报时metric的前缀, 如 "statsd."
+	Percentiles []float64 `json:"percentiles"` // timer上报的分位值, 默认 [90]
+	GaugeExpire int       `json:"gaugeExpire"` // gauge连续多少个周期没有更新后丢弃, 默认10
+}
+
+// prometheus exporter的抓取目标, 也可以通过hbs下发 prom.scrape url=http://127.0.0.1:9100/metrics,interval=30
+type PromTarget struct {
+	Url      string `json:"url"`
+	Interval int    `json:"interval"` // 抓取周期, 单位秒, 默认使用prometheus.interval
+	Tags     string `json:"tags"`     // 附加的tag, 如 "service=node"
+}
+
+type PrometheusConfig struct {
+	Enabled     bool          `json:"enabled"`
+	Interval    int           `json:"interval"` // 默认抓取周期, 单位秒, 默认60
+	Timeout     int           `json:"timeout"`  // 单次抓取的超时时间, 单位秒, 默认10
+	Prefix      string        `json:"prefix"`   // 上报时metric的前缀, 如 "prom."
+	Targets     []*PromTarget `json:"targets"`
+	MetricAllow []string      `json:"metricAllow"` // metric名称的正则, 配置后只上报匹配的metric
+	MetricDeny  []string      `json:"metricDeny"`  // metric名称的正则, 匹配的metric不上报
+	LabelAllow  []string      `json:"labelAllow"`  // label名称的正则, 配置后只有匹配的label转换为tag
+	LabelDeny   []string      `json:"labelDeny"`   // label名称的正则, 匹配的label不转换为tag
+}
+
+// 通过snmp采集的oid, walk为true时遍历oid下的所有实例, 上报时带上 index=<实例的后缀>
+type SnmpOid struct {
+	Metric string `json:"metric"`
+	Oid    string `json:"oid"`
+	Type   string `json:"type"` // GAUGE或COUNTER, COUNTER在agent端计算每秒的速率后上报, 并处理计数器回绕
+	Walk   bool   `json:"walk"`
+	Tags   string `json:"tags"`
+}
+
+type SnmpDevice struct {
+	Address      string     `json:"address"`  // host[:port], 默认端口161
+	Endpoint     string     `json:"endpoint"` // 上报时使用的endpoint, 默认为address中的host
+	Version      string     `json:"version"`  // "2c"或"3", 默认"2c"
+	Community    string     `json:"community"`
+	User         string     `json:"user"`
+	AuthProtocol string     `json:"authProtocol"` // MD5, SHA, SHA224, SHA256, SHA384或SHA512
+	AuthPassword string     `json:"authPassword"`
+	PrivProtocol string     `json:"privProtocol"` // DES, AES, AES192或AES256
+	PrivPassword string     `json:"privPassword"`
+	Interfaces   bool       `json:"interfaces"` // 是否采集接口的流量、包量、错误和状态
+	Oids         []*SnmpOid `json:"oids"`
+	Tags         string     `json:"tags"` // 附加的tag, 如 "idc=bj"
+}
+
+type SnmpConfig struct {
+	Enabled        bool          `json:"enabled"`
+	Interval       int           `json:"interval"`       // 采集周期, 单位秒, 默认60
+	Timeout        int           `json:"timeout"`        // 单次请求的超时时间, 单位秒, 默认5
+	Retries        int           `json:"retries"`        // 超时后的重试次数
+	MaxRepetitions int           `json:"maxRepetitions"` // GetBulk每次返回的最大行数, 默认20
+	Concurrency    int           `json:"concurrency"`    // 同时采集的设备数, 默认10
+	Oids           []*SnmpOid    `json:"oids"`           // 所有设备都采集的oid
+	Devices        []*SnmpDevice `json:"devices"`
+}
+
+type ActionArg struct {
+	Name     string `json:"name"`
+	Pattern  string `json:"pattern"` // 参数值必须完整匹配的正则, 默认 \w[\w.-]*
+	Required bool   `json:"required"`
+}
+
+// 预先声明的远程操作, 命令不经过shell执行, 参数中的 {{name}} 替换为请求中的参数值
+type Action struct {
+	Name    string       `json:"name"`
+	Command []string     `json:"command"`
+	Args    []*ActionArg `json:"args"`
+	Timeout int          `json:"timeout"` // 单位秒, 默认10
+}
+
+type ActionConfig struct {
+	Enabled   bool      `json:"enabled"`
+	Key       string    `json:"key"`       // 请求签名(hmac-sha256)的共享密钥
+	MaxSkew   int       `json:"maxSkew"`   // 请求时间戳与本机时间允许的误差, 单位秒, 默认300
+	MaxOutput int       `json:"maxOutput"` // 返回的输出的最大字节数, 默认65536
+	AuditLog  string    `json:"auditLog"`  // 审计日志, 记录每次请求的来源、用户、操作和结果
+	Actions   []*Action `json:"actions"`
+}
+
+type UpgradeConfig struct {
+	Enabled       bool   `json:"enabled"`
+	PublicKey     string `json:"publicKey"`     // base64编码的ed25519公钥, 配置后只安装签名正确的版本
+	StateFile     string `json:"stateFile"`     // 保存升级状态的文件, 默认 ./var/upgrade.json
+	HealthTimeout int    `json:"healthTimeout"` // 新版本在这段时间(秒)内无法连接hbs时回滚, 默认300
+	MaxBoots      int    `json:"maxBoots"`      // 新版本在确认之前退出这么多次时回滚, 默认3
+}
+
+// 发送给transfer之前按顺序执行的处理规则, metric和tags为匹配条件, 不满足条件的数据原样保留.
+// action为:
+// drop/keep: 丢弃匹配/不匹配的数据
+// rename: 将metric中匹配的部分替换为name, 可以使用$1引用分组
+// tag: 添加或改写set中的tag; untag: 删除remove中的tag
+// retag: 将tag的值中匹配pattern的部分替换为replace
+// scale: value = value * factor + offset, 用于单位换算
+// aggregate: 按metric和by中的tag分组, 使用func(sum/avg/max/min/count)合并, name不为空时作为合并后的metric
+type PipelineRule struct {
+	Action  string            `json:"action"`
+	Metric  string            `json:"metric"` // metric名称的正则, 为空时匹配所有
+	Tags    map[string]string `json:"tags"`   // tag值的正则, 所有tag都匹配时才执行
+	Name    string            `json:"name"`
+	Set     map[string]string `json:"set"`
+	Remove  []string          `json:"remove"`
+	Tag     string            `json:"tag"`
+	Pattern string            `json:"pattern"`
+	Replace string            `json:"replace"`
+	Factor  float64           `json:"factor"` // 为0时按1处理
+	Offset  float64           `json:"offset"`
+	By      []string          `json:"by"`
+	Func    string            `json:"func"`
 }
 
 type GlobalConfig struct {
@@ -64,6 +255,13 @@ type GlobalConfig struct {
 	Transfer      *TransferConfig   `json:"transfer"`
 	Http          *HttpConfig       `json:"http"`
 	Collector     *CollectorConfig  `json:"collector"`
+	Log           *LogConfig        `json:"log"`
+	Statsd        *StatsdConfig     `json:"statsd"`
+	Prometheus    *PrometheusConfig `json:"prometheus"`
+	Snmp          *SnmpConfig       `json:"snmp"`
+	Action        *ActionConfig     `json:"action"`
+	Upgrade       *UpgradeConfig    `json:"upgrade"`
+	Pipeline      []*PipelineRule   `json:"pipeline"`
 	DefaultTags   map[string]string `json:"default_tags"`
 	IgnoreMetrics map[string]bool   `json:"ignore"`
 }
@@ -39,7 +39,54 @@ func VersionMsg() string {
 const (
 	COLLECT_INTERVAL = time.Second
 	URL_CHECK_HEALTH = "url.check.health"
+	TCP_CHECK_HEALTH = "tcp.check.health"
+	DNS_CHECK_HEALTH = "dns.check.health"
 	NET_PORT_LISTEN  = "net.port.listen"
 	DU_BS            = "du.bs"
 	PROC_NUM         = "proc.num"
+	LOG_MATCH_COUNT  = "log.match.count"
+	LOG_MATCH_VALUE  = "log.match.value"
+	PROM_SCRAPE      = "prom.scrape"
 )
+
+const (
+	PLUGIN_SOURCE_GIT = "git"
+	PLUGIN_SOURCE_HBS = "hbs"
+)
+
+// nagios格式插件的退出码和性能数据
+const (
+	NAGIOS_STATUS = "nagios.status"
+	NAGIOS_PERF   = "nagios.perf"
+)
+
+// 进程组的资源指标, 与proc.num使用相同的name/cmdline匹配规则
+const (
+	PROC_METRIC_PREFIX = "proc."
+	PROC_CPU_PERCENT   = "proc.cpu.percent"
+	PROC_MEM_RSS       = "proc.mem.rss"
+	PROC_FD_NUM        = "proc.fd.num"
+	PROC_THREAD_NUM    = "proc.thread.num"
+	PROC_IO_READ       = "proc.io.read.bytes"
+	PROC_IO_WRITE      = "proc.io.write.bytes"
+	PROC_UPTIME        = "proc.uptime"
+)
+
+// systemd unit的状态, 与proc.num使用相同的方式通过hbs下发, tags为 unit=nginx.service
+const (
+	SYSTEMD_UNIT_PREFIX       = "systemd.unit."
+	SYSTEMD_UNIT_ACTIVE       = "systemd.unit.active"
+	SYSTEMD_UNIT_FAILED       = "systemd.unit.failed"
+	SYSTEMD_UNIT_ACTIVE_STATE = "systemd.unit.active.state"
+	SYSTEMD_UNIT_SUB_STATE    = "systemd.unit.sub.state"
+	SYSTEMD_UNIT_RESTARTS     = "systemd.unit.restarts"
+	SYSTEMD_UNIT_MEM          = "systemd.unit.mem.bytes"
+	SYSTEMD_UNIT_CPU_PERCENT  = "systemd.unit.cpu.percent"
+	SYSTEMD_UNIT_TASKS        = "systemd.unit.tasks"
+)
+
+// transfer不支持Transfer.UpdateBatch时, 间隔多久重新尝试
+const TRANSFER_LEGACY_RECHECK = 10 * time.Minute
+
+// 从hbs下载插件包和agent二进制文件的超时时间
+const DOWNLOAD_TIMEOUT = 10 * time.Minute
@@ -0,0 +1,64 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package g
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadFile(t *testing.T) {
+	data := []byte("plugin bundle")
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sign") != "ok" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "agent-download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bundle.tar.gz")
+
+	if err := DownloadFile(server.URL+"?sign=ok", path, checksum); err != nil {
+		t.Fatal(err)
+	}
+	if bs, _ := ioutil.ReadFile(path); string(bs) != string(data) {
+		t.Errorf("unexpected content %q", bs)
+	}
+
+	if err := DownloadFile(server.URL+"?sign=ok", path, checksum[:60]+"0000"); err == nil {
+		t.Error("expect checksum mismatch")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expect file removed after checksum mismatch")
+	}
+	if err := DownloadFile(server.URL, path, checksum); err == nil {
+		t.Error("expect download refused")
+	}
+}
@@ -0,0 +1,315 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package g
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+)
+
+const (
+	PIPELINE_DROP      = "drop"
+	PIPELINE_KEEP      = "keep"
+	PIPELINE_RENAME    = "rename"
+	PIPELINE_TAG       = "tag"
+	PIPELINE_UNTAG     = "untag"
+	PIPELINE_RETAG     = "retag"
+	PIPELINE_SCALE     = "scale"
+	PIPELINE_AGGREGATE = "aggregate"
+)
+
+var pipeline *Pipeline
+
+// InitPipeline 编译配置中的处理规则, 规则有误时退出
+func InitPipeline() {
+	if len(Config().Pipeline) == 0 {
+		return
+	}
+	p, err := NewPipeline(Config().Pipeline)
+	if err != nil {
+		log.Fatalln("invalid pipeline:", err)
+	}
+	pipeline = p
+	log.Printf("pipeline: %d rules", len(p.rules))
+}
+
+type pipelineRule struct {
+	*PipelineRule
+	metric  *regexp.Regexp
+	tags    map[string]*regexp.Regexp
+	pattern *regexp.Regexp
+}
+
+type Pipeline struct {
+	rules []*pipelineRule
+}
+
+func NewPipeline(rules []*PipelineRule) (*Pipeline, error) {
+	p := &Pipeline{}
+	for i, r := range rules {
+		cr := &pipelineRule{PipelineRule: r, tags: make(map[string]*regexp.Regexp)}
+		var err error
+		if r.Metric != "" {
+			if cr.metric, err = regexp.Compile(r.Metric); err != nil {
+				return nil, fmt.Errorf("rule %d: %v", i, err)
+			}
+		}
+		for k, v := range r.Tags {
+			if cr.tags[k], err = regexp.Compile(v); err != nil {
+				return nil, fmt.Errorf("rule %d: %v", i, err)
+			}
+		}
+
+		switch r.Action {
+		case PIPELINE_DROP, PIPELINE_KEEP, PIPELINE_TAG, PIPELINE_UNTAG, PIPELINE_SCALE:
+		case PIPELINE_RENAME:
+			if r.Name == "" {
+				return nil, fmt.Errorf("rule %d: rename requires name", i)
+			}
+		case PIPELINE_RETAG:
+			if r.Tag == "" || r.Pattern == "" {
+				return nil, fmt.Errorf("rule %d: retag requires tag and pattern", i)
+			}
+			if cr.pattern, err = regexp.Compile(r.Pattern); err != nil {
+				return nil, fmt.Errorf("rule %d: %v", i, err)
+			}
+		case PIPELINE_AGGREGATE:
+			switch r.Func {
+			case "sum", "avg", "max", "min", "count":
+			default:
+				return nil, fmt.Errorf("rule %d: unknown aggregate func %q", i, r.Func)
+			}
+		default:
+			return nil, fmt.Errorf("rule %d: unknown action %q", i, r.Action)
+		}
+		p.rules = append(p.rules, cr)
+	}
+	return p, nil
+}
+
+// parseTags 将 "k1=v1,k2=v2" 解析为map
+func parseTags(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		arr := strings.SplitN(kv, "=", 2)
+		if len(arr) == 2 {
+			tags[strings.TrimSpace(arr[0])] = strings.TrimSpace(arr[1])
+		}
+	}
+	return tags
+}
+
+func joinTags(tags map[string]string) string {
+	kv := make([]string, 0, len(tags))
+	for k, v := range tags {
+		kv = append(kv, k+"="+v)
+	}
+	sort.Strings(kv)
+	return strings.Join(kv, ",")
+}
+
+// metricFloat 将MetricValue中的值转换为float64, push接口传入的值可能是字符串
+func metricFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case float32:
+		return float64(x), true
+	case int:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	case string:
+		f, err := strconv.ParseFloat(x, 64)
+		return f, err == nil
+	}
+	f, err := strconv.ParseFloat(fmt.Sprint(v), 64)
+	return f, err == nil
+}
+
+func (r *pipelineRule) match(mv *model.MetricValue, tags map[string]string) bool {
+	if r.metric != nil && !r.metric.MatchString(mv.Metric) {
+		return false
+	}
+	for k, re := range r.tags {
+		v, ok := tags[k]
+		if !ok || !re.MatchString(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Process 依次执行所有规则, 返回处理后的数据, 可能修改传入的MetricValue
+func (p *Pipeline) Process(metrics []*model.MetricValue) []*model.MetricValue {
+	for _, r := range p.rules {
+		if r.Action == PIPELINE_AGGREGATE {
+			metrics = r.aggregate(metrics)
+			continue
+		}
+
+		ret := make([]*model.MetricValue, 0, len(metrics))
+		for _, mv := range metrics {
+			tags := parseTags(mv.Tags)
+			matched := r.match(mv, tags)
+			if r.Action == PIPELINE_KEEP {
+				if matched {
+					ret = append(ret, mv)
+				}
+				continue
+			}
+			if !matched {
+				ret = append(ret, mv)
+				continue
+			}
+			if r.apply(mv, tags) {
+				ret = append(ret, mv)
+			}
+		}
+		metrics = ret
+	}
+	return metrics
+}
+
+// apply 对匹配的数据执行规则, 返回false时丢弃
+func (r *pipelineRule) apply(mv *model.MetricValue, tags map[string]string) bool {
+	switch r.Action {
+	case PIPELINE_DROP:
+		return false
+	case PIPELINE_RENAME:
+		if r.metric == nil {
+			mv.Metric = r.Name
+		} else {
+			mv.Metric = r.metric.ReplaceAllString(mv.Metric, r.Name)
+		}
+	case PIPELINE_TAG:
+		for k, v := range r.Set {
+			tags[k] = v
+		}
+		mv.Tags = joinTags(tags)
+	case PIPELINE_UNTAG:
+		for _, k := range r.Remove {
+			delete(tags, k)
+		}
+		mv.Tags = joinTags(tags)
+	case PIPELINE_RETAG:
+		if v, ok := tags[r.Tag]; ok {
+			tags[r.Tag] = r.pattern.ReplaceAllString(v, r.Replace)
+			mv.Tags = joinTags(tags)
+		}
+	case PIPELINE_SCALE:
+		v, ok := metricFloat(mv.Value)
+		if !ok {
+			return false
+		}
+		factor := r.Factor
+		if factor == 0 {
+			factor = 1
+		}
+		mv.Value = v*factor + r.Offset
+	}
+	return true
+}
+
+type aggregation struct {
+	mv    *model.MetricValue
+	value float64
+	count int
+}
+
+// aggregate 合并匹配的数据, 只保留by中的tag, endpoint、metric、类型和周期相同的数据才会合并
+func (r *pipelineRule) aggregate(metrics []*model.MetricValue) []*model.MetricValue {
+	var ret []*model.MetricValue
+	groups := make(map[string]*aggregation)
+	var keys []string
+
+	for _, mv := range metrics {
+		tags := parseTags(mv.Tags)
+		if !r.match(mv, tags) {
+			ret = append(ret, mv)
+			continue
+		}
+		v, ok := metricFloat(mv.Value)
+		if !ok {
+			continue
+		}
+
+		kept := make(map[string]string)
+		for _, k := range r.By {
+			if tv, ok := tags[k]; ok {
+				kept[k] = tv
+			}
+		}
+		metric := mv.Metric
+		if r.Name != "" {
+			metric = r.Name
+		}
+		tagStr := joinTags(kept)
+		key := fmt.Sprintf("%s/%s/%s/%s/%d", mv.Endpoint, metric, tagStr, mv.Type, mv.Step)
+
+		a, ok := groups[key]
+		if !ok {
+			a = &aggregation{
+				mv: &model.MetricValue{
+					Endpoint:  mv.Endpoint,
+					Metric:    metric,
+					Tags:      tagStr,
+					Type:      mv.Type,
+					Step:      mv.Step,
+					Timestamp: mv.Timestamp,
+				},
+				value: v,
+			}
+			groups[key] = a
+			keys = append(keys, key)
+		} else {
+			switch r.Func {
+			case "sum", "avg":
+				a.value += v
+			case "max":
+				a.value = math.Max(a.value, v)
+			case "min":
+				a.value = math.Min(a.value, v)
+			}
+			if mv.Timestamp > a.mv.Timestamp {
+				a.mv.Timestamp = mv.Timestamp
+			}
+		}
+		a.count++
+	}
+
+	for _, key := range keys {
+		a := groups[key]
+		switch r.Func {
+		case "avg":
+			a.mv.Value = a.value / float64(a.count)
+		case "count":
+			a.mv.Value = a.count
+		default:
+			a.mv.Value = a.value
+		}
+		ret = append(ret, a.mv)
+	}
+	return ret
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package g
+
+import (
+	"testing"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+)
+
+func testMetrics() []*model.MetricValue {
+	return []*model.MetricValue{
+		{Endpoint: "host", Metric: "net.if.in.bytes", Value: int64(100), Type: "COUNTER", Tags: "iface=eth0", Step: 60},
+		{Endpoint: "host", Metric: "net.if.in.bytes", Value: int64(200), Type: "COUNTER", Tags: "iface=eth1", Step: 60},
+		{Endpoint: "host", Metric: "net.if.in.bytes", Value: int64(50), Type: "COUNTER", Tags: "iface=lo", Step: 60},
+		{Endpoint: "host", Metric: "mem.memfree", Value: uint64(2048), Type: "GAUGE", Step: 60},
+		{Endpoint: "host", Metric: "app.latency", Value: "1.5", Type: "GAUGE", Tags: "api=/login,env=dev", Step: 60},
+	}
+}
+
+func process(t *testing.T, rules ...*PipelineRule) map[string]*model.MetricValue {
+	t.Helper()
+	p, err := NewPipeline(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := make(map[string]*model.MetricValue)
+	for _, mv := range p.Process(testMetrics()) {
+		ret[mv.Metric+"/"+mv.Tags] = mv
+	}
+	return ret
+}
+
+func TestPipelineFilter(t *testing.T) {
+	ret := process(t, &PipelineRule{Action: PIPELINE_DROP, Metric: "^net\\.", Tags: map[string]string{"iface": "^lo$"}})
+	if len(ret) != 4 || ret["net.if.in.bytes/iface=lo"] != nil {
+		t.Errorf("unexpected drop result %v", ret)
+	}
+
+	ret = process(t, &PipelineRule{Action: PIPELINE_KEEP, Metric: "^(mem|app)\\."})
+	if len(ret) != 2 || ret["mem.memfree/"] == nil {
+		t.Errorf("unexpected keep result %v", ret)
+	}
+}
+
+func TestPipelineTransform(t *testing.T) {
+	ret := process(t,
+		&PipelineRule{Action: PIPELINE_RENAME, Metric: "^app\\.(.*)$", Name: "service.$1"},
+		&PipelineRule{Action: PIPELINE_TAG, Metric: "^service\\.", Set: map[string]string{"team": "sre", "env": "prod"}},
+		&PipelineRule{Action: PIPELINE_UNTAG, Remove: []string{"iface"}, Tags: map[string]string{"iface": "^eth0$"}},
+		&PipelineRule{Action: PIPELINE_RETAG, Tag: "api", Pattern: "^/", Replace: ""},
+		&PipelineRule{Action: PIPELINE_SCALE, Metric: "^mem\\.", Factor: 1.0 / 1024},
+		&PipelineRule{Action: PIPELINE_SCALE, Metric: "^service\\.", Factor: 1000, Offset: 1},
+	)
+	if mv := ret["service.latency/api=login,env=prod,team=sre"]; mv == nil || mv.Value != 1501.0 {
+		t.Errorf("unexpected service.latency %v", mv)
+	}
+	if mv := ret["net.if.in.bytes/"]; mv == nil || mv.Value != int64(100) {
+		t.Errorf("unexpected untag result %v", ret)
+	}
+	if mv := ret["mem.memfree/"]; mv == nil || mv.Value != 2.0 {
+		t.Errorf("unexpected scale result %v", mv)
+	}
+}
+
+func TestPipelineAggregate(t *testing.T) {
+	ret := process(t,
+		&PipelineRule{Action: PIPELINE_DROP, Metric: "^net\\.", Tags: map[string]string{"iface": "^lo$"}},
+		&PipelineRule{Action: PIPELINE_AGGREGATE, Metric: "^net\\.if\\.in\\.bytes$", Func: "sum", Name: "net.if.in.bytes.total"},
+	)
+	if len(ret) != 3 {
+		t.Errorf("unexpected aggregate result %v", ret)
+	}
+	if mv := ret["net.if.in.bytes.total/"]; mv == nil || mv.Value != 300.0 || mv.Type != "COUNTER" || mv.Endpoint != "host" || mv.Step != 60 {
+		t.Errorf("unexpected sum %v", mv)
+	}
+
+	ret = process(t, &PipelineRule{Action: PIPELINE_AGGREGATE, Metric: "^net\\.", Func: "avg", By: []string{"iface"}})
+	if mv := ret["net.if.in.bytes/iface=eth1"]; mv == nil || mv.Value != 200.0 {
+		t.Errorf("unexpected avg by iface %v", ret)
+	}
+
+	ret = process(t, &PipelineRule{Action: PIPELINE_AGGREGATE, Metric: "^net\\.", Func: "count"})
+	if mv := ret["net.if.in.bytes/"]; mv == nil || mv.Value != 3 {
+		t.Errorf("unexpected count %v", mv)
+	}
+}
+
+func TestPipelineInvalid(t *testing.T) {
+	for _, r := range []*PipelineRule{
+		{Action: "unknown"},
+		{Action: PIPELINE_DROP, Metric: "("},
+		{Action: PIPELINE_RENAME},
+		{Action: PIPELINE_RETAG, Tag: "a"},
+		{Action: PIPELINE_AGGREGATE, Func: "median"},
+	} {
+		if _, err := NewPipeline([]*PipelineRule{r}); err == nil {
+			t.Errorf("expect error for %+v", r)
+		}
+	}
+}
@@ -15,8 +15,9 @@
 package g
 
 import (
+	"crypto/tls"
 	"errors"
-	"github.com/toolkits/net"
+	"github.com/open-falcon/falcon-plus/common/utils"
 	"log"
 	"math"
 	"net/rpc"
@@ -29,6 +30,7 @@ type SingleConnRpcClient struct {
 	rpcClient *rpc.Client
 	RpcServer string
 	Timeout   time.Duration
+	TlsConfig *tls.Config
 }
 
 func (this *SingleConnRpcClient) close() {
@@ -51,7 +53,7 @@ func (this *SingleConnRpcClient) serverConn() error {
 			return nil
 		}
 
-		this.rpcClient, err = net.JsonRpcClient("tcp", this.RpcServer, this.Timeout)
+		this.rpcClient, err = utils.JsonRpcClient("tcp", this.RpcServer, this.Timeout, this.TlsConfig)
 		if err != nil {
 			log.Printf("dial %s fail: %v", this.RpcServer, err)
 			if retry > 3 {
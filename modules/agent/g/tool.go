@@ -18,7 +18,9 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/toolkits/file"
+	"io/ioutil"
 	"os/exec"
+	"path/filepath"
 	"strings"
 )
 
@@ -32,6 +34,10 @@ func GetCurrPluginVersion() string {
 		return "plugin dir not existent"
 	}
 
+	if Config().Plugin.Source == PLUGIN_SOURCE_HBS {
+		return PluginBundleVersion()
+	}
+
 	cmd := exec.Command("git", "rev-parse", "HEAD")
 	cmd.Dir = pluginDir
 
@@ -44,3 +50,15 @@ func GetCurrPluginVersion() string {
 
 	return strings.TrimSpace(out.String())
 }
+
+// hbs分发的插件包解压后, 版本号保存在插件目录下的这个文件中
+const PluginBundleVersionFile = ".bundle_version"
+
+// PluginBundleVersion 当前安装的插件包版本, 没有安装时返回空
+func PluginBundleVersion() string {
+	bs, err := ioutil.ReadFile(filepath.Join(Config().Plugin.Dir, PluginBundleVersionFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(bs))
+}
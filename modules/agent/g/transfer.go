@@ -15,8 +15,11 @@
 package g
 
 import (
+	"crypto/tls"
 	"log"
 	"math/rand"
+	"net/rpc"
+	"strings"
 	"sync"
 	"time"
 
@@ -28,7 +31,19 @@ var (
 	TransferClients     map[string]*SingleConnRpcClient = map[string]*SingleConnRpcClient{}
 )
 
-func SendMetrics(metrics []*model.MetricValue, resp *model.TransferResponse) {
+var transferBuffer *TransferBuffer
+
+// 连接transfer使用的tls配置, 在InitRpcClients中加载
+var transferTlsConfig *tls.Config
+
+// 不支持Transfer.UpdateBatch的transfer及探测的时间, 超过TRANSFER_LEGACY_RECHECK后重新尝试, 以便transfer升级后生效
+var (
+	transferLegacyLock = new(sync.RWMutex)
+	transferLegacyAt   = map[string]time.Time{}
+)
+
+// SendMetrics 依次尝试各个transfer, 全部失败时返回false
+func SendMetrics(metrics []*model.MetricValue, resp *model.TransferResponse) bool {
 	rand.Seed(time.Now().UnixNano())
 	for _, i := range rand.Perm(len(Config().Transfer.Addrs)) {
 		addr := Config().Transfer.Addrs[i]
@@ -39,15 +54,136 @@ func SendMetrics(metrics []*model.MetricValue, resp *model.TransferResponse) {
 		}
 
 		if updateMetrics(c, metrics, resp) {
-			break
+			return true
 		}
 	}
+	return false
+}
+
+// PingTransfer 有任意一个transfer可用时返回true
+func PingTransfer() bool {
+	for _, addr := range Config().Transfer.Addrs {
+		c := getTransferClient(addr)
+		if c == nil {
+			c = initTransferClient(addr)
+		}
+
+		var resp model.SimpleRpcResponse
+		if err := c.Call("Transfer.Ping", model.NullRpcRequest{}, &resp); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// InitTransferBuffer 开启transfer不可用时的本地缓存, 并在后台补发缓存的数据
+func InitTransferBuffer() {
+	cfg := Config().Transfer.Buffer
+	if cfg == nil || !cfg.Enabled {
+		return
+	}
+
+	maxSize := cfg.MaxSize
+	if maxSize <= 0 {
+		maxSize = 100000
+	}
+	maxDiskMB := cfg.MaxDiskMB
+	if maxDiskMB <= 0 {
+		maxDiskMB = 1024
+	}
+	maxBackoff := cfg.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = 60
+	}
+	maxRetries := cfg.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = 5
+	}
+
+	transferBuffer = NewTransferBuffer(maxSize, cfg.Dir, int64(maxDiskMB)*1024*1024)
+	d := &bufferDrainer{buffer: transferBuffer, send: SendMetrics, ping: PingTransfer, maxRetries: maxRetries}
+	go d.run(time.Duration(maxBackoff) * time.Second)
+}
+
+// TransferBufferStat 返回缓存深度, 没有开启缓存时返回nil
+func TransferBufferStat() *BufferStat {
+	if transferBuffer == nil {
+		return nil
+	}
+	stat := transferBuffer.Stat()
+	return &stat
+}
+
+// bufferDrainer 按从旧到新的顺序补发缓存的数据, 缓存不为空时实时数据也放入缓存, 保证按时间顺序发送.
+// 一批数据发送失败, 而transfer能ping通时, 说明这批数据本身发送不出去,
+// 这样失败maxRetries次后丢弃这批数据, 以免一直阻塞后面的数据
+type bufferDrainer struct {
+	buffer     *TransferBuffer
+	send       func([]*model.MetricValue, *model.TransferResponse) bool
+	ping       func() bool
+	maxRetries int
+	seq        uint64 // 正在补发的一批数据的序号
+	failures   int    // 在transfer可用时这批数据失败的次数
+}
+
+// 失败后按指数退避重试
+func (d *bufferDrainer) run(maxBackoff time.Duration) {
+	backoff := time.Second
+	for {
+		if d.drainOnce() {
+			backoff = time.Second
+			continue
+		}
+		if d.buffer.Len() == 0 {
+			time.Sleep(time.Second)
+			continue
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// drainOnce 补发最旧的一批数据, 发送成功或者丢弃时返回true
+func (d *bufferDrainer) drainOnce() bool {
+	metrics, seq, ok := d.buffer.Peek()
+	if !ok {
+		return false
+	}
+	if seq != d.seq {
+		d.seq = seq
+		d.failures = 0
+	}
+
+	var resp model.TransferResponse
+	if d.send(metrics, &resp) {
+		d.buffer.Pop(seq)
+		if Config().Debug {
+			log.Printf("resend %d buffered metrics, <= %v", len(metrics), &resp)
+		}
+		return true
+	}
+
+	if !d.ping() {
+		return false
+	}
+	d.failures++
+	if d.failures >= d.maxRetries {
+		log.Printf("discard %d buffered metrics after %d failures", len(metrics), d.failures)
+		d.buffer.Discard(seq)
+		return true
+	}
+	return false
 }
 
 func initTransferClient(addr string) *SingleConnRpcClient {
 	var c *SingleConnRpcClient = &SingleConnRpcClient{
 		RpcServer: addr,
 		Timeout:   time.Duration(Config().Transfer.Timeout) * time.Millisecond,
+		TlsConfig: transferTlsConfig,
 	}
 	TransferClientsLock.Lock()
 	defer TransferClientsLock.Unlock()
@@ -57,6 +193,25 @@ func initTransferClient(addr string) *SingleConnRpcClient {
 }
 
 func updateMetrics(c *SingleConnRpcClient, metrics []*model.MetricValue, resp *model.TransferResponse) bool {
+	codec := Config().Transfer.Codec
+	if codec != "" && !transferLegacy(c.RpcServer) {
+		batch, err := model.EncodeTransferBatch(metrics, codec)
+		if err != nil {
+			log.Println("encode transfer batch fail:", err)
+		} else {
+			err = c.Call("Transfer.UpdateBatch", batch, resp)
+			if err == nil {
+				return true
+			}
+			if !isMethodNotFound(err) {
+				log.Println("call Transfer.UpdateBatch fail:", c, err)
+				return false
+			}
+			log.Printf("transfer %s does not support Transfer.UpdateBatch, fallback to Transfer.Update", c.RpcServer)
+			setTransferLegacy(c.RpcServer)
+		}
+	}
+
 	err := c.Call("Transfer.Update", metrics, resp)
 	if err != nil {
 		log.Println("call Transfer.Update fail:", c, err)
@@ -74,3 +229,22 @@ func getTransferClient(addr string) *SingleConnRpcClient {
 	}
 	return nil
 }
+
+func transferLegacy(addr string) bool {
+	transferLegacyLock.RLock()
+	defer transferLegacyLock.RUnlock()
+
+	t, ok := transferLegacyAt[addr]
+	return ok && time.Since(t) < TRANSFER_LEGACY_RECHECK
+}
+
+func setTransferLegacy(addr string) {
+	transferLegacyLock.Lock()
+	defer transferLegacyLock.Unlock()
+	transferLegacyAt[addr] = time.Now()
+}
+
+func isMethodNotFound(err error) bool {
+	_, ok := err.(rpc.ServerError)
+	return ok && strings.HasPrefix(err.Error(), "rpc: can't find method")
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package g
+
+import (
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"testing"
+	"time"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+)
+
+type legacyTransfer struct {
+	received []*model.MetricValue
+}
+
+func (t *legacyTransfer) Update(args []*model.MetricValue, reply *model.TransferResponse) error {
+	t.received = append(t.received, args...)
+	reply.Total = len(args)
+	return nil
+}
+
+type batchTransfer struct {
+	legacyTransfer
+	batches int
+}
+
+func (t *batchTransfer) UpdateBatch(args *model.TransferBatch, reply *model.TransferResponse) error {
+	metrics, err := model.DecodeTransferBatch(args)
+	if err != nil {
+		return err
+	}
+	t.batches++
+	return t.Update(metrics, reply)
+}
+
+func startTransfer(t *testing.T, rcvr interface{}) net.Listener {
+	server := rpc.NewServer()
+	if err := server.RegisterName("Transfer", rcvr); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+		}
+	}()
+	return ln
+}
+
+func TestUpdateMetricsBatch(t *testing.T) {
+	lock.Lock()
+	old := config
+	config = &GlobalConfig{Transfer: &TransferConfig{Codec: "snappy"}}
+	lock.Unlock()
+	defer func() {
+		lock.Lock()
+		config = old
+		lock.Unlock()
+	}()
+
+	metrics := []*model.MetricValue{
+		{Endpoint: "host1", Metric: "cpu.idle", Value: 95.5, Step: 60, Type: "GAUGE", Tags: "env=prod", Timestamp: 1500000000},
+		{Endpoint: "host1", Metric: "load.1min", Value: 1, Step: 60, Type: "GAUGE", Tags: "env=prod", Timestamp: 1500000000},
+	}
+
+	bt := &batchTransfer{}
+	ln := startTransfer(t, bt)
+	defer ln.Close()
+	addr := ln.Addr().String()
+	c := &SingleConnRpcClient{RpcServer: addr, Timeout: time.Second}
+	var resp model.TransferResponse
+	if !updateMetrics(c, metrics, &resp) {
+		t.Fatal("update metrics fail")
+	}
+	if bt.batches != 1 || len(bt.received) != 2 || resp.Total != 2 {
+		t.Errorf("expect 1 batch with 2 metrics, got %d batches, %v", bt.batches, bt.received)
+	}
+	if transferLegacy(addr) {
+		t.Error("transfer with UpdateBatch should not be marked legacy")
+	}
+
+	lt := &legacyTransfer{}
+	ln = startTransfer(t, lt)
+	defer ln.Close()
+	addr = ln.Addr().String()
+	c = &SingleConnRpcClient{RpcServer: addr, Timeout: time.Second}
+	for i := 0; i < 2; i++ {
+		if !updateMetrics(c, metrics, &resp) {
+			t.Fatal("update metrics fail")
+		}
+	}
+	if len(lt.received) != 4 {
+		t.Errorf("expect 4 metrics by fallback, got %v", lt.received)
+	}
+	if !transferLegacy(addr) {
+		t.Error("transfer without UpdateBatch should be marked legacy")
+	}
+}
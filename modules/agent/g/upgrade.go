@@ -0,0 +1,137 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package g
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// 升级过程中的状态
+const (
+	UPGRADE_UPGRADING = "upgrading" // 已经替换为新版本, 等待新版本确认可以连接hbs
+	UPGRADE_ROLLBACK  = "rollback"  // 新版本不可用, 已经换回旧版本
+)
+
+const (
+	// 开启自动升级时agent在supervisor的子进程中运行, 子进程带有该环境变量
+	UPGRADE_SUPERVISED_ENV = "FALCON_AGENT_SUPERVISED"
+	// 子进程安装了新版本或者回滚后以该退出码退出, supervisor立即重新启动
+	UPGRADE_RESTART_CODE = 100
+)
+
+// UpgradeState 保存在stateFile中, agent重新执行后据此确认升级结果或者回滚
+type UpgradeState struct {
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	Status  string   `json:"status"`
+	Message string   `json:"message"`
+	Time    int64    `json:"time"`
+	Boots   int      `json:"boots"`  // 新版本确认之前退出的次数, 由supervisor记录
+	Failed  []string `json:"failed"` // 升级失败的版本, 不再尝试
+}
+
+func (s *UpgradeState) IsFailed(version string) bool {
+	for _, v := range s.Failed {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *UpgradeState) AddFailed(version string) {
+	if !s.IsFailed(version) {
+		s.Failed = append(s.Failed, version)
+	}
+}
+
+func LoadUpgradeState(path string) (*UpgradeState, error) {
+	s := &UpgradeState{}
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	return s, json.Unmarshal(bs, s)
+}
+
+func SaveUpgradeState(path string, s *UpgradeState) error {
+	bs, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, bs, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// VerifyAgentBinary 校验agent二进制文件的sha256, 配置了公钥时校验ed25519签名
+func VerifyAgentBinary(data []byte, checksum, signature, publicKey string) error {
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != checksum {
+		return errors.New("checksum mismatch")
+	}
+	if publicKey == "" {
+		return nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("bad public key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(key), data, sig) {
+		return errors.New("bad signature")
+	}
+	return nil
+}
+
+// GuardUpgrade 由supervisor在agent子进程退出后调用: 新版本还没有确认升级成功就退出时计入启动次数,
+// 达到maxBoots次后换回 <exe>.old, 返回是否回滚. 新版本在启动时崩溃时也可以据此回滚
+func GuardUpgrade(exe, stateFile string, maxBoots int) (bool, error) {
+	state, err := LoadUpgradeState(stateFile)
+	if err != nil || state.Status != UPGRADE_UPGRADING {
+		return false, err
+	}
+
+	state.Boots++
+	if state.Boots < maxBoots {
+		return false, SaveUpgradeState(stateFile, state)
+	}
+
+	if err := os.Rename(exe+".old", exe); err != nil {
+		return false, err
+	}
+	state.Status = UPGRADE_ROLLBACK
+	state.Message = fmt.Sprintf("agent %s exited %d times before confirming the upgrade", state.To, state.Boots)
+	state.AddFailed(state.To)
+	return true, SaveUpgradeState(stateFile, state)
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package g
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyAgentBinary(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("falcon-agent binary")
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data))
+	publicKey := base64.StdEncoding.EncodeToString(pub)
+
+	if err := VerifyAgentBinary(data, checksum, "", ""); err != nil {
+		t.Errorf("expect checksum only verify ok, got %v", err)
+	}
+	if err := VerifyAgentBinary(data, checksum, signature, publicKey); err != nil {
+		t.Errorf("expect signature verify ok, got %v", err)
+	}
+	if err := VerifyAgentBinary([]byte("other"), checksum, signature, publicKey); err == nil {
+		t.Error("expect checksum mismatch")
+	}
+	if err := VerifyAgentBinary(data, checksum, "", publicKey); err == nil {
+		t.Error("expect signature required")
+	}
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	if err := VerifyAgentBinary(data, checksum, signature, base64.StdEncoding.EncodeToString(other)); err == nil {
+		t.Error("expect bad signature with another key")
+	}
+}
+
+func TestUpgradeState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent-upgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "var", "upgrade.json")
+
+	state, err := LoadUpgradeState(path)
+	if err != nil || state.Status != "" {
+		t.Fatalf("expect empty state, got %+v %v", state, err)
+	}
+
+	state = &UpgradeState{From: "6.0.0", To: "6.0.1", Status: UPGRADE_UPGRADING}
+	state.AddFailed("6.0.1")
+	state.AddFailed("6.0.1")
+	if err := SaveUpgradeState(path, state); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadUpgradeState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.To != "6.0.1" || loaded.Status != UPGRADE_UPGRADING || len(loaded.Failed) != 1 || !loaded.IsFailed("6.0.1") {
+		t.Errorf("unexpected state %+v", loaded)
+	}
+}
+
+func TestGuardUpgrade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent-upgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	exe := filepath.Join(dir, "falcon-agent")
+	stateFile := filepath.Join(dir, "var", "upgrade.json")
+	ioutil.WriteFile(exe, []byte("new"), 0755)
+	ioutil.WriteFile(exe+".old", []byte("old"), 0755)
+
+	// 没有在升级时不处理
+	if rolledBack, err := GuardUpgrade(exe, stateFile, 3); rolledBack || err != nil {
+		t.Fatalf("expect nothing to do, got %v %v", rolledBack, err)
+	}
+
+	SaveUpgradeState(stateFile, &UpgradeState{From: "6.0.0", To: "6.0.1", Status: UPGRADE_UPGRADING})
+	for i := 0; i < 2; i++ {
+		if rolledBack, err := GuardUpgrade(exe, stateFile, 3); rolledBack || err != nil {
+			t.Fatalf("expect no rollback before 3 exits, got %v %v", rolledBack, err)
+		}
+	}
+	if rolledBack, err := GuardUpgrade(exe, stateFile, 3); !rolledBack || err != nil {
+		t.Fatalf("expect rollback after 3 exits, got %v %v", rolledBack, err)
+	}
+
+	if bs, _ := ioutil.ReadFile(exe); string(bs) != "old" {
+		t.Errorf("expect old binary restored, got %s", bs)
+	}
+	state, _ := LoadUpgradeState(stateFile)
+	if state.Status != UPGRADE_ROLLBACK || state.Boots != 3 || !state.IsFailed("6.0.1") {
+		t.Errorf("unexpected state %+v", state)
+	}
+}
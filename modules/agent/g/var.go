@@ -95,10 +95,23 @@ var (
 
 func InitRpcClients() {
 	if Config().Heartbeat.Enabled {
+		tlsConfig, err := Config().Heartbeat.Tls.ClientConfig()
+		if err != nil {
+			log.Fatalln("load heartbeat tls config fail:", err)
+		}
 		HbsClient = &SingleConnRpcClient{
 			RpcServer: Config().Heartbeat.Addr,
 			Timeout:   time.Duration(Config().Heartbeat.Timeout) * time.Millisecond,
+			TlsConfig: tlsConfig,
+		}
+	}
+
+	if Config().Transfer.Enabled {
+		tlsConfig, err := Config().Transfer.Tls.ClientConfig()
+		if err != nil {
+			log.Fatalln("load transfer tls config fail:", err)
 		}
+		transferTlsConfig = tlsConfig
 	}
 }
 
@@ -107,6 +120,13 @@ func SendToTransfer(metrics []*model.MetricValue) {
 		return
 	}
 
+	if pipeline != nil {
+		metrics = pipeline.Process(metrics)
+		if len(metrics) == 0 {
+			return
+		}
+	}
+
 	dt := Config().DefaultTags
 	if len(dt) > 0 {
 		var buf bytes.Buffer
@@ -139,29 +159,50 @@ func SendToTransfer(metrics []*model.MetricValue) {
 		log.Printf("=> <Total=%d> %v\n", len(metrics), metrics[0])
 	}
 
+	// 有缓存的数据还没有补发时直接放入缓存, 保证数据按时间顺序发送
+	if transferBuffer != nil && transferBuffer.Len() > 0 {
+		transferBuffer.Push(metrics)
+		return
+	}
+
 	var resp model.TransferResponse
-	SendMetrics(metrics, &resp)
+	if !SendMetrics(metrics, &resp) {
+		if transferBuffer != nil {
+			transferBuffer.Push(metrics)
+		}
+		return
+	}
 
 	if debug {
 		log.Println("<=", &resp)
 	}
 }
 
+// 通过hbs下发的拨测任务, 如
+// url.check.health url=https://example.com/health,timeout=5,status=200,body=ok
+// tcp.check.health addr=127.0.0.1:3306,timeout=3
+// dns.check.health domain=example.com,timeout=3,server=8.8.8.8:53
+type Probe struct {
+	Metric string
+	Tags   string            // strategy中的tags, 上报时原样带上
+	Params map[string]string // 解析后的tags
+}
+
 var (
-	reportUrls     map[string]string
-	reportUrlsLock = new(sync.RWMutex)
+	reportProbes     []*Probe
+	reportProbesLock = new(sync.RWMutex)
 )
 
-func ReportUrls() map[string]string {
-	reportUrlsLock.RLock()
-	defer reportUrlsLock.RUnlock()
-	return reportUrls
+func ReportProbes() []*Probe {
+	reportProbesLock.RLock()
+	defer reportProbesLock.RUnlock()
+	return reportProbes
 }
 
-func SetReportUrls(urls map[string]string) {
-	reportUrlsLock.RLock()
-	defer reportUrlsLock.RUnlock()
-	reportUrls = urls
+func SetReportProbes(probes []*Probe) {
+	reportProbesLock.Lock()
+	defer reportProbesLock.Unlock()
+	reportProbes = probes
 }
 
 var (
@@ -218,6 +259,60 @@ func SetReportProcs(procs map[string]map[int]string) {
 	reportProcs = procs
 }
 
+var (
+	// 通过hbs下发的日志监控规则
+	reportLogs     []*LogRule
+	reportLogsLock = new(sync.RWMutex)
+)
+
+func ReportLogs() []*LogRule {
+	reportLogsLock.RLock()
+	defer reportLogsLock.RUnlock()
+	return reportLogs
+}
+
+func SetReportLogs(rules []*LogRule) {
+	reportLogsLock.Lock()
+	defer reportLogsLock.Unlock()
+	reportLogs = rules
+}
+
+var (
+	// 通过hbs下发的prometheus抓取目标
+	reportPromTargets     []*PromTarget
+	reportPromTargetsLock = new(sync.RWMutex)
+)
+
+func ReportPromTargets() []*PromTarget {
+	reportPromTargetsLock.RLock()
+	defer reportPromTargetsLock.RUnlock()
+	return reportPromTargets
+}
+
+func SetReportPromTargets(targets []*PromTarget) {
+	reportPromTargetsLock.Lock()
+	defer reportPromTargetsLock.Unlock()
+	reportPromTargets = targets
+}
+
+var (
+	// 通过hbs下发的systemd unit
+	reportUnits     []string
+	reportUnitsLock = new(sync.RWMutex)
+)
+
+func ReportUnits() []string {
+	reportUnitsLock.RLock()
+	defer reportUnitsLock.RUnlock()
+	return reportUnits
+}
+
+func SetReportUnits(units []string) {
+	reportUnitsLock.Lock()
+	defer reportUnitsLock.Unlock()
+	reportUnits = units
+}
+
 var (
 	ips     []string
 	ipsLock = new(sync.Mutex)
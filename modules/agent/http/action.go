@@ -0,0 +1,390 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+)
+
+const (
+	ActionSignatureHeader = "X-Falcon-Signature"
+	defaultActionArg      = `\w[\w.-]*` // 不能以 - 开头, 以免被当作命令的选项
+)
+
+// 远程操作请求, 请求体的hmac-sha256签名放在X-Falcon-Signature头中
+type ActionRequest struct {
+	Action    string            `json:"action"`
+	Args      map[string]string `json:"args"`
+	User      string            `json:"user"`      // 发起操作的用户, 记录在审计日志中
+	Timestamp int64             `json:"timestamp"` // 请求时间, 超过maxSkew的请求被拒绝
+	Nonce     string            `json:"nonce"`     // 随机串, 防止请求被重放
+}
+
+type ActionResult struct {
+	Action    string `json:"action"`
+	ExitCode  int    `json:"exitCode"`
+	Output    string `json:"output"`
+	Truncated bool   `json:"truncated"`
+	Timeout   bool   `json:"timeout"`
+	Duration  int64  `json:"duration"` // 毫秒
+}
+
+type actionAudit struct {
+	Time     string            `json:"time"`
+	Remote   string            `json:"remote"`
+	User     string            `json:"user"`
+	Action   string            `json:"action"`
+	Args     map[string]string `json:"args,omitempty"`
+	Command  []string          `json:"command,omitempty"`
+	ExitCode int               `json:"exitCode"`
+	Duration int64             `json:"duration"`
+	Error    string            `json:"error,omitempty"`
+}
+
+var (
+	auditLock  = new(sync.Mutex)
+	nonceLock  = new(sync.Mutex)
+	seenNonces = make(map[string]int64)
+)
+
+func configActionRoutes() {
+	http.HandleFunc("/action", func(w http.ResponseWriter, r *http.Request) {
+		cfg := g.Config().Action
+		if cfg == nil || !cfg.Enabled {
+			http.Error(w, "/action disabled", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		audit := &actionAudit{Remote: r.RemoteAddr, ExitCode: -1}
+		defer func() { writeAudit(cfg.AuditLog, audit) }()
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 64*1024))
+		if err != nil {
+			audit.Error = err.Error()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var req ActionRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			audit.Error = err.Error()
+			http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		audit.User, audit.Action, audit.Args = req.User, req.Action, req.Args
+
+		if err := verifyAction(cfg, body, r.Header.Get(ActionSignatureHeader), &req, time.Now()); err != nil {
+			audit.Error = err.Error()
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		action := findAction(cfg, req.Action)
+		if action == nil {
+			audit.Error = "unknown action"
+			http.Error(w, "unknown action: "+req.Action, http.StatusNotFound)
+			return
+		}
+		command, err := actionCommand(action, req.Args)
+		if err != nil {
+			audit.Error = err.Error()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		audit.Command = command
+
+		result, err := runAction(action, command, actionMaxOutput(cfg))
+		if err != nil {
+			audit.Error = err.Error()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		audit.ExitCode, audit.Duration = result.ExitCode, result.Duration
+		if result.Timeout {
+			audit.Error = "timeout"
+		}
+		RenderDataJson(w, result)
+	})
+
+	// 列出可用的操作及其参数, 不包含命令
+	http.HandleFunc("/actions", func(w http.ResponseWriter, r *http.Request) {
+		cfg := g.Config().Action
+		if cfg == nil || !cfg.Enabled {
+			http.Error(w, "/action disabled", http.StatusForbidden)
+			return
+		}
+		if !g.IsTrustable(r.RemoteAddr) {
+			w.Write([]byte("no privilege"))
+			return
+		}
+		ret := make(map[string][]*g.ActionArg)
+		for _, action := range cfg.Actions {
+			ret[action.Name] = action.Args
+		}
+		RenderDataJson(w, ret)
+	})
+}
+
+// ActionSignature 请求体的签名: hex(hmac-sha256(key, body))
+func ActionSignature(key string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 校验签名、时间戳和nonce
+func verifyAction(cfg *g.ActionConfig, body []byte, signature string, req *ActionRequest, now time.Time) error {
+	if cfg.Key == "" {
+		return errors.New("action key not configured")
+	}
+	if !hmac.Equal([]byte(ActionSignature(cfg.Key, body)), []byte(strings.ToLower(signature))) {
+		return errors.New("bad signature")
+	}
+
+	maxSkew := int64(cfg.MaxSkew)
+	if maxSkew <= 0 {
+		maxSkew = 300
+	}
+	ts := now.Unix()
+	if req.Timestamp < ts-maxSkew || req.Timestamp > ts+maxSkew {
+		return errors.New("request expired")
+	}
+	if req.Nonce == "" {
+		return errors.New("nonce is blank")
+	}
+
+	nonceLock.Lock()
+	defer nonceLock.Unlock()
+	for nonce, t := range seenNonces {
+		if t < ts-maxSkew {
+			delete(seenNonces, nonce)
+		}
+	}
+	if _, ok := seenNonces[req.Nonce]; ok {
+		return errors.New("request replayed")
+	}
+	seenNonces[req.Nonce] = ts
+	return nil
+}
+
+func findAction(cfg *g.ActionConfig, name string) *g.Action {
+	for _, action := range cfg.Actions {
+		if action.Name == name {
+			return action
+		}
+	}
+	return nil
+}
+
+// 校验参数并替换命令中的 {{name}}, 引用了没有传入的可选参数的命令行参数被去掉
+func actionCommand(action *g.Action, args map[string]string) ([]string, error) {
+	if len(action.Command) == 0 {
+		return nil, fmt.Errorf("action %s has no command", action.Name)
+	}
+
+	declared := make(map[string]bool)
+	missing := []string{}
+	replaces := []string{}
+	for _, arg := range action.Args {
+		declared[arg.Name] = true
+		v, ok := args[arg.Name]
+		if !ok {
+			if arg.Required {
+				return nil, fmt.Errorf("arg %s is required", arg.Name)
+			}
+			missing = append(missing, "{{"+arg.Name+"}}")
+			continue
+		}
+		pattern := arg.Pattern
+		if pattern == "" {
+			pattern = defaultActionArg
+		}
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern of arg %s: %v", arg.Name, err)
+		}
+		if !re.MatchString(v) {
+			return nil, fmt.Errorf("arg %s does not match %s", arg.Name, pattern)
+		}
+		replaces = append(replaces, "{{"+arg.Name+"}}", v)
+	}
+	for name := range args {
+		if !declared[name] {
+			return nil, fmt.Errorf("unknown arg %s", name)
+		}
+	}
+
+	r := strings.NewReplacer(replaces...)
+	command := []string{}
+next:
+	for _, s := range action.Command {
+		for _, m := range missing {
+			if strings.Contains(s, m) {
+				continue next
+			}
+		}
+		command = append(command, r.Replace(s))
+	}
+	if len(command) == 0 {
+		return nil, fmt.Errorf("action %s has no command", action.Name)
+	}
+	return command, nil
+}
+
+func actionMaxOutput(cfg *g.ActionConfig) int {
+	if cfg.MaxOutput > 0 {
+		return cfg.MaxOutput
+	}
+	return 65536
+}
+
+// 只保留前max个字节的输出.
+// 超时后进程组外的子进程可能仍然持有输出的管道, 加锁以便在等待超时后安全地读取
+type limitedBuffer struct {
+	sync.Mutex
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	if left := b.max - b.buf.Len(); left < len(p) {
+		b.truncated = true
+		if left > 0 {
+			b.buf.Write(p[:left])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) Result() (string, bool) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String(), b.truncated
+}
+
+// 超时kill之后等待进程退出的时间
+const actionKillWait = 5 * time.Second
+
+func runAction(action *g.Action, command []string, maxOutput int) (*ActionResult, error) {
+	timeout := action.Timeout
+	if timeout <= 0 {
+		timeout = 10
+	}
+
+	out := &limitedBuffer{max: maxOutput}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var err error
+	isTimeout := false
+	select {
+	case err = <-done:
+	case <-time.After(time.Duration(timeout) * time.Second):
+		isTimeout = true
+		log.Printf("action %s timeout, kill process %d", action.Name, cmd.Process.Pid)
+		if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+			log.Println("kill action process fail:", err)
+		}
+		// 等进程退出、输出读完后再生成结果
+		select {
+		case <-done:
+		case <-time.After(actionKillWait):
+			log.Printf("action %s process %d not exited after kill", action.Name, cmd.Process.Pid)
+		}
+	}
+
+	output, truncated := out.Result()
+	result := &ActionResult{
+		Action:    action.Name,
+		Timeout:   isTimeout,
+		Duration:  int64(time.Since(start) / time.Millisecond),
+		Truncated: truncated,
+		Output:    output,
+	}
+	if isTimeout {
+		result.ExitCode = -1
+	} else if exitErr, ok := err.(*exec.ExitError); ok {
+		result.ExitCode = exitErr.ExitCode()
+	} else if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// 审计日志, 每行一个json
+func writeAudit(path string, audit *actionAudit) {
+	audit.Time = time.Now().Format("2006-01-02 15:04:05")
+	bs, err := json.Marshal(audit)
+	if err != nil {
+		return
+	}
+	if path == "" {
+		log.Println("[AUDIT]", string(bs))
+		return
+	}
+
+	auditLock.Lock()
+	defer auditLock.Unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Println("create audit log dir fail:", err)
+		return
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Println("open audit log fail:", err)
+		return
+	}
+	defer f.Close()
+	f.Write(append(bs, '\n'))
+}
@@ -0,0 +1,121 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+)
+
+func TestVerifyAction(t *testing.T) {
+	cfg := &g.ActionConfig{Key: "secret", MaxSkew: 60}
+	now := time.Now()
+	req := &ActionRequest{Action: "restart", Timestamp: now.Unix(), Nonce: "n1"}
+	body, _ := json.Marshal(req)
+	sig := ActionSignature(cfg.Key, body)
+
+	if err := verifyAction(cfg, body, "bad", req, now); err == nil {
+		t.Error("expect bad signature")
+	}
+	if err := verifyAction(cfg, body, sig, req, now.Add(2*time.Minute)); err == nil {
+		t.Error("expect request expired")
+	}
+	if err := verifyAction(cfg, body, sig, req, now); err != nil {
+		t.Errorf("expect valid request, got %v", err)
+	}
+	if err := verifyAction(cfg, body, sig, req, now); err == nil {
+		t.Error("expect request replayed")
+	}
+	if err := verifyAction(&g.ActionConfig{}, body, ActionSignature("", body), req, now); err == nil {
+		t.Error("expect error without key")
+	}
+}
+
+func TestActionCommand(t *testing.T) {
+	action := &g.Action{
+		Name:    "restart",
+		Command: []string{"systemctl", "restart", "--job-mode={{mode}}", "{{service}}"},
+		Args: []*g.ActionArg{
+			{Name: "service", Pattern: "[a-z]+", Required: true},
+			{Name: "mode"},
+		},
+	}
+
+	// 没有传入的可选参数不会以空串的形式出现在命令中
+	command, err := actionCommand(action, map[string]string{"service": "nginx"})
+	if err != nil || strings.Join(command, "|") != "systemctl|restart|nginx" {
+		t.Errorf("unexpected command %q %v", command, err)
+	}
+	command, err = actionCommand(action, map[string]string{"service": "nginx", "mode": "replace"})
+	if err != nil || strings.Join(command, "|") != "systemctl|restart|--job-mode=replace|nginx" {
+		t.Errorf("unexpected command %q %v", command, err)
+	}
+	for _, args := range []map[string]string{
+		{},
+		{"service": "nginx; rm -rf /"},
+		{"service": "nginx", "mode": "a b"},
+		{"service": "nginx", "mode": ""},
+		{"service": "nginx", "other": "x"},
+	} {
+		if _, err := actionCommand(action, args); err == nil {
+			t.Errorf("expect error on args %v", args)
+		}
+	}
+}
+
+// 默认的参数格式不允许以 - 开头, 以免被命令当作选项
+func TestActionCommandLeadingDash(t *testing.T) {
+	action := &g.Action{
+		Name:    "tail",
+		Command: []string{"tail", "-n", "{{lines}}", "{{file}}"},
+		Args:    []*g.ActionArg{{Name: "lines", Required: true}, {Name: "file", Required: true}},
+	}
+	if _, err := actionCommand(action, map[string]string{"lines": "100", "file": "app.log"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range []map[string]string{
+		{"lines": "100", "file": "--follow=name"},
+		{"lines": "-1", "file": "app.log"},
+		{"lines": "100", "file": ".-x"},
+	} {
+		if _, err := actionCommand(action, args); err == nil {
+			t.Errorf("expect error on args %v", args)
+		}
+	}
+}
+
+func TestRunAction(t *testing.T) {
+	action := &g.Action{Name: "echo", Timeout: 1}
+	result, err := runAction(action, []string{"sh", "-c", "echo 0123456789; exit 3"}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Output != "01234" || !result.Truncated || result.ExitCode != 3 {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	// 超时kill之后仍然返回kill之前的输出
+	result, err = runAction(action, []string{"sh", "-c", "echo abc; sleep 5"}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Timeout || result.Output != "abc\n" || result.ExitCode != -1 {
+		t.Errorf("expect timeout, got %+v", result)
+	}
+}
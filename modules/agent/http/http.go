@@ -28,6 +28,7 @@ type Dto struct {
 }
 
 func init() {
+	configActionRoutes()
 	configAdminRoutes()
 	configCpuRoutes()
 	configDfRoutes()
@@ -40,6 +41,7 @@ func init() {
 	configPushRoutes()
 	configRunRoutes()
 	configSystemRoutes()
+	configTransferRoutes()
 }
 
 func RenderJson(w http.ResponseWriter, v interface{}) {
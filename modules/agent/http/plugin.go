@@ -30,6 +30,11 @@ func configPluginRoutes() {
 			return
 		}
 
+		if g.Config().Plugin.Source == g.PLUGIN_SOURCE_HBS {
+			w.Write([]byte("plugins are distributed by hbs, current bundle: " + g.PluginBundleVersion()))
+			return
+		}
+
 		dir := g.Config().Plugin.Dir
 		parentDir := file.Dir(dir)
 		file.InsureDir(parentDir)
@@ -63,6 +68,11 @@ func configPluginRoutes() {
 			return
 		}
 
+		if g.Config().Plugin.Source == g.PLUGIN_SOURCE_HBS {
+			w.Write([]byte("plugins are distributed by hbs, current bundle: " + g.PluginBundleVersion()))
+			return
+		}
+
 		dir := g.Config().Plugin.Dir
 
 		if file.IsExist(dir) {
@@ -78,7 +88,6 @@ func configPluginRoutes() {
 	})
 
 	http.HandleFunc("/plugins", func(w http.ResponseWriter, r *http.Request) {
-		//TODO: not thread safe
-		RenderDataJson(w, plugins.Plugins)
+		RenderDataJson(w, plugins.Statuses())
 	})
 }
@@ -17,12 +17,18 @@ package http
 import (
 	"encoding/json"
 	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/common/utils"
 	"github.com/open-falcon/falcon-plus/modules/agent/g"
 	"net/http"
 )
 
 func configPushRoutes() {
 	http.HandleFunc("/v1/push", func(w http.ResponseWriter, req *http.Request) {
+		if !utils.CheckToken(req, g.Config().Http.Token) {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+
 		if req.ContentLength == 0 {
 			http.Error(w, "body is blank", http.StatusBadRequest)
 			return
@@ -41,8 +41,17 @@ func configRunRoutes() {
 			}
 
 			body := string(bs)
+			audit := &actionAudit{Remote: r.RemoteAddr, Action: "/run", Command: []string{"sh", "-c", body}}
+			auditLog := ""
+			if cfg := g.Config().Action; cfg != nil {
+				auditLog = cfg.AuditLog
+			}
+			defer func() { writeAudit(auditLog, audit) }()
+
 			out, err := sys.CmdOutBytes("sh", "-c", body)
 			if err != nil {
+				audit.ExitCode = -1
+				audit.Error = err.Error()
 				w.Write([]byte("exec fail: " + err.Error()))
 				return
 			}
@@ -0,0 +1,37 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+)
+
+func configTransferRoutes() {
+	http.HandleFunc("/page/transfer/buffer", func(w http.ResponseWriter, r *http.Request) {
+		stat := g.TransferBufferStat()
+		if stat == nil {
+			RenderDataJson(w, "disabled")
+			return
+		}
+		RenderDataJson(w, fmt.Sprintf("%d in memory, %d on disk, %d dropped, %d discarded", stat.MemoryPoints, stat.DiskPoints, stat.Dropped, stat.Discarded))
+	})
+
+	http.HandleFunc("/proc/transfer/buffer", func(w http.ResponseWriter, r *http.Request) {
+		RenderDataJson(w, g.TransferBufferStat())
+	})
+}
@@ -21,6 +21,9 @@ import (
 	"github.com/open-falcon/falcon-plus/modules/agent/funcs"
 	"github.com/open-falcon/falcon-plus/modules/agent/g"
 	"github.com/open-falcon/falcon-plus/modules/agent/http"
+	"github.com/open-falcon/falcon-plus/modules/agent/prom"
+	"github.com/open-falcon/falcon-plus/modules/agent/snmp"
+	"github.com/open-falcon/falcon-plus/modules/agent/statsd"
 	"os"
 )
 
@@ -54,9 +57,17 @@ func main() {
 		g.InitLog("info")
 	}
 
+	// 开启自动升级时由supervisor在子进程中运行agent, 新版本启动失败时可以换回旧版本
+	if up := g.Config().Upgrade; up != nil && up.Enabled && os.Getenv(g.UPGRADE_SUPERVISED_ENV) == "" {
+		cron.SuperviseAgent(up)
+		return
+	}
+
 	g.InitRootDir()
 	g.InitLocalIp()
 	g.InitRpcClients()
+	g.InitTransferBuffer()
+	g.InitPipeline()
 
 	funcs.BuildMappers()
 
@@ -66,7 +77,12 @@ func main() {
 	cron.SyncMinePlugins()
 	cron.SyncBuiltinMetrics()
 	cron.SyncTrustableIps()
+	cron.SyncAgentUpgrade()
 	cron.Collect()
+	cron.TailLogs()
+	statsd.Start()
+	prom.Start()
+	snmp.Start()
 
 	go http.Start()
 
@@ -0,0 +1,211 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+)
+
+var bundleVersionRe = regexp.MustCompile(`^[\w.-]+$`)
+
+// SyncBundle hbs上的插件包版本与本地不同时, 通过http下载、校验并安装新的插件包
+func SyncBundle(version, checksum, url string) error {
+	if version == "" || version == g.PluginBundleVersion() {
+		return nil
+	}
+	if !bundleVersionRe.MatchString(version) {
+		return fmt.Errorf("bad plugin bundle version %q", version)
+	}
+	if url == "" {
+		return fmt.Errorf("no download url of plugin bundle %s", version)
+	}
+
+	dir := filepath.Clean(g.Config().Plugin.Dir)
+	archive := dir + "-" + version + ".tar.gz"
+	if err := g.DownloadFile(url, archive, checksum); err != nil {
+		return err
+	}
+	defer os.Remove(archive)
+
+	if err := InstallBundle(dir, version, archive); err != nil {
+		return err
+	}
+	log.Printf("plugin bundle %s installed", version)
+	return nil
+}
+
+// 本机安装过的插件包目录记录在 <dir>.bundles 中, 清理旧版本时只删除其中记录的目录
+func bundleListFile(dir string) string {
+	return dir + ".bundles"
+}
+
+func loadBundleList(dir string) []string {
+	bs, err := ioutil.ReadFile(bundleListFile(dir))
+	if err != nil {
+		return nil
+	}
+	prefix := filepath.Base(dir) + "-"
+	names := []string{}
+	for _, name := range strings.Fields(string(bs)) {
+		// 只接受 <dir>-<version> 形式的名字, 避免删除其他目录
+		if strings.HasPrefix(name, prefix) && bundleVersionRe.MatchString(name[len(prefix):]) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func saveBundleList(dir string, names []string) error {
+	return ioutil.WriteFile(bundleListFile(dir), []byte(strings.Join(names, "\n")+"\n"), 0644)
+}
+
+// InstallBundle 将已经校验过的插件包archive解压到 <dir>-<version> 目录, 然后把dir替换为指向该目录的符号链接.
+// 通过rename替换符号链接, 正在运行的插件不会看到只解压了一半的目录.
+// 保留上一个版本以便回滚, 本机安装过的更早版本被删除; dir原来是普通目录时被重命名为 <dir>.orig
+func InstallBundle(dir, version, archive string) error {
+	if !bundleVersionRe.MatchString(version) {
+		return fmt.Errorf("bad plugin bundle version %q", version)
+	}
+
+	dir = filepath.Clean(dir)
+	target := dir + "-" + version
+	tmp := target + ".tmp"
+	os.RemoveAll(tmp)
+	if err := extractBundle(archive, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, g.PluginBundleVersionFile), []byte(version+"\n"), 0644); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	os.RemoveAll(target)
+	if err := os.Rename(tmp, target); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	previous := ""
+	if fi, err := os.Lstat(dir); err == nil {
+		if fi.Mode()&os.ModeSymlink != 0 {
+			previous, _ = os.Readlink(dir)
+		} else {
+			orig := dir + ".orig"
+			os.RemoveAll(orig)
+			if err := os.Rename(dir, orig); err != nil {
+				return err
+			}
+		}
+	}
+
+	link := dir + ".link"
+	os.Remove(link)
+	if err := os.Symlink(filepath.Base(target), link); err != nil {
+		return err
+	}
+	if err := os.Rename(link, dir); err != nil {
+		os.Remove(link)
+		return err
+	}
+
+	// 删除本机安装过的更早版本
+	names := append(loadBundleList(dir), filepath.Base(target))
+	keep := []string{}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if name != filepath.Base(target) && name != previous {
+			os.RemoveAll(filepath.Join(filepath.Dir(dir), name))
+			continue
+		}
+		keep = append(keep, name)
+	}
+	return saveBundleList(dir, keep)
+}
+
+// 解压tar.gz, 只处理目录和普通文件, 拒绝解压到dest之外的路径
+func extractBundle(archive string, dest string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Clean(hdr.Name)
+		if name == "." {
+			continue
+		}
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("bad file name in plugin bundle: %s", hdr.Name)
+		}
+		path := filepath.Join(dest, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode)&0755)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			log.Printf("skip %s in plugin bundle, type %c", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+)
+
+func makeBundle(t *testing.T, dir string, files map[string]string) string {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for name, body := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(body)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(body))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	f, err := ioutil.TempFile(dir, "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func readPlugin(t *testing.T, path string) string {
+	t.Helper()
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bs)
+}
+
+func TestInstallBundle(t *testing.T) {
+	root, err := ioutil.TempDir("", "agent-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	dir := filepath.Join(root, "plugin")
+	os.MkdirAll(filepath.Join(dir, "sys"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "sys", "60_old.sh"), []byte("old"), 0755)
+
+	// dir旁边其他的目录不会被当作旧版本删除
+	os.MkdirAll(filepath.Join(root, "plugin-data"), 0755)
+
+	v1 := makeBundle(t, root, map[string]string{"sys/60_ntp.sh": "v1"})
+	if err := InstallBundle(dir, "v1", v1); err != nil {
+		t.Fatal(err)
+	}
+	if got := readPlugin(t, filepath.Join(dir, "sys", "60_ntp.sh")); got != "v1" {
+		t.Errorf("expect v1, got %s", got)
+	}
+	if got := readPlugin(t, filepath.Join(dir, g.PluginBundleVersionFile)); got != "v1\n" {
+		t.Errorf("unexpected version file %q", got)
+	}
+	// 原来的插件目录被保留
+	if got := readPlugin(t, filepath.Join(root, "plugin.orig", "sys", "60_old.sh")); got != "old" {
+		t.Errorf("expect original dir kept, got %s", got)
+	}
+
+	v2 := makeBundle(t, root, map[string]string{"sys/60_ntp.sh": "v2"})
+	v3 := makeBundle(t, root, map[string]string{"sys/60_ntp.sh": "v3"})
+	if err := InstallBundle(dir, "v2", v2); err != nil {
+		t.Fatal(err)
+	}
+	if err := InstallBundle(dir, "v3", v3); err != nil {
+		t.Fatal(err)
+	}
+	if got := readPlugin(t, filepath.Join(dir, "sys", "60_ntp.sh")); got != "v3" {
+		t.Errorf("expect v3, got %s", got)
+	}
+	// 只保留当前和上一个版本
+	versions, _ := filepath.Glob(dir + "-*")
+	if len(versions) != 3 || filepath.Base(versions[0]) != "plugin-data" || filepath.Base(versions[1]) != "plugin-v2" || filepath.Base(versions[2]) != "plugin-v3" {
+		t.Errorf("unexpected versions %v", versions)
+	}
+	if got := readPlugin(t, dir+".bundles"); got != "plugin-v2\nplugin-v3\n" {
+		t.Errorf("unexpected bundle list %q", got)
+	}
+
+	evil := makeBundle(t, root, map[string]string{"../evil.sh": "evil"})
+	if err := InstallBundle(dir, "v4", evil); err == nil {
+		t.Error("expect bad file name")
+	}
+	if _, err := os.Stat(filepath.Join(root, "evil.sh")); !os.IsNotExist(err) {
+		t.Error("expect nothing written outside the bundle")
+	}
+	if err := InstallBundle(dir, "../v5", v3); err == nil {
+		t.Error("expect bad version")
+	}
+}
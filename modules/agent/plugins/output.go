@@ -0,0 +1,242 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+)
+
+// 插件的输出格式
+const (
+	FORMAT_JSON   = "json"   // MetricValue的json数组
+	FORMAT_NAGIOS = "nagios" // nagios插件: 退出码表示状态, 第一行 "TEXT | perfdata"
+	FORMAT_INFLUX = "influx" // influx line protocol
+)
+
+var (
+	nagiosStatusRe = regexp.MustCompile(`^[\w .:-]*\b(OK|WARNING|CRITICAL|UNKNOWN)\b`)
+	nagiosPerfRe   = regexp.MustCompile(`('[^']+'|[^'=\s]+)=(-?[0-9.]+)([a-zA-Z%]*)(;\S*)?`)
+)
+
+// DetectFormat 根据输出内容和退出码判断插件的输出格式
+func DetectFormat(data []byte, exitCode int) string {
+	data = bytes.TrimSpace(data)
+	if exitCode == 0 && len(data) > 0 && data[0] == '[' {
+		return FORMAT_JSON
+	}
+	if isNagiosOutput(data) {
+		return FORMAT_NAGIOS
+	}
+	return FORMAT_INFLUX
+}
+
+// 第一行以nagios状态开头, 或者 | 后面是性能数据
+func isNagiosOutput(data []byte) bool {
+	first := bytes.TrimSpace(data)
+	if idx := bytes.IndexByte(first, '\n'); idx >= 0 {
+		first = first[:idx]
+	}
+	if nagiosStatusRe.Match(first) {
+		return true
+	}
+	idx := bytes.IndexByte(first, '|')
+	return idx >= 0 && nagiosPerfRe.Match(first[idx+1:])
+}
+
+// ParseOutput 按格式解析插件的输出, name为插件名, 作为nagios指标的plugin tag.
+// nagios和influx格式的指标没有Endpoint和Step, 由调用方填充
+func ParseOutput(format string, data []byte, exitCode int, name string) ([]*model.MetricValue, error) {
+	switch format {
+	case FORMAT_JSON:
+		var metrics []*model.MetricValue
+		err := json.Unmarshal(data, &metrics)
+		return metrics, err
+	case FORMAT_NAGIOS:
+		return parseNagios(data, exitCode, name), nil
+	case FORMAT_INFLUX:
+		return parseInflux(data)
+	}
+	return nil, fmt.Errorf("unknown plugin output format %s", format)
+}
+
+func newGauge(metric string, value float64, tags string) *model.MetricValue {
+	return &model.MetricValue{Metric: metric, Value: value, Type: "GAUGE", Tags: tags}
+}
+
+// nagios的退出码 0:OK 1:WARNING 2:CRITICAL 3:UNKNOWN 上报为nagios.status,
+// 性能数据 'label'=value[UOM];warn;crit;min;max 上报为nagios.perf, 字节和时间换算为B和秒
+func parseNagios(data []byte, exitCode int, name string) []*model.MetricValue {
+	if exitCode < 0 || exitCode > 3 {
+		exitCode = 3
+	}
+	tags := "plugin=" + sanitizeTag(name)
+	L := []*model.MetricValue{newGauge(g.NAGIOS_STATUS, float64(exitCode), tags)}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		idx := strings.IndexByte(line, '|')
+		if idx < 0 {
+			continue
+		}
+		for _, m := range nagiosPerfRe.FindAllStringSubmatch(line[idx+1:], -1) {
+			v, err := strconv.ParseFloat(m[2], 64)
+			if err != nil {
+				continue
+			}
+			label := strings.Trim(m[1], "'")
+			mv := newGauge(g.NAGIOS_PERF, v, tags+",label="+sanitizeTag(label))
+			switch m[3] {
+			case "ms":
+				mv.Value = v / 1e3
+			case "us":
+				mv.Value = v / 1e6
+			case "KB":
+				mv.Value = v * 1024
+			case "MB":
+				mv.Value = v * 1024 * 1024
+			case "GB":
+				mv.Value = v * 1024 * 1024 * 1024
+			case "TB":
+				mv.Value = v * 1024 * 1024 * 1024 * 1024
+			case "c":
+				mv.Type = "COUNTER"
+			}
+			L = append(L, mv)
+		}
+	}
+	return L
+}
+
+// influx line protocol: measurement[,tag=v...] field=v[,field=v...] [timestamp(ns)]
+// 每个数值字段上报为 measurement.field, 字段名为value时上报为measurement, 字符串字段被忽略
+func parseInflux(data []byte) ([]*model.MetricValue, error) {
+	L := []*model.MetricValue{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := splitEscaped(line, ' ')
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("bad influx line: %s", line)
+		}
+
+		keys := splitEscaped(parts[0], ',')
+		measurement := unescape(keys[0])
+		tags := []string{}
+		for _, kv := range keys[1:] {
+			pair := splitEscaped(kv, '=')
+			if len(pair) != 2 {
+				return nil, fmt.Errorf("bad influx tag %s", kv)
+			}
+			tags = append(tags, sanitizeTag(unescape(pair[0]))+"="+sanitizeTag(unescape(pair[1])))
+		}
+		sort.Strings(tags)
+
+		var ts int64
+		if len(parts) == 3 {
+			ns, err := strconv.ParseInt(parts[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad influx timestamp %s", parts[2])
+			}
+			ts = ns / 1e9
+		}
+
+		for _, kv := range splitEscaped(parts[1], ',') {
+			pair := splitEscaped(kv, '=')
+			if len(pair) != 2 {
+				return nil, fmt.Errorf("bad influx field %s", kv)
+			}
+			v, ok := influxValue(pair[1])
+			if !ok {
+				continue
+			}
+			metric := measurement
+			if field := unescape(pair[0]); field != "value" {
+				metric += "." + field
+			}
+			mv := newGauge(metric, v, strings.Join(tags, ","))
+			mv.Timestamp = ts
+			L = append(L, mv)
+		}
+	}
+	return L, nil
+}
+
+func influxValue(s string) (float64, bool) {
+	switch s {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true
+	}
+	if strings.HasPrefix(s, "\"") {
+		return 0, false
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "i"), "u")
+	v, err := strconv.ParseFloat(s, 64)
+	return v, err == nil
+}
+
+// 按sep切分, 跳过反斜杠转义的字符和双引号中的内容
+func splitEscaped(s string, sep byte) []string {
+	ret := []string{}
+	start := 0
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			ret = append(ret, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(ret, s[start:])
+}
+
+func unescape(s string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.String()
+}
+
+func sanitizeTag(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', '=', ' ', '\t':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		data     string
+		exitCode int
+		expect   string
+	}{
+		{`[{"metric":"a","value":1}]`, 0, FORMAT_JSON},
+		{"DISK OK - free space: / 3326 MB (56%);| /=2643MB;5948;5958;0;5968", 0, FORMAT_NAGIOS},
+		{"CRITICAL - load too high", 2, FORMAT_NAGIOS},
+		{"PING OK - Packet loss = 0%", 0, FORMAT_NAGIOS},
+		{"cpu,host=a usage=0.5 1500000000000000000", 0, FORMAT_INFLUX},
+		{"check_foo: /etc/foo.conf: no such file", 1, FORMAT_INFLUX},
+		{"connections 12 | grep fail", 1, FORMAT_INFLUX},
+		{"| load1=0.5;5;10", 1, FORMAT_NAGIOS},
+	}
+	for _, tt := range tests {
+		if got := DetectFormat([]byte(tt.data), tt.exitCode); got != tt.expect {
+			t.Errorf("DetectFormat(%q, %d) expect %s, got %s", tt.data, tt.exitCode, tt.expect, got)
+		}
+	}
+}
+
+func findMetric(L []*model.MetricValue, metric, tags string) *model.MetricValue {
+	for _, mv := range L {
+		if mv.Metric == metric && mv.Tags == tags {
+			return mv
+		}
+	}
+	return nil
+}
+
+func TestParseNagios(t *testing.T) {
+	out := "DISK WARNING - free space: /data 100 MB|'/data used'=2MB;5;8;0;10 time=150ms\nmore text | inodes=42%;80;90 reqs=100c\n"
+	L, err := ParseOutput(FORMAT_NAGIOS, []byte(out), 1, "60_check_disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := "plugin=60_check_disk"
+	expects := []struct {
+		metric, tags string
+		value        float64
+		typ          string
+	}{
+		{g.NAGIOS_STATUS, tags, 1, "GAUGE"},
+		{g.NAGIOS_PERF, tags + ",label=/data_used", 2 * 1024 * 1024, "GAUGE"},
+		{g.NAGIOS_PERF, tags + ",label=time", 0.15, "GAUGE"},
+		{g.NAGIOS_PERF, tags + ",label=inodes", 42, "GAUGE"},
+		{g.NAGIOS_PERF, tags + ",label=reqs", 100, "COUNTER"},
+	}
+	if len(L) != len(expects) {
+		t.Fatalf("expect %d metrics, got %v", len(expects), L)
+	}
+	for _, e := range expects {
+		mv := findMetric(L, e.metric, e.tags)
+		if mv == nil {
+			t.Errorf("metric %s %s not found", e.metric, e.tags)
+			continue
+		}
+		if mv.Value != e.value || mv.Type != e.typ {
+			t.Errorf("metric %s %s expect %v %s, got %v %s", e.metric, e.tags, e.value, e.typ, mv.Value, mv.Type)
+		}
+	}
+
+	// 超出范围的退出码作为UNKNOWN
+	L, _ = ParseOutput(FORMAT_NAGIOS, nil, 127, "check")
+	if len(L) != 1 || L[0].Value != float64(3) {
+		t.Errorf("expect unknown status, got %v", L)
+	}
+}
+
+func TestParseInflux(t *testing.T) {
+	out := "# comment\n" +
+		"nginx,host=web\\ 1,dc=bj requests=10i,active=3.5,up=true,version=\"1.2 3\" 1500000000123456789\n" +
+		"temperature value=21.5\n"
+	L, err := ParseOutput(FORMAT_INFLUX, []byte(out), 0, "60_nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(L) != 4 {
+		t.Fatalf("expect 4 metrics, got %v", L)
+	}
+	tags := "dc=bj,host=web_1"
+	for metric, value := range map[string]float64{"nginx.requests": 10, "nginx.active": 3.5, "nginx.up": 1} {
+		mv := findMetric(L, metric, tags)
+		if mv == nil || mv.Value != value || mv.Timestamp != 1500000000 {
+			t.Errorf("unexpected metric %s: %v", metric, mv)
+		}
+	}
+	if mv := findMetric(L, "temperature", ""); mv == nil || mv.Value != 21.5 || mv.Timestamp != 0 {
+		t.Errorf("unexpected metric temperature: %v", mv)
+	}
+
+	if _, err := ParseOutput(FORMAT_INFLUX, []byte("bad line without fields x y z"), 0, "x"); err == nil {
+		t.Error("expect error on bad line")
+	}
+}
+
+func TestSandboxCommand(t *testing.T) {
+	cmd, err := sandboxCommand("/bin/sh", []string{"-c", "ulimit -n"}, &g.PluginLimit{OpenFiles: 64}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "64" {
+		t.Errorf("expect open files limit 64, got %s", got)
+	}
+}
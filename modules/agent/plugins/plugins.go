@@ -42,6 +42,7 @@ func AddNewPlugins(newPlugins map[string]*Plugin) {
 		}
 
 		Plugins[fpath] = newPlugin
+		initStatus(newPlugin)
 		sch := NewPluginScheduler(newPlugin)
 		PluginsWithScheduler[fpath] = sch
 		sch.Schedule()
@@ -60,5 +61,8 @@ func deletePlugin(key string) {
 		v.Stop()
 		delete(PluginsWithScheduler, key)
 	}
+	if p, ok := Plugins[key]; ok {
+		deleteStatus(p)
+	}
 	delete(Plugins, key)
 }
@@ -0,0 +1,108 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"fmt"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+)
+
+var (
+	runSlots     chan struct{}
+	runSlotsOnce sync.Once
+)
+
+// 获取一个运行名额, 同时运行的插件数达到上限时最多等待timeout
+func acquireSlot(timeout time.Duration) bool {
+	runSlotsOnce.Do(func() {
+		if n := g.Config().Plugin.MaxConcurrent; n > 0 {
+			runSlots = make(chan struct{}, n)
+		}
+	})
+	if runSlots == nil {
+		return true
+	}
+	select {
+	case runSlots <- struct{}{}:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func releaseSlot() {
+	if runSlots != nil {
+		<-runSlots
+	}
+}
+
+// 插件的资源限制, 单个插件的配置优先
+func pluginLimit(filePath string) *g.PluginLimit {
+	cfg := g.Config().Plugin
+	if limit, ok := cfg.Limits[filePath]; ok {
+		return limit
+	}
+	return cfg.Limit
+}
+
+// sandboxCommand 创建运行插件的命令: 有资源限制时通过sh的ulimit设置后再exec插件,
+// 配置了user时以该用户的身份运行
+func sandboxCommand(fpath string, args []string, limit *g.PluginLimit, username string) (*exec.Cmd, error) {
+	ulimit := ""
+	if limit != nil {
+		if limit.CpuSeconds > 0 {
+			ulimit += fmt.Sprintf("ulimit -t %d && ", limit.CpuSeconds)
+		}
+		if limit.MemoryMB > 0 {
+			ulimit += fmt.Sprintf("ulimit -v %d && ", limit.MemoryMB*1024)
+		}
+		if limit.OpenFiles > 0 {
+			ulimit += fmt.Sprintf("ulimit -n %d && ", limit.OpenFiles)
+		}
+	}
+
+	var cmd *exec.Cmd
+	if ulimit == "" {
+		cmd = exec.Command(fpath, args...)
+	} else {
+		cmd = exec.Command("/bin/sh", append([]string{"-c", ulimit + `exec "$0" "$@"`, fpath}, args...)...)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if username != "" {
+		u, err := user.Lookup(username)
+		if err != nil {
+			return nil, err
+		}
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+		cmd.Env = []string{"HOME=" + u.HomeDir, "USER=" + u.Username, "PATH=/usr/local/bin:/usr/bin:/bin:/usr/local/sbin:/usr/sbin:/sbin"}
+	}
+	return cmd, nil
+}
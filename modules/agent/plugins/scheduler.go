@@ -16,15 +16,12 @@ package plugins
 
 import (
 	"bytes"
-	"encoding/json"
 	"log"
 	"os/exec"
 	"path/filepath"
 	"strings"
-	"syscall"
 	"time"
 
-	"github.com/open-falcon/falcon-plus/common/model"
 	"github.com/open-falcon/falcon-plus/modules/agent/g"
 	"github.com/toolkits/file"
 	"github.com/toolkits/sys"
@@ -111,41 +108,58 @@ func PluginRun(plugin *Plugin) {
 
 	if !file.IsExist(fpath) {
 		log.Printf("no such plugin: %s(%s)", fpath, args)
+		setStatus(plugin, &PluginStatus{Status: STATUS_ERROR, LastRun: time.Now().Unix(), LastError: "no such plugin"})
 		return
 	}
 
+	if !acquireSlot(time.Duration(timeout) * time.Millisecond) {
+		log.Printf("[WARN] too many plugins running, skip %s(%s)", fpath, args)
+		setStatus(plugin, &PluginStatus{Status: STATUS_SKIPPED, LastRun: time.Now().Unix()})
+		return
+	}
+	defer releaseSlot()
+
+	start := time.Now()
+	st := pluginExec(plugin, fpath, args, time.Duration(timeout)*time.Millisecond)
+	st.LastRun = start.Unix()
+	st.Duration = int64(time.Since(start) / time.Millisecond)
+	setStatus(plugin, st)
+}
+
+func pluginExec(plugin *Plugin, fpath, args string, timeout time.Duration) *PluginStatus {
 	debug := g.Config().Debug
 	if debug {
 		log.Printf("%s(%s) running...", fpath, args)
 	}
 
-	var cmd *exec.Cmd
-	if args == "" {
-		cmd = exec.Command(fpath)
-	} else {
-		arg_list := PluginArgsParse(args)
-		cmd = exec.Command(fpath, arg_list...)
+	var arg_list []string
+	if args != "" {
+		arg_list = PluginArgsParse(args)
+	}
+	cmd, err := sandboxCommand(fpath, arg_list, pluginLimit(plugin.FilePath), g.Config().Plugin.User)
+	if err != nil {
+		log.Printf("[ERROR] plugin sandbox fail: %s(%s) , error: %s\n", fpath, args, err)
+		return &PluginStatus{Status: STATUS_ERROR, LastError: err.Error()}
 	}
 	var stdout bytes.Buffer
 	cmd.Stdout = &stdout
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-	err := cmd.Start()
+	err = cmd.Start()
 	if err != nil {
 		log.Printf("[ERROR] plugin start fail: %s(%s) , error: %s\n", fpath, args, err)
-		return
+		return &PluginStatus{Status: STATUS_ERROR, LastError: err.Error()}
 	}
 	if debug {
 		log.Printf("plugin started: %s(%s)", fpath, args)
 	}
 
-	err, isTimeout := sys.CmdRunWithTimeout(cmd, time.Duration(timeout)*time.Millisecond)
+	err, isTimeout := sys.CmdRunWithTimeout(cmd, timeout)
 
 	errStr := stderr.String()
 	if errStr != "" {
 		logFile := filepath.Join(g.Config().Plugin.LogDir, plugin.FilePath+"("+plugin.Args+")"+".stderr.log")
-		if _, err = file.WriteString(logFile, errStr); err != nil {
+		if _, err := file.WriteString(logFile, errStr); err != nil {
 			log.Printf("[ERROR] write log to %s fail, error: %s\n", logFile, err)
 		}
 	}
@@ -160,29 +174,60 @@ func PluginRun(plugin *Plugin) {
 			log.Println("[ERROR] kill process ", fpath, "(", args, ")", " occur error:", err)
 		}
 
-		return
+		return &PluginStatus{Status: STATUS_TIMEOUT}
 	}
 
+	// nagios插件用退出码1~3表示WARNING/CRITICAL/UNKNOWN,
+	// 输出不是nagios格式时和其他插件一样, 非0的退出码视为执行失败
+	exitCode := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		exitCode = exitErr.ExitCode()
+		if exitCode >= 1 && exitCode <= 3 && isNagiosOutput(stdout.Bytes()) {
+			err = nil
+		}
+	}
 	if err != nil {
 		log.Println("[ERROR] exec plugin", fpath, "(", args, ")", "fail. error:", err)
-		return
+		return &PluginStatus{Status: STATUS_ERROR, ExitCode: exitCode, LastError: lastLine(errStr, err.Error())}
 	}
 
 	// exec successfully
 	data := stdout.Bytes()
-	if len(data) == 0 {
+	if len(data) == 0 && exitCode == 0 {
 		if debug {
 			log.Println("[DEBUG] stdout of", fpath, "(", args, ")", "is blank")
 		}
-		return
+		return &PluginStatus{Status: STATUS_OK}
 	}
 
-	var metrics []*model.MetricValue
-	err = json.Unmarshal(data, &metrics)
+	format := DetectFormat(data, exitCode)
+	metrics, err := ParseOutput(format, data, exitCode, filepath.Base(plugin.FilePath))
 	if err != nil {
-		log.Printf("[ERROR] json.Unmarshal stdout of %s(%s) fail. error:%s stdout: \n%s\n", fpath, args, err, stdout.String())
-		return
+		log.Printf("[ERROR] parse %s stdout of %s(%s) fail. error:%s stdout: \n%s\n", format, fpath, args, err, stdout.String())
+		return &PluginStatus{Status: STATUS_ERROR, ExitCode: exitCode, Format: format, LastError: err.Error()}
+	}
+
+	if format != FORMAT_JSON {
+		hostname, _ := g.Hostname()
+		now := time.Now().Unix()
+		for _, mv := range metrics {
+			mv.Endpoint = hostname
+			mv.Step = int64(plugin.Cycle)
+			if mv.Timestamp == 0 {
+				mv.Timestamp = now
+			}
+		}
 	}
 
 	g.SendToTransfer(metrics)
+	return &PluginStatus{Status: STATUS_OK, ExitCode: exitCode, Format: format, Metrics: len(metrics)}
+}
+
+// stderr的最后一行, 作为错误信息
+func lastLine(s string, def string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return def
+	}
+	return s[strings.LastIndex(s, "\n")+1:]
 }
@@ -0,0 +1,94 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"sort"
+	"sync"
+)
+
+// 插件的运行状态
+const (
+	STATUS_PENDING = "pending" // 还没有运行过
+	STATUS_OK      = "ok"
+	STATUS_ERROR   = "error"
+	STATUS_TIMEOUT = "timeout"
+	STATUS_SKIPPED = "skipped" // 同时运行的插件数达到上限
+)
+
+// PluginStatus 插件最近一次运行的结果, 通过/plugins查看
+type PluginStatus struct {
+	Plugin
+	Status    string `json:"status"`
+	LastRun   int64  `json:"lastRun"`
+	Duration  int64  `json:"duration"` // 毫秒
+	ExitCode  int    `json:"exitCode"`
+	Format    string `json:"format"`
+	Metrics   int    `json:"metrics"`
+	LastError string `json:"lastError"`
+}
+
+var (
+	statusLock = new(sync.RWMutex)
+	statuses   = make(map[*Plugin]*PluginStatus)
+)
+
+func initStatus(p *Plugin) {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+	statuses[p] = &PluginStatus{Plugin: *p, Status: STATUS_PENDING}
+}
+
+func deleteStatus(p *Plugin) {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+	delete(statuses, p)
+}
+
+func setStatus(p *Plugin, st *PluginStatus) {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+	last, ok := statuses[p]
+	if !ok {
+		// 插件已经被删除
+		return
+	}
+	st.Plugin = *p
+	if st.Status == STATUS_OK {
+		// 保留最近一次的错误信息
+		st.LastError = last.LastError
+	} else if st.LastError == "" {
+		st.LastError = st.Status
+	}
+	statuses[p] = st
+}
+
+// Statuses 返回所有插件的运行状态, 按路径排序
+func Statuses() []*PluginStatus {
+	statusLock.RLock()
+	defer statusLock.RUnlock()
+	ret := make([]*PluginStatus, 0, len(statuses))
+	for _, st := range statuses {
+		copied := *st
+		ret = append(ret, &copied)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].FilePath == ret[j].FilePath {
+			return ret[i].Args < ret[j].Args
+		}
+		return ret[i].FilePath < ret[j].FilePath
+	})
+	return ret
+}
@@ -0,0 +1,139 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prom
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+)
+
+// histogram和summary的分桶label, 不受label过滤规则影响
+var reservedLabels = map[string]bool{"le": true, "quantile": true}
+
+type Filter struct {
+	MetricAllow []*regexp.Regexp
+	MetricDeny  []*regexp.Regexp
+	LabelAllow  []*regexp.Regexp
+	LabelDeny   []*regexp.Regexp
+}
+
+func compileAll(exprs []string) ([]*regexp.Regexp, error) {
+	var ret []*regexp.Regexp
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, re)
+	}
+	return ret, nil
+}
+
+func NewFilter(cfg *g.PrometheusConfig) (*Filter, error) {
+	f := &Filter{}
+	var err error
+	if f.MetricAllow, err = compileAll(cfg.MetricAllow); err != nil {
+		return nil, err
+	}
+	if f.MetricDeny, err = compileAll(cfg.MetricDeny); err != nil {
+		return nil, err
+	}
+	if f.LabelAllow, err = compileAll(cfg.LabelAllow); err != nil {
+		return nil, err
+	}
+	if f.LabelDeny, err = compileAll(cfg.LabelDeny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func matchAny(list []*regexp.Regexp, s string) bool {
+	for _, re := range list {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowMetric 按family名称过滤, histogram和summary的各个sample一起保留或丢弃
+func (f *Filter) AllowMetric(family string) bool {
+	if len(f.MetricAllow) > 0 && !matchAny(f.MetricAllow, family) {
+		return false
+	}
+	return !matchAny(f.MetricDeny, family)
+}
+
+func (f *Filter) AllowLabel(name string) bool {
+	if reservedLabels[name] {
+		return true
+	}
+	if len(f.LabelAllow) > 0 && !matchAny(f.LabelAllow, name) {
+		return false
+	}
+	return !matchAny(f.LabelDeny, name)
+}
+
+func sanitizeTag(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', '=', ' ', '\t', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// Convert 将sample转换为MetricValue, label转换为tag并追加target的tags, NaN和Inf的值丢弃
+func Convert(samples []*Sample, prefix string, tags string, f *Filter) []*model.MetricValue {
+	ret := make([]*model.MetricValue, 0, len(samples))
+	for _, s := range samples {
+		if !f.AllowMetric(s.Family) {
+			continue
+		}
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			continue
+		}
+
+		var kv []string
+		for k, v := range s.Labels {
+			if v == "" || !f.AllowLabel(k) {
+				continue
+			}
+			kv = append(kv, sanitizeTag(k)+"="+sanitizeTag(v))
+		}
+		sort.Strings(kv)
+		if tags != "" {
+			kv = append(kv, tags)
+		}
+
+		mv := &model.MetricValue{
+			Metric: prefix + s.Name,
+			Value:  s.Value,
+			Type:   "GAUGE",
+			Tags:   strings.Join(kv, ","),
+		}
+		if s.IsCounter() {
+			mv.Type = "COUNTER"
+		}
+		ret = append(ret, mv)
+	}
+	return ret
+}
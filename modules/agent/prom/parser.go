@@ -0,0 +1,221 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prom
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// metric family的类型, 见 https://prometheus.io/docs/instrumenting/exposition_formats/
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+	TypeSummary   = "summary"
+	TypeUntyped   = "untyped"
+)
+
+// 单行文本行的最大长度
+const maxLineSize = 1024 * 1024
+
+type Sample struct {
+	Family string // 所属的metric family, histogram的 xx_bucket 属于 xx
+	Type   string // family的类型
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// IsCounter 判断sample是否是单调递增的累计值, 应该以COUNTER类型上报
+func (s *Sample) IsCounter() bool {
+	switch s.Type {
+	case TypeCounter:
+		return true
+	case TypeHistogram:
+		return true
+	case TypeSummary:
+		return s.Name != s.Family
+	}
+	return false
+}
+
+// Parse 解析prometheus的text exposition format, 忽略HELP和注释, 样本上的时间戳不使用
+func Parse(r io.Reader) ([]*Sample, error) {
+	types := make(map[string]string)
+	var samples []*Sample
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), maxLineSize)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line[0] == '#' {
+			// # TYPE http_requests_total counter
+			fields := strings.Fields(line[1:])
+			if len(fields) >= 3 && fields[0] == "TYPE" {
+				types[fields[1]] = strings.ToLower(fields[2])
+			}
+			continue
+		}
+
+		s, err := parseSample(line)
+		if err != nil {
+			return samples, fmt.Errorf("line %d: %v", lineno, err)
+		}
+		s.Family, s.Type = family(s.Name, types)
+		samples = append(samples, s)
+	}
+	return samples, scanner.Err()
+}
+
+// family 根据TYPE声明找到sample所属的family, 没有声明的按untyped处理
+func family(name string, types map[string]string) (string, string) {
+	if t, ok := types[name]; ok {
+		return name, t
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		base := strings.TrimSuffix(name, suffix)
+		t := types[base]
+		if t == TypeHistogram || (t == TypeSummary && suffix != "_bucket") {
+			return base, t
+		}
+	}
+	return name, TypeUntyped
+}
+
+// parseSample 解析 name{k="v",...} value [timestamp]
+func parseSample(line string) (*Sample, error) {
+	s := &Sample{Labels: make(map[string]string)}
+
+	i := strings.IndexAny(line, "{ \t")
+	if i < 0 {
+		return nil, fmt.Errorf("missing value")
+	}
+	s.Name = line[:i]
+	if s.Name == "" {
+		return nil, fmt.Errorf("missing metric name")
+	}
+	rest := line[i:]
+
+	if rest[0] == '{' {
+		n, err := parseLabels(rest[1:], s.Labels)
+		if err != nil {
+			return nil, err
+		}
+		rest = rest[1+n:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid value %q", rest)
+	}
+	v, err := parseValue(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	s.Value = v
+	return s, nil
+}
+
+// parseLabels 解析 k="v",...} 并返回消耗的字节数(包括右括号)
+func parseLabels(text string, labels map[string]string) (int, error) {
+	i := 0
+	for {
+		for i < len(text) && (text[i] == ' ' || text[i] == '\t') {
+			i++
+		}
+		if i >= len(text) {
+			return 0, fmt.Errorf("unterminated label set")
+		}
+		if text[i] == '}' {
+			return i + 1, nil
+		}
+
+		eq := strings.IndexByte(text[i:], '=')
+		if eq < 0 {
+			return 0, fmt.Errorf("invalid label")
+		}
+		name := strings.TrimSpace(text[i : i+eq])
+		i += eq + 1
+		for i < len(text) && text[i] == ' ' {
+			i++
+		}
+		if name == "" || i >= len(text) || text[i] != '"' {
+			return 0, fmt.Errorf("invalid label %q", name)
+		}
+		i++
+
+		var value bytes.Buffer
+		closed := false
+		for i < len(text) {
+			c := text[i]
+			i++
+			if c == '"' {
+				closed = true
+				break
+			}
+			if c == '\\' && i < len(text) {
+				switch text[i] {
+				case 'n':
+					value.WriteByte('\n')
+				case '\\', '"':
+					value.WriteByte(text[i])
+				default:
+					value.WriteByte('\\')
+					value.WriteByte(text[i])
+				}
+				i++
+				continue
+			}
+			value.WriteByte(c)
+		}
+		if !closed {
+			return 0, fmt.Errorf("unterminated label value of %s", name)
+		}
+		labels[name] = value.String()
+
+		for i < len(text) && text[i] == ' ' {
+			i++
+		}
+		if i < len(text) && text[i] == ',' {
+			i++
+		}
+	}
+}
+
+func parseValue(s string) (float64, error) {
+	switch s {
+	case "+Inf", "Inf":
+		return math.Inf(1), nil
+	case "-Inf":
+		return math.Inf(-1), nil
+	case "NaN":
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
@@ -0,0 +1,152 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prom
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+)
+
+const exposition = `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+
+# A comment line
+msdos_file_access_time_seconds{path="C:\\DIR\\FILE.TXT",error="Cannot find file:\n\"FILE.TXT\""} 1.458255915e9
+
+# TYPE go_goroutines gauge
+go_goroutines 12
+something_weird{problem="division by zero"} +Inf -3982045
+
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.05"} 24054
+http_request_duration_seconds_bucket{le="+Inf"} 144320
+http_request_duration_seconds_sum 53423
+http_request_duration_seconds_count 144320
+
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5",} 4773
+rpc_duration_seconds{quantile="0.99"} NaN
+rpc_duration_seconds_sum 1.7560473e+07
+rpc_duration_seconds_count 2693
+`
+
+func metricsByKey(L []*model.MetricValue) map[string]*model.MetricValue {
+	ret := make(map[string]*model.MetricValue)
+	for _, mv := range L {
+		ret[mv.Metric+"/"+mv.Tags] = mv
+	}
+	return ret
+}
+
+func TestParse(t *testing.T) {
+	samples, err := Parse(strings.NewReader(exposition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 13 {
+		t.Fatalf("expect 13 samples, got %d", len(samples))
+	}
+
+	s := samples[2]
+	if s.Name != "msdos_file_access_time_seconds" || s.Type != TypeUntyped {
+		t.Errorf("unexpected sample %+v", s)
+	}
+	if s.Labels["path"] != `C:\DIR\FILE.TXT` || s.Labels["error"] != "Cannot find file:\n\"FILE.TXT\"" {
+		t.Errorf("unexpected labels %q", s.Labels)
+	}
+
+	bucket := samples[6]
+	if bucket.Family != "http_request_duration_seconds" || bucket.Type != TypeHistogram || !bucket.IsCounter() {
+		t.Errorf("unexpected bucket %+v", bucket)
+	}
+	quantile := samples[9]
+	if quantile.Type != TypeSummary || quantile.IsCounter() || quantile.Labels["quantile"] != "0.5" {
+		t.Errorf("unexpected quantile %+v", quantile)
+	}
+	if !samples[12].IsCounter() {
+		t.Errorf("summary count should be counter")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, text := range []string{
+		`foo{bar="baz" 1`,
+		`foo{bar=baz} 1`,
+		`foo`,
+		`foo abc`,
+		`foo 1 2 3`,
+	} {
+		if _, err := Parse(strings.NewReader(text)); err == nil {
+			t.Errorf("expect error for %q", text)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	samples, err := Parse(strings.NewReader(exposition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFilter(&g.PrometheusConfig{
+		MetricDeny: []string{"^go_", "^msdos_"},
+		LabelDeny:  []string{"^code$"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := metricsByKey(Convert(samples, "prom.", "service=web", f))
+	cases := []struct {
+		key string
+		typ string
+		val float64
+	}{
+		{"prom.http_requests_total/method=post,service=web", "COUNTER", 3},
+		{"prom.http_request_duration_seconds_bucket/le=0.05,service=web", "COUNTER", 24054},
+		{"prom.http_request_duration_seconds_bucket/le=+Inf,service=web", "COUNTER", 144320},
+		{"prom.http_request_duration_seconds_sum/service=web", "COUNTER", 53423},
+		{"prom.rpc_duration_seconds/quantile=0.5,service=web", "GAUGE", 4773},
+		{"prom.rpc_duration_seconds_count/service=web", "COUNTER", 2693},
+	}
+	for _, c := range cases {
+		mv, ok := metrics[c.key]
+		if !ok {
+			t.Errorf("metric %s not found", c.key)
+			continue
+		}
+		if mv.Type != c.typ || mv.Value.(float64) != c.val {
+			t.Errorf("%s: expect %s %v, got %s %v", c.key, c.typ, c.val, mv.Type, mv.Value)
+		}
+	}
+
+	for key := range metrics {
+		if strings.HasPrefix(key, "prom.go_") || strings.HasPrefix(key, "prom.msdos_") ||
+			strings.HasPrefix(key, "prom.something_weird") || strings.HasPrefix(key, "prom.rpc_duration_seconds/quantile=0.99") {
+			t.Errorf("unexpected metric %s", key)
+		}
+	}
+
+	f, _ = NewFilter(&g.PrometheusConfig{MetricAllow: []string{"^rpc_"}, LabelAllow: []string{"^method$"}})
+	for _, mv := range Convert(samples, "", "", f) {
+		if !strings.HasPrefix(mv.Metric, "rpc_duration_seconds") {
+			t.Errorf("unexpected metric %s", mv.Metric)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prom
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+)
+
+// 单次抓取最多读取的字节数
+const maxScrapeSize = 32 * 1024 * 1024
+
+const acceptHeader = "text/plain;version=0.0.4;q=1,*/*;q=0.1"
+
+type targetState struct {
+	next    time.Time
+	running int32
+}
+
+// Start 按各个target的周期抓取配置文件中和hbs下发的exporter, 结果发送给transfer
+func Start() {
+	cfg := g.Config().Prometheus
+	if cfg == nil || !cfg.Enabled {
+		return
+	}
+
+	filter, err := NewFilter(cfg)
+	if err != nil {
+		log.Fatalln("prometheus: invalid allow/deny pattern:", err)
+	}
+
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = 60
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 10
+	}
+	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
+
+	go loop(client, filter, cfg.Prefix, interval)
+}
+
+func targets() []*g.PromTarget {
+	var ret []*g.PromTarget
+	ret = append(ret, g.Config().Prometheus.Targets...)
+	return append(ret, g.ReportPromTargets()...)
+}
+
+func loop(client *http.Client, filter *Filter, prefix string, defaultInterval int) {
+	states := make(map[string]*targetState)
+	for range time.Tick(time.Second) {
+		now := time.Now()
+		current := make(map[string]bool)
+		for _, t := range targets() {
+			if t.Url == "" {
+				continue
+			}
+			key := t.Url + "|" + t.Tags
+			current[key] = true
+
+			st, ok := states[key]
+			if !ok {
+				st = &targetState{next: now}
+				states[key] = st
+			}
+			if now.Before(st.next) {
+				continue
+			}
+
+			interval := t.Interval
+			if interval <= 0 {
+				interval = defaultInterval
+			}
+			st.next = now.Add(time.Duration(interval) * time.Second)
+
+			// 上一次抓取还没有结束的target跳过本周期
+			if !atomic.CompareAndSwapInt32(&st.running, 0, 1) {
+				continue
+			}
+			go func(t *g.PromTarget, st *targetState, step int) {
+				defer atomic.StoreInt32(&st.running, 0)
+				if err := scrape(client, filter, prefix, t, step); err != nil {
+					log.Println("prometheus: scrape", t.Url, "fail:", err)
+				}
+			}(t, st, interval)
+		}
+
+		for key := range states {
+			if !current[key] {
+				delete(states, key)
+			}
+		}
+	}
+}
+
+func scrape(client *http.Client, filter *Filter, prefix string, t *g.PromTarget, step int) error {
+	req, err := http.NewRequest("GET", t.Url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", acceptHeader)
+	req.Header.Set("User-Agent", "falcon-agent/"+g.Version)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	samples, err := Parse(io.LimitReader(resp.Body, maxScrapeSize))
+	if err != nil {
+		return err
+	}
+
+	mvs := Convert(samples, prefix, t.Tags, filter)
+	if len(mvs) == 0 {
+		return nil
+	}
+
+	hostname, err := g.Hostname()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, mv := range mvs {
+		mv.Step = int64(step)
+		mv.Endpoint = hostname
+		mv.Timestamp = now
+	}
+
+	if g.Config().Debug {
+		log.Printf("prometheus: %s %d samples, %d metrics", t.Url, len(samples), len(mvs))
+	}
+	g.SendToTransfer(mvs)
+	return nil
+}
@@ -230,6 +230,7 @@ dashboard.getOs = function () {
     generate_os_data("proc/kernel/hostname", "#os-hostname");
     generate_os_data("system/date", "#os-time");
     generate_os_data("page/system/uptime", "#os-uptime");
+    generate_os_data("page/transfer/buffer", "#transfer-buffer");
 
     $.get("version", function(d){
         $("#agent-version").text(d);
@@ -0,0 +1,159 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snmp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+)
+
+var authProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"":       gosnmp.NoAuth,
+	"MD5":    gosnmp.MD5,
+	"SHA":    gosnmp.SHA,
+	"SHA224": gosnmp.SHA224,
+	"SHA256": gosnmp.SHA256,
+	"SHA384": gosnmp.SHA384,
+	"SHA512": gosnmp.SHA512,
+}
+
+var privProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"":       gosnmp.NoPriv,
+	"DES":    gosnmp.DES,
+	"AES":    gosnmp.AES,
+	"AES192": gosnmp.AES192,
+	"AES256": gosnmp.AES256,
+}
+
+// newClient 根据设备配置创建一个未连接的gosnmp客户端.
+// gosnmp在客户端中保存v3发现的engineId、engineBoots等状态, 连接出错后应丢弃客户端重新创建
+func newClient(cfg *g.SnmpDevice, timeout time.Duration, retries, maxRepetitions int) (*gosnmp.GoSNMP, error) {
+	host, port := cfg.Address, uint16(161)
+	if h, p, err := net.SplitHostPort(cfg.Address); err == nil {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", p)
+		}
+		host, port = h, uint16(n)
+	}
+	if maxRepetitions <= 0 {
+		maxRepetitions = 20
+	}
+
+	c := &gosnmp.GoSNMP{
+		Target:         host,
+		Port:           port,
+		Transport:      "udp",
+		Community:      cfg.Community,
+		Version:        gosnmp.Version2c,
+		Timeout:        timeout,
+		Retries:        retries,
+		MaxOids:        gosnmp.MaxOids,
+		MaxRepetitions: uint32(maxRepetitions),
+	}
+
+	switch cfg.Version {
+	case "", "2c", "2":
+		return c, nil
+	case "3":
+	default:
+		return nil, fmt.Errorf("unsupported version %q", cfg.Version)
+	}
+
+	if cfg.User == "" {
+		return nil, errors.New("v3 requires a user")
+	}
+	auth, ok := authProtocols[strings.ToUpper(cfg.AuthProtocol)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported auth protocol %q", cfg.AuthProtocol)
+	}
+	priv, ok := privProtocols[strings.ToUpper(cfg.PrivProtocol)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported priv protocol %q", cfg.PrivProtocol)
+	}
+
+	c.Version = gosnmp.Version3
+	c.SecurityModel = gosnmp.UserSecurityModel
+	switch {
+	case priv != gosnmp.NoPriv && auth == gosnmp.NoAuth:
+		return nil, errors.New("privacy requires authentication")
+	case priv != gosnmp.NoPriv:
+		c.MsgFlags = gosnmp.AuthPriv
+	case auth != gosnmp.NoAuth:
+		c.MsgFlags = gosnmp.AuthNoPriv
+	default:
+		c.MsgFlags = gosnmp.NoAuthNoPriv
+	}
+	c.SecurityParameters = &gosnmp.UsmSecurityParameters{
+		UserName:                 cfg.User,
+		AuthenticationProtocol:   auth,
+		AuthenticationPassphrase: cfg.AuthPassword,
+		PrivacyProtocol:          priv,
+		PrivacyPassphrase:        cfg.PrivPassword,
+	}
+	return c, nil
+}
+
+// exists 对于不存在的oid, agent返回noSuchObject/noSuchInstance/endOfMibView
+func exists(v gosnmp.SnmpPDU) bool {
+	return v.Type != gosnmp.NoSuchObject && v.Type != gosnmp.NoSuchInstance && v.Type != gosnmp.EndOfMibView
+}
+
+// toNumber 返回数值类型的值, Integer可能为负数, 以int64返回, 其他类型见toUint
+func toNumber(v gosnmp.SnmpPDU) (interface{}, bool) {
+	if x, ok := v.Value.(int); ok {
+		return int64(x), true
+	}
+	if n, ok := toUint(v); ok {
+		return n, true
+	}
+	return nil, false
+}
+
+// toUint 返回计数器等非负数值类型的值, gosnmp中Integer为int, Counter32/Gauge32为uint, TimeTicks为uint32,
+// Counter64为uint64; 负的Integer返回false
+func toUint(v gosnmp.SnmpPDU) (uint64, bool) {
+	switch x := v.Value.(type) {
+	case uint:
+		return uint64(x), true
+	case uint32:
+		return uint64(x), true
+	case uint64:
+		return x, true
+	case int:
+		if x >= 0 {
+			return uint64(x), true
+		}
+	}
+	return 0, false
+}
+
+func toString(v gosnmp.SnmpPDU) string {
+	switch x := v.Value.(type) {
+	case []byte:
+		return string(x)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(x)
+	}
+}
@@ -0,0 +1,434 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snmp
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+)
+
+// compareOid 按数值比较两个oid
+func compareOid(a, b string) int {
+	x := strings.Split(strings.Trim(a, "."), ".")
+	y := strings.Split(strings.Trim(b, "."), ".")
+	for i := 0; i < len(x) && i < len(y); i++ {
+		m, _ := strconv.ParseUint(x[i], 10, 32)
+		n, _ := strconv.ParseUint(y[i], 10, 32)
+		if m != n {
+			if m < n {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(x) - len(y)
+}
+
+// stubAgent 是测试用的snmp agent, 使用gosnmp解析请求和编码响应.
+// v3时只支持noAuthNoPriv, 响应engine发现请求, 不响应engineId不匹配的请求
+type stubAgent struct {
+	conn      net.PacketConn
+	community string
+	v3        bool
+
+	mu          sync.Mutex
+	vars        []gosnmp.SnmpPDU
+	engineID    string
+	discoveries int
+}
+
+func newStubAgent(t *testing.T, community string) *stubAgent {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &stubAgent{conn: conn, community: community}
+	go a.serve()
+	return a
+}
+
+func newStubAgentV3(t *testing.T, engineID string) *stubAgent {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &stubAgent{conn: conn, v3: true, engineID: engineID}
+	go a.serve()
+	return a
+}
+
+func (a *stubAgent) Addr() string {
+	return a.conn.LocalAddr().String()
+}
+
+func (a *stubAgent) Close() {
+	a.conn.Close()
+}
+
+func (a *stubAgent) set(vars ...gosnmp.SnmpPDU) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, v := range vars {
+		v.Name = "." + strings.Trim(v.Name, ".")
+		replaced := false
+		for i := range a.vars {
+			if a.vars[i].Name == v.Name {
+				a.vars[i] = v
+				replaced = true
+			}
+		}
+		if !replaced {
+			a.vars = append(a.vars, v)
+		}
+	}
+	sort.Slice(a.vars, func(i, j int) bool { return compareOid(a.vars[i].Name, a.vars[j].Name) < 0 })
+}
+
+// setEngine 模拟设备更换或者重置后engineId改变
+func (a *stubAgent) setEngine(engineID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.engineID = engineID
+}
+
+func (a *stubAgent) discovered() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.discoveries
+}
+
+func (a *stubAgent) serve() {
+	buf := make([]byte, 65535)
+	decoder := &gosnmp.GoSNMP{Version: gosnmp.Version2c}
+	if a.v3 {
+		decoder = &gosnmp.GoSNMP{
+			Version:            gosnmp.Version3,
+			SecurityModel:      gosnmp.UserSecurityModel,
+			MsgFlags:           gosnmp.NoAuthNoPriv,
+			SecurityParameters: &gosnmp.UsmSecurityParameters{UserName: "stub"},
+		}
+	}
+	for {
+		n, addr, err := a.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req, err := decoder.SnmpDecodePacket(buf[:n])
+		if err != nil || !a.v3 && req.Community != a.community {
+			continue
+		}
+		packet := a.respond(req)
+		if packet == nil {
+			continue
+		}
+		if resp, err := packet.MarshalMsg(); err == nil {
+			a.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (a *stubAgent) next(oid string) gosnmp.SnmpPDU {
+	for _, v := range a.vars {
+		if compareOid(v.Name, oid) > 0 {
+			return v
+		}
+	}
+	return gosnmp.SnmpPDU{Name: oid, Type: gosnmp.EndOfMibView}
+}
+
+func (a *stubAgent) respond(req *gosnmp.SnmpPacket) *gosnmp.SnmpPacket {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	resp := &gosnmp.SnmpPacket{
+		Version:   req.Version,
+		Community: req.Community,
+		PDUType:   gosnmp.GetResponse,
+		RequestID: req.RequestID,
+	}
+	if a.v3 {
+		usp := req.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+		resp.MsgID = req.MsgID
+		resp.MsgFlags = gosnmp.NoAuthNoPriv
+		resp.SecurityModel = gosnmp.UserSecurityModel
+		resp.ContextEngineID = a.engineID
+		resp.SecurityParameters = &gosnmp.UsmSecurityParameters{
+			UserName:                 usp.UserName,
+			AuthoritativeEngineID:    a.engineID,
+			AuthoritativeEngineBoots: 1,
+			AuthoritativeEngineTime:  100,
+		}
+		switch usp.AuthoritativeEngineID {
+		case "":
+			a.discoveries++
+			resp.PDUType = gosnmp.Report
+			resp.Variables = []gosnmp.SnmpPDU{{Name: ".1.3.6.1.6.3.15.1.1.4.0", Type: gosnmp.Counter32, Value: uint32(a.discoveries)}}
+			return resp
+		case a.engineID:
+		default:
+			return nil
+		}
+	}
+	for _, rv := range req.Variables {
+		switch req.PDUType {
+		case gosnmp.GetRequest:
+			v := gosnmp.SnmpPDU{Name: rv.Name, Type: gosnmp.NoSuchObject}
+			for _, x := range a.vars {
+				if x.Name == rv.Name {
+					v = x
+				}
+			}
+			resp.Variables = append(resp.Variables, v)
+		case gosnmp.GetNextRequest:
+			resp.Variables = append(resp.Variables, a.next(rv.Name))
+		case gosnmp.GetBulkRequest:
+			// gosnmp解析请求时不能得到max-repetitions, 固定每次返回2行以覆盖多次请求的情况
+			oid := rv.Name
+			for i := 0; i < 2; i++ {
+				v := a.next(oid)
+				resp.Variables = append(resp.Variables, v)
+				if v.Type == gosnmp.EndOfMibView {
+					break
+				}
+				oid = v.Name
+			}
+		}
+	}
+	return resp
+}
+
+var systemVars = []gosnmp.SnmpPDU{
+	{Name: "1.3.6.1.2.1.1.1.0", Type: gosnmp.OctetString, Value: []byte("stub switch")},
+	{Name: oidSysUpTime, Type: gosnmp.TimeTicks, Value: uint32(100000)},
+	{Name: "1.3.6.1.2.1.1.5.0", Type: gosnmp.OctetString, Value: []byte("sw-01")},
+}
+
+func TestNewClient(t *testing.T) {
+	c, err := newClient(&g.SnmpDevice{Address: "10.0.0.1"}, time.Second, 1, 0)
+	if err != nil || c.Target != "10.0.0.1" || c.Port != 161 || c.Version != gosnmp.Version2c || c.MaxRepetitions != 20 {
+		t.Errorf("unexpected v2c client %+v %v", c, err)
+	}
+
+	c, err = newClient(&g.SnmpDevice{
+		Address:      "10.0.0.1:1161",
+		Version:      "3",
+		User:         "falcon",
+		AuthProtocol: "sha",
+		AuthPassword: "auth-secret",
+		PrivProtocol: "aes",
+		PrivPassword: "priv-secret",
+	}, time.Second, 1, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp := c.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+	if c.Port != 1161 || c.MsgFlags != gosnmp.AuthPriv || sp.AuthenticationProtocol != gosnmp.SHA || sp.PrivacyProtocol != gosnmp.AES {
+		t.Errorf("unexpected v3 client %+v %+v", c, sp)
+	}
+
+	for _, cfg := range []*g.SnmpDevice{
+		{Address: "10.0.0.1", Version: "1"},
+		{Address: "10.0.0.1", Version: "3"},
+		{Address: "10.0.0.1", Version: "3", User: "falcon", PrivProtocol: "DES"},
+		{Address: "10.0.0.1", Version: "3", User: "falcon", AuthProtocol: "SHA1"},
+	} {
+		if _, err := newClient(cfg, time.Second, 1, 0); err == nil {
+			t.Errorf("expect error for %+v", cfg)
+		}
+	}
+}
+
+func TestDeviceGetWalk(t *testing.T) {
+	agent := newStubAgent(t, "public")
+	defer agent.Close()
+	agent.set(systemVars...)
+
+	d, err := NewDevice(&g.SnmpDevice{Address: agent.Addr(), Community: "public"}, time.Second, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.close()
+
+	vars, err := d.get(oidSysUpTime, "1.3.6.1.2.1.1.4.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := toUint(vars[0]); len(vars) != 2 || vars[0].Type != gosnmp.TimeTicks || n != 100000 || exists(vars[1]) {
+		t.Errorf("unexpected get result %v", vars)
+	}
+
+	vars, err = d.walk("1.3.6.1.2.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vars) != 3 || toString(vars[2]) != "sw-01" {
+		t.Errorf("unexpected walk result %v", vars)
+	}
+
+	// 请求失败后丢弃客户端, 下次请求使用新的客户端
+	wrong, _ := NewDevice(&g.SnmpDevice{Address: agent.Addr(), Community: "wrong"}, 100*time.Millisecond, 1, 0)
+	if _, err := wrong.get(oidSysUpTime); err == nil || wrong.client != nil {
+		t.Errorf("expect timeout with wrong community, got %v", err)
+	}
+}
+
+// 请求失败后丢弃客户端, 重新发现设备的engineId
+func TestDeviceV3Rediscover(t *testing.T) {
+	agent := newStubAgentV3(t, "engine-a")
+	defer agent.Close()
+	agent.set(systemVars...)
+
+	d, err := NewDevice(&g.SnmpDevice{Address: agent.Addr(), Version: "3", User: "falcon"}, 200*time.Millisecond, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.close()
+
+	for i := 0; i < 2; i++ {
+		vars, err := d.get(oidSysUpTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := toUint(vars[0]); n != 100000 {
+			t.Errorf("unexpected get result %v", vars)
+		}
+	}
+	if n := agent.discovered(); n != 1 {
+		t.Errorf("expect engine discovered once, got %d", n)
+	}
+
+	agent.setEngine("engine-b")
+	if _, err := d.get(oidSysUpTime); err == nil || d.client != nil {
+		t.Fatalf("expect timeout with the stale engine id, got %v", err)
+	}
+	if _, err := d.get(oidSysUpTime); err != nil {
+		t.Fatal(err)
+	}
+	if n := agent.discovered(); n != 2 {
+		t.Errorf("expect engine discovered again, got %d", n)
+	}
+	if sp := d.client.SecurityParameters.(*gosnmp.UsmSecurityParameters); sp.AuthoritativeEngineID != "engine-b" {
+		t.Errorf("unexpected engine id %q", sp.AuthoritativeEngineID)
+	}
+}
+
+func TestCounterDelta(t *testing.T) {
+	if d, _ := counterDelta(4294967290, 4, gosnmp.Counter32); d != 10 {
+		t.Errorf("counter32 wrap: %d", d)
+	}
+	if d, _ := counterDelta(1<<64-6, 4, gosnmp.Counter64); d != 10 {
+		t.Errorf("counter64 wrap: %d", d)
+	}
+	if _, ok := counterDelta(10, 4, gosnmp.Gauge32); ok {
+		t.Errorf("gauge decrease should be dropped")
+	}
+}
+
+func metricsByKey(L []*model.MetricValue) map[string]*model.MetricValue {
+	ret := make(map[string]*model.MetricValue)
+	for _, mv := range L {
+		ret[mv.Metric+"/"+mv.Tags] = mv
+	}
+	return ret
+}
+
+func TestDevicePoll(t *testing.T) {
+	agent := newStubAgent(t, "public")
+	defer agent.Close()
+	agent.set(systemVars...)
+	agent.set(
+		gosnmp.SnmpPDU{Name: oidIfName + ".1", Type: gosnmp.OctetString, Value: []byte("Gi1/0/1")},
+		gosnmp.SnmpPDU{Name: oidIfName + ".2", Type: gosnmp.OctetString, Value: []byte("Gi1/0/2")},
+		gosnmp.SnmpPDU{Name: oidIfAlias + ".1", Type: gosnmp.OctetString, Value: []byte("uplink to core")},
+		gosnmp.SnmpPDU{Name: oidIfAlias + ".2", Type: gosnmp.OctetString, Value: []byte("")},
+		gosnmp.SnmpPDU{Name: "1.3.6.1.2.1.31.1.1.1.6.1", Type: gosnmp.Counter64, Value: uint64(1000)},
+		gosnmp.SnmpPDU{Name: "1.3.6.1.2.1.31.1.1.1.6.2", Type: gosnmp.Counter64, Value: uint64(0)},
+		gosnmp.SnmpPDU{Name: "1.3.6.1.2.1.2.2.1.14.1", Type: gosnmp.Counter32, Value: uint32(4294967290)},
+		gosnmp.SnmpPDU{Name: "1.3.6.1.2.1.2.2.1.8.1", Type: gosnmp.Integer, Value: 1},
+		gosnmp.SnmpPDU{Name: "1.3.6.1.4.1.9.9.13.1.3.1.3.1", Type: gosnmp.Gauge32, Value: uint32(42)},
+		gosnmp.SnmpPDU{Name: "1.3.6.1.4.1.9.9.13.1.3.1.3.2", Type: gosnmp.Integer, Value: -5},
+	)
+
+	d, err := NewDevice(&g.SnmpDevice{
+		Address:    agent.Addr(),
+		Endpoint:   "sw-01",
+		Community:  "public",
+		Interfaces: true,
+		Tags:       "idc=bj",
+	}, time.Second, 0, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oids := []*g.SnmpOid{{Metric: "switch.temperature", Oid: "1.3.6.1.4.1.9.9.13.1.3.1.3", Walk: true}}
+
+	now := time.Now()
+	metrics := metricsByKey(d.Poll(now, oids))
+	if mv := metrics["snmp.device.alive/idc=bj"]; mv == nil || mv.Value != 1 || mv.Endpoint != "sw-01" {
+		t.Errorf("unexpected alive %v", mv)
+	}
+	if _, ok := metrics["switch.if.in.bytes/ifIndex=1,ifName=Gi1/0/1,ifAlias=uplink_to_core,idc=bj"]; ok {
+		t.Errorf("first poll should not report rates")
+	}
+	if mv := metrics["switch.if.oper.status/ifIndex=1,ifName=Gi1/0/1,ifAlias=uplink_to_core,idc=bj"]; mv == nil || mv.Value != int64(1) {
+		t.Errorf("unexpected oper status %v", mv)
+	}
+	if mv := metrics["switch.temperature/index=1,idc=bj"]; mv == nil || mv.Value != uint64(42) {
+		t.Errorf("unexpected temperature %v", mv)
+	}
+	if mv := metrics["switch.temperature/index=2,idc=bj"]; mv == nil || mv.Value != int64(-5) {
+		t.Errorf("unexpected negative temperature %v", mv)
+	}
+
+	agent.set(
+		gosnmp.SnmpPDU{Name: oidSysUpTime, Type: gosnmp.TimeTicks, Value: uint32(101000)},
+		gosnmp.SnmpPDU{Name: "1.3.6.1.2.1.31.1.1.1.6.1", Type: gosnmp.Counter64, Value: uint64(11000)},
+		gosnmp.SnmpPDU{Name: "1.3.6.1.2.1.2.2.1.14.1", Type: gosnmp.Counter32, Value: uint32(4)},
+	)
+	metrics = metricsByKey(d.Poll(now.Add(10*time.Second), oids))
+	if mv := metrics["switch.if.in.bytes/ifIndex=1,ifName=Gi1/0/1,ifAlias=uplink_to_core,idc=bj"]; mv == nil || mv.Value != 1000.0 {
+		t.Errorf("unexpected in.bytes %v", mv)
+	}
+	if mv := metrics["switch.if.in.bytes/ifIndex=2,ifName=Gi1/0/2,idc=bj"]; mv == nil || mv.Value != 0.0 {
+		t.Errorf("unexpected in.bytes %v", mv)
+	}
+	if mv := metrics["switch.if.in.errors/ifIndex=1,ifName=Gi1/0/1,ifAlias=uplink_to_core,idc=bj"]; mv == nil || mv.Value != 1.0 {
+		t.Errorf("unexpected in.errors after wrap %v", mv)
+	}
+
+	// 设备重启后不计算速率
+	agent.set(
+		gosnmp.SnmpPDU{Name: oidSysUpTime, Type: gosnmp.TimeTicks, Value: uint32(500)},
+		gosnmp.SnmpPDU{Name: "1.3.6.1.2.1.31.1.1.1.6.1", Type: gosnmp.Counter64, Value: uint64(10)},
+	)
+	metrics = metricsByKey(d.Poll(now.Add(20*time.Second), oids))
+	if _, ok := metrics["switch.if.in.bytes/ifIndex=1,ifName=Gi1/0/1,ifAlias=uplink_to_core,idc=bj"]; ok {
+		t.Errorf("rates after reboot should be dropped")
+	}
+
+	agent.Close()
+	metrics = metricsByKey(d.Poll(now.Add(30*time.Second), oids))
+	if mv := metrics["snmp.device.alive/idc=bj"]; mv == nil || mv.Value != 0 || len(metrics) != 1 {
+		t.Errorf("unexpected metrics of unreachable device %v", metrics)
+	}
+}
@@ -0,0 +1,433 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snmp
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+)
+
+const (
+	oidSysUpTime = "1.3.6.1.2.1.1.3.0"
+	oidIfDescr   = "1.3.6.1.2.1.2.2.1.2"
+	oidIfName    = "1.3.6.1.2.1.31.1.1.1.1"
+	oidIfAlias   = "1.3.6.1.2.1.31.1.1.1.18"
+)
+
+// 接口表中采集的列, 优先使用ifXTable中的64位计数器, 设备不支持时使用ifTable中的32位计数器.
+// 计数器上报为每秒的速率, speed的单位为Mbps
+var ifColumns = []struct {
+	metric  string
+	oid     string
+	oid32   string
+	counter bool
+}{
+	{"switch.if.in.bytes", "1.3.6.1.2.1.31.1.1.1.6", "1.3.6.1.2.1.2.2.1.10", true},
+	{"switch.if.out.bytes", "1.3.6.1.2.1.31.1.1.1.10", "1.3.6.1.2.1.2.2.1.16", true},
+	{"switch.if.in.packets", "1.3.6.1.2.1.31.1.1.1.7", "1.3.6.1.2.1.2.2.1.11", true},
+	{"switch.if.out.packets", "1.3.6.1.2.1.31.1.1.1.11", "1.3.6.1.2.1.2.2.1.17", true},
+	{"switch.if.in.errors", "", "1.3.6.1.2.1.2.2.1.14", true},
+	{"switch.if.out.errors", "", "1.3.6.1.2.1.2.2.1.20", true},
+	{"switch.if.in.discards", "", "1.3.6.1.2.1.2.2.1.13", true},
+	{"switch.if.out.discards", "", "1.3.6.1.2.1.2.2.1.19", true},
+	{"switch.if.oper.status", "", "1.3.6.1.2.1.2.2.1.8", false},
+	{"switch.if.speed", "1.3.6.1.2.1.31.1.1.1.15", "", false},
+}
+
+type counterSample struct {
+	value uint64
+	ts    time.Time
+}
+
+// Device 保存一台设备的连接和上一次采集的计数器, 用于计算速率
+type Device struct {
+	cfg            *g.SnmpDevice
+	endpoint       string
+	timeout        time.Duration
+	retries        int
+	maxRepetitions int
+	client         *gosnmp.GoSNMP
+	uptime         uint64
+	counters       map[string]*counterSample
+	running        int32
+}
+
+func NewDevice(cfg *g.SnmpDevice, timeout time.Duration, retries, maxRepetitions int) (*Device, error) {
+	// 提前检查配置, 连接在第一次采集时建立
+	if _, err := newClient(cfg, timeout, retries, maxRepetitions); err != nil {
+		return nil, err
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = cfg.Address
+		if host, _, err := net.SplitHostPort(cfg.Address); err == nil {
+			endpoint = host
+		}
+	}
+
+	return &Device{
+		cfg:            cfg,
+		endpoint:       endpoint,
+		timeout:        timeout,
+		retries:        retries,
+		maxRepetitions: maxRepetitions,
+		counters:       make(map[string]*counterSample),
+	}, nil
+}
+
+func (d *Device) connect() error {
+	if d.client != nil {
+		return nil
+	}
+	c, err := newClient(d.cfg, d.timeout, d.retries, d.maxRepetitions)
+	if err != nil {
+		return err
+	}
+	if err := c.Connect(); err != nil {
+		return err
+	}
+	d.client = c
+	return nil
+}
+
+// close 关闭连接并丢弃客户端, 下次采集时重新发现engineId, 设备重装或更换后engineId会变化
+func (d *Device) close() {
+	if d.client == nil {
+		return
+	}
+	d.client.Conn.Close()
+	d.client = nil
+}
+
+// get和walk在请求出错(如超时)时关闭连接
+func (d *Device) get(oids ...string) ([]gosnmp.SnmpPDU, error) {
+	if err := d.connect(); err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Get(oids)
+	if err != nil {
+		d.close()
+		return nil, err
+	}
+	if resp.Error != gosnmp.NoError {
+		return nil, fmt.Errorf("%v at index %d", resp.Error, resp.ErrorIndex)
+	}
+	return resp.Variables, nil
+}
+
+func (d *Device) walk(root string) ([]gosnmp.SnmpPDU, error) {
+	if err := d.connect(); err != nil {
+		return nil, err
+	}
+	vars, err := d.client.BulkWalkAll(root)
+	if err != nil {
+		d.close()
+	}
+	return vars, err
+}
+
+// counterDelta 计算两次采集之间的增量, Counter32和Counter64回绕时按模计算, 其他类型减小时丢弃
+func counterDelta(prev, cur uint64, typ gosnmp.Asn1BER) (uint64, bool) {
+	switch typ {
+	case gosnmp.Counter32:
+		return (cur - prev) & 0xffffffff, true
+	case gosnmp.Counter64:
+		return cur - prev, true
+	}
+	if cur < prev {
+		return 0, false
+	}
+	return cur - prev, true
+}
+
+func joinTags(tags ...string) string {
+	var ret []string
+	for _, t := range tags {
+		if t != "" {
+			ret = append(ret, t)
+		}
+	}
+	return strings.Join(ret, ",")
+}
+
+func sanitizeTag(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', '=', ' ', '\t', '\n':
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(s))
+}
+
+type poll struct {
+	d        *Device
+	now      time.Time
+	counters map[string]*counterSample
+	metrics  []*model.MetricValue
+}
+
+func (p *poll) gauge(metric string, value interface{}, tags string) {
+	p.metrics = append(p.metrics, &model.MetricValue{
+		Endpoint: p.d.endpoint,
+		Metric:   metric,
+		Value:    value,
+		Type:     "GAUGE",
+		Tags:     joinTags(tags, p.d.cfg.Tags),
+	})
+}
+
+// rate 记录计数器的值, 与上一次的值计算每秒的速率后上报, 第一次采集时不上报
+func (p *poll) rate(metric string, v gosnmp.SnmpPDU, tags string) {
+	cur, ok := toUint(v)
+	if !ok {
+		return
+	}
+	key := metric + "/" + tags
+	p.counters[key] = &counterSample{value: cur, ts: p.now}
+
+	prev, ok := p.d.counters[key]
+	if !ok {
+		return
+	}
+	elapsed := p.now.Sub(prev.ts).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	delta, ok := counterDelta(prev.value, cur, v.Type)
+	if !ok {
+		return
+	}
+	p.gauge(metric, float64(delta)/elapsed, tags)
+}
+
+// Poll 采集一次设备的指标, 设备不可达时只上报 snmp.device.alive=0
+func (d *Device) Poll(now time.Time, oids []*g.SnmpOid) []*model.MetricValue {
+	p := &poll{d: d, now: now, counters: make(map[string]*counterSample)}
+
+	vars, err := d.get(oidSysUpTime)
+	if err == nil && (len(vars) == 0 || !exists(vars[0])) {
+		err = fmt.Errorf("no sysUpTime")
+	}
+	if err != nil {
+		log.Println("snmp: poll", d.cfg.Address, "fail:", err)
+		d.close()
+		p.gauge("snmp.device.alive", 0, "")
+		return p.metrics
+	}
+	p.gauge("snmp.device.alive", 1, "")
+
+	// sysUpTime变小说明设备重启过, 之前的计数器不能用于计算速率
+	uptime, _ := toUint(vars[0])
+	if uptime < d.uptime {
+		d.counters = make(map[string]*counterSample)
+	}
+	d.uptime = uptime
+	p.gauge("snmp.device.uptime", uptime/100, "")
+
+	if d.cfg.Interfaces {
+		if err := p.interfaces(); err != nil {
+			log.Println("snmp: walk interfaces of", d.cfg.Address, "fail:", err)
+		}
+	}
+	for _, oid := range append(oids, d.cfg.Oids...) {
+		if err := p.oid(oid); err != nil {
+			log.Println("snmp: poll", oid.Oid, "of", d.cfg.Address, "fail:", err)
+		}
+	}
+
+	d.counters = p.counters
+	return p.metrics
+}
+
+// index 返回walk得到的oid相对于列的后缀, gosnmp返回的oid以"."开头
+func index(column string, v gosnmp.SnmpPDU) string {
+	return strings.TrimPrefix(strings.TrimPrefix(v.Name, "."), strings.Trim(column, ".")+".")
+}
+
+func (p *poll) interfaces() error {
+	names, err := p.d.walk(oidIfName)
+	if err != nil {
+		return err
+	}
+	column := oidIfName
+	if len(names) == 0 {
+		column = oidIfDescr
+		if names, err = p.d.walk(oidIfDescr); err != nil {
+			return err
+		}
+	}
+
+	tags := make(map[string]string)
+	for _, v := range names {
+		idx := index(column, v)
+		tags[idx] = "ifIndex=" + idx + ",ifName=" + sanitizeTag(toString(v))
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	if aliases, err := p.d.walk(oidIfAlias); err == nil {
+		for _, v := range aliases {
+			idx := index(oidIfAlias, v)
+			if t, ok := tags[idx]; ok && sanitizeTag(toString(v)) != "" {
+				tags[idx] = t + ",ifAlias=" + sanitizeTag(toString(v))
+			}
+		}
+	}
+
+	for _, col := range ifColumns {
+		oid := col.oid
+		var vars []gosnmp.SnmpPDU
+		if oid != "" {
+			if vars, err = p.d.walk(oid); err != nil {
+				return err
+			}
+		}
+		if len(vars) == 0 && col.oid32 != "" {
+			oid = col.oid32
+			if vars, err = p.d.walk(oid); err != nil {
+				return err
+			}
+		}
+
+		for _, v := range vars {
+			t, ok := tags[index(oid, v)]
+			if !ok {
+				continue
+			}
+			if col.counter {
+				p.rate(col.metric, v, t)
+			} else if n, ok := toNumber(v); ok {
+				p.gauge(col.metric, n, t)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *poll) oid(o *g.SnmpOid) error {
+	var vars []gosnmp.SnmpPDU
+	var err error
+	if o.Walk {
+		vars, err = p.d.walk(o.Oid)
+	} else {
+		vars, err = p.d.get(o.Oid)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, v := range vars {
+		if !exists(v) {
+			continue
+		}
+		tags := o.Tags
+		if o.Walk {
+			tags = joinTags(o.Tags, "index="+index(o.Oid, v))
+		}
+
+		if strings.ToUpper(o.Type) == "COUNTER" {
+			p.rate(o.Metric, v, tags)
+			continue
+		}
+		if n, ok := toNumber(v); ok {
+			p.gauge(o.Metric, n, tags)
+		} else if x, ok := v.Value.([]byte); ok {
+			// 部分设备以字符串返回数值, 如温度
+			if f, err := strconv.ParseFloat(strings.TrimSpace(string(x)), 64); err == nil {
+				p.gauge(o.Metric, f, tags)
+			}
+		}
+	}
+	return nil
+}
+
+// Start 按配置的周期并发采集所有设备, 结果发送给transfer
+func Start() {
+	cfg := g.Config().Snmp
+	if cfg == nil || !cfg.Enabled {
+		return
+	}
+
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = 60
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 5
+	}
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = 10
+	}
+
+	var devices []*Device
+	for _, dc := range cfg.Devices {
+		d, err := NewDevice(dc, time.Duration(timeout)*time.Second, cfg.Retries, cfg.MaxRepetitions)
+		if err != nil {
+			log.Fatalln("snmp: device", dc.Address, err)
+		}
+		devices = append(devices, d)
+	}
+	if len(devices) == 0 {
+		return
+	}
+
+	go loop(devices, cfg.Oids, interval, concurrency)
+}
+
+func loop(devices []*Device, oids []*g.SnmpOid, interval, concurrency int) {
+	sema := make(chan struct{}, concurrency)
+	for {
+		now := time.Now()
+		for _, d := range devices {
+			// 上一次采集还没有结束的设备跳过本周期
+			if !atomic.CompareAndSwapInt32(&d.running, 0, 1) {
+				continue
+			}
+			go func(d *Device) {
+				defer atomic.StoreInt32(&d.running, 0)
+				sema <- struct{}{}
+				defer func() { <-sema }()
+				send(d.Poll(time.Now(), oids), interval)
+			}(d)
+		}
+
+		time.Sleep(time.Duration(interval)*time.Second - time.Since(now))
+	}
+}
+
+func send(mvs []*model.MetricValue, step int) {
+	now := time.Now().Unix()
+	for _, mv := range mvs {
+		mv.Step = int64(step)
+		mv.Timestamp = now
+	}
+	if g.Config().Debug {
+		log.Printf("snmp: send %d metrics", len(mvs))
+	}
+	g.SendToTransfer(mvs)
+}
@@ -0,0 +1,273 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsd
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/agent/funcs"
+)
+
+// 每个timer在一个周期内最多保留的样本数, 超过后只统计count/sum/min/max
+const maxTimerSamples = 100000
+
+// 默认连续多少个周期没有更新后丢弃gauge的值
+const defaultGaugeExpire = 10
+
+type metricKey struct {
+	name string
+	tags string
+}
+
+type timerStat struct {
+	count  float64 // 按采样率还原后的次数
+	n      int     // 实际收到的样本数
+	sum    float64
+	min    float64
+	max    float64
+	values []float64
+}
+
+// Aggregator 按周期聚合statsd的counter、gauge、timer和set
+type Aggregator struct {
+	sync.Mutex
+	prefix      string
+	percentiles []float64
+	counters    map[metricKey]float64
+	gaugeExpire int
+	gauges      map[metricKey]float64 // 保留gauge的最新值, 用于处理 +N/-N 形式的增量
+	gaugeIdle   map[metricKey]int     // gauge连续没有更新的周期数, 0表示本周期内更新过
+	timers      map[metricKey]*timerStat
+	sets        map[metricKey]map[string]bool
+}
+
+// NewAggregator gaugeExpire为gauge连续多少个周期没有更新后丢弃其值, 之后的 +N/-N 从0开始累加;
+// 为1时每个周期上报后即丢弃, <=0时使用默认值
+func NewAggregator(prefix string, percentiles []float64, gaugeExpire int) *Aggregator {
+	if gaugeExpire <= 0 {
+		gaugeExpire = defaultGaugeExpire
+	}
+	return &Aggregator{
+		prefix:      prefix,
+		percentiles: percentiles,
+		gaugeExpire: gaugeExpire,
+		counters:    make(map[metricKey]float64),
+		gauges:      make(map[metricKey]float64),
+		gaugeIdle:   make(map[metricKey]int),
+		timers:      make(map[metricKey]*timerStat),
+		sets:        make(map[metricKey]map[string]bool),
+	}
+}
+
+// Process 处理一个数据包, 每行一个数据点, 返回解析失败的行数
+func (a *Aggregator) Process(packet []byte) (bad int) {
+	for _, line := range strings.Split(string(packet), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if err := a.ProcessLine(line); err != nil {
+			bad++
+		}
+	}
+	return
+}
+
+// ProcessLine 解析一行数据: <name>:<value>|<c|g|ms|h|s>[|@<rate>][|#k1:v1,k2:v2]
+func (a *Aggregator) ProcessLine(line string) error {
+	idx := strings.LastIndex(line[:strings.IndexByte(line+"|", '|')], ":")
+	if idx <= 0 {
+		return errors.New("bad statsd line: " + line)
+	}
+	name := sanitizeName(line[:idx])
+	fields := strings.Split(line[idx+1:], "|")
+	if len(fields) < 2 || name == "" {
+		return errors.New("bad statsd line: " + line)
+	}
+	value, typ := fields[0], fields[1]
+
+	rate := 1.0
+	tags := ""
+	for _, f := range fields[2:] {
+		switch {
+		case strings.HasPrefix(f, "@"):
+			r, err := strconv.ParseFloat(f[1:], 64)
+			if err != nil || r <= 0 || r > 1 {
+				return errors.New("bad sample rate: " + f)
+			}
+			rate = r
+		case strings.HasPrefix(f, "#"):
+			tags = parseTags(f[1:])
+		}
+	}
+	key := metricKey{name: name, tags: tags}
+
+	a.Lock()
+	defer a.Unlock()
+
+	switch typ {
+	case "c":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		a.counters[key] += v / rate
+	case "g":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-") {
+			v += a.gauges[key]
+		}
+		a.gauges[key] = v
+		a.gaugeIdle[key] = 0
+	case "ms", "h":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		stat, ok := a.timers[key]
+		if !ok {
+			stat = &timerStat{min: v, max: v}
+			a.timers[key] = stat
+		}
+		stat.count += 1 / rate
+		stat.n++
+		stat.sum += v
+		stat.min = math.Min(stat.min, v)
+		stat.max = math.Max(stat.max, v)
+		if len(stat.values) < maxTimerSamples {
+			stat.values = append(stat.values, v)
+		}
+	case "s":
+		set, ok := a.sets[key]
+		if !ok {
+			set = make(map[string]bool)
+			a.sets[key] = set
+		}
+		set[value] = true
+	default:
+		return errors.New("unknown statsd type: " + typ)
+	}
+	return nil
+}
+
+// Flush 返回本周期的聚合结果并清空, interval为周期的秒数.
+// counter上报 .count 和每秒的 .rate; timer上报 .count/.min/.max/.mean/.median 和各个分位值 .p<N>;
+// set上报 .count; gauge上报本周期内更新过的最新值, 连续gaugeExpire个周期没有更新的gauge被丢弃
+func (a *Aggregator) Flush(interval int) (L []*model.MetricValue) {
+	a.Lock()
+	defer a.Unlock()
+
+	if interval <= 0 {
+		interval = 1
+	}
+
+	for key, v := range a.counters {
+		L = append(L, a.gauge(key, ".count", v))
+		L = append(L, a.gauge(key, ".rate", v/float64(interval)))
+	}
+	for key, idle := range a.gaugeIdle {
+		if idle == 0 {
+			L = append(L, a.gauge(key, "", a.gauges[key]))
+		}
+		if idle+1 >= a.gaugeExpire {
+			delete(a.gauges, key)
+			delete(a.gaugeIdle, key)
+		} else {
+			a.gaugeIdle[key] = idle + 1
+		}
+	}
+	for key, stat := range a.timers {
+		sort.Float64s(stat.values)
+		L = append(L, a.gauge(key, ".count", stat.count))
+		L = append(L, a.gauge(key, ".min", stat.min))
+		L = append(L, a.gauge(key, ".max", stat.max))
+		L = append(L, a.gauge(key, ".mean", stat.sum/float64(stat.n)))
+		L = append(L, a.gauge(key, ".median", percentile(stat.values, 50)))
+		for _, p := range a.percentiles {
+			suffix := ".p" + strings.Replace(strconv.FormatFloat(p, 'f', -1, 64), ".", "_", -1)
+			L = append(L, a.gauge(key, suffix, percentile(stat.values, p)))
+		}
+	}
+	for key, set := range a.sets {
+		L = append(L, a.gauge(key, ".count", len(set)))
+	}
+
+	a.counters = make(map[metricKey]float64)
+	a.timers = make(map[metricKey]*timerStat)
+	a.sets = make(map[metricKey]map[string]bool)
+	return
+}
+
+func (a *Aggregator) gauge(key metricKey, suffix string, val interface{}) *model.MetricValue {
+	if key.tags == "" {
+		return funcs.GaugeValue(a.prefix+key.name+suffix, val)
+	}
+	return funcs.GaugeValue(a.prefix+key.name+suffix, val, key.tags)
+}
+
+// 取排序后样本的分位值(nearest-rank)
+func percentile(sorted []float64, p float64) float64 {
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p/100*float64(n))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= n {
+		idx = n - 1
+	}
+	return sorted[idx]
+}
+
+func sanitizeName(name string) string {
+	name = strings.TrimSpace(name)
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '/', '\\', '=', ',', '\t':
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// 将dogstatsd格式的tag "k1:v1,k2:v2" 转换为 "k1=v1,k2=v2", 按key排序以保证同一组tag聚合在一起
+func parseTags(s string) string {
+	tags := []string{}
+	for _, kv := range strings.Split(s, ",") {
+		idx := strings.IndexByte(kv, ':')
+		if idx <= 0 {
+			continue
+		}
+		k := sanitizeName(kv[:idx])
+		v := sanitizeName(kv[idx+1:])
+		if k == "" || v == "" {
+			continue
+		}
+		tags = append(tags, k+"="+v)
+	}
+	sort.Strings(tags)
+	return strings.Join(tags, ",")
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsd
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+)
+
+func metricsByKey(L []*model.MetricValue) map[string]*model.MetricValue {
+	ret := make(map[string]*model.MetricValue)
+	for _, mv := range L {
+		ret[mv.Metric+"/"+mv.Tags] = mv
+	}
+	return ret
+}
+
+func checkMetric(t *testing.T, metrics map[string]*model.MetricValue, key string, expect float64) {
+	t.Helper()
+	mv, ok := metrics[key]
+	if !ok {
+		t.Errorf("metric %s not found", key)
+		return
+	}
+	v, err := strconv.ParseFloat(fmt.Sprint(mv.Value), 64)
+	if err != nil || v != expect {
+		t.Errorf("metric %s expect %v, got %v", key, expect, mv.Value)
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	a := NewAggregator("statsd.", []float64{90, 99.9}, 0)
+	packet := "page.views:1|c\npage.views:2|c|@0.5\n" +
+		"queue.size:10|g\nqueue.size:-3|g\n" +
+		"api.latency:20|ms|#method:GET,code:200\napi.latency:10|ms|#code:200,method:GET\n" +
+		"users:alice|s\nusers:bob|s\nusers:alice|s\n" +
+		"bad line\nfoo:1|x\nfoo:abc|c\n"
+	for i := 1; i <= 8; i++ {
+		packet += fmt.Sprintf("api.latency:%d|ms|#code:200,method:GET\n", i*100)
+	}
+	if bad := a.Process([]byte(packet)); bad != 3 {
+		t.Errorf("expect 3 bad lines, got %d", bad)
+	}
+
+	metrics := metricsByKey(a.Flush(10))
+	checkMetric(t, metrics, "statsd.page.views.count/", 5)
+	checkMetric(t, metrics, "statsd.page.views.rate/", 0.5)
+	checkMetric(t, metrics, "statsd.queue.size/", 7)
+	checkMetric(t, metrics, "statsd.users.count/", 2)
+
+	tags := "code=200,method=GET"
+	checkMetric(t, metrics, "statsd.api.latency.count/"+tags, 10)
+	checkMetric(t, metrics, "statsd.api.latency.min/"+tags, 10)
+	checkMetric(t, metrics, "statsd.api.latency.max/"+tags, 800)
+	checkMetric(t, metrics, "statsd.api.latency.mean/"+tags, 363)
+	checkMetric(t, metrics, "statsd.api.latency.median/"+tags, 300)
+	checkMetric(t, metrics, "statsd.api.latency.p90/"+tags, 700)
+	checkMetric(t, metrics, "statsd.api.latency.p99_9/"+tags, 800)
+
+	// 周期结束后counter、timer和set被清空, gauge的增量基于上次的值
+	a.ProcessLine("queue.size:+1|g")
+	L := a.Flush(10)
+	if len(L) != 1 {
+		t.Fatalf("expect only the gauge after flush, got %v", L)
+	}
+	checkMetric(t, metricsByKey(L), "statsd.queue.size/", 8)
+}
+
+// 连续gaugeExpire个周期没有更新的gauge被丢弃, 之后的增量从0开始
+func TestAggregatorGaugeExpire(t *testing.T) {
+	a := NewAggregator("", nil, 3)
+	a.ProcessLine("queue.size:10|g")
+	a.ProcessLine("pool.size:5|g")
+	checkMetric(t, metricsByKey(a.Flush(10)), "queue.size/", 10)
+
+	a.ProcessLine("pool.size:+1|g")
+	checkMetric(t, metricsByKey(a.Flush(10)), "pool.size/", 6)
+	if L := a.Flush(10); len(L) != 0 {
+		t.Fatalf("expect no metrics without updates, got %v", L)
+	}
+	if len(a.gauges) != 1 || len(a.gaugeIdle) != 1 {
+		t.Fatalf("expect queue.size expired, got %v", a.gauges)
+	}
+
+	a.ProcessLine("queue.size:+1|g")
+	a.ProcessLine("pool.size:+1|g")
+	metrics := metricsByKey(a.Flush(10))
+	checkMetric(t, metrics, "queue.size/", 1)
+	checkMetric(t, metrics, "pool.size/", 7)
+
+	// gaugeExpire为1时每个周期上报后即丢弃
+	a = NewAggregator("", nil, 1)
+	a.ProcessLine("queue.size:10|g")
+	a.Flush(10)
+	if len(a.gauges) != 0 || len(a.gaugeIdle) != 0 {
+		t.Fatalf("expect gauges dropped after flush, got %v", a.gauges)
+	}
+}
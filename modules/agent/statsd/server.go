@@ -0,0 +1,127 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsd
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"time"
+
+	"github.com/open-falcon/falcon-plus/modules/agent/g"
+)
+
+// udp数据包的最大长度
+const maxPacketSize = 65535
+
+var aggregator *Aggregator
+
+// Start 监听statsd的udp和tcp端口, 每个周期将聚合结果发送给transfer
+func Start() {
+	cfg := g.Config().Statsd
+	if cfg == nil || !cfg.Enabled {
+		return
+	}
+
+	addr := cfg.Listen
+	if addr == "" {
+		addr = ":8125"
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = 60
+	}
+	percentiles := cfg.Percentiles
+	if len(percentiles) == 0 {
+		percentiles = []float64{90}
+	}
+	aggregator = NewAggregator(cfg.Prefix, percentiles, cfg.GaugeExpire)
+
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		log.Fatalln("statsd listen udp", addr, "fail:", err)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalln("statsd listen tcp", addr, "fail:", err)
+	}
+	log.Println("statsd listening", addr)
+
+	go serveUdp(conn)
+	go serveTcp(ln)
+	go flush(interval)
+}
+
+func serveUdp(conn net.PacketConn) {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			log.Println("statsd read udp fail:", err)
+			continue
+		}
+		if bad := aggregator.Process(buf[:n]); bad > 0 && g.Config().Debug {
+			log.Printf("statsd: %d bad lines in packet", bad)
+		}
+	}
+}
+
+func serveTcp(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Println("statsd accept fail:", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		go handleTcp(conn)
+	}
+}
+
+func handleTcp(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxPacketSize)
+	for scanner.Scan() {
+		if err := aggregator.ProcessLine(scanner.Text()); err != nil && g.Config().Debug {
+			log.Println("statsd:", err)
+		}
+	}
+}
+
+func flush(interval int) {
+	for range time.Tick(time.Duration(interval) * time.Second) {
+		mvs := aggregator.Flush(interval)
+		if len(mvs) == 0 {
+			continue
+		}
+
+		hostname, err := g.Hostname()
+		if err != nil {
+			continue
+		}
+		now := time.Now().Unix()
+		for _, mv := range mvs {
+			mv.Step = int64(interval)
+			mv.Endpoint = hostname
+			mv.Timestamp = now
+		}
+
+		if g.Config().Debug {
+			log.Printf("statsd: flush %d metrics", len(mvs))
+		}
+		g.SendToTransfer(mvs)
+	}
+}
@@ -39,6 +39,7 @@ type ApiConfig struct {
 	PlusApi        string `json:"plus_api"`
 	PlusApiToken   string `json:"plus_api_token"`
 	PushApi        string `json:"push_api"`
+	PushApiToken   string `json:"push_api_token"` // push_api对应的agent或transfer的http.token
 }
 
 type GlobalConfig struct {
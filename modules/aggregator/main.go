@@ -57,6 +57,7 @@ func main() {
 	// sdk configuration
 	sender.Debug = g.Config().Debug
 	sender.PostPushUrl = g.Config().Api.PushApi
+	sender.PostPushToken = g.Config().Api.PushApiToken
 
 	sender.StartSender()
 
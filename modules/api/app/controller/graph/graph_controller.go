@@ -341,6 +341,13 @@ func DeleteGraphEndpoint(c *gin.Context) {
 		}
 		affected_counter = dt.RowsAffected
 
+		dt = tx.Table("counter_tag").Where("counter_id in (?)", cids).Delete(&m.CounterTag{})
+		if dt.Error != nil {
+			h.JSONR(c, badstatus, dt.Error)
+			tx.Rollback()
+			return
+		}
+
 		dt = tx.Exec(`delete from tag_endpoint where endpoint_id in 
 			(select id from endpoint where endpoint in (?))`, inputs)
 		if dt.Error != nil {
@@ -435,6 +442,13 @@ func DeleteGraphCounter(c *gin.Context) {
 		return
 	}
 	affected_counter := dt.RowsAffected
+
+	dt = tx.Table("counter_tag").Where("counter_id in (?)", cids).Delete(&m.CounterTag{})
+	if dt.Error != nil {
+		h.JSONR(c, badstatus, dt.Error)
+		tx.Rollback()
+		return
+	}
 	tx.Commit()
 
 	h.JSONR(c, map[string]int64{
@@ -38,6 +38,11 @@ func Routes(r *gin.Engine) {
 	authapi.POST("/graph/lastpoint", QueryGraphLastPoint)
 	authapi.DELETE("/graph/endpoint", DeleteGraphEndpoint)
 	authapi.DELETE("/graph/counter", DeleteGraphCounter)
+	authapi.GET("/graph/metrics", GraphMetricQuery)
+	authapi.GET("/graph/tags", GraphTagKeyQuery)
+	authapi.GET("/graph/tags/:key/values", GraphTagValueQuery)
+	authapi.GET("/graph/series", GraphSeriesQuery)
+	authapi.POST("/graph/series", GraphSeriesQuery)
 
 	grfanaapi := r.Group("/api")
 	grfanaapi.GET("/v1/grafana", GrafanaMainQuery)
@@ -45,4 +50,15 @@ func Routes(r *gin.Engine) {
 	grfanaapi.POST("/v1/grafana/render", GrafanaRender)
 	grfanaapi.GET("/v1/grafana/render", GrafanaRender)
 
+	//prometheus http api compatible subset, for grafana's prometheus datasource
+	grfanaapi.GET("/v1/query", PrometheusQuery)
+	grfanaapi.POST("/v1/query", PrometheusQuery)
+	grfanaapi.GET("/v1/query_range", PrometheusQueryRange)
+	grfanaapi.POST("/v1/query_range", PrometheusQueryRange)
+	grfanaapi.GET("/v1/series", PrometheusSeries)
+	grfanaapi.POST("/v1/series", PrometheusSeries)
+	grfanaapi.GET("/v1/labels", PrometheusLabels)
+	grfanaapi.POST("/v1/labels", PrometheusLabels)
+	grfanaapi.GET("/v1/label/:name/values", PrometheusLabelValues)
+
 }
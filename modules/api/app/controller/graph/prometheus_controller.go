@@ -0,0 +1,493 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// the same default as prometheus uses for instant vectors
+	promDefaultLookback = 300
+	// upper bound of series touched by one selector
+	promMaxSeries = 2000
+	// graph queries running at the same time for one selector
+	promFetchConcurrency = 20
+	// upper bound of points returned by one range query
+	promMaxPoints  = 11000
+	promLabelLimit = 10000
+)
+
+type promSeriesRow struct {
+	Endpoint string
+	Counter  string
+	Step     int
+}
+
+func promFormValue(c *gin.Context, key string) string {
+	if v, ok := c.GetQuery(key); ok {
+		return v
+	}
+	return c.PostForm(key)
+}
+
+func promFormArray(c *gin.Context, key string) []string {
+	if v, ok := c.GetQueryArray(key); ok {
+		return v
+	}
+	return c.PostFormArray(key)
+}
+
+func promSuccess(c *gin.Context, data interface{}) {
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   data,
+	})
+}
+
+func promError(c *gin.Context, code int, errorType string, err error) {
+	c.JSON(code, map[string]interface{}{
+		"status":    "error",
+		"errorType": errorType,
+		"error":     err.Error(),
+	})
+}
+
+// findPromSeries maps the matchers onto endpoint and endpoint_counter. Every
+// matcher mysql can express is turned into sql so that the series limit applies
+// to the final result, the matchers are checked here again since mysql and go
+// regexps are not exactly the same
+func findPromSeries(matchers []*promMatcher, since int64) ([]promSeriesRow, error) {
+	sqlStr, args, err := promSeriesSQL(matchers, since)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []promSeriesRow{}
+	dt := db.Graph.Raw(sqlStr, args...).Scan(&rows)
+	if dt.Error != nil {
+		return nil, dt.Error
+	}
+	if len(rows) > promMaxSeries {
+		return nil, fmt.Errorf("the selector matches more than %d series, add more matchers to narrow it down", promMaxSeries)
+	}
+
+	result := []promSeriesRow{}
+	for _, row := range rows {
+		if matchPromLabels(matchers, promSeriesLabels(row.Endpoint, row.Counter)) {
+			result = append(result, row)
+		}
+	}
+	return result, nil
+}
+
+func promSeriesSQL(matchers []*promMatcher, since int64) (string, []interface{}, error) {
+	where := []string{"b.endpoint_id = a.id"}
+	args := []interface{}{}
+	selective := false
+	for _, m := range matchers {
+		switch m.Name {
+		case promLabelName:
+			switch m.Type {
+			case promMatchEqual:
+				where = append(where, "(b.counter = ? OR b.counter LIKE ?)")
+				args = append(args, m.Value, escapeLike(m.Value)+"/%")
+				selective = true
+			case promMatchNotEqual:
+				where = append(where, "NOT (b.counter = ? OR b.counter LIKE ?)")
+				args = append(args, m.Value, escapeLike(m.Value)+"/%")
+			case promMatchRegexp:
+				where = append(where, "b.counter REGEXP ?")
+				args = append(args, "^("+m.Value+")(/|$)")
+				selective = true
+			case promMatchNotRegexp:
+				where = append(where, "b.counter NOT REGEXP ?")
+				args = append(args, "^("+m.Value+")(/|$)")
+			}
+		case promLabelEndpoint:
+			switch m.Type {
+			case promMatchEqual:
+				where = append(where, "a.endpoint = ?")
+				args = append(args, m.Value)
+				selective = true
+			case promMatchNotEqual:
+				where = append(where, "a.endpoint != ?")
+				args = append(args, m.Value)
+			case promMatchRegexp:
+				where = append(where, "a.endpoint REGEXP ?")
+				args = append(args, "^("+m.Value+")$")
+				selective = true
+			case promMatchNotRegexp:
+				where = append(where, "a.endpoint NOT REGEXP ?")
+				args = append(args, "^("+m.Value+")$")
+			}
+		default:
+			for _, cond := range promTagConditions(m) {
+				if cond.in {
+					where = append(where, "b.counter REGEXP ?")
+					selective = selective || m.Type == promMatchEqual || m.Type == promMatchRegexp
+				} else {
+					where = append(where, "b.counter NOT REGEXP ?")
+				}
+				args = append(args, cond.pattern)
+			}
+		}
+	}
+	if !selective {
+		return "", nil, errors.New("at least one metric name, endpoint or tag equality matcher is required")
+	}
+	if since > 0 {
+		where = append(where, "b.ts >= ?")
+		args = append(args, since)
+	}
+
+	sqlStr := fmt.Sprintf(`select a.endpoint, b.counter, b.step from endpoint as a, endpoint_counter as b
+		where %s limit %d`, strings.Join(where, " AND "), promMaxSeries+1)
+	return sqlStr, args, nil
+}
+
+type promTagCond struct {
+	pattern string
+	in      bool
+}
+
+// promTagConditions expresses a tag matcher as regexps on the counter, which
+// is "metric/k1=v1,k2=v2" with sorted tags. The series matches when the counter
+// matches (in) or does not match each pattern. A missing tag is matched as an
+// empty value, as prometheus does. A regexp matching the empty value can not be
+// expressed and is only checked in go
+func promTagConditions(m *promMatcher) []promTagCond {
+	tag := "^[^/]*/(.*,)?" + regexp.QuoteMeta(m.Name) + "="
+	present := tag + "[^,]"
+	switch m.Type {
+	case promMatchEqual:
+		if m.Value == "" {
+			return []promTagCond{{present, false}}
+		}
+		return []promTagCond{{tag + "(" + regexp.QuoteMeta(m.Value) + ")(,|$)", true}}
+	case promMatchNotEqual:
+		if m.Value == "" {
+			return []promTagCond{{present, true}}
+		}
+		return []promTagCond{{tag + "(" + regexp.QuoteMeta(m.Value) + ")(,|$)", false}}
+	case promMatchRegexp:
+		if m.re.MatchString("") {
+			return nil
+		}
+		return []promTagCond{{tag + "(" + m.Value + ")(,|$)", true}}
+	default:
+		if m.re.MatchString("") {
+			return []promTagCond{{present, true}, {tag + "(" + m.Value + ")(,|$)", false}}
+		}
+		return []promTagCond{{tag + "(" + m.Value + ")(,|$)", false}}
+	}
+}
+
+// resamplePromPoints picks for every timestamp of the evaluation grid the
+// latest point within the lookback window, as prometheus does
+func resamplePromPoints(points map[int64]float64, grid []int64, lookback int64) map[int64]float64 {
+	tss := make([]int64, 0, len(points))
+	for ts, v := range points {
+		if !math.IsNaN(v) {
+			tss = append(tss, ts)
+		}
+	}
+	sort.Slice(tss, func(i, j int) bool { return tss[i] < tss[j] })
+
+	result := map[int64]float64{}
+	for _, t := range grid {
+		idx := sort.Search(len(tss), func(i int) bool { return tss[i] > t }) - 1
+		if idx >= 0 && t-tss[idx] <= lookback {
+			result[t] = points[tss[idx]]
+		}
+	}
+	return result
+}
+
+func evalPromExpr(expr *promExpr, grid []int64) ([]*promSeries, error) {
+	if expr.Op != "" {
+		inner, err := evalPromExpr(expr.Expr, grid)
+		if err != nil {
+			return nil, err
+		}
+		return aggregatePromSeries(expr.Op, expr.Grouping, expr.Without, inner), nil
+	}
+
+	rows, err := findPromSeries(expr.Matchers, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// every series is a separate graph query, run them concurrently
+	start, end := grid[0], grid[len(grid)-1]
+	fetched := make([]*promSeries, len(rows))
+	idx := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < promFetchConcurrency && w < len(rows); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				fetched[i] = fetchPromSeries(rows[i], grid, start, end)
+			}
+		}()
+	}
+	for i := range rows {
+		idx <- i
+	}
+	close(idx)
+	wg.Wait()
+
+	result := []*promSeries{}
+	for _, s := range fetched {
+		if s != nil {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+func fetchPromSeries(row promSeriesRow, grid []int64, start, end int64) *promSeries {
+	lookback := int64(promDefaultLookback)
+	if l := int64(row.Step * 2); l > lookback {
+		lookback = l
+	}
+	resp, err := fetchData(row.Endpoint, row.Counter, "AVERAGE", start-lookback, end, row.Step)
+	if err != nil {
+		log.Debugf("query graph got error with: %s/%s", row.Endpoint, row.Counter)
+		return nil
+	}
+	points := map[int64]float64{}
+	for _, v := range resp.Values {
+		points[v.Timestamp] = float64(v.Value)
+	}
+	points = resamplePromPoints(points, grid, lookback)
+	if len(points) == 0 {
+		return nil
+	}
+	return &promSeries{
+		Labels: promSeriesLabels(row.Endpoint, row.Counter),
+		Points: points,
+	}
+}
+
+func formatPromValue(ts int64, v float64) []interface{} {
+	return []interface{}{ts, strconv.FormatFloat(v, 'f', -1, 64)}
+}
+
+func PrometheusQuery(c *gin.Context) {
+	query := promFormValue(c, "query")
+	ts, err := parsePromTime(promFormValue(c, "time"), time.Now().Unix())
+	if err != nil {
+		promError(c, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	expr, err := parsePromQL(query)
+	if err != nil {
+		promError(c, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	series, err := evalPromExpr(expr, []int64{ts})
+	if err != nil {
+		promError(c, http.StatusUnprocessableEntity, "execution", err)
+		return
+	}
+
+	result := []map[string]interface{}{}
+	for _, s := range series {
+		v, ok := s.Points[ts]
+		if !ok {
+			continue
+		}
+		result = append(result, map[string]interface{}{
+			"metric": s.Labels,
+			"value":  formatPromValue(ts, v),
+		})
+	}
+	promSuccess(c, map[string]interface{}{
+		"resultType": "vector",
+		"result":     result,
+	})
+}
+
+func PrometheusQueryRange(c *gin.Context) {
+	query := promFormValue(c, "query")
+	start, err := parsePromTime(promFormValue(c, "start"), 0)
+	if err != nil {
+		promError(c, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	end, err := parsePromTime(promFormValue(c, "end"), 0)
+	if err != nil {
+		promError(c, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	step, err := parsePromDuration(promFormValue(c, "step"))
+	if err != nil {
+		promError(c, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	stepSec := int64(step / time.Second)
+	if start <= 0 || end < start || stepSec <= 0 {
+		promError(c, http.StatusBadRequest, "bad_data", errors.New("invalid start, end or step"))
+		return
+	}
+	if (end-start)/stepSec > promMaxPoints {
+		promError(c, http.StatusBadRequest, "bad_data",
+			fmt.Errorf("exceeded maximum resolution of %d points per timeseries", promMaxPoints))
+		return
+	}
+	expr, err := parsePromQL(query)
+	if err != nil {
+		promError(c, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+
+	grid := []int64{}
+	for t := start; t <= end; t += stepSec {
+		grid = append(grid, t)
+	}
+	series, err := evalPromExpr(expr, grid)
+	if err != nil {
+		promError(c, http.StatusUnprocessableEntity, "execution", err)
+		return
+	}
+
+	result := []map[string]interface{}{}
+	for _, s := range series {
+		values := [][]interface{}{}
+		for _, t := range grid {
+			if v, ok := s.Points[t]; ok {
+				values = append(values, formatPromValue(t, v))
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		result = append(result, map[string]interface{}{
+			"metric": s.Labels,
+			"values": values,
+		})
+	}
+	promSuccess(c, map[string]interface{}{
+		"resultType": "matrix",
+		"result":     result,
+	})
+}
+
+func PrometheusSeries(c *gin.Context) {
+	matches := promFormArray(c, "match[]")
+	if len(matches) == 0 {
+		promError(c, http.StatusBadRequest, "bad_data", errors.New("no match[] parameter provided"))
+		return
+	}
+	start, err := parsePromTime(promFormValue(c, "start"), 0)
+	if err != nil {
+		promError(c, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+
+	seen := map[string]bool{}
+	result := []map[string]string{}
+	for _, match := range matches {
+		expr, err := parsePromQL(match)
+		if err != nil {
+			promError(c, http.StatusBadRequest, "bad_data", err)
+			return
+		}
+		if expr.Op != "" {
+			promError(c, http.StatusBadRequest, "bad_data", errors.New("match[] must be a series selector"))
+			return
+		}
+		rows, err := findPromSeries(expr.Matchers, start)
+		if err != nil {
+			promError(c, http.StatusUnprocessableEntity, "execution", err)
+			return
+		}
+		for _, row := range rows {
+			key := row.Endpoint + "/" + row.Counter
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			result = append(result, promSeriesLabels(row.Endpoint, row.Counter))
+		}
+	}
+	promSuccess(c, result)
+}
+
+// promLabelRows scans single column label queries, the column must be named value
+func promLabelRows(sqlStr string, args ...interface{}) ([]string, error) {
+	type valueRow struct {
+		Value string
+	}
+	rows := []valueRow{}
+	dt := db.Graph.Raw(sqlStr, args...).Scan(&rows)
+	if dt.Error != nil {
+		return nil, dt.Error
+	}
+	values := make([]string, len(rows))
+	for i, row := range rows {
+		values[i] = row.Value
+	}
+	return values, nil
+}
+
+func PrometheusLabels(c *gin.Context) {
+	keys, err := promLabelRows(fmt.Sprintf(`select distinct tag_key as value from counter_tag
+		where tag_key != '' limit %d`, promLabelLimit))
+	if err != nil {
+		promError(c, http.StatusUnprocessableEntity, "execution", err)
+		return
+	}
+	result := append([]string{promLabelName, promLabelEndpoint}, keys...)
+	sort.Strings(result)
+	promSuccess(c, result)
+}
+
+func PrometheusLabelValues(c *gin.Context) {
+	name := c.Param("name")
+	var values []string
+	var err error
+	switch name {
+	case promLabelName:
+		values, err = promLabelRows(fmt.Sprintf(`select distinct metric as value from counter_tag limit %d`,
+			promLabelLimit))
+	case promLabelEndpoint:
+		values, err = promLabelRows(fmt.Sprintf(`select endpoint as value from endpoint limit %d`,
+			promLabelLimit))
+	default:
+		values, err = promLabelRows(fmt.Sprintf(`select distinct tag_value as value from counter_tag
+			where tag_key = ? limit %d`, promLabelLimit), name)
+	}
+	if err != nil {
+		promError(c, http.StatusUnprocessableEntity, "execution", err)
+		return
+	}
+	sort.Strings(values)
+	promSuccess(c, values)
+}
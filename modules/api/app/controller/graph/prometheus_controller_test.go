@@ -0,0 +1,63 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the requests are rejected before graph or the database is touched
+func TestPrometheusControllerBadRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/v1/query", PrometheusQuery)
+	r.GET("/api/v1/query_range", PrometheusQueryRange)
+	r.GET("/api/v1/series", PrometheusSeries)
+
+	cases := []struct {
+		uri       string
+		code      int
+		errorType string
+	}{
+		{"/api/v1/query?query=", http.StatusBadRequest, "bad_data"},
+		{"/api/v1/query?query=cpu.idle&time=abc", http.StatusBadRequest, "bad_data"},
+		{"/api/v1/query?query=" + "%7Bidc!%3D%22bj%22%7D", http.StatusUnprocessableEntity, "execution"},
+		{"/api/v1/query_range?query=cpu.idle&start=100&end=50&step=10", http.StatusBadRequest, "bad_data"},
+		{"/api/v1/query_range?query=cpu.idle&start=1&end=1000000&step=1", http.StatusBadRequest, "bad_data"},
+		{"/api/v1/series", http.StatusBadRequest, "bad_data"},
+		{"/api/v1/series?match[]=sum(cpu.idle)", http.StatusBadRequest, "bad_data"},
+		{"/api/v1/series?match[]=" + "%7Bcore%3D~%22.*%22%7D", http.StatusUnprocessableEntity, "execution"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", c.uri, nil))
+		var resp struct {
+			Status    string `json:"status"`
+			ErrorType string `json:"errorType"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Errorf("%s: %v %s", c.uri, err, w.Body.String())
+			continue
+		}
+		if w.Code != c.code || resp.Status != "error" || resp.ErrorType != c.errorType {
+			t.Errorf("%s: expect %d %s, got %d %s", c.uri, c.code, c.errorType, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,453 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	cutils "github.com/open-falcon/falcon-plus/common/utils"
+)
+
+// label names with special meaning, everything else is a falcon tag
+const (
+	promLabelName     = "__name__"
+	promLabelEndpoint = "endpoint"
+)
+
+type promMatchType int
+
+const (
+	promMatchEqual promMatchType = iota
+	promMatchNotEqual
+	promMatchRegexp
+	promMatchNotRegexp
+)
+
+type promMatcher struct {
+	Name  string
+	Type  promMatchType
+	Value string
+	re    *regexp.Regexp
+}
+
+func newPromMatcher(name string, t promMatchType, value string) (*promMatcher, error) {
+	m := &promMatcher{Name: name, Type: t, Value: value}
+	if t == promMatchRegexp || t == promMatchNotRegexp {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, err
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+func (m *promMatcher) Matches(s string) bool {
+	switch m.Type {
+	case promMatchEqual:
+		return s == m.Value
+	case promMatchNotEqual:
+		return s != m.Value
+	case promMatchRegexp:
+		return m.re.MatchString(s)
+	case promMatchNotRegexp:
+		return !m.re.MatchString(s)
+	}
+	return false
+}
+
+// promExpr is either a series selector (Matchers) or an aggregation
+// over another expression (Op + Expr)
+type promExpr struct {
+	Matchers []*promMatcher
+
+	Op       string
+	Grouping []string
+	Without  bool
+	Expr     *promExpr
+}
+
+var promAggregators = map[string]bool{
+	"sum":   true,
+	"avg":   true,
+	"min":   true,
+	"max":   true,
+	"count": true,
+}
+
+type promParser struct {
+	input string
+	pos   int
+}
+
+func parsePromQL(input string) (*promExpr, error) {
+	p := &promParser{input: input}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.input[p.pos:], p.pos)
+	}
+	return expr, nil
+}
+
+func (p *promParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *promParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *promParser) expect(b byte) error {
+	if p.peek() != b {
+		return fmt.Errorf("expected %q at position %d", b, p.pos)
+	}
+	p.pos++
+	return nil
+}
+
+// falcon metric names usually contain dots, so they are allowed in identifiers
+func isPromIdentChar(b byte) bool {
+	return b == '_' || b == ':' || b == '.' || b == '-' ||
+		(b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9')
+}
+
+func (p *promParser) ident() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.input) && isPromIdentChar(p.input[p.pos]) {
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+func (p *promParser) str() (string, error) {
+	q := p.peek()
+	if q != '"' && q != '\'' && q != '`' {
+		return "", fmt.Errorf("expected string at position %d", p.pos)
+	}
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.input) {
+		switch p.input[p.pos] {
+		case '\\':
+			p.pos += 2
+			continue
+		case q:
+			p.pos++
+			raw := p.input[start:p.pos]
+			if q == '\'' {
+				raw = `"` + strings.Replace(raw[1:len(raw)-1], `"`, `\"`, -1) + `"`
+			}
+			return strconv.Unquote(raw)
+		}
+		p.pos++
+	}
+	return "", fmt.Errorf("unterminated string at position %d", start)
+}
+
+func (p *promParser) parseExpr() (*promExpr, error) {
+	save := p.pos
+	name := p.ident()
+	if name == "" {
+		if p.peek() == '{' {
+			return p.parseSelector("")
+		}
+		return nil, fmt.Errorf("unexpected token at position %d", p.pos)
+	}
+
+	if promAggregators[name] {
+		after := p.pos
+		next := p.peek()
+		if word := p.ident(); next == '(' || word == "by" || word == "without" {
+			p.pos = after
+			return p.parseAggregation(name)
+		}
+		p.pos = save
+		name = p.ident()
+	}
+	return p.parseSelector(name)
+}
+
+func (p *promParser) parseGrouping(expr *promExpr) (bool, error) {
+	after := p.pos
+	switch p.ident() {
+	case "by":
+		expr.Without = false
+	case "without":
+		expr.Without = true
+	default:
+		p.pos = after
+		return false, nil
+	}
+	if err := p.expect('('); err != nil {
+		return false, err
+	}
+	expr.Grouping = []string{}
+	for p.peek() != ')' {
+		label := p.ident()
+		if label == "" {
+			return false, fmt.Errorf("expected label name at position %d", p.pos)
+		}
+		expr.Grouping = append(expr.Grouping, label)
+		if p.peek() == ',' {
+			p.pos++
+		}
+	}
+	return true, p.expect(')')
+}
+
+func (p *promParser) parseAggregation(op string) (*promExpr, error) {
+	expr := &promExpr{Op: op}
+	grouped, err := p.parseGrouping(expr)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	if expr.Expr, err = p.parseExpr(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	if !grouped {
+		if _, err := p.parseGrouping(expr); err != nil {
+			return nil, err
+		}
+	}
+	return expr, nil
+}
+
+func (p *promParser) parseSelector(name string) (*promExpr, error) {
+	expr := &promExpr{Matchers: []*promMatcher{}}
+	if name != "" {
+		m, _ := newPromMatcher(promLabelName, promMatchEqual, name)
+		expr.Matchers = append(expr.Matchers, m)
+	}
+	if p.peek() == '{' {
+		p.pos++
+		for p.peek() != '}' {
+			label := p.ident()
+			if label == "" {
+				return nil, fmt.Errorf("expected label name at position %d", p.pos)
+			}
+			p.skipSpace()
+			var t promMatchType
+			switch {
+			case strings.HasPrefix(p.input[p.pos:], "=~"):
+				t = promMatchRegexp
+				p.pos += 2
+			case strings.HasPrefix(p.input[p.pos:], "!~"):
+				t = promMatchNotRegexp
+				p.pos += 2
+			case strings.HasPrefix(p.input[p.pos:], "!="):
+				t = promMatchNotEqual
+				p.pos += 2
+			case strings.HasPrefix(p.input[p.pos:], "="):
+				t = promMatchEqual
+				p.pos++
+			default:
+				return nil, fmt.Errorf("expected label matcher at position %d", p.pos)
+			}
+			value, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			m, err := newPromMatcher(label, t, value)
+			if err != nil {
+				return nil, err
+			}
+			expr.Matchers = append(expr.Matchers, m)
+			if p.peek() == ',' {
+				p.pos++
+			}
+		}
+		p.pos++
+	}
+	if len(expr.Matchers) == 0 {
+		return nil, fmt.Errorf("vector selector must contain at least one matcher")
+	}
+	return expr, nil
+}
+
+// promSeries is one graph counter mapped onto prometheus labels
+type promSeries struct {
+	Labels map[string]string
+	Points map[int64]float64
+}
+
+func promSeriesLabels(endpoint, counter string) map[string]string {
+	labels := map[string]string{promLabelEndpoint: endpoint}
+	fields := strings.SplitN(counter, "/", 2)
+	labels[promLabelName] = fields[0]
+	if len(fields) == 2 {
+		for k, v := range cutils.DictedTagstring(fields[1]) {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+func matchPromLabels(matchers []*promMatcher, labels map[string]string) bool {
+	for _, m := range matchers {
+		if !m.Matches(labels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+func promGroupKey(labels map[string]string, grouping []string, without bool) (string, map[string]string) {
+	group := map[string]string{}
+	if without {
+		drop := map[string]bool{promLabelName: true}
+		for _, l := range grouping {
+			drop[l] = true
+		}
+		for k, v := range labels {
+			if !drop[k] {
+				group[k] = v
+			}
+		}
+	} else {
+		for _, l := range grouping {
+			if v, ok := labels[l]; ok {
+				group[l] = v
+			}
+		}
+	}
+	keys := make([]string, 0, len(group))
+	for k := range group {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + group[k]
+	}
+	return strings.Join(parts, ","), group
+}
+
+// aggregatePromSeries applies op to the points sharing the same timestamp of
+// the series falling into the same group
+func aggregatePromSeries(op string, grouping []string, without bool, series []*promSeries) []*promSeries {
+	type acc struct {
+		sum, min, max float64
+		count         int
+	}
+	groups := map[string]*promSeries{}
+	accs := map[string]map[int64]*acc{}
+	order := []string{}
+	for _, s := range series {
+		key, labels := promGroupKey(s.Labels, grouping, without)
+		if _, ok := groups[key]; !ok {
+			groups[key] = &promSeries{Labels: labels, Points: map[int64]float64{}}
+			accs[key] = map[int64]*acc{}
+			order = append(order, key)
+		}
+		for ts, v := range s.Points {
+			if math.IsNaN(v) {
+				continue
+			}
+			a, ok := accs[key][ts]
+			if !ok {
+				accs[key][ts] = &acc{sum: v, min: v, max: v, count: 1}
+				continue
+			}
+			a.sum += v
+			a.count++
+			a.min = math.Min(a.min, v)
+			a.max = math.Max(a.max, v)
+		}
+	}
+
+	result := make([]*promSeries, 0, len(order))
+	for _, key := range order {
+		g := groups[key]
+		for ts, a := range accs[key] {
+			switch op {
+			case "sum":
+				g.Points[ts] = a.sum
+			case "avg":
+				g.Points[ts] = a.sum / float64(a.count)
+			case "min":
+				g.Points[ts] = a.min
+			case "max":
+				g.Points[ts] = a.max
+			case "count":
+				g.Points[ts] = float64(a.count)
+			}
+		}
+		result = append(result, g)
+	}
+	return result
+}
+
+var promDurationRegexp = regexp.MustCompile(`^([0-9]+)(ms|s|m|h|d|w|y)$`)
+
+// parsePromDuration accepts both prometheus durations (5m) and float seconds
+func parsePromDuration(s string) (time.Duration, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	matched := promDurationRegexp.FindStringSubmatch(s)
+	if matched == nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	n, _ := strconv.ParseInt(matched[1], 10, 64)
+	units := map[string]time.Duration{
+		"ms": time.Millisecond,
+		"s":  time.Second,
+		"m":  time.Minute,
+		"h":  time.Hour,
+		"d":  24 * time.Hour,
+		"w":  7 * 24 * time.Hour,
+		"y":  365 * 24 * time.Hour,
+	}
+	return time.Duration(n) * units[matched[2]], nil
+}
+
+// parsePromTime accepts unix timestamps (with fraction) and RFC3339 times
+func parsePromTime(s string, def int64) (int64, error) {
+	if s == "" {
+		return def, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return int64(f), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return t.Unix(), nil
+}
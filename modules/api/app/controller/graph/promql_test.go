@@ -0,0 +1,202 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePromQLSelector(t *testing.T) {
+	expr, err := parsePromQL(`cpu.idle{endpoint=~"host-.*", service!="db"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expr.Matchers) != 3 {
+		t.Fatalf("expect 3 matchers, got %d", len(expr.Matchers))
+	}
+	labels := promSeriesLabels("host-1", "cpu.idle/service=web")
+	if !matchPromLabels(expr.Matchers, labels) {
+		t.Errorf("expect %v matched", labels)
+	}
+	labels = promSeriesLabels("host-1", "cpu.idle/service=db")
+	if matchPromLabels(expr.Matchers, labels) {
+		t.Errorf("expect %v not matched", labels)
+	}
+	labels = promSeriesLabels("db-1", "cpu.idle")
+	if matchPromLabels(expr.Matchers, labels) {
+		t.Errorf("expect %v not matched", labels)
+	}
+}
+
+func TestParsePromQLAggregation(t *testing.T) {
+	cases := []struct {
+		query    string
+		op       string
+		grouping []string
+		without  bool
+	}{
+		{`sum(load.1min)`, "sum", nil, false},
+		{`avg by (idc) (load.1min{endpoint="a"})`, "avg", []string{"idc"}, false},
+		{`max(load.1min) by (idc, rack)`, "max", []string{"idc", "rack"}, false},
+		{`count without (endpoint) ({__name__="load.1min"})`, "count", []string{"endpoint"}, true},
+	}
+	for _, c := range cases {
+		expr, err := parsePromQL(c.query)
+		if err != nil {
+			t.Errorf("parse %s: %v", c.query, err)
+			continue
+		}
+		if expr.Op != c.op || expr.Without != c.without || len(expr.Grouping) != len(c.grouping) {
+			t.Errorf("parse %s: unexpected %+v", c.query, expr)
+			continue
+		}
+		for i := range c.grouping {
+			if expr.Grouping[i] != c.grouping[i] {
+				t.Errorf("parse %s: unexpected grouping %v", c.query, expr.Grouping)
+			}
+		}
+		if expr.Expr == nil || len(expr.Expr.Matchers) == 0 {
+			t.Errorf("parse %s: missing inner selector", c.query)
+		}
+	}
+
+	for _, bad := range []string{``, `sum(`, `cpu{a=}`, `cpu{a="b"`, `{}`, `cpu{a=~"("}`} {
+		if _, err := parsePromQL(bad); err == nil {
+			t.Errorf("expect error for %q", bad)
+		}
+	}
+}
+
+func TestAggregatePromSeries(t *testing.T) {
+	series := []*promSeries{
+		{Labels: map[string]string{"__name__": "m", "endpoint": "a", "idc": "x"}, Points: map[int64]float64{60: 1, 120: 2}},
+		{Labels: map[string]string{"__name__": "m", "endpoint": "b", "idc": "x"}, Points: map[int64]float64{60: 3}},
+		{Labels: map[string]string{"__name__": "m", "endpoint": "c", "idc": "y"}, Points: map[int64]float64{60: 5}},
+	}
+	result := aggregatePromSeries("sum", []string{"idc"}, false, series)
+	if len(result) != 2 {
+		t.Fatalf("expect 2 groups, got %d", len(result))
+	}
+	if result[0].Labels["idc"] != "x" || result[0].Points[60] != 4 || result[0].Points[120] != 2 {
+		t.Errorf("unexpected group %+v", result[0])
+	}
+	result = aggregatePromSeries("avg", nil, false, series)
+	if len(result) != 1 || result[0].Points[60] != 3 {
+		t.Errorf("unexpected avg %+v", result[0])
+	}
+}
+
+func TestResamplePromPoints(t *testing.T) {
+	points := map[int64]float64{60: 1, 120: 2, 600: 3}
+	result := resamplePromPoints(points, []int64{90, 120, 500, 700}, 300)
+	if result[90] != 1 || result[120] != 2 || result[700] != 3 {
+		t.Errorf("unexpected resample %v", result)
+	}
+	if _, ok := result[500]; ok {
+		t.Errorf("expect 500 out of lookback, got %v", result)
+	}
+}
+
+func TestParsePromDuration(t *testing.T) {
+	cases := map[string]time.Duration{
+		"15":  15 * time.Second,
+		"1.5": 1500 * time.Millisecond,
+		"5m":  5 * time.Minute,
+		"1h":  time.Hour,
+	}
+	for s, expect := range cases {
+		d, err := parsePromDuration(s)
+		if err != nil || d != expect {
+			t.Errorf("parse %s: expect %v, got %v %v", s, expect, d, err)
+		}
+	}
+	if _, err := parsePromDuration("5x"); err == nil {
+		t.Error("expect error for 5x")
+	}
+}
+
+func TestPromTagConditions(t *testing.T) {
+	counters := []string{
+		"cpu.idle",
+		"cpu.idle/core=0",
+		"cpu.idle/core=1,idc=bj",
+		"cpu.idle/core=10,idc=sh",
+		"cpu.idle/idc=bj",
+		"cpu.idle/a.b=x,core=",
+		"net.if.in.bytes/iface=eth0,xcore=0",
+	}
+	cases := []struct {
+		t     promMatchType
+		value string
+		conds int
+	}{
+		{promMatchEqual, "1", 1},
+		{promMatchEqual, "", 1},
+		{promMatchNotEqual, "1", 1},
+		{promMatchNotEqual, "", 1},
+		{promMatchRegexp, "1.*", 1},
+		{promMatchRegexp, "1|", 0},
+		{promMatchNotRegexp, "1.*", 1},
+		{promMatchNotRegexp, "1|", 2},
+	}
+	for _, c := range cases {
+		m, err := newPromMatcher("core", c.t, c.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conds := promTagConditions(m)
+		if len(conds) != c.conds {
+			t.Errorf("%v %q: expect %d conditions, got %v", c.t, c.value, c.conds, conds)
+			continue
+		}
+		if len(conds) == 0 {
+			continue
+		}
+		// the sql conditions must select exactly the series the matcher accepts
+		for _, counter := range counters {
+			sql := true
+			for _, cond := range conds {
+				if regexp.MustCompile(cond.pattern).MatchString(counter) != cond.in {
+					sql = false
+				}
+			}
+			if expect := m.Matches(promSeriesLabels("host", counter)["core"]); sql != expect {
+				t.Errorf("%v %q on %s: sql %v, matcher %v", c.t, c.value, counter, sql, expect)
+			}
+		}
+	}
+}
+
+func TestPromSeriesSQL(t *testing.T) {
+	expr, err := parsePromQL(`cpu.idle{core="1",idc!~"sh|gz"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlStr, args, err := promSeriesSQL(expr.Matchers, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sqlStr, "counter_tag") || strings.Count(sqlStr, "?") != len(args) || args[len(args)-1] != int64(100) {
+		t.Errorf("unexpected sql %s %v", sqlStr, args)
+	}
+
+	expr, _ = parsePromQL(`{idc!="bj"}`)
+	if _, _, err := promSeriesSQL(expr.Matchers, 0); err == nil {
+		t.Error("expect error for a selector without equality matchers")
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	h "github.com/open-falcon/falcon-plus/modules/api/app/helper"
+)
+
+func escapeLike(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "%", "\\%", -1)
+	return strings.Replace(s, "_", "\\_", -1)
+}
+
+const (
+	graphSuggestMaxLimit = 1000
+	graphSeriesMaxLimit  = 5000
+)
+
+// limitOf falls back to def when limit is not positive and caps it at max
+func limitOf(limit, def, max int) int {
+	if limit <= 0 {
+		return def
+	}
+	if limit > max {
+		return max
+	}
+	return limit
+}
+
+type APIGraphMetricQueryInputs struct {
+	Q     string `json:"q" form:"q"`
+	Limit int    `json:"limit" form:"limit"`
+}
+
+// metric name autocompletion over the counter_tag index
+func GraphMetricQuery(c *gin.Context) {
+	inputs := APIGraphMetricQueryInputs{
+		Limit: 50,
+	}
+	if err := c.Bind(&inputs); err != nil {
+		h.JSONR(c, badstatus, err)
+		return
+	}
+
+	var metrics []string
+	dt := db.Graph.Table("counter_tag").Select("distinct metric").
+		Where("metric like ?", escapeLike(inputs.Q)+"%").
+		Order("metric").Limit(limitOf(inputs.Limit, 50, graphSuggestMaxLimit)).Pluck("distinct metric", &metrics)
+	if dt.Error != nil {
+		h.JSONR(c, http.StatusBadRequest, dt.Error)
+		return
+	}
+	h.JSONR(c, metrics)
+}
+
+type APIGraphTagQueryInputs struct {
+	Metric string `json:"metric" form:"metric"`
+	Q      string `json:"q" form:"q"`
+	Limit  int    `json:"limit" form:"limit"`
+}
+
+// tag key autocompletion, optionally limited to one metric
+func GraphTagKeyQuery(c *gin.Context) {
+	inputs := APIGraphTagQueryInputs{
+		Limit: 50,
+	}
+	if err := c.Bind(&inputs); err != nil {
+		h.JSONR(c, badstatus, err)
+		return
+	}
+
+	var keys []string
+	dt := db.Graph.Table("counter_tag").Select("distinct tag_key").
+		Where("tag_key != '' and tag_key like ?", escapeLike(inputs.Q)+"%")
+	if inputs.Metric != "" {
+		dt = dt.Where("metric = ?", inputs.Metric)
+	}
+	dt = dt.Order("tag_key").Limit(limitOf(inputs.Limit, 50, graphSuggestMaxLimit)).Pluck("distinct tag_key", &keys)
+	if dt.Error != nil {
+		h.JSONR(c, http.StatusBadRequest, dt.Error)
+		return
+	}
+	h.JSONR(c, keys)
+}
+
+// tag value autocompletion of one tag key, optionally limited to one metric
+func GraphTagValueQuery(c *gin.Context) {
+	inputs := APIGraphTagQueryInputs{
+		Limit: 50,
+	}
+	if err := c.Bind(&inputs); err != nil {
+		h.JSONR(c, badstatus, err)
+		return
+	}
+
+	var values []string
+	dt := db.Graph.Table("counter_tag").Select("distinct tag_value").
+		Where("tag_key = ? and tag_value like ?", c.Param("key"), escapeLike(inputs.Q)+"%")
+	if inputs.Metric != "" {
+		dt = dt.Where("metric = ?", inputs.Metric)
+	}
+	dt = dt.Order("tag_value").Limit(limitOf(inputs.Limit, 50, graphSuggestMaxLimit)).Pluck("distinct tag_value", &values)
+	if dt.Error != nil {
+		h.JSONR(c, http.StatusBadRequest, dt.Error)
+		return
+	}
+	h.JSONR(c, values)
+}
+
+type APIGraphSeriesQueryInputs struct {
+	Metric    string   `json:"metric" form:"metric"`
+	Endpoints []string `json:"endpoints" form:"endpoints"`
+	Selector  string   `json:"selector" form:"selector"`
+	Limit     int      `json:"limit" form:"limit"`
+	Page      int      `json:"page" form:"page"`
+}
+
+// series matching with a boolean tag selector, ex.
+// selector=service=foo and (idc=bj or idc=sh) and not env=test
+func GraphSeriesQuery(c *gin.Context) {
+	inputs := APIGraphSeriesQueryInputs{
+		Limit: 500,
+		Page:  1,
+	}
+	if err := c.Bind(&inputs); err != nil {
+		h.JSONR(c, badstatus, err)
+		return
+	}
+	if inputs.Metric == "" && inputs.Selector == "" && len(inputs.Endpoints) == 0 {
+		h.JSONR(c, http.StatusBadRequest, "metric, endpoints and selector are all missing")
+		return
+	}
+
+	inputs.Limit = limitOf(inputs.Limit, 500, graphSeriesMaxLimit)
+	var offset int = 0
+	if inputs.Page > 1 {
+		offset = (inputs.Page - 1) * inputs.Limit
+	}
+
+	type DBRows struct {
+		EndpointId int
+		Endpoint   string
+		Counter    string
+		Step       int
+		Type       string
+	}
+	rows := []DBRows{}
+	dt := db.Graph.Table("endpoint_counter as b").
+		Select("a.id as endpoint_id, a.endpoint, b.counter, b.step, b.type").
+		Joins("join endpoint as a on a.id = b.endpoint_id")
+	if inputs.Metric != "" {
+		dt = dt.Where("b.id in (select counter_id from counter_tag where metric = ?)", inputs.Metric)
+	}
+	if len(inputs.Endpoints) != 0 {
+		dt = dt.Where("a.endpoint in (?)", inputs.Endpoints)
+	}
+	if inputs.Selector != "" {
+		sel, err := parseTagSelector(inputs.Selector)
+		if err != nil {
+			h.JSONR(c, http.StatusBadRequest, err)
+			return
+		}
+		sqlStr, args := sel.SQL("b.id")
+		dt = dt.Where(sqlStr, args...)
+	}
+	dt = dt.Order("b.id").Limit(inputs.Limit).Offset(offset).Scan(&rows)
+	if dt.Error != nil {
+		h.JSONR(c, http.StatusBadRequest, dt.Error)
+		return
+	}
+
+	series := []map[string]interface{}{}
+	for _, r := range rows {
+		series = append(series, map[string]interface{}{
+			"endpoint_id": r.EndpointId,
+			"endpoint":    r.Endpoint,
+			"counter":     r.Counter,
+			"step":        r.Step,
+			"type":        r.Type,
+		})
+	}
+	h.JSONR(c, series)
+}
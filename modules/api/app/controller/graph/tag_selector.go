@@ -0,0 +1,245 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tagSelector is a boolean expression over counter tags, ex.
+// service=foo and (idc=bj or idc=sh) and not env=~"test.*"
+// the operators are and(&&), or(||), not(!) and parentheses,
+// the terms are key=value, key!=value, key=~regexp and key!~regexp
+type tagSelector struct {
+	Op       string // and, or, not; empty for a term
+	Children []*tagSelector
+
+	Key   string
+	Match string // =, !=, =~, !~
+	Value string
+}
+
+type tagSelectorParser struct {
+	input string
+	pos   int
+}
+
+func parseTagSelector(input string) (*tagSelector, error) {
+	p := &tagSelectorParser{input: input}
+	sel, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.input[p.pos:], p.pos)
+	}
+	return sel, nil
+}
+
+func (p *tagSelectorParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// keyword consumes one of the given words if it is next in the input, words
+// made of letters must be followed by a delimiter
+func (p *tagSelectorParser) keyword(words ...string) bool {
+	p.skipSpace()
+	rest := strings.ToLower(p.input[p.pos:])
+	for _, w := range words {
+		if !strings.HasPrefix(rest, w) {
+			continue
+		}
+		if unicode.IsLetter(rune(w[0])) && len(rest) > len(w) {
+			next := rune(rest[len(w)])
+			if !unicode.IsSpace(next) && next != '(' {
+				continue
+			}
+		}
+		p.pos += len(w)
+		return true
+	}
+	return false
+}
+
+func (p *tagSelectorParser) parseOr() (*tagSelector, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or", "||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &tagSelector{Op: "or", Children: []*tagSelector{left, right}}
+	}
+	return left, nil
+}
+
+func (p *tagSelectorParser) parseAnd() (*tagSelector, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and", "&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &tagSelector{Op: "and", Children: []*tagSelector{left, right}}
+	}
+	return left, nil
+}
+
+func (p *tagSelectorParser) parseUnary() (*tagSelector, error) {
+	p.skipSpace()
+	rest := p.input[p.pos:]
+	negated := p.keyword("not")
+	if !negated && strings.HasPrefix(rest, "!") && !strings.HasPrefix(rest, "!=") && !strings.HasPrefix(rest, "!~") {
+		p.pos++
+		negated = true
+	}
+	if negated {
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &tagSelector{Op: "not", Children: []*tagSelector{child}}, nil
+	}
+	if p.pos < len(p.input) && p.input[p.pos] == '(' {
+		p.pos++
+		sel, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+			return nil, fmt.Errorf("expected ')' at position %d", p.pos)
+		}
+		p.pos++
+		return sel, nil
+	}
+	return p.parseTerm()
+}
+
+func (p *tagSelectorParser) word() string {
+	start := p.pos
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		if unicode.IsSpace(rune(c)) || strings.IndexByte("()=!~\"'&|", c) != -1 {
+			break
+		}
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+func (p *tagSelectorParser) parseTerm() (*tagSelector, error) {
+	p.skipSpace()
+	key := p.word()
+	if key == "" {
+		return nil, fmt.Errorf("expected tag key at position %d", p.pos)
+	}
+	p.skipSpace()
+	sel := &tagSelector{Key: key}
+	for _, op := range []string{"=~", "!~", "!=", "="} {
+		if strings.HasPrefix(p.input[p.pos:], op) {
+			sel.Match = op
+			p.pos += len(op)
+			break
+		}
+	}
+	if sel.Match == "" {
+		return nil, fmt.Errorf("expected =, !=, =~ or !~ at position %d", p.pos)
+	}
+
+	p.skipSpace()
+	if p.pos < len(p.input) && (p.input[p.pos] == '"' || p.input[p.pos] == '\'') {
+		q := p.input[p.pos]
+		end := strings.IndexByte(p.input[p.pos+1:], q)
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated string at position %d", p.pos)
+		}
+		sel.Value = p.input[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+	} else {
+		start := p.pos
+		for p.pos < len(p.input) && !unicode.IsSpace(rune(p.input[p.pos])) && strings.IndexByte("()", p.input[p.pos]) == -1 {
+			p.pos++
+		}
+		sel.Value = p.input[start:p.pos]
+	}
+
+	if sel.Match == "=~" || sel.Match == "!~" {
+		if _, err := regexp.Compile(sel.Value); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+// SQL renders the selector as a condition on endpoint_counter.id, the
+// column is given by counterIdColumn
+func (s *tagSelector) SQL(counterIdColumn string) (string, []interface{}) {
+	switch s.Op {
+	case "and", "or":
+		parts := []string{}
+		args := []interface{}{}
+		for _, c := range s.Children {
+			sqlStr, cargs := c.SQL(counterIdColumn)
+			parts = append(parts, sqlStr)
+			args = append(args, cargs...)
+		}
+		return "(" + strings.Join(parts, " "+strings.ToUpper(s.Op)+" ") + ")", args
+	case "not":
+		sqlStr, args := s.Children[0].SQL(counterIdColumn)
+		return "NOT " + sqlStr, args
+	}
+
+	in := "IN"
+	if s.Match == "!=" || s.Match == "!~" {
+		in = "NOT IN"
+	}
+	valueCond := "tag_value = ?"
+	value := s.Value
+	if s.Match == "=~" || s.Match == "!~" {
+		valueCond = "tag_value REGEXP ?"
+		value = "^(" + s.Value + ")$"
+	}
+	sqlStr := fmt.Sprintf("%s %s (SELECT counter_id FROM counter_tag WHERE tag_key = ? AND %s)",
+		counterIdColumn, in, valueCond)
+	return sqlStr, []interface{}{s.Key, value}
+}
+
+func (s *tagSelector) String() string {
+	switch s.Op {
+	case "and", "or":
+		parts := []string{}
+		for _, c := range s.Children {
+			parts = append(parts, c.String())
+		}
+		return "(" + strings.Join(parts, " "+s.Op+" ") + ")"
+	case "not":
+		return "not " + s.Children[0].String()
+	}
+	return s.Key + s.Match + strconv.Quote(s.Value)
+}
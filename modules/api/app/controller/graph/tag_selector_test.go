@@ -0,0 +1,66 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"testing"
+)
+
+func TestParseTagSelector(t *testing.T) {
+	cases := []struct {
+		input  string
+		expect string
+	}{
+		{`service=foo`, `service="foo"`},
+		{`service = "foo bar"`, `service="foo bar"`},
+		{`a=1 and b!=2`, `(a="1" and b!="2")`},
+		{`a=1 && b=2 || c=~"x.*"`, `((a="1" and b="2") or c=~"x.*")`},
+		{`a=1 and (b=2 or c=3)`, `(a="1" and (b="2" or c="3"))`},
+		{`not env=test and !idc!~bj`, `(not env="test" and not idc!~"bj")`},
+		{`notify=1 or order=2`, `(notify="1" or order="2")`},
+	}
+	for _, c := range cases {
+		sel, err := parseTagSelector(c.input)
+		if err != nil {
+			t.Errorf("parse %s: %v", c.input, err)
+			continue
+		}
+		if sel.String() != c.expect {
+			t.Errorf("parse %s: expect %s, got %s", c.input, c.expect, sel.String())
+		}
+	}
+
+	for _, bad := range []string{``, `a`, `a=1 and`, `(a=1`, `a="1`, `a=~"("`, `a=1 b=2`} {
+		if _, err := parseTagSelector(bad); err == nil {
+			t.Errorf("expect error for %q", bad)
+		}
+	}
+}
+
+func TestTagSelectorSQL(t *testing.T) {
+	sel, err := parseTagSelector(`a=1 and not b=~"x|y"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlStr, args := sel.SQL("b.id")
+	expect := "(b.id IN (SELECT counter_id FROM counter_tag WHERE tag_key = ? AND tag_value = ?) AND " +
+		"NOT b.id IN (SELECT counter_id FROM counter_tag WHERE tag_key = ? AND tag_value REGEXP ?))"
+	if sqlStr != expect {
+		t.Errorf("unexpected sql %s", sqlStr)
+	}
+	if len(args) != 4 || args[0] != "a" || args[1] != "1" || args[2] != "b" || args[3] != "^(x|y)$" {
+		t.Errorf("unexpected args %v", args)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package host
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/common/utils"
+	h "github.com/open-falcon/falcon-plus/modules/api/app/helper"
+	f "github.com/open-falcon/falcon-plus/modules/api/app/model/falcon_portal"
+)
+
+var agentVersionRe = regexp.MustCompile(`^[\w.-]{1,16}$`)
+
+type APISetAgentUpgradeInput struct {
+	GrpId   int64  `json:"hostgroup_id" binding:"required"`
+	Version string `json:"version" binding:"required"`
+	// 灰度发布时升级组内机器的百分比, 默认100. 调大比例时已经升级的机器不受影响
+	Percent int `json:"percent"`
+}
+
+// 检查用户是否可以修改机器组的升级计划
+func checkHostGroupOwner(c *gin.Context, grpID int64) (f.HostGroup, bool) {
+	hostgroup := f.HostGroup{}
+	if dt := db.Falcon.Where("id = ?", grpID).Find(&hostgroup); dt.Error != nil {
+		h.JSONR(c, expecstatus, dt.Error)
+		return hostgroup, false
+	}
+	user, _ := h.GetUser(c)
+	if !user.IsAdmin() && hostgroup.CreateUser != user.Name {
+		h.JSONR(c, badstatus, "You don't have permission!")
+		return hostgroup, false
+	}
+	return hostgroup, true
+}
+
+// 指定机器组的agent版本, hbs据此通知agent升级
+func SetAgentUpgrade(c *gin.Context) {
+	var inputs APISetAgentUpgradeInput
+	if err := c.Bind(&inputs); err != nil {
+		h.JSONR(c, badstatus, err)
+		return
+	}
+	if !agentVersionRe.MatchString(inputs.Version) {
+		h.JSONR(c, badstatus, "bad version")
+		return
+	}
+	if inputs.Percent == 0 {
+		inputs.Percent = 100
+	}
+	if inputs.Percent < 0 || inputs.Percent > 100 {
+		h.JSONR(c, badstatus, "percent should be between 1 and 100")
+		return
+	}
+	if _, ok := checkHostGroupOwner(c, inputs.GrpId); !ok {
+		return
+	}
+
+	user, _ := h.GetUser(c)
+	// 重新创建记录以更新id, hbs以id最大的记录为准
+	tx := db.Falcon.Begin()
+	if dt := tx.Where("grp_id = ?", inputs.GrpId).Delete(&f.AgentUpgrade{}); dt.Error != nil {
+		tx.Rollback()
+		h.JSONR(c, expecstatus, dt.Error)
+		return
+	}
+	upgrade := f.AgentUpgrade{GrpId: inputs.GrpId, Version: inputs.Version, Percent: inputs.Percent, CreateUser: user.Name}
+	if dt := tx.Omit("create_at").Create(&upgrade); dt.Error != nil {
+		tx.Rollback()
+		h.JSONR(c, expecstatus, dt.Error)
+		return
+	}
+	tx.Commit()
+	h.JSONR(c, upgrade)
+}
+
+func DeleteAgentUpgrade(c *gin.Context) {
+	grpID, err := strconv.ParseInt(c.Params.ByName("host_group"), 10, 64)
+	if err != nil {
+		h.JSONR(c, badstatus, err)
+		return
+	}
+	if _, ok := checkHostGroupOwner(c, grpID); !ok {
+		return
+	}
+	if dt := db.Falcon.Where("grp_id = ?", grpID).Delete(&f.AgentUpgrade{}); dt.Error != nil {
+		h.JSONR(c, expecstatus, dt.Error)
+		return
+	}
+	h.JSONR(c, fmt.Sprintf("agent upgrade of hostgroup:%v has been deleted", grpID))
+}
+
+type AgentUpgradeHostProgress struct {
+	Hostname     string `json:"hostname"`
+	AgentVersion string `json:"agent_version"`
+	Status       string `json:"status"` // upgraded, pending, waiting(不在本次灰度范围内), rollback or failed
+	Message      string `json:"message"`
+}
+
+// 机器组的升级进度
+func GetAgentUpgrade(c *gin.Context) {
+	grpID, err := strconv.ParseInt(c.Params.ByName("host_group"), 10, 64)
+	if err != nil {
+		h.JSONR(c, badstatus, err)
+		return
+	}
+	upgrade := f.AgentUpgrade{}
+	if dt := db.Falcon.Where("grp_id = ?", grpID).Find(&upgrade); dt.Error != nil {
+		h.JSONR(c, expecstatus, fmt.Sprintf("no agent upgrade of hostgroup:%v", grpID))
+		return
+	}
+
+	hosts := []f.Host{}
+	if dt := db.Falcon.Table("host").Select("host.*").
+		Joins("join grp_host on grp_host.host_id = host.id").
+		Where("grp_host.grp_id = ?", grpID).Scan(&hosts); dt.Error != nil {
+		h.JSONR(c, expecstatus, dt.Error)
+		return
+	}
+	hostnames := make([]string, len(hosts))
+	for i, host := range hosts {
+		hostnames[i] = host.Hostname
+	}
+	reports := map[string]f.AgentUpgradeHost{}
+	if len(hostnames) > 0 {
+		rows := []f.AgentUpgradeHost{}
+		db.Falcon.Where("hostname in (?) and to_version = ?", hostnames, upgrade.Version).Find(&rows)
+		for _, r := range rows {
+			reports[r.Hostname] = r
+		}
+	}
+
+	counts := map[string]int{"upgraded": 0, "pending": 0, "waiting": 0, model.AGENT_UPGRADE_ROLLBACK: 0, model.AGENT_UPGRADE_FAILED: 0}
+	progress := make([]AgentUpgradeHostProgress, 0, len(hosts))
+	for _, host := range hosts {
+		p := AgentUpgradeHostProgress{Hostname: host.Hostname, AgentVersion: host.AgentVersion, Status: "pending"}
+		// agent上报的版本为 version@commit
+		if strings.SplitN(host.AgentVersion, "@", 2)[0] == upgrade.Version {
+			p.Status = "upgraded"
+		} else if r, ok := reports[host.Hostname]; ok && r.Status != model.AGENT_UPGRADE_SUCCESS {
+			p.Status = r.Status
+			p.Message = r.Message
+		} else if !utils.InRollout(upgrade.Version, host.Hostname, upgrade.Percent) {
+			p.Status = "waiting"
+		}
+		counts[p.Status]++
+		progress = append(progress, p)
+	}
+
+	h.JSONR(c, map[string]interface{}{
+		"upgrade": upgrade,
+		"total":   len(hosts),
+		"counts":  counts,
+		"hosts":   progress,
+	})
+}
@@ -46,6 +46,11 @@ func Routes(r *gin.Engine) {
 	hostr.POST("/plugin", CreatePlugin)
 	hostr.DELETE("/plugin/:id", DeletePlugin)
 
+	//agent upgrade
+	hostr.POST("/agent/upgrade", SetAgentUpgrade)
+	hostr.GET("/agent/upgrade/:host_group", GetAgentUpgrade)
+	hostr.DELETE("/agent/upgrade/:host_group", DeleteAgentUpgrade)
+
 	//aggregator
 	hostr.GET("/hostgroup/:host_group/aggregators", GetAggregatorListOfGrp)
 	hostr.GET("/aggregator/:id", GetAggregator)
@@ -61,6 +66,8 @@ func Routes(r *gin.Engine) {
 	//host
 	hostr.GET("/host/:host_id/template", GetTplsRelatedHost)
 	hostr.GET("/host/:host_id/hostgroup", GetGrpsRelatedHost)
+	hostr.GET("/host/:host_id/services", GetHostServices)
+	hostr.POST("/host/:host_id/services/strategy", CreateServiceStrategies)
 
 	//maintain
 	hostr.POST("/host/maintain", SetMaintain)
@@ -0,0 +1,144 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package host
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	h "github.com/open-falcon/falcon-plus/modules/api/app/helper"
+	f "github.com/open-falcon/falcon-plus/modules/api/app/model/falcon_portal"
+)
+
+func findHostByParam(c *gin.Context) (f.Host, bool) {
+	host := f.Host{}
+	hostID, err := strconv.Atoi(c.Params.ByName("host_id"))
+	if err != nil {
+		h.JSONR(c, badstatus, err)
+		return host, false
+	}
+	if dt := db.Falcon.Where("id = ?", hostID).Find(&host); dt.Error != nil {
+		h.JSONR(c, expecstatus, dt.Error)
+		return host, false
+	}
+	return host, true
+}
+
+// agent发现的监听服务
+func GetHostServices(c *gin.Context) {
+	host, ok := findHostByParam(c)
+	if !ok {
+		return
+	}
+	services := []f.HostService{}
+	if dt := db.Falcon.Where("hostname = ?", host.Hostname).Order("port, name").Find(&services); dt.Error != nil {
+		h.JSONR(c, expecstatus, dt.Error)
+		return
+	}
+	h.JSONR(c, services)
+}
+
+type APICreateServiceStrategyInput struct {
+	TplId    int64 `json:"tpl_id" binding:"required"`
+	Ports    []int `json:"ports"` // 为空表示机器上发现的所有端口
+	MaxStep  int   `json:"max_step" binding:"required"`
+	Priority int   `json:"priority" binding:"exists"`
+}
+
+// 根据机器的服务清单在模板中创建net.port.listen和proc.num策略, 模板中已有的相同策略会跳过
+func CreateServiceStrategies(c *gin.Context) {
+	var inputs APICreateServiceStrategyInput
+	if err := c.Bind(&inputs); err != nil {
+		h.JSONR(c, badstatus, err)
+		return
+	}
+	host, ok := findHostByParam(c)
+	if !ok {
+		return
+	}
+
+	user, _ := h.GetUser(c)
+	var tpl f.Template
+	if dt := db.Falcon.Find(&tpl, inputs.TplId); dt.Error != nil {
+		h.JSONR(c, expecstatus, dt.Error)
+		return
+	}
+	if tpl.CreateUser != user.Name && !user.IsAdmin() {
+		h.JSONR(c, badstatus, "You don't have permission!")
+		return
+	}
+
+	services := []f.HostService{}
+	dt := db.Falcon.Where("hostname = ?", host.Hostname)
+	if len(inputs.Ports) > 0 {
+		dt = dt.Where("port in (?)", inputs.Ports)
+	}
+	if dt = dt.Order("port, name").Find(&services); dt.Error != nil {
+		h.JSONR(c, expecstatus, dt.Error)
+		return
+	}
+	if len(services) == 0 {
+		h.JSONR(c, badstatus, fmt.Sprintf("no service discovered on %s", host.Hostname))
+		return
+	}
+
+	existing := []f.Strategy{}
+	if dt := db.Falcon.Where("tpl_id = ? and metric in (?)", tpl.ID, []string{"net.port.listen", "proc.num"}).Find(&existing); dt.Error != nil {
+		h.JSONR(c, expecstatus, dt.Error)
+		return
+	}
+	seen := map[string]bool{}
+	for _, s := range existing {
+		seen[s.Metric+"/"+s.Tags] = true
+	}
+
+	strategies := []f.Strategy{}
+	add := func(metric, tags, op, rightValue, note string) {
+		if seen[metric+"/"+tags] {
+			return
+		}
+		seen[metric+"/"+tags] = true
+		strategies = append(strategies, f.Strategy{
+			Metric:     metric,
+			Tags:       tags,
+			MaxStep:    inputs.MaxStep,
+			Priority:   inputs.Priority,
+			Func:       "all(#3)",
+			Op:         op,
+			RightValue: rightValue,
+			Note:       note,
+			TplId:      tpl.ID,
+		})
+	}
+	for _, s := range services {
+		add("net.port.listen", fmt.Sprintf("port=%d", s.Port), "==", "0", fmt.Sprintf("port %d is not listening", s.Port))
+		// 没有权限读取进程信息时进程名为空, 只能监控端口
+		if s.Name != "" {
+			add("proc.num", "name="+s.Name, "<", "1", fmt.Sprintf("process %s is not running", s.Name))
+		}
+	}
+
+	tx := db.Falcon.Begin()
+	for i := range strategies {
+		if dt := tx.Save(&strategies[i]); dt.Error != nil {
+			tx.Rollback()
+			h.JSONR(c, expecstatus, dt.Error)
+			return
+		}
+	}
+	tx.Commit()
+	h.JSONR(c, strategies)
+}
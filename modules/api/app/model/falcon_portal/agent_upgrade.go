@@ -0,0 +1,67 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package falcon_portal
+
+import (
+	"time"
+)
+
+// +-------------+------------------+------+-----+-------------------+----------------+
+// | Field       | Type             | Null | Key | Default           | Extra          |
+// +-------------+------------------+------+-----+-------------------+----------------+
+// | id          | int(10) unsigned | NO   | PRI | NULL              | auto_increment |
+// | grp_id      | int(10) unsigned | NO   | UNI | NULL              |                |
+// | version     | varchar(16)      | NO   |     | NULL              |                |
+// | percent     | tinyint unsigned | NO   |     | 100               |                |
+// | create_user | varchar(64)      | NO   |     |                   |                |
+// | create_at   | timestamp        | NO   |     | CURRENT_TIMESTAMP |                |
+// +-------------+------------------+------+-----+-------------------+----------------+
+
+type AgentUpgrade struct {
+	ID         int64     `json:"id" gorm:"column:id"`
+	GrpId      int64     `json:"grp_id" gorm:"column:grp_id"`
+	Version    string    `json:"version" gorm:"column:version"`
+	Percent    int       `json:"percent" gorm:"column:percent"`
+	CreateUser string    `json:"create_user" gorm:"column:create_user"`
+	CreateAt   time.Time `json:"create_at" gorm:"column:create_at"`
+}
+
+func (this AgentUpgrade) TableName() string {
+	return "agent_upgrade"
+}
+
+// +--------------+--------------+------+-----+-------------------+-----------------------------+
+// | Field        | Type         | Null | Key | Default           | Extra                       |
+// +--------------+--------------+------+-----+-------------------+-----------------------------+
+// | hostname     | varchar(255) | NO   | PRI | NULL              |                             |
+// | from_version | varchar(16)  | NO   |     |                   |                             |
+// | to_version   | varchar(16)  | NO   |     |                   |                             |
+// | status       | varchar(16)  | NO   |     |                   |                             |
+// | message      | varchar(255) | NO   |     |                   |                             |
+// | update_at    | timestamp    | NO   |     | CURRENT_TIMESTAMP | on update CURRENT_TIMESTAMP |
+// +--------------+--------------+------+-----+-------------------+-----------------------------+
+
+type AgentUpgradeHost struct {
+	Hostname    string    `json:"hostname" gorm:"column:hostname"`
+	FromVersion string    `json:"from_version" gorm:"column:from_version"`
+	ToVersion   string    `json:"to_version" gorm:"column:to_version"`
+	Status      string    `json:"status" gorm:"column:status"`
+	Message     string    `json:"message" gorm:"column:message"`
+	UpdateAt    time.Time `json:"update_at" gorm:"column:update_at"`
+}
+
+func (this AgentUpgradeHost) TableName() string {
+	return "agent_upgrade_host"
+}
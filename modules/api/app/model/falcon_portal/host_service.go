@@ -0,0 +1,43 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package falcon_portal
+
+import (
+	"time"
+)
+
+// +-----------+----------------------+------+-----+-------------------+-----------------------------+
+// | Field     | Type                 | Null | Key | Default           | Extra                       |
+// +-----------+----------------------+------+-----+-------------------+-----------------------------+
+// | id        | int(10) unsigned     | NO   | PRI | NULL              | auto_increment              |
+// | hostname  | varchar(255)         | NO   | MUL | NULL              |                             |
+// | proto     | varchar(8)           | NO   |     | tcp               |                             |
+// | port      | smallint(5) unsigned | NO   |     | NULL              |                             |
+// | name      | varchar(64)          | NO   |     |                   |                             |
+// | update_at | timestamp            | NO   |     | CURRENT_TIMESTAMP | on update CURRENT_TIMESTAMP |
+// +-----------+----------------------+------+-----+-------------------+-----------------------------+
+
+type HostService struct {
+	ID       int64     `json:"id" gorm:"column:id"`
+	Hostname string    `json:"hostname" gorm:"column:hostname"`
+	Proto    string    `json:"proto" gorm:"column:proto"`
+	Port     int       `json:"port" gorm:"column:port"`
+	Name     string    `json:"name" gorm:"column:name"`
+	UpdateAt time.Time `json:"update_at" gorm:"column:update_at"`
+}
+
+func (this HostService) TableName() string {
+	return "host_service"
+}
@@ -390,10 +390,21 @@ func initConnPools(clusterMap map[string]string) {
 	for _, address := range clusterMap {
 		graphInstances.Add(address)
 	}
-	GraphConnPools = backend.CreateSafeRpcConnPools(
+	tlsConfig := &cutils.TlsConfig{
+		Enabled:    viper.GetBool("graphs.tls.enabled"),
+		Cert:       viper.GetString("graphs.tls.cert"),
+		Key:        viper.GetString("graphs.tls.key"),
+		Ca:         viper.GetString("graphs.tls.ca"),
+		ServerName: viper.GetString("graphs.tls.serverName"),
+	}
+	clientTlsConfig, err := tlsConfig.ClientConfig()
+	if err != nil {
+		log.Fatalf("load graphs tls config fail: %v", err)
+	}
+	GraphConnPools = backend.CreateSafeRpcConnPoolsWithTls(
 		int(viper.GetInt("graphs.max_conns")),
 		int(viper.GetInt("graphs.max_idle")),
-		int(connTimeout), int(callTimeout), graphInstances.ToSlice())
+		int(connTimeout), int(callTimeout), graphInstances.ToSlice(), clientTlsConfig)
 }
 
 func initNodeRings(clusterMap map[string]string) {
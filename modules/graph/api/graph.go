@@ -17,6 +17,7 @@ package api
 import (
 	"fmt"
 	"math"
+	"sort"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -124,6 +125,72 @@ func handleItems(items []*cmodel.GraphItem) {
 	}
 }
 
+// 回灌历史数据: 直接写入rrd文件, 不经过GraphItems缓存
+// rrd不存在时以第一个数据点为起始时间创建; 已存在且有更早的数据时, 与rrd中已有的数据合并后重建rrd
+func (this *Graph) Backfill(req cmodel.GraphBackfillRequest, resp *cmodel.GraphBackfillResp) error {
+	items := req.Items
+	resp.Total = len(items)
+
+	type series struct {
+		item  *cmodel.GraphItem
+		md5   string
+		items []*cmodel.GraphItem
+	}
+	all := make(map[string]*series)
+	for _, item := range items {
+		if item == nil || !g.IsValidString(item.Endpoint) ||
+			!g.IsValidString(cutils.Counter(item.Metric, item.Tags)) || item.Step <= 0 {
+			resp.Skipped++
+			continue
+		}
+		item.Timestamp = item.Timestamp - item.Timestamp%int64(item.Step)
+
+		checksum := item.Checksum()
+		key := g.FormRrdCacheKey(checksum, item.DsType, item.Step)
+		s, ok := all[key]
+		if !ok {
+			s = &series{md5: checksum}
+			all[key] = s
+		}
+		s.items = append(s.items, item)
+	}
+
+	cfg := g.Config()
+	for _, s := range all {
+		sort.SliceStable(s.items, func(i, j int) bool {
+			return s.items[i].Timestamp < s.items[j].Timestamp
+		})
+		// 同一时间戳只保留最后一个
+		uniq := s.items[:0]
+		for _, item := range s.items {
+			if len(uniq) > 0 && uniq[len(uniq)-1].Timestamp == item.Timestamp {
+				uniq[len(uniq)-1] = item
+				resp.Skipped++
+				continue
+			}
+			uniq = append(uniq, item)
+		}
+		last := uniq[len(uniq)-1]
+
+		// 先建索引, rrd文件存在时ReceiveItem会认为索引已经建立
+		index.ReceiveItem(last, s.md5)
+
+		filename := g.RrdFileName(cfg.RRD.Storage, s.md5, last.DsType, last.Step)
+		written, err := rrdtool.Backfill(filename, s.md5, uniq, req.Merge)
+		if err == rrdtool.ErrSnapshotRunning {
+			return err
+		}
+		if err != nil {
+			log.Errorf("backfill %s fail, %v", filename, err)
+		}
+		resp.Written += written
+		resp.Skipped += len(uniq) - written
+	}
+
+	proc.GraphRpcBackfillCnt.IncrBy(int64(resp.Written))
+	return nil
+}
+
 func (this *Graph) Query(param cmodel.GraphQueryParam, resp *cmodel.GraphQueryResponse) error {
 	var (
 		datas      []*cmodel.RRDData
@@ -16,6 +16,7 @@ package api
 
 import (
 	"container/list"
+	"crypto/tls"
 	"log"
 	"net"
 	"net/rpc"
@@ -68,6 +69,11 @@ func Start() {
 		log.Println("rpc.Start ok, listening on", addr)
 	}
 
+	tlsConfig, err := g.Config().Rpc.Tls.ServerConfig()
+	if err != nil {
+		log.Fatalf("rpc.Start error, load tls config failed, %s", err)
+	}
+
 	rpc.Register(new(Graph))
 
 	go func() {
@@ -89,10 +95,14 @@ func Start() {
 
 			conn.SetKeepAlive(true)
 			tempDelay = 0
+			var c net.Conn = conn
+			if tlsConfig != nil {
+				c = tls.Server(conn, tlsConfig)
+			}
 			go func() {
-				e := connects.insert(conn)
+				e := connects.insert(c)
 				defer connects.remove(e)
-				rpc.ServeConn(conn)
+				rpc.ServeConn(c)
 			}()
 		}
 	}()
@@ -29,12 +29,19 @@
 package cron
 
 import (
+	"database/sql"
+	"errors"
 	"log"
+	"os"
+	"path"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	cutils "github.com/open-falcon/falcon-plus/common/utils"
 	"github.com/open-falcon/falcon-plus/modules/graph/g"
 	"github.com/open-falcon/falcon-plus/modules/graph/index"
+	"github.com/open-falcon/falcon-plus/modules/graph/rrdtool"
 	"github.com/open-falcon/falcon-plus/modules/graph/store"
 
 	pfc "github.com/niean/goperfcounter"
@@ -111,3 +118,320 @@ func DeleteInvalidHistory() int {
 
 	return deleteCnt
 }
+
+var expireRunning int32
+
+// 一次过期数据清理的结果
+type ExpireReport struct {
+	DryRun    bool     `json:"dryRun"`
+	Before    int64    `json:"before"` // 最后更新时间早于此时间的数据被认为已过期
+	Files     int      `json:"files"`
+	Counters  int      `json:"counters"`
+	Endpoints int      `json:"endpoints"`
+	Errors    int      `json:"errors"`
+	Samples   []string `json:"samples"` // 部分被清理的counter, 便于dry run时检查
+}
+
+const expireReportMaxSamples = 100
+
+func (r *ExpireReport) addSample(s string) {
+	if len(r.Samples) < expireReportMaxSamples {
+		r.Samples = append(r.Samples, s)
+	}
+}
+
+// 定时清理长期不更新的rrd文件及其索引
+func CleanExpired() {
+	cfg := g.Config().Expire
+	if !cfg.Enabled {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(cfg.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		cfg = g.Config().Expire
+		if !cfg.Enabled {
+			continue
+		}
+		report, err := DeleteExpiredSeries(cfg.Days, cfg.DryRun, cfg.MaxDeletePerSec)
+		if err != nil {
+			log.Println("delete expired series fail:", err)
+			continue
+		}
+		pfc.Gauge("GraphExpiredFileCnt", int64(report.Files))
+		pfc.Gauge("GraphExpiredCounterCnt", int64(report.Counters))
+		pfc.Gauge("GraphExpiredEndpointCnt", int64(report.Endpoints))
+	}
+}
+
+// 删除days天内没有更新过的序列:
+//  1. 本实例上mtime早于过期时间、且内存中没有待写入数据的rrd文件
+//  2. 上述rrd文件对应的endpoint_counter、counter_tag索引; 索引表由所有graph实例共享,
+//     每个实例只删除自己的rrd文件对应的索引
+//  3. 已经没有任何counter且长期不更新的endpoint, 及其tag_endpoint索引
+//
+// 先删除索引再删除文件, 中途失败时下次清理可以继续. 删除索引和文件前都会再次检查文件是否仍然过期,
+// 清理期间恢复上报的序列会保留文件并重建索引. dryRun时只统计不删除;
+// maxDeletePerSec>0时限制每秒删除的序列数, 避免对磁盘和mysql造成压力
+func DeleteExpiredSeries(days int, dryRun bool, maxDeletePerSec int) (*ExpireReport, error) {
+	if days <= 0 {
+		return nil, errors.New("days must be greater than 0")
+	}
+	if !atomic.CompareAndSwapInt32(&expireRunning, 0, 1) {
+		return nil, errors.New("another expire task is running")
+	}
+	defer atomic.StoreInt32(&expireRunning, 0)
+	if !dryRun && rrdtool.IsSnapshotRunning() {
+		return nil, rrdtool.ErrSnapshotRunning
+	}
+
+	begin := time.Now()
+	report := &ExpireReport{
+		DryRun:  dryRun,
+		Before:  begin.Unix() - int64(days)*86400,
+		Samples: []string{},
+	}
+
+	var throttle <-chan time.Time
+	if maxDeletePerSec > 0 && !dryRun {
+		ticker := time.NewTicker(time.Second / time.Duration(maxDeletePerSec))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+	wait := func() {
+		if throttle != nil {
+			<-throttle
+		}
+	}
+
+	// 过期的rrd文件, key = md5_type_step
+	storage := g.Config().RRD.Storage
+	entries, err := rrdtool.ListRrdFiles(0)
+	if err != nil {
+		return nil, err
+	}
+	expired := make(map[string]string)
+	for _, entry := range entries {
+		if entry.ModTime >= report.Before {
+			continue
+		}
+		ckey := strings.TrimSuffix(path.Base(entry.Name), ".rrd")
+		if len(strings.Split(ckey, "_")) != 3 {
+			continue
+		}
+		// 有尚未刷盘的数据
+		if store.GraphItems.ItemCnt(ckey) > 0 {
+			continue
+		}
+		expired[ckey] = entry.Name
+	}
+
+	dbConn, err := g.GetDbConn("ExpireTask")
+	if err != nil {
+		return nil, err
+	}
+
+	// endpoint_counter索引, 按id分批扫描
+	var lastId int64
+	for len(expired) > 0 {
+		rows, err := dbConn.Query(`SELECT ec.id, e.endpoint, ec.counter, ec.type, ec.step
+			FROM endpoint_counter ec JOIN endpoint e ON e.id = ec.endpoint_id
+			WHERE ec.id > ? AND ec.ts < ? ORDER BY ec.id LIMIT 1000`, lastId, report.Before)
+		if err != nil {
+			return nil, err
+		}
+		type counterRow struct {
+			id                     int64
+			endpoint, counter, typ string
+			step                   int
+		}
+		batch := []counterRow{}
+		for rows.Next() {
+			r := counterRow{}
+			if err := rows.Scan(&r.id, &r.endpoint, &r.counter, &r.typ, &r.step); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if len(batch) == 0 {
+			break
+		}
+		lastId = batch[len(batch)-1].id
+
+		for _, r := range batch {
+			ckey := g.FormRrdCacheKey(cutils.Md5(cutils.PK2(r.endpoint, r.counter)), r.typ, r.step)
+			name, ok := expired[ckey]
+			if !ok {
+				continue
+			}
+			if dryRun {
+				report.Counters++
+				report.addSample(r.endpoint + "/" + r.counter)
+				continue
+			}
+			wait()
+			// 过期文件列表是清理开始时的快照, 限速删除可能持续很久, 删除索引前再检查一次
+			if !stillExpired(ckey, path.Join(storage, name), report.Before) {
+				delete(expired, ckey)
+				continue
+			}
+			deleted, err := deleteExpiredCounter(dbConn, r.id, report.Before)
+			if err != nil {
+				log.Println("delete expired counter fail:", err)
+				report.Errors++
+				delete(expired, ckey) // 保留rrd文件, 下次清理时重试
+				continue
+			}
+			if !deleted {
+				// 索引在此期间被更新过
+				delete(expired, ckey)
+				continue
+			}
+			report.Counters++
+			report.addSample(r.endpoint + "/" + r.counter)
+		}
+	}
+
+	// rrd文件及内存缓存
+	for ckey, name := range expired {
+		if dryRun {
+			report.Files++
+			continue
+		}
+		wait()
+		md5 := strings.Split(ckey, "_")[0]
+		removed, err := rrdtool.RemoveExpiredFile(path.Join(storage, name), md5, ckey, report.Before)
+		if err != nil {
+			log.Println("remove rrd file fail:", err)
+			report.Errors++
+			reindex(md5)
+			continue
+		}
+		// 清理期间又收到了新数据, 保留rrd文件并重建索引
+		if !removed || store.GraphItems.ItemCnt(ckey) > 0 {
+			reindex(md5)
+			if !removed {
+				continue
+			}
+		}
+		index.IndexedItemCache.Remove(md5)
+		store.HistoryCache.Remove(md5)
+		report.Files++
+	}
+
+	// 没有counter的endpoint
+	rows, err := dbConn.Query(`SELECT id, endpoint FROM endpoint e
+		WHERE e.ts < ? AND NOT EXISTS (SELECT 1 FROM endpoint_counter ec WHERE ec.endpoint_id = e.id)`,
+		report.Before)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var endpoint string
+		if err := rows.Scan(&id, &endpoint); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		endpoints[id] = endpoint
+	}
+	rows.Close()
+	for id, endpoint := range endpoints {
+		if dryRun {
+			report.Endpoints++
+			report.addSample(endpoint)
+			continue
+		}
+		wait()
+		deleted, err := deleteExpiredEndpoint(dbConn, id, report.Before)
+		if err != nil {
+			log.Println("delete expired endpoint fail:", err)
+			report.Errors++
+			continue
+		}
+		if !deleted {
+			// endpoint在此期间恢复了上报
+			continue
+		}
+		report.Endpoints++
+		report.addSample(endpoint)
+	}
+
+	log.Printf("DeleteExpiredSeries: DryRun=>%v, Files=>%d, Counters=>%d, Endpoints=>%d, Errors=>%d, Time=>%s",
+		dryRun, report.Files, report.Counters, report.Endpoints, report.Errors, time.Since(begin))
+	return report, nil
+}
+
+// 删除索引前再次确认rrd文件仍然过期: 没有待写入的数据, 修改时间早于before
+func stillExpired(ckey, filename string, before int64) bool {
+	if store.GraphItems.ItemCnt(ckey) > 0 {
+		return false
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	return info.ModTime().Unix() < before
+}
+
+// 在同一个事务中删除endpoint_counter及其counter_tag, endpoint_counter在此期间被更新过时不删除
+func deleteExpiredCounter(dbConn *sql.DB, id, before int64) (bool, error) {
+	tx, err := dbConn.Begin()
+	if err != nil {
+		return false, err
+	}
+	res, err := tx.Exec("DELETE FROM endpoint_counter WHERE id = ? AND ts < ?", id, before)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		return false, nil
+	}
+	if _, err := tx.Exec("DELETE FROM counter_tag WHERE counter_id = ?", id); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// 在同一个事务中删除endpoint及其tag_endpoint, endpoint在此期间被更新过或者有了新的counter时不删除
+func deleteExpiredEndpoint(dbConn *sql.DB, id, before int64) (bool, error) {
+	tx, err := dbConn.Begin()
+	if err != nil {
+		return false, err
+	}
+	res, err := tx.Exec(`DELETE FROM endpoint WHERE id = ? AND ts < ?
+		AND NOT EXISTS (SELECT 1 FROM endpoint_counter WHERE endpoint_id = ?)`, id, before, id)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		return false, nil
+	}
+	if _, err := tx.Exec("DELETE FROM tag_endpoint WHERE endpoint_id = ?", id); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// 序列在清理期间恢复上报, 重建已经删除的索引
+func reindex(md5 string) {
+	cached := index.IndexedItemCache.Get(md5)
+	if cached == nil {
+		return
+	}
+	item := cached.(*index.IndexCacheItem).Item
+	if err := index.UpdateIndexOne(item.Endpoint, item.Metric, item.Tags, item.DsType, item.Step); err != nil {
+		log.Println("update index fail:", err)
+	}
+}
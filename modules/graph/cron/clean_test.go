@@ -0,0 +1,156 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cron
+
+import (
+	"container/list"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	cmodel "github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/graph/store"
+)
+
+// 过期文件列表在清理开始时确定, 删除索引前序列恢复了上报
+func TestStillExpiredResumed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graph-expire")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	before := time.Now().Unix() - 86400
+	old := time.Unix(before-3600, 0)
+	filename := filepath.Join(dir, "a_GAUGE_60.rrd")
+	if err := ioutil.WriteFile(filename, []byte("rrd"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(filename, old, old)
+
+	if !stillExpired("a_GAUGE_60", filename, before) {
+		t.Error("expect expired")
+	}
+	if !stillExpired("x_GAUGE_60", filepath.Join(dir, "missing.rrd"), before) {
+		t.Error("expect missing file expired")
+	}
+
+	store.GraphItems.Set("a_GAUGE_60", &store.SafeLinkedList{L: list.New()})
+	store.GraphItems.PushAll("a_GAUGE_60", []*cmodel.GraphItem{{Timestamp: time.Now().Unix()}})
+	if stillExpired("a_GAUGE_60", filename, before) {
+		t.Error("expect not expired with pending items")
+	}
+	store.GraphItems.Remove("a_GAUGE_60")
+
+	os.Chtimes(filename, time.Now(), time.Now())
+	if stillExpired("a_GAUGE_60", filename, before) {
+		t.Error("expect not expired after flushed")
+	}
+}
+
+// 记录执行过的语句的sql driver, affected为每条DELETE语句依次返回的影响行数
+type recordDriver struct {
+	affected []int64
+	stmts    []string
+	commits  int
+	rollback int
+}
+
+func (d *recordDriver) Open(string) (driver.Conn, error) { return &recordConn{d}, nil }
+
+type recordConn struct{ d *recordDriver }
+
+func (c *recordConn) Prepare(query string) (driver.Stmt, error) { return &recordStmt{c.d, query}, nil }
+func (c *recordConn) Close() error                              { return nil }
+func (c *recordConn) Begin() (driver.Tx, error)                 { return &recordTx{c.d}, nil }
+
+type recordTx struct{ d *recordDriver }
+
+func (tx *recordTx) Commit() error   { tx.d.commits++; return nil }
+func (tx *recordTx) Rollback() error { tx.d.rollback++; return nil }
+
+type recordStmt struct {
+	d     *recordDriver
+	query string
+}
+
+func (s *recordStmt) Close() error  { return nil }
+func (s *recordStmt) NumInput() int { return -1 }
+func (s *recordStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.stmts = append(s.d.stmts, strings.Join(strings.Fields(s.query), " "))
+	if len(s.d.affected) == 0 {
+		return nil, errors.New("unexpected statement: " + s.query)
+	}
+	n := s.d.affected[0]
+	s.d.affected = s.d.affected[1:]
+	return driver.RowsAffected(n), nil
+}
+func (s *recordStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+var recordDriverSeq int
+
+func openRecordDB(t *testing.T, affected ...int64) (*sql.DB, *recordDriver) {
+	d := &recordDriver{affected: affected}
+	recordDriverSeq++
+	name := "graph-expire-record-" + strconv.Itoa(recordDriverSeq)
+	sql.Register(name, d)
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, d
+}
+
+// 查询出待删除的endpoint后, endpoint恢复上报或者有了新的counter
+func TestDeleteExpiredEndpoint(t *testing.T) {
+	before := time.Now().Unix() - 86400
+
+	db, d := openRecordDB(t, 1, 3)
+	deleted, err := deleteExpiredEndpoint(db, 7, before)
+	db.Close()
+	if err != nil || !deleted {
+		t.Fatalf("expect deleted, got %v %v", deleted, err)
+	}
+	if len(d.stmts) != 2 || d.commits != 1 || d.rollback != 0 {
+		t.Fatalf("unexpected statements %q, commits %d, rollbacks %d", d.stmts, d.commits, d.rollback)
+	}
+	if !strings.HasPrefix(d.stmts[0], "DELETE FROM endpoint WHERE id = ? AND ts < ?") ||
+		!strings.Contains(d.stmts[0], "NOT EXISTS (SELECT 1 FROM endpoint_counter WHERE endpoint_id = ?)") {
+		t.Errorf("endpoint delete is not conditional: %q", d.stmts[0])
+	}
+	if d.stmts[1] != "DELETE FROM tag_endpoint WHERE endpoint_id = ?" {
+		t.Errorf("unexpected tag_endpoint delete: %q", d.stmts[1])
+	}
+
+	// 条件不满足, endpoint未被删除, tag_endpoint保留
+	db, d = openRecordDB(t, 0)
+	deleted, err = deleteExpiredEndpoint(db, 7, before)
+	db.Close()
+	if err != nil || deleted {
+		t.Fatalf("expect kept, got %v %v", deleted, err)
+	}
+	if len(d.stmts) != 1 || d.commits != 0 || d.rollback != 1 {
+		t.Fatalf("unexpected statements %q, commits %d, rollbacks %d", d.stmts, d.commits, d.rollback)
+	}
+}
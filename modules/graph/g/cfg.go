@@ -21,6 +21,7 @@ import (
 	"sync/atomic"
 	"unsafe"
 
+	"github.com/open-falcon/falcon-plus/common/utils"
 	"github.com/toolkits/file"
 )
 
@@ -35,8 +36,9 @@ type HttpConfig struct {
 }
 
 type RpcConfig struct {
-	Enabled bool   `json:"enabled"`
-	Listen  string `json:"listen"`
+	Enabled bool             `json:"enabled"`
+	Listen  string           `json:"listen"`
+	Tls     *utils.TlsConfig `json:"tls"`
 }
 
 type RRDConfig struct {
@@ -48,6 +50,15 @@ type DBConfig struct {
 	MaxIdle int    `json:"maxIdle"`
 }
 
+// 过期数据清理: 超过Days天没有更新的rrd文件及其索引会被删除
+type ExpireConfig struct {
+	Enabled         bool `json:"enabled"`
+	Days            int  `json:"days"`
+	Interval        int  `json:"interval"`        // 清理间隔, 单位秒
+	DryRun          bool `json:"dryRun"`          // 只打印需要清理的数据, 不做删除
+	MaxDeletePerSec int  `json:"maxDeletePerSec"` // 每秒最多删除的序列数, 0表示不限制
+}
+
 type GlobalConfig struct {
 	Pid            string      `json:"pid"`
 	Debug          bool        `json:"debug"`
@@ -63,7 +74,9 @@ type GlobalConfig struct {
 		Enabled     bool              `json:"enabled"`
 		Replicas    int               `json:"replicas"`
 		Cluster     map[string]string `json:"cluster"`
+		Tls         *utils.TlsConfig  `json:"tls"` // 连接新集群的tls配置
 	} `json:"migrate"`
+	Expire ExpireConfig `json:"expire"`
 }
 
 var (
@@ -101,6 +114,13 @@ func ParseConfig(cfg string) {
 		c.Migrate.Enabled = false
 	}
 
+	if c.Expire.Enabled && c.Expire.Days <= 0 {
+		log.Fatalln("expire.days must be greater than 0")
+	}
+	if c.Expire.Interval <= 0 {
+		c.Expire.Interval = 3600
+	}
+
 	// 确保ioWorkerNum是2^N
 	if c.IOWorkerNum == 0 || (c.IOWorkerNum&(c.IOWorkerNum-1) != 0) {
 		log.Fatalf("IOWorkerNum must be 2^N, current IOWorkerNum is %v", c.IOWorkerNum)
@@ -16,6 +16,7 @@ package http
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -65,3 +66,8 @@ func JSONR(c *gin.Context, arg ...interface{}) (werror error) {
 	}
 	return
 }
+
+// 管理接口只接受本机的请求, 与其他模块的/config/reload一致
+func isLocalRequest(c *gin.Context) bool {
+	return strings.HasPrefix(c.Request.RemoteAddr, "127.0.0.1")
+}
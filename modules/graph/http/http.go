@@ -101,6 +101,7 @@ func Start() {
 	configCommonRoutes()
 	configProcRoutes()
 	configIndexRoutes()
+	configSnapshotRoutes()
 
 	router.GET("/api/v2/counter/migrate", func(c *gin.Context) {
 		counter := rrdtool.GetCounterV2()
@@ -15,8 +15,13 @@
 package http
 
 import (
+	"fmt"
+	"strconv"
+
 	"github.com/gin-gonic/gin"
 	cutils "github.com/open-falcon/falcon-plus/common/utils"
+	"github.com/open-falcon/falcon-plus/modules/graph/cron"
+	"github.com/open-falcon/falcon-plus/modules/graph/g"
 	"github.com/open-falcon/falcon-plus/modules/graph/index"
 	log "github.com/sirupsen/logrus"
 )
@@ -28,6 +33,12 @@ func configIndexRoutes() {
 		JSONR(c, 200, gin.H{"msg": "ok"})
 	})
 
+	// 从endpoint_counter补全counter_tag, 启动时会自动执行一次
+	router.GET("/index/counterTag/backfill", func(c *gin.Context) {
+		go index.BackfillCounterTags()
+		JSONR(c, 200, gin.H{"msg": "ok"})
+	})
+
 	// 获取索引全量更新的并行数
 	router.GET("/index/updateAll/concurrent", func(c *gin.Context) {
 		JSONR(c, 200, gin.H{"msg": "ok", "value": index.GetConcurrentOfUpdateIndexAll()})
@@ -66,4 +77,57 @@ func configIndexRoutes() {
 
 		JSONR(c, 200, gin.H{"msg": "ok"})
 	})
+
+	// 过期数据清理的dry run结果, days默认为配置的值
+	router.GET("/api/v2/expire", func(c *gin.Context) {
+		days := g.Config().Expire.Days
+		if v := c.Query("days"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				JSONR(c, 400, err)
+				return
+			}
+			days = n
+		}
+
+		report, err := cron.DeleteExpiredSeries(days, true, 0)
+		if err != nil {
+			JSONR(c, 500, err)
+			return
+		}
+		JSONR(c, 200, report)
+	})
+
+	// 按配置的限速执行过期数据清理, 同步操作. 只接受本机的请求, days不能小于配置的过期时间
+	router.POST("/api/v2/expire", func(c *gin.Context) {
+		if !isLocalRequest(c) {
+			JSONR(c, 403, "no privilege")
+			return
+		}
+		cfg := g.Config().Expire
+		if cfg.Days <= 0 {
+			JSONR(c, 400, "expire.days is not configured")
+			return
+		}
+		days := cfg.Days
+		if v := c.PostForm("days"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				JSONR(c, 400, err)
+				return
+			}
+			if n < cfg.Days {
+				JSONR(c, 400, fmt.Sprintf("days must not be less than expire.days %d", cfg.Days))
+				return
+			}
+			days = n
+		}
+
+		report, err := cron.DeleteExpiredSeries(days, false, cfg.MaxDeletePerSec)
+		if err != nil {
+			JSONR(c, 500, err)
+			return
+		}
+		JSONR(c, 200, report)
+	})
 }
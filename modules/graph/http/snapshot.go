@@ -0,0 +1,71 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/open-falcon/falcon-plus/modules/graph/rrdtool"
+	log "github.com/sirupsen/logrus"
+)
+
+func configSnapshotRoutes() {
+	// 以tar流的形式导出rrd文件, since=unix时间戳时只导出此后修改过的文件. 快照包含全部监控数据, 只允许本机访问
+	router.GET("/api/v2/snapshot", func(c *gin.Context) {
+		if !isLocalRequest(c) {
+			JSONR(c, 403, "no privilege")
+			return
+		}
+		since, err := strconv.ParseInt(c.DefaultQuery("since", "0"), 10, 64)
+		if err != nil {
+			JSONR(c, 400, err)
+			return
+		}
+
+		filename := fmt.Sprintf("graph-snapshot-%s.tar", time.Now().Format("20060102150405"))
+		c.Header("Content-Type", "application/x-tar")
+		c.Header("Content-Disposition", "attachment; filename="+filename)
+		if err := rrdtool.Snapshot(c.Writer, since); err != nil {
+			// 已经开始输出时无法再返回错误码, tar流不完整且缺少MANIFEST, 恢复时会失败
+			log.Error("snapshot fail:", err)
+			if !c.Writer.Written() {
+				JSONR(c, 500, err)
+			}
+		}
+	})
+
+	// rrd文件列表及校验和, 用于增量备份时与已有备份对比
+	router.GET("/api/v2/snapshot/manifest", func(c *gin.Context) {
+		if !isLocalRequest(c) {
+			JSONR(c, 403, "no privilege")
+			return
+		}
+		since, err := strconv.ParseInt(c.DefaultQuery("since", "0"), 10, 64)
+		if err != nil {
+			JSONR(c, 400, err)
+			return
+		}
+
+		entries, err := rrdtool.Manifest(since)
+		if err != nil {
+			JSONR(c, 500, err)
+			return
+		}
+		JSONR(c, 200, entries)
+	})
+}
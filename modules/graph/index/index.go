@@ -27,6 +27,7 @@ import (
 func Start() {
 	InitCache()
 	go StartIndexUpdateIncrTask()
+	go BackfillCounterTags()
 	log.Debug("index.Start ok")
 }
 
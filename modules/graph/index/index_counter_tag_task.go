@@ -0,0 +1,123 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	cutils "github.com/open-falcon/falcon-plus/common/utils"
+	"github.com/open-falcon/falcon-plus/modules/graph/g"
+	proc "github.com/open-falcon/falcon-plus/modules/graph/proc"
+	nsema "github.com/toolkits/concurrent/semaphore"
+)
+
+const (
+	counterTagBackfillBatch = 1000
+)
+
+var semaCounterTagBackfill = nsema.NewSemaphore(1)
+
+// counter_tag只在增量更新索引时写入, 升级前已建立索引的counter没有记录,
+// 按metric和tag查询时会查不到. 从endpoint_counter补全缺少的记录, 可以重复执行
+func BackfillCounterTags() {
+	if !semaCounterTagBackfill.TryAcquire() {
+		log.Println("backfill counter_tag, concurrent not available")
+		return
+	}
+	defer semaCounterTagBackfill.Release()
+
+	dbConn, err := g.GetDbConn("CounterTagBackfillTask")
+	if err != nil {
+		log.Println("[ERROR] make dbConn fail", err)
+		return
+	}
+
+	startTs := time.Now().Unix()
+	cnt, err := backfillCounterTags(dbConn)
+	if err != nil {
+		log.Errorf("backfill counter_tag fail after %d counters: %v", cnt, err)
+	} else {
+		log.Printf("backfill counter_tag, %d counters, %d seconds", cnt, time.Now().Unix()-startTs)
+	}
+	proc.IndexCounterTagBackfillCnt.SetCnt(int64(cnt))
+}
+
+func backfillCounterTags(conn *sql.DB) (int, error) {
+	cnt := 0
+	var lastId int64 = 0
+	for {
+		rows, err := conn.Query(`SELECT b.id, b.endpoint_id, b.counter, b.ts FROM endpoint_counter AS b
+			LEFT JOIN counter_tag AS t ON t.counter_id = b.id
+			WHERE b.id > ? AND t.id IS NULL ORDER BY b.id LIMIT ?`, lastId, counterTagBackfillBatch)
+		if err != nil {
+			return cnt, err
+		}
+
+		type counterRow struct {
+			id, endpointId int64
+			counter        string
+			ts             sql.NullInt64
+		}
+		batch := []counterRow{}
+		for rows.Next() {
+			var r counterRow
+			if err := rows.Scan(&r.id, &r.endpointId, &r.counter, &r.ts); err != nil {
+				rows.Close()
+				return cnt, err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if len(batch) == 0 {
+			return cnt, nil
+		}
+
+		for _, r := range batch {
+			lastId = r.id
+			metric, tags := splitCounter(r.counter)
+			if len(tags) == 0 {
+				tags = map[string]string{"": ""}
+			}
+			for tagKey, tagVal := range tags {
+				_, err := conn.Exec(`INSERT INTO counter_tag(counter_id, endpoint_id, metric, tag_key, tag_value, ts, t_create)
+					VALUES (?, ?, ?, ?, ?, ?, NOW())
+					ON DUPLICATE KEY UPDATE t_modify=NOW()`,
+					r.id, r.endpointId, metric, tagKey, tagVal, r.ts)
+				if err != nil {
+					return cnt, err
+				}
+			}
+			cnt++
+		}
+	}
+}
+
+// endpoint_counter中的counter为 metric/sorted_tags
+func splitCounter(counter string) (string, map[string]string) {
+	idx := strings.Index(counter, "/")
+	if idx < 0 {
+		return counter, nil
+	}
+	err, tags := cutils.SplitTagsString(counter[idx+1:])
+	if err != nil {
+		// tag不合法时只按metric索引
+		return counter[:idx], nil
+	}
+	return counter[:idx], tags
+}
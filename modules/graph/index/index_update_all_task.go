@@ -213,5 +213,33 @@ func updateIndexFromOneItem(item *cmodel.GraphItem, conn *sql.DB) error {
 	}
 	proc.IndexUpdateIncrDbEndpointCounterInsertCnt.Incr()
 
+	var counterId int64 = -1
+	err = conn.QueryRow("SELECT id FROM endpoint_counter WHERE endpoint_id = ? AND counter = ?",
+		endpointId, counter).Scan(&counterId)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	proc.IndexUpdateIncrDbEndpointCounterSelectCnt.Incr()
+
+	// counter_tag表, tag_key -> tag_value -> counter 的倒排索引
+	// 没有tag的counter用空的tag_key索引, 保证按metric查询时能覆盖到
+	tags := item.Tags
+	if len(tags) == 0 {
+		tags = map[string]string{"": ""}
+	}
+	for tagKey, tagVal := range tags {
+		sqlStr := `INSERT INTO counter_tag(counter_id, endpoint_id, metric, tag_key, tag_value, ts, t_create)
+			VALUES (?, ?, ?, ?, ?, ?, NOW())
+			ON DUPLICATE KEY UPDATE tag_value=?, ts=?, t_modify=NOW()`
+
+		_, err := conn.Exec(sqlStr, counterId, endpointId, item.Metric, tagKey, tagVal, ts, tagVal, ts)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		proc.IndexUpdateIncrDbCounterTagInsertCnt.Incr()
+	}
+
 	return nil
 }
@@ -72,6 +72,7 @@ func main() {
 	cfg := flag.String("c", "cfg.json", "specify config file")
 	version := flag.Bool("v", false, "show version")
 	versionGit := flag.Bool("vg", false, "show version and git commit log")
+	restore := flag.String("restore", "", "restore rrd files from a snapshot tar file (- for stdin) and exit")
 	flag.Parse()
 
 	if *version {
@@ -86,6 +87,11 @@ func main() {
 	// global config
 	g.ParseConfig(*cfg)
 
+	if *restore != "" {
+		restoreSnapshot(*restore)
+		os.Exit(0)
+	}
+
 	if g.Config().Debug {
 		g.InitLog("debug")
 	} else {
@@ -105,6 +111,27 @@ func main() {
 	// start http server
 	go http.Start()
 	go cron.CleanCache()
+	go cron.CleanExpired()
 
 	start_signal(os.Getpid(), g.Config())
 }
+
+// 从快照恢复rrd文件, 需要先停止graph
+func restoreSnapshot(name string) {
+	r := os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			log.Fatalln("open snapshot fail:", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	storage := g.Config().RRD.Storage
+	n, err := rrdtool.Restore(r, storage)
+	if err != nil {
+		log.Fatalln("restore fail:", err)
+	}
+	log.Printf("restore ok, %d files restored to %s\n", n, storage)
+}
@@ -33,6 +33,9 @@ var (
 	IndexUpdateIncrDbEndpointCounterSelectCnt = nproc.NewSCounterQps("IndexUpdateIncrDbEndpointCounterSelectCnt")
 	IndexUpdateIncrDbEndpointCounterInsertCnt = nproc.NewSCounterQps("IndexUpdateIncrDbEndpointCounterInsertCnt")
 	IndexUpdateIncrDbEndpointCounterUpdateCnt = nproc.NewSCounterQps("IndexUpdateIncrDbEndpointCounterUpdateCnt")
+
+	IndexUpdateIncrDbCounterTagInsertCnt = nproc.NewSCounterQps("IndexUpdateIncrDbCounterTagInsertCnt")
+	IndexCounterTagBackfillCnt           = nproc.NewSCounterBase("IndexCounterTagBackfillCnt")
 )
 
 // 索引全量更新
@@ -52,7 +55,8 @@ var (
 
 // Rpc
 var (
-	GraphRpcRecvCnt = nproc.NewSCounterQps("GraphRpcRecvCnt")
+	GraphRpcRecvCnt     = nproc.NewSCounterQps("GraphRpcRecvCnt")
+	GraphRpcBackfillCnt = nproc.NewSCounterQps("GraphRpcBackfillCnt")
 )
 
 // Query
@@ -70,6 +74,7 @@ func GetAll() []interface{} {
 
 	// rpc recv
 	ret = append(ret, GraphRpcRecvCnt.Get())
+	ret = append(ret, GraphRpcBackfillCnt.Get())
 
 	// query
 	ret = append(ret, GraphQueryCnt.Get())
@@ -99,6 +104,9 @@ func GetAll() []interface{} {
 	ret = append(ret, IndexUpdateIncrDbEndpointCounterInsertCnt.Get())
 	ret = append(ret, IndexUpdateIncrDbEndpointCounterUpdateCnt.Get())
 
+	ret = append(ret, IndexUpdateIncrDbCounterTagInsertCnt.Get())
+	ret = append(ret, IndexCounterTagBackfillCnt.Get())
+
 	// index db cache
 	ret = append(ret, IndexedItemCacheCnt.Get())
 	ret = append(ret, UnIndexedItemCacheCnt.Get())
@@ -15,6 +15,7 @@
 package rrdtool
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log"
@@ -114,7 +115,18 @@ func GetCounter() (ret string) {
 		atomic.LoadUint64(&stat_cnt[CONN_S_DIAL]))
 }
 
+// 连接新集群使用的tls配置, 在migrate_start中加载
+var migrateTlsConfig *tls.Config
+
 func dial(address string, timeout time.Duration) (*rpc.Client, error) {
+	if migrateTlsConfig != nil {
+		conn, err := cutils.DialTimeout("tcp", address, timeout, migrateTlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		return rpc.NewClient(conn), nil
+	}
+
 	d := net.Dialer{Timeout: timeout}
 	conn, err := d.Dial("tcp", address)
 	if err != nil {
@@ -133,6 +145,9 @@ func migrate_start(cfg *g.GlobalConfig) {
 	var err error
 	var i int
 	if cfg.Migrate.Enabled {
+		if migrateTlsConfig, err = cfg.Migrate.Tls.ClientConfig(); err != nil {
+			log.Fatalf("load migrate tls config fail: %s", err)
+		}
 		Consistent.NumberOfReplicas = cfg.Migrate.Replicas
 
 		nodes := cutils.KeysOfMap(cfg.Migrate.Cluster)
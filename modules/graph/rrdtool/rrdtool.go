@@ -18,6 +18,8 @@ import (
 	"errors"
 	"log"
 	"math"
+	"os"
+	"sort"
 	"sync/atomic"
 	"time"
 
@@ -78,9 +80,22 @@ const (
 	RRA720PointCnt = 730 // 12h一个点存1year
 )
 
+// 回灌时每次rrd update写入的数据点数
+const BackfillBatchSize = 1000
+
+type backfill_t struct {
+	filename string
+	items    []*cmodel.GraphItem
+	merge    bool
+	written  int
+}
+
 func create(filename string, item *cmodel.GraphItem) error {
 	now := time.Now()
-	start := now.Add(time.Duration(-24) * time.Hour)
+	return createWithStart(filename, item, now.Add(time.Duration(-24)*time.Hour))
+}
+
+func createWithStart(filename string, item *cmodel.GraphItem, start time.Time) error {
 	step := uint(item.Step)
 
 	c := rrdlite.NewCreator(filename, start, step)
@@ -156,6 +171,151 @@ func flushrrd(filename string, items []*cmodel.GraphItem) error {
 	return update(filename, items)
 }
 
+// backfill rrd with historical items, sorted by timestamp.
+// a missing rrd is created to start right before the first item. items older
+// than the last update of an existing rrd can not be written in place, with
+// merge a gauge rrd is then rebuilt from the items merged with its current
+// data, otherwise they are skipped
+func backfillrrd(filename string, items []*cmodel.GraphItem, merge bool) (int, error) {
+	if len(items) == 0 {
+		return 0, errors.New("empty items")
+	}
+
+	if !g.IsRrdFileExist(filename) {
+		baseDir := file.Dir(filename)
+
+		err := file.InsureDir(baseDir)
+		if err != nil {
+			return 0, err
+		}
+
+		start := time.Unix(items[0].Timestamp-int64(items[0].Step), 0)
+		err = createWithStart(filename, items[0], start)
+		if err != nil {
+			return 0, err
+		}
+		return updateInBatches(filename, items)
+	}
+
+	info, err := rrdlite.Info(filename)
+	if err != nil {
+		return 0, err
+	}
+	lastUpdate, ok := info["last_update"].(uint)
+	if !ok || items[0].Timestamp > int64(lastUpdate) {
+		return updateInBatches(filename, items)
+	}
+	// counter类型的rrd中保存的是速率, 无法还原为原始值重建, 只写入比最后更新时间新的数据.
+	// 重建会丢失已有数据中MIN、MAX归档的极值, 需要调用方明确要求
+	if items[0].DsType != g.GAUGE || !merge {
+		idx := 0
+		for idx < len(items) && items[idx].Timestamp <= int64(lastUpdate) {
+			idx++
+		}
+		return updateInBatches(filename, items[idx:])
+	}
+	return mergerrd(filename, items, int64(lastUpdate))
+}
+
+// mergerrd rebuilds a gauge rrd from the backfilled items and the data it
+// already has. the existing data wins for the timestamps both of them have.
+// rows older than the finest archive only survive consolidated, they are
+// written back as one point per step so that averages stay the same, their
+// MIN and MAX rows become the averages as well
+func mergerrd(filename string, items []*cmodel.GraphItem, lastUpdate int64) (int, error) {
+	tpl := items[0]
+	step := int64(tpl.Step)
+
+	existing := make(map[int64]float64)
+	resolutions := []struct{ steps, rows int64 }{
+		{1, RRA1PointCnt}, {5, RRA5PointCnt}, {20, RRA20PointCnt}, {180, RRA180PointCnt}, {720, RRA720PointCnt},
+	}
+	for _, r := range resolutions {
+		rowStep := step * r.steps
+		end := lastUpdate - lastUpdate%rowStep
+		rows, err := fetch(filename, "AVERAGE", end-rowStep*r.rows, end, int(rowStep))
+		if err != nil {
+			return 0, err
+		}
+		for _, row := range rows {
+			v := float64(row.Value)
+			if math.IsNaN(v) || row.Timestamp > lastUpdate {
+				continue
+			}
+			for ts := row.Timestamp - rowStep + step; ts <= row.Timestamp; ts += step {
+				// 优先使用精度更高的归档
+				if _, ok := existing[ts]; !ok {
+					existing[ts] = v
+				}
+			}
+		}
+	}
+
+	merged := make([]*cmodel.GraphItem, 0, len(items)+len(existing))
+	written := 0
+	for _, item := range items {
+		if _, ok := existing[item.Timestamp]; !ok {
+			merged = append(merged, item)
+			written++
+		}
+	}
+	for ts, v := range existing {
+		merged = append(merged, &cmodel.GraphItem{Timestamp: ts, Value: v, DsType: tpl.DsType})
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Timestamp < merged[j].Timestamp })
+
+	tmp := filename + ".backfill"
+	start := time.Unix(merged[0].Timestamp-step, 0)
+	if err := createWithStart(tmp, tpl, start); err != nil {
+		return 0, err
+	}
+	if _, err := updateInBatches(tmp, merged); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return written, nil
+}
+
+// keep each rrd update call reasonably small
+func updateInBatches(filename string, items []*cmodel.GraphItem) (int, error) {
+	written := 0
+	for len(items) > 0 {
+		n := len(items)
+		if n > BackfillBatchSize {
+			n = BackfillBatchSize
+		}
+		if err := update(filename, items[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		items = items[n:]
+	}
+	return written, nil
+}
+
+// Backfill writes historical items straight into the rrd file, bypassing
+// the GraphItems cache
+func Backfill(filename, md5 string, items []*cmodel.GraphItem, merge bool) (int, error) {
+	done := make(chan error, 1)
+	task := &io_task_t{
+		method: IO_TASK_M_BACKFILL,
+		args: &backfill_t{
+			filename: filename,
+			items:    items,
+			merge:    merge,
+		},
+		done: done,
+	}
+	io_task_chans[getIndex(md5)] <- task
+	atomic.AddUint64(&disk_counter, 1)
+	err := <-done
+	return task.args.(*backfill_t).written, err
+}
+
 func ReadFile(filename, md5 string) ([]byte, error) {
 	done := make(chan error, 1)
 	task := &io_task_t{
@@ -169,6 +329,52 @@ func ReadFile(filename, md5 string) ([]byte, error) {
 	return task.args.(*readfile_t).data, err
 }
 
+type removefile_t struct {
+	filename string
+	ckey     string
+	before   int64
+	removed  bool
+}
+
+// RemoveExpiredFile 删除过期的rrd文件, 与该文件的其他读写操作串行.
+// 删除前在io worker中再次检查: 文件在before之后被修改过、或内存中有待写入的数据时不删除, 返回false
+func RemoveExpiredFile(filename, md5, ckey string, before int64) (bool, error) {
+	done := make(chan error, 1)
+	task := &io_task_t{
+		method: IO_TASK_M_REMOVE,
+		args: &removefile_t{
+			filename: filename,
+			ckey:     ckey,
+			before:   before,
+		},
+		done: done,
+	}
+	io_task_chans[getIndex(md5)] <- task
+	atomic.AddUint64(&disk_counter, 1)
+	err := <-done
+	return task.args.(*removefile_t).removed, err
+}
+
+func removeExpiredFile(filename, ckey string, before int64) (bool, error) {
+	if store.GraphItems.ItemCnt(ckey) > 0 {
+		return false, nil
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if info.ModTime().Unix() >= before {
+		return false, nil
+	}
+	if err := os.Remove(filename); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func FlushFile(filename, md5 string, items []*cmodel.GraphItem) error {
 	done := make(chan error, 1)
 	io_task_chans[getIndex(md5)] <- &io_task_t{
@@ -0,0 +1,141 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rrdtool
+
+import (
+	"container/list"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cmodel "github.com/open-falcon/falcon-plus/common/model"
+	"github.com/open-falcon/falcon-plus/modules/graph/store"
+)
+
+func gaugeItems(from int64, n int, value float64) []*cmodel.GraphItem {
+	items := make([]*cmodel.GraphItem, n)
+	for i := range items {
+		items[i] = &cmodel.GraphItem{
+			Timestamp: from + int64(i)*60,
+			Value:     value,
+			DsType:    "GAUGE",
+			Step:      60,
+			Heartbeat: 120,
+			Min:       "U",
+			Max:       "U",
+		}
+	}
+	return items
+}
+
+func TestBackfillIntoExistingRrd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graph-backfill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "test_GAUGE_60.rrd")
+
+	var base int64 = 1500000000 - 1500000000%60
+	// 已经在上报的series
+	live := gaugeItems(base+1000*60, 100, 2)
+	if err := createWithStart(filename, live[0], time.Unix(live[0].Timestamp-60, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := update(filename, live); err != nil {
+		t.Fatal(err)
+	}
+
+	// 比rrd最后更新时间早的历史数据, 不合并时跳过
+	written, err := backfillrrd(filename, gaugeItems(base+60, 500, 1), false)
+	if err != nil || written != 0 {
+		t.Fatalf("expect nothing written without merge, got %d %v", written, err)
+	}
+	written, err = backfillrrd(filename, gaugeItems(base+60, 500, 1), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 500 {
+		t.Errorf("expect 500 points written, got %d", written)
+	}
+
+	check := func(start, end int64, expect float64) {
+		rows, err := fetch(filename, "AVERAGE", start, end, 60)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range rows {
+			if row.Timestamp <= start || row.Timestamp > end {
+				continue
+			}
+			if v := float64(row.Value); v != expect && !(math.IsNaN(v) && math.IsNaN(expect)) {
+				t.Fatalf("expect %v at %d, got %v", expect, row.Timestamp, v)
+			}
+		}
+	}
+	check(base+400*60, base+450*60, 1)
+	check(base+1010*60, base+1090*60, 2)
+	check(base+600*60, base+900*60, math.NaN())
+
+	if _, err := os.Stat(filename + ".backfill"); !os.IsNotExist(err) {
+		t.Error("expect temporary rrd removed")
+	}
+}
+
+func TestRemoveExpiredFileResumed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graph-expire")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	before := time.Now().Unix() - 86400
+	old := time.Unix(before-3600, 0)
+	newFile := func(name string) string {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte("rrd"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(filename, old, old)
+		return filename
+	}
+
+	expired := newFile("a_GAUGE_60.rrd")
+	if removed, err := removeExpiredFile(expired, "a_GAUGE_60", before); err != nil || !removed {
+		t.Errorf("expect expired file removed, got %v %v", removed, err)
+	}
+
+	// 清理开始后又有数据刷盘
+	resumed := newFile("b_GAUGE_60.rrd")
+	os.Chtimes(resumed, time.Now(), time.Now())
+	if removed, err := removeExpiredFile(resumed, "b_GAUGE_60", before); err != nil || removed {
+		t.Errorf("expect resumed file kept, got %v %v", removed, err)
+	}
+
+	// 清理开始后收到了数据, 还没有刷盘
+	pending := newFile("c_GAUGE_60.rrd")
+	store.GraphItems.Set("c_GAUGE_60", &store.SafeLinkedList{L: list.New()})
+	defer store.GraphItems.Remove("c_GAUGE_60")
+	store.GraphItems.PushAll("c_GAUGE_60", gaugeItems(time.Now().Unix(), 1, 1))
+	if removed, err := removeExpiredFile(pending, "c_GAUGE_60", before); err != nil || removed {
+		t.Errorf("expect file with pending items kept, got %v %v", removed, err)
+	}
+	if _, err := os.Stat(pending); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rrdtool
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/open-falcon/falcon-plus/modules/graph/g"
+)
+
+// 快照中记录各个rrd文件校验和的文件, 位于tar包的最后
+const SnapshotManifest = "MANIFEST"
+
+var snapshotRunning int32
+
+// 快照期间拒绝回灌和过期删除, 这两种操作会重建或删除已有的rrd文件
+var ErrSnapshotRunning = errors.New("a snapshot is running, try again later")
+
+type SnapshotEntry struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	Md5     string `json:"md5,omitempty"`
+}
+
+func IsSnapshotRunning() bool {
+	return atomic.LoadInt32(&snapshotRunning) == 1
+}
+
+// rrd文件名形如 ${md5[0:2]}/${md5}_${dsType}_${step}.rrd
+func md5OfRrdFile(name string) string {
+	base := filepath.Base(name)
+	if idx := strings.IndexByte(base, '_'); idx > 0 {
+		return base[:idx]
+	}
+	return base
+}
+
+// 列出storage下mtime晚于since的rrd文件, since<=0时列出全部
+func ListRrdFiles(since int64) ([]*SnapshotEntry, error) {
+	storage := g.Config().RRD.Storage
+	entries := []*SnapshotEntry{}
+	err := filepath.Walk(storage, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			// 跳过恢复时使用的临时目录
+			if strings.HasPrefix(info.Name(), ".") && path != storage {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(info.Name(), ".rrd") || info.ModTime().Unix() <= since {
+			return nil
+		}
+		name, err := filepath.Rel(storage, path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, &SnapshotEntry{
+			Name:    filepath.ToSlash(name),
+			Size:    info.Size(),
+			ModTime: info.ModTime().Unix(),
+		})
+		return nil
+	})
+	return entries, err
+}
+
+// 标记快照开始, 此后io worker拒绝回灌和删除文件; 然后将内存中的数据全部刷盘, 返回刷盘的时间和结束函数.
+// 定时刷盘不会暂停, 内存缓存不会在打包期间堆积
+func beginSnapshot() (int64, func(), error) {
+	if !atomic.CompareAndSwapInt32(&snapshotRunning, 0, 1) {
+		return 0, nil, errors.New("another snapshot is running")
+	}
+	cutoff := time.Now().Unix()
+	FlushAll(true)
+	return cutoff, func() {
+		atomic.StoreInt32(&snapshotRunning, 0)
+	}, nil
+}
+
+// Snapshot 将storage下的rrd文件打包为tar流写入w, since>0时只包含此后修改过的文件(增量).
+// 打包前先强制刷盘, 快照中的每个文件都包含刷盘时刻之前的全部数据, 打包期间定时刷盘照常进行,
+// 个别文件可能还包含之后写入的数据. 每个文件都通过io worker读取, 与该文件的其他读写操作串行;
+// 打包期间回灌和过期删除会被拒绝, 已有的历史数据不会在打包过程中被重建或删除
+func Snapshot(w io.Writer, since int64) error {
+	cutoff, end, err := beginSnapshot()
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	begin := time.Now()
+	entries, err := ListRrdFiles(since)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	manifest := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		filename := filepath.Join(g.Config().RRD.Storage, filepath.FromSlash(entry.Name))
+		body, err := ReadFile(filename, md5OfRrdFile(filename))
+		if err != nil {
+			if os.IsNotExist(err) {
+				// 打包过程中被删除
+				continue
+			}
+			return err
+		}
+
+		hdr := &tar.Header{
+			Name:    entry.Name,
+			Mode:    0644,
+			Size:    int64(len(body)),
+			ModTime: time.Unix(entry.ModTime, 0),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(body); err != nil {
+			return err
+		}
+		manifest = append(manifest, fmt.Sprintf("%x  %s\n", md5.Sum(body), entry.Name))
+	}
+
+	body := []byte(strings.Join(manifest, ""))
+	hdr := &tar.Header{
+		Name:    SnapshotManifest,
+		Mode:    0644,
+		Size:    int64(len(body)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(body); err != nil {
+		return err
+	}
+
+	log.Printf("snapshot done, since %d, cutoff %d, files %d, time %s\n", since, cutoff, len(manifest), time.Since(begin))
+	return tw.Close()
+}
+
+// Manifest 计算storage下rrd文件的校验和, 用于和已有的备份对比
+func Manifest(since int64) ([]*SnapshotEntry, error) {
+	_, end, err := beginSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+
+	entries, err := ListRrdFiles(since)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*SnapshotEntry, 0, len(entries))
+	for _, entry := range entries {
+		filename := filepath.Join(g.Config().RRD.Storage, filepath.FromSlash(entry.Name))
+		body, err := ReadFile(filename, md5OfRrdFile(filename))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		entry.Md5 = fmt.Sprintf("%x", md5.Sum(body))
+		ret = append(ret, entry)
+	}
+	return ret, nil
+}
+
+// Restore 将Snapshot生成的tar流恢复到storage目录, graph不能处于运行状态.
+// 文件先解压到临时目录, 所有文件的校验和与MANIFEST一致后才会替换到storage中.
+// 快照只保证单个文件的一致性, 恢复后各文件最后的数据时间可能不同
+func Restore(r io.Reader, storage string) (int, error) {
+	tmpDir := filepath.Join(storage, fmt.Sprintf(".restore-%d", time.Now().UnixNano()))
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sums := make(map[string]string)
+	var manifest map[string]string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if hdr.Name == SnapshotManifest {
+			if manifest, err = parseManifest(tr); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || strings.HasPrefix(name, "..") || !strings.HasSuffix(name, ".rrd") {
+			return 0, fmt.Errorf("bad file name in snapshot: %s", hdr.Name)
+		}
+		tmpFile := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(tmpFile), 0755); err != nil {
+			return 0, err
+		}
+		f, err := os.OpenFile(tmpFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return 0, err
+		}
+		h := md5.New()
+		_, err = io.Copy(io.MultiWriter(f, h), tr)
+		if err1 := f.Close(); err == nil {
+			err = err1
+		}
+		if err != nil {
+			return 0, err
+		}
+		os.Chtimes(tmpFile, hdr.ModTime, hdr.ModTime)
+		sums[filepath.ToSlash(name)] = fmt.Sprintf("%x", h.Sum(nil))
+	}
+
+	if manifest == nil {
+		return 0, errors.New("no MANIFEST in snapshot")
+	}
+	if len(manifest) != len(sums) {
+		return 0, fmt.Errorf("MANIFEST has %d files, snapshot has %d", len(manifest), len(sums))
+	}
+	for name, sum := range sums {
+		if manifest[name] != sum {
+			return 0, fmt.Errorf("checksum mismatch: %s", name)
+		}
+	}
+
+	for name := range sums {
+		dst := filepath.Join(storage, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return 0, err
+		}
+		if err := os.Rename(filepath.Join(tmpDir, filepath.FromSlash(name)), dst); err != nil {
+			return 0, err
+		}
+	}
+	return len(sums), nil
+}
+
+// MANIFEST每行为 "md5  文件名", 与md5sum的输出格式相同
+func parseManifest(r io.Reader) (map[string]string, error) {
+	manifest := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "  ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("bad MANIFEST line: %s", line)
+		}
+		manifest[fields[1]] = fields[0]
+	}
+	return manifest, scanner.Err()
+}
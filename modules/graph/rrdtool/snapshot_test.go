@@ -0,0 +1,76 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rrdtool
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func makeSnapshot(t *testing.T, files map[string]string, manifest string) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for name, body := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body))})
+		tw.Write([]byte(body))
+	}
+	tw.WriteHeader(&tar.Header{Name: SnapshotManifest, Mode: 0644, Size: int64(len(manifest))})
+	tw.Write([]byte(manifest))
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestRestore(t *testing.T) {
+	storage, err := ioutil.TempDir("", "graph-restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storage)
+
+	name := "b0/b026324c6904b2a9cb4b88d6d61c81d1_GAUGE_60.rrd"
+	body := "rrd body"
+	files := map[string]string{name: body}
+
+	bad := makeSnapshot(t, files, fmt.Sprintf("%x  %s\n", md5.Sum([]byte("other")), name))
+	if _, err := Restore(bad, storage); err == nil {
+		t.Error("expect checksum mismatch")
+	}
+	if _, err := os.Stat(filepath.Join(storage, name)); !os.IsNotExist(err) {
+		t.Error("expect nothing restored on checksum mismatch")
+	}
+
+	good := makeSnapshot(t, files, fmt.Sprintf("%x  %s\n", md5.Sum([]byte(body)), name))
+	n, err := Restore(good, storage)
+	if err != nil || n != 1 {
+		t.Fatalf("restore fail, n=%d err=%v", n, err)
+	}
+	restored, err := ioutil.ReadFile(filepath.Join(storage, name))
+	if err != nil || string(restored) != body {
+		t.Errorf("unexpected restored file %q %v", restored, err)
+	}
+
+	evil := makeSnapshot(t, map[string]string{"../evil.rrd": body}, "")
+	if _, err := Restore(evil, storage); err == nil {
+		t.Error("expect bad file name")
+	}
+}
@@ -32,6 +32,8 @@ const (
 	IO_TASK_M_WRITE
 	IO_TASK_M_FLUSH
 	IO_TASK_M_FETCH
+	IO_TASK_M_BACKFILL
+	IO_TASK_M_REMOVE
 )
 
 type io_task_t struct {
@@ -121,6 +123,24 @@ func ioWorker() {
 							args.data, err = fetch(args.filename, args.cf, args.start, args.end, args.step)
 							task.done <- err
 						}
+					} else if task.method == IO_TASK_M_BACKFILL {
+						if args, ok := task.args.(*backfill_t); ok {
+							if IsSnapshotRunning() {
+								task.done <- ErrSnapshotRunning
+								continue
+							}
+							args.written, err = backfillrrd(args.filename, args.items, args.merge)
+							task.done <- err
+						}
+					} else if task.method == IO_TASK_M_REMOVE {
+						if args, ok := task.args.(*removefile_t); ok {
+							if IsSnapshotRunning() {
+								task.done <- ErrSnapshotRunning
+								continue
+							}
+							args.removed, err = removeExpiredFile(args.filename, args.ckey, args.before)
+							task.done <- err
+						}
 					}
 				}
 			}
@@ -0,0 +1,140 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/open-falcon/falcon-plus/common/utils"
+	"github.com/open-falcon/falcon-plus/modules/hbs/db"
+	"github.com/open-falcon/falcon-plus/modules/hbs/g"
+)
+
+// 机器组指定的agent版本
+type SafeGroupAgentUpgrades struct {
+	sync.RWMutex
+	M map[int]*db.AgentUpgrade
+}
+
+var GroupAgentUpgrades = &SafeGroupAgentUpgrades{M: make(map[int]*db.AgentUpgrade)}
+
+func (this *SafeGroupAgentUpgrades) Init() {
+	if cfg := g.Config().AgentUpgrade; cfg == nil || !cfg.Enabled {
+		return
+	}
+
+	m, err := db.QueryAgentUpgrades()
+	if err != nil {
+		return
+	}
+
+	this.Lock()
+	defer this.Unlock()
+	this.M = m
+}
+
+func (this *SafeGroupAgentUpgrades) Get(gid int) (*db.AgentUpgrade, bool) {
+	this.RLock()
+	defer this.RUnlock()
+	u, exists := this.M[gid]
+	return u, exists
+}
+
+// GetAgentVersion 机器应该运行的agent版本, 机器属于多个指定了版本的组时以最近创建的为准,
+// 机器不在该版本的灰度范围内时返回空
+func GetAgentVersion(hostname string) string {
+	hid, exists := HostMap.GetID(hostname)
+	if !exists {
+		return ""
+	}
+
+	gids, exists := HostGroupsMap.GetGroupIds(hid)
+	if !exists {
+		return ""
+	}
+
+	var latest *db.AgentUpgrade
+	for _, gid := range gids {
+		if u, exists := GroupAgentUpgrades.Get(gid); exists && (latest == nil || u.Id > latest.Id) {
+			latest = u
+		}
+	}
+	if latest == nil || !utils.InRollout(latest.Version, hostname, latest.Percent) {
+		return ""
+	}
+	return latest.Version
+}
+
+// agent的二进制文件, 文件没有变化时复用计算好的checksum
+type AgentBinary struct {
+	Version   string
+	Checksum  string
+	Signature string
+	Path      string
+	Size      int64
+	MTime     int64
+}
+
+type SafeAgentBinaries struct {
+	sync.Mutex
+	M map[string]*AgentBinary
+}
+
+var AgentBinaries = &SafeAgentBinaries{M: make(map[string]*AgentBinary)}
+
+var agentVersionRe = regexp.MustCompile(`^[\w.-]+$`)
+
+func (this *SafeAgentBinaries) Get(version string) (*AgentBinary, error) {
+	if !agentVersionRe.MatchString(version) {
+		return nil, fmt.Errorf("bad agent version %q", version)
+	}
+	path := filepath.Join(g.Config().AgentUpgrade.BinaryDir, "falcon-agent-"+version)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	this.Lock()
+	defer this.Unlock()
+	if b, ok := this.M[version]; ok && b.Size == fi.Size() && b.MTime == fi.ModTime().Unix() {
+		return b, nil
+	}
+
+	checksum, err := fileSha256(path)
+	if err != nil {
+		return nil, err
+	}
+	signature := ""
+	if bs, err := ioutil.ReadFile(path + ".sig"); err == nil {
+		signature = strings.TrimSpace(string(bs))
+	}
+
+	b := &AgentBinary{
+		Version:   version,
+		Checksum:  checksum,
+		Signature: signature,
+		Path:      path,
+		Size:      fi.Size(),
+		MTime:     fi.ModTime().Unix(),
+	}
+	this.M[version] = b
+	return b, nil
+}
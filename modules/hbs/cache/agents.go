@@ -51,12 +51,34 @@ func (this *SafeAgents) Put(req *model.AgentReportRequest) {
 		db.UpdateAgent(val)
 	}
 
+	// 没有开启服务发现的agent上报的Services为nil, 不修改其服务清单
+	if req.Services != nil {
+		if agentInfo, exists := this.Get(req.Hostname); !exists ||
+			!sameServices(agentInfo.ReportRequest.Services, req.Services) {
+
+			db.UpdateHostServices(req.Hostname, req.Services)
+		}
+	}
+
 	// 更新hbs 时间
 	this.Lock()
 	this.M[req.Hostname] = val
 	this.Unlock()
 }
 
+// agent上报的服务是排好序的, 逐个比较即可
+func sameServices(a, b []*model.AgentService) bool {
+	if a == nil || len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if *a[i] != *b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (this *SafeAgents) Get(hostname string) (*model.AgentUpdateInfo, bool) {
 	this.RLock()
 	defer this.RUnlock()
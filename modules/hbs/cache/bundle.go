@@ -0,0 +1,117 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/open-falcon/falcon-plus/modules/hbs/g"
+)
+
+const PluginBundleSuffix = ".tar.gz"
+
+// 分发给agent的插件包, 版本为去掉.tar.gz后缀的文件名
+type PluginBundle struct {
+	Version  string `json:"version"`
+	Checksum string `json:"checksum"` // sha256
+	Size     int64  `json:"size"`
+	MTime    int64  `json:"mtime"`
+	Path     string `json:"-"`
+}
+
+type SafePluginBundle struct {
+	sync.RWMutex
+	bundle *PluginBundle
+}
+
+var CurrentPluginBundle = &SafePluginBundle{}
+
+func (this *SafePluginBundle) Get() *PluginBundle {
+	this.RLock()
+	defer this.RUnlock()
+	return this.bundle
+}
+
+// Init 在BundleDir中找到修改时间最新的插件包, 文件没有变化时不重新计算checksum
+func (this *SafePluginBundle) Init() {
+	cfg := g.Config().Plugin
+	if cfg == nil || !cfg.Enabled || cfg.BundleDir == "" {
+		return
+	}
+
+	fs, err := ioutil.ReadDir(cfg.BundleDir)
+	if err != nil {
+		log.Println("read plugin bundle dir fail:", err)
+		return
+	}
+
+	var latest os.FileInfo
+	for _, fi := range fs {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), PluginBundleSuffix) {
+			continue
+		}
+		if latest == nil || fi.ModTime().After(latest.ModTime()) {
+			latest = fi
+		}
+	}
+	if latest == nil {
+		return
+	}
+
+	version := strings.TrimSuffix(latest.Name(), PluginBundleSuffix)
+	if old := this.Get(); old != nil && old.Version == version && old.Size == latest.Size() && old.MTime == latest.ModTime().Unix() {
+		return
+	}
+
+	path := filepath.Join(cfg.BundleDir, latest.Name())
+	checksum, err := fileSha256(path)
+	if err != nil {
+		log.Println("checksum plugin bundle fail:", err)
+		return
+	}
+
+	this.Lock()
+	defer this.Unlock()
+	this.bundle = &PluginBundle{
+		Version:  version,
+		Checksum: checksum,
+		Size:     latest.Size(),
+		MTime:    latest.ModTime().Unix(),
+		Path:     path,
+	}
+	log.Printf("plugin bundle %s, sha256: %s", version, checksum)
+}
+
+func fileSha256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
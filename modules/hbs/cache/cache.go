@@ -49,6 +49,12 @@ func Init() {
 	log.Println("#9 MonitoredHosts...")
 	MonitoredHosts.Init()
 
+	log.Println("#10 PluginBundle...")
+	CurrentPluginBundle.Init()
+
+	log.Println("#11 GroupAgentUpgrades...")
+	GroupAgentUpgrades.Init()
+
 	log.Println("cache done")
 
 	go LoopInit()
@@ -67,5 +73,7 @@ func LoopInit() {
 		HostTemplateIds.Init()
 		ExpressionCache.Init()
 		MonitoredHosts.Init()
+		CurrentPluginBundle.Init()
+		GroupAgentUpgrades.Init()
 	}
 }
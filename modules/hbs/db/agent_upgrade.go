@@ -0,0 +1,67 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"log"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+)
+
+type AgentUpgrade struct {
+	Id      int
+	Version string
+	Percent int // 灰度发布时升级组内机器的百分比
+}
+
+// 机器组 => 该组的agent应该升级到的版本
+func QueryAgentUpgrades() (map[int]*AgentUpgrade, error) {
+	m := make(map[int]*AgentUpgrade)
+
+	sql := "select id, grp_id, version, percent from agent_upgrade"
+	rows, err := DB.Query(sql)
+	if err != nil {
+		log.Println("ERROR:", err)
+		return m, err
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			grpId int
+			u     AgentUpgrade
+		)
+
+		err = rows.Scan(&u.Id, &grpId, &u.Version, &u.Percent)
+		if err != nil {
+			log.Println("ERROR:", err)
+			continue
+		}
+
+		m[grpId] = &u
+	}
+
+	return m, nil
+}
+
+func UpdateAgentUpgradeHost(report *model.AgentUpgradeReport) error {
+	sql := "insert into agent_upgrade_host(hostname, from_version, to_version, status, message) values (?, ?, ?, ?, ?) " +
+		"on duplicate key update from_version=values(from_version), to_version=values(to_version), status=values(status), message=values(message)"
+	_, err := DB.Exec(sql, report.Hostname, report.FromVersion, report.ToVersion, report.Status, report.Message)
+	if err != nil {
+		log.Println("exec", sql, "fail", err)
+	}
+	return err
+}
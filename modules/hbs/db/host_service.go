@@ -0,0 +1,48 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"log"
+
+	"github.com/open-falcon/falcon-plus/common/model"
+)
+
+// 用agent最新上报的服务替换该机器的服务清单
+func UpdateHostServices(hostname string, services []*model.AgentService) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("ERROR:", err)
+		return err
+	}
+
+	sql := "delete from host_service where hostname = ?"
+	if _, err = tx.Exec(sql, hostname); err != nil {
+		log.Println("exec", sql, "fail", err)
+		tx.Rollback()
+		return err
+	}
+
+	sql = "insert into host_service(hostname, proto, port, name) values (?, ?, ?, ?)"
+	for _, s := range services {
+		if _, err = tx.Exec(sql, hostname, s.Proto, s.Port, s.Name); err != nil {
+			log.Println("exec", sql, "fail", err)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
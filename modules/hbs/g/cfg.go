@@ -16,6 +16,7 @@ package g
 
 import (
 	"encoding/json"
+	"github.com/open-falcon/falcon-plus/common/utils"
 	"github.com/toolkits/file"
 	"log"
 	"sync"
@@ -26,15 +27,36 @@ type HttpConfig struct {
 	Listen  string `json:"listen"`
 }
 
+type PluginConfig struct {
+	Enabled   bool   `json:"enabled"`
+	BundleDir string `json:"bundleDir"` // 插件包(*.tar.gz)所在目录, 分发其中最新的一个
+}
+
+type AgentUpgradeConfig struct {
+	Enabled   bool   `json:"enabled"`
+	BinaryDir string `json:"binaryDir"` // agent二进制文件 falcon-agent-<version> 及其签名 falcon-agent-<version>.sig 所在目录
+}
+
+// 插件包和agent二进制文件通过http下载, 下载地址由hbs签名后在rpc应答中下发
+type DownloadConfig struct {
+	Url           string `json:"url"`           // agent访问hbs http端口的地址, 如 http://hbs.example.com:6031
+	Secret        string `json:"secret"`        // 下载地址的签名密钥, 部署多个hbs时需要相同, 为空时启动时随机生成
+	MaxConcurrent int    `json:"maxConcurrent"` // 同时进行的最大下载数, 超过时返回503, 默认20
+}
+
 type GlobalConfig struct {
-	Debug     bool        `json:"debug"`
-	Hosts     string      `json:"hosts"`
-	Database  string      `json:"database"`
-	MaxConns  int         `json:"maxConns"`
-	MaxIdle   int         `json:"maxIdle"`
-	Listen    string      `json:"listen"`
-	Trustable []string    `json:"trustable"`
-	Http      *HttpConfig `json:"http"`
+	Debug        bool                `json:"debug"`
+	Hosts        string              `json:"hosts"`
+	Database     string              `json:"database"`
+	MaxConns     int                 `json:"maxConns"`
+	MaxIdle      int                 `json:"maxIdle"`
+	Listen       string              `json:"listen"`
+	Tls          *utils.TlsConfig    `json:"tls"` // rpc端口的tls配置
+	Trustable    []string            `json:"trustable"`
+	Http         *HttpConfig         `json:"http"`
+	Plugin       *PluginConfig       `json:"plugin"`
+	AgentUpgrade *AgentUpgradeConfig `json:"agentUpgrade"`
+	Download     *DownloadConfig     `json:"download"`
 }
 
 var (
@@ -0,0 +1,80 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package g
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 下载地址的有效期
+const DownloadExpire = 10 * time.Minute
+
+var (
+	downloadSecret     []byte
+	downloadSecretOnce sync.Once
+)
+
+func downloadKey() []byte {
+	downloadSecretOnce.Do(func() {
+		if cfg := Config().Download; cfg != nil && cfg.Secret != "" {
+			downloadSecret = []byte(cfg.Secret)
+			return
+		}
+		downloadSecret = make([]byte, 32)
+		rand.Read(downloadSecret)
+	})
+	return downloadSecret
+}
+
+func signDownload(path string, params url.Values) string {
+	mac := hmac.New(sha256.New, downloadKey())
+	mac.Write([]byte(path + "?" + params.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// DownloadUrl 返回agent下载path的带签名的地址, 没有配置download.url时返回空
+func DownloadUrl(path string, params url.Values) string {
+	cfg := Config().Download
+	if cfg == nil || cfg.Url == "" {
+		return ""
+	}
+	params.Set("expire", strconv.FormatInt(time.Now().Add(DownloadExpire).Unix(), 10))
+	params.Set("sign", signDownload(path, params))
+	return strings.TrimRight(cfg.Url, "/") + path + "?" + params.Encode()
+}
+
+// VerifyDownload 校验下载请求的签名和有效期
+func VerifyDownload(path string, params url.Values) bool {
+	sign := params.Get("sign")
+	expire, err := strconv.ParseInt(params.Get("expire"), 10, 64)
+	if sign == "" || err != nil || time.Now().Unix() > expire {
+		return false
+	}
+	signed := url.Values{}
+	for k, v := range params {
+		if k != "sign" {
+			signed[k] = v
+		}
+	}
+	return hmac.Equal([]byte(sign), []byte(signDownload(path, signed)))
+}
@@ -0,0 +1,43 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"net/http"
+
+	"github.com/open-falcon/falcon-plus/modules/hbs/cache"
+	"github.com/open-falcon/falcon-plus/modules/hbs/g"
+)
+
+func configAgentRoutes() {
+	// 下载agent的二进制文件, 只接受hbs在Agent.UpgradeInfo中下发的带签名的地址
+	http.HandleFunc("/agent/binary", func(w http.ResponseWriter, r *http.Request) {
+		if !verifyDownload(w, r) {
+			return
+		}
+		if cfg := g.Config().AgentUpgrade; cfg == nil || !cfg.Enabled {
+			http.NotFound(w, r)
+			return
+		}
+		binary, err := cache.AgentBinaries.Get(r.URL.Query().Get("version"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Checksum-Sha256", binary.Checksum)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		serveDownload(w, r, binary.Path)
+	})
+}
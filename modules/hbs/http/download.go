@@ -0,0 +1,66 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/open-falcon/falcon-plus/modules/hbs/g"
+)
+
+// 同时进行的下载数超过download.maxConcurrent时返回503, agent在下次心跳时重试
+var (
+	downloadSlots     chan struct{}
+	downloadSlotsOnce sync.Once
+)
+
+func acquireDownload() bool {
+	downloadSlotsOnce.Do(func() {
+		n := 20
+		if cfg := g.Config().Download; cfg != nil && cfg.MaxConcurrent > 0 {
+			n = cfg.MaxConcurrent
+		}
+		downloadSlots = make(chan struct{}, n)
+	})
+	select {
+	case downloadSlots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func releaseDownload() {
+	<-downloadSlots
+}
+
+// 校验下载地址的签名, 然后以流的方式发送文件
+func serveDownload(w http.ResponseWriter, r *http.Request, path string) {
+	if !acquireDownload() {
+		http.Error(w, "too many downloads", http.StatusServiceUnavailable)
+		return
+	}
+	defer releaseDownload()
+	http.ServeFile(w, r, path)
+}
+
+func verifyDownload(w http.ResponseWriter, r *http.Request) bool {
+	if !g.VerifyDownload(r.URL.Path, r.URL.Query()) {
+		http.Error(w, "bad or expired download url", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -29,6 +29,8 @@ type Dto struct {
 
 func init() {
 	configCommonRoutes()
+	configPluginRoutes()
+	configAgentRoutes()
 	configProcRoutes()
 }
 
@@ -0,0 +1,43 @@
+// Copyright 2017 Xiaomi, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"net/http"
+
+	"github.com/open-falcon/falcon-plus/modules/hbs/cache"
+)
+
+func configPluginRoutes() {
+	http.HandleFunc("/plugin/bundle/info", func(w http.ResponseWriter, r *http.Request) {
+		RenderDataJson(w, cache.CurrentPluginBundle.Get())
+	})
+
+	// 下载当前的插件包, 只接受hbs在Agent.MinePlugins中下发的带签名的地址, checksum在X-Checksum-Sha256头中
+	http.HandleFunc("/plugin/bundle", func(w http.ResponseWriter, r *http.Request) {
+		if !verifyDownload(w, r) {
+			return
+		}
+		bundle := cache.CurrentPluginBundle.Get()
+		if bundle == nil || bundle.Version != r.URL.Query().Get("version") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Bundle-Version", bundle.Version)
+		w.Header().Set("X-Checksum-Sha256", bundle.Checksum)
+		w.Header().Set("Content-Type", "application/gzip")
+		serveDownload(w, r, bundle.Path)
+	})
+}
@@ -19,7 +19,10 @@ import (
 	"github.com/open-falcon/falcon-plus/common/model"
 	"github.com/open-falcon/falcon-plus/common/utils"
 	"github.com/open-falcon/falcon-plus/modules/hbs/cache"
+	"github.com/open-falcon/falcon-plus/modules/hbs/db"
 	"github.com/open-falcon/falcon-plus/modules/hbs/g"
+	"log"
+	"net/url"
 	"sort"
 	"strings"
 	"time"
@@ -33,6 +36,60 @@ func (t *Agent) MinePlugins(args model.AgentHeartbeatRequest, reply *model.Agent
 	reply.Plugins = cache.GetPlugins(args.Hostname)
 	reply.Timestamp = time.Now().Unix()
 
+	// 插件包通过http下载, 没有配置download.url时不分发
+	if bundle := cache.CurrentPluginBundle.Get(); bundle != nil {
+		params := url.Values{"hostname": {args.Hostname}, "version": {bundle.Version}}
+		if u := g.DownloadUrl("/plugin/bundle", params); u != "" {
+			reply.BundleVersion = bundle.Version
+			reply.BundleChecksum = bundle.Checksum
+			reply.BundleUrl = u
+		}
+	}
+
+	return nil
+}
+
+// agent所在机器组指定了与agent当前版本不同的版本时, 返回该版本的checksum和签名
+func (t *Agent) UpgradeInfo(args model.AgentHeartbeatRequest, reply *model.AgentUpgradeResponse) error {
+	if cfg := g.Config().AgentUpgrade; cfg == nil || !cfg.Enabled || args.Hostname == "" {
+		return nil
+	}
+
+	version := cache.GetAgentVersion(args.Hostname)
+	if version == "" {
+		return nil
+	}
+
+	binary, err := cache.AgentBinaries.Get(version)
+	if err != nil {
+		log.Println("agent binary of version", version, "not available:", err)
+		return nil
+	}
+
+	// 二进制文件通过http下载
+	params := url.Values{"hostname": {args.Hostname}, "version": {binary.Version}}
+	u := g.DownloadUrl("/agent/binary", params)
+	if u == "" {
+		log.Println("download.url not configured, cannot upgrade agents")
+		return nil
+	}
+
+	reply.Version = binary.Version
+	reply.Checksum = binary.Checksum
+	reply.Signature = binary.Signature
+	reply.Url = u
+	return nil
+}
+
+func (t *Agent) ReportUpgrade(args model.AgentUpgradeReport, reply *model.SimpleRpcResponse) error {
+	if args.Hostname == "" {
+		reply.Code = 1
+		return nil
+	}
+
+	if err := db.UpdateAgentUpgradeHost(&args); err != nil {
+		reply.Code = 1
+	}
 	return nil
 }
 
@@ -15,6 +15,7 @@
 package rpc
 
 import (
+	"crypto/tls"
 	"log"
 	"net"
 	"net/rpc"
@@ -35,6 +36,11 @@ func Start() {
 	server.Register(new(Agent))
 	server.Register(new(Hbs))
 
+	tlsConfig, err := g.Config().Tls.ServerConfig()
+	if err != nil {
+		log.Fatalln("load tls config fail:", err)
+	}
+
 	l, e := net.Listen("tcp", addr)
 	if e != nil {
 		log.Fatalln("listen error:", e)
@@ -49,6 +55,9 @@ func Start() {
 			time.Sleep(time.Duration(100) * time.Millisecond)
 			continue
 		}
+		if tlsConfig != nil {
+			conn = tls.Server(conn, tlsConfig)
+		}
 		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
 	}
 }
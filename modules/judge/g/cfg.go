@@ -16,6 +16,7 @@ package g
 
 import (
 	"encoding/json"
+	"github.com/open-falcon/falcon-plus/common/utils"
 	"github.com/toolkits/file"
 	"log"
 	"sync"
@@ -27,14 +28,16 @@ type HttpConfig struct {
 }
 
 type RpcConfig struct {
-	Enabled bool   `json:"enabled"`
-	Listen  string `json:"listen"`
+	Enabled bool             `json:"enabled"`
+	Listen  string           `json:"listen"`
+	Tls     *utils.TlsConfig `json:"tls"`
 }
 
 type HbsConfig struct {
-	Servers  []string `json:"servers"`
-	Timeout  int64    `json:"timeout"`
-	Interval int64    `json:"interval"`
+	Servers  []string         `json:"servers"`
+	Timeout  int64            `json:"timeout"`
+	Interval int64            `json:"interval"`
+	Tls      *utils.TlsConfig `json:"tls"`
 }
 
 type RedisConfig struct {
@@ -15,8 +15,9 @@
 package g
 
 import (
+	"crypto/tls"
 	"errors"
-	"github.com/toolkits/net"
+	"github.com/open-falcon/falcon-plus/common/utils"
 	"log"
 	"math"
 	"net/rpc"
@@ -30,6 +31,7 @@ type SingleConnRpcClient struct {
 	RpcServers  []string
 	Timeout     time.Duration
 	CallTimeout time.Duration
+	TlsConfig   *tls.Config
 }
 
 func (this *SingleConnRpcClient) close() {
@@ -53,7 +55,7 @@ func (this *SingleConnRpcClient) insureConn() {
 		}
 
 		for _, s := range this.RpcServers {
-			this.rpcClient, err = net.JsonRpcClient("tcp", s, this.Timeout)
+			this.rpcClient, err = utils.JsonRpcClient("tcp", s, this.Timeout, this.TlsConfig)
 			if err == nil {
 				return
 			}
@@ -15,6 +15,7 @@
 package g
 
 import (
+	"log"
 	"sync"
 	"time"
 
@@ -53,10 +54,15 @@ var (
 )
 
 func InitHbsClient() {
+	tlsConfig, err := Config().Hbs.Tls.ClientConfig()
+	if err != nil {
+		log.Fatalln("load hbs tls config fail:", err)
+	}
 	HbsClient = &SingleConnRpcClient{
 		RpcServers:  Config().Hbs.Servers,
 		Timeout:     time.Duration(Config().Hbs.Timeout) * time.Millisecond,
 		CallTimeout: time.Duration(3000) * time.Millisecond,
+		TlsConfig:   tlsConfig,
 	}
 }
 
@@ -15,6 +15,7 @@
 package rpc
 
 import (
+	"crypto/tls"
 	"github.com/open-falcon/falcon-plus/modules/judge/g"
 	"log"
 	"net"
@@ -38,6 +39,11 @@ func Start() {
 		log.Println("rpc listening", addr)
 	}
 
+	tlsConfig, err := g.Config().Rpc.Tls.ServerConfig()
+	if err != nil {
+		log.Fatalf("load rpc tls config fail: %s", err)
+	}
+
 	rpc.Register(new(Judge))
 
 	for {
@@ -46,6 +52,9 @@ func Start() {
 			log.Printf("listener.Accept occur error: %s", err)
 			continue
 		}
+		if tlsConfig != nil {
+			conn = tls.Server(conn, tlsConfig)
+		}
 		go rpc.ServeConn(conn)
 	}
 }
@@ -51,6 +51,7 @@ type SenderConfig struct {
 	ConnectTimeout int32  `json:"connectTimeout"`
 	RequestTimeout int32  `json:"requestTimeout"`
 	Batch          int32  `json:"batch"`
+	Token          string `json:"token"` // transfer的http.token
 }
 
 type GlobalConfig struct {
@@ -74,6 +75,22 @@ func Config() *GlobalConfig {
 	return config
 }
 
+// RedactedConfig 返回隐去token的配置副本, 用于/config接口
+func RedactedConfig() *GlobalConfig {
+	cfg := *Config()
+	if cfg.PlusApi != nil && cfg.PlusApi.Token != "" {
+		p := *cfg.PlusApi
+		p.Token = "******"
+		cfg.PlusApi = &p
+	}
+	if cfg.Sender != nil && cfg.Sender.Token != "" {
+		s := *cfg.Sender
+		s.Token = "******"
+		cfg.Sender = &s
+	}
+	return &cfg
+}
+
 func ParseConfig(cfg string) {
 	if cfg == "" {
 		log.Fatalln("use -c to specify configuration file")
@@ -23,6 +23,7 @@ import (
 	"time"
 
 	cmodel "github.com/open-falcon/falcon-plus/common/model"
+	cutils "github.com/open-falcon/falcon-plus/common/utils"
 	tsema "github.com/toolkits/concurrent/semaphore"
 	"github.com/toolkits/container/nmap"
 	thttpclient "github.com/toolkits/http/httpclient"
@@ -136,6 +137,9 @@ func sendItemsToTransfer(items []*cmodel.JsonMetaData, size int, httpcliname str
 	req, err := http.NewRequest("POST", transUlr, bytes.NewBuffer(itemsBody))
 	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
 	req.Header.Set("Connection", "close")
+	if cfg.Sender.Token != "" {
+		req.Header.Set(cutils.TOKEN_HEADER, cfg.Sender.Token)
+	}
 	postResp, err := hcli.Do(req)
 	if err != nil {
 		log.Println(transUlr+", post to dest error,", err)
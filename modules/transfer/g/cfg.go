@@ -20,17 +20,20 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/open-falcon/falcon-plus/common/utils"
 	"github.com/toolkits/file"
 )
 
 type HttpConfig struct {
 	Enabled bool   `json:"enabled"`
 	Listen  string `json:"listen"`
+	Token   string `json:"token"` // 不为空时/api/push需要在X-Falcon-Token header中带上该token
 }
 
 type RpcConfig struct {
-	Enabled bool   `json:"enabled"`
-	Listen  string `json:"listen"`
+	Enabled bool             `json:"enabled"`
+	Listen  string           `json:"listen"`
+	Tls     *utils.TlsConfig `json:"tls"`
 }
 
 type SocketConfig struct {
@@ -49,6 +52,7 @@ type JudgeConfig struct {
 	Replicas    int                     `json:"replicas"`
 	Cluster     map[string]string       `json:"cluster"`
 	ClusterList map[string]*ClusterNode `json:"clusterList"`
+	Tls         *utils.TlsConfig        `json:"tls"`
 }
 
 type GraphConfig struct {
@@ -61,6 +65,7 @@ type GraphConfig struct {
 	Replicas    int                     `json:"replicas"`
 	Cluster     map[string]string       `json:"cluster"`
 	ClusterList map[string]*ClusterNode `json:"clusterList"`
+	Tls         *utils.TlsConfig        `json:"tls"`
 }
 
 type TsdbConfig struct {
@@ -83,6 +88,7 @@ type TransferConfig struct {
 	MaxIdle     int               `json:"maxIdle"`
 	MaxRetry    int               `json:"retry"`
 	Cluster     map[string]string `json:"cluster"`
+	Tls         *utils.TlsConfig  `json:"tls"`
 }
 
 type InfluxdbConfig struct {
@@ -123,6 +129,17 @@ func Config() *GlobalConfig {
 	return config
 }
 
+// RedactedConfig 返回隐去token的配置副本, 用于/config接口
+func RedactedConfig() *GlobalConfig {
+	cfg := *Config()
+	if cfg.Http != nil && cfg.Http.Token != "" {
+		h := *cfg.Http
+		h.Token = "******"
+		cfg.Http = &h
+	}
+	return &cfg
+}
+
 func ParseConfig(cfg string) {
 	if cfg == "" {
 		log.Fatalln("use -c to specify configuration file")
@@ -17,11 +17,18 @@ package http
 import (
 	"encoding/json"
 	cmodel "github.com/open-falcon/falcon-plus/common/model"
+	cutils "github.com/open-falcon/falcon-plus/common/utils"
+	"github.com/open-falcon/falcon-plus/modules/transfer/g"
 	prpc "github.com/open-falcon/falcon-plus/modules/transfer/receiver/rpc"
 	"net/http"
 )
 
 func api_push_datapoints(rw http.ResponseWriter, req *http.Request) {
+	if !cutils.CheckToken(req, g.Config().Http.Token) {
+		http.Error(rw, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
 	if req.ContentLength == 0 {
 		http.Error(rw, "blank body", http.StatusBadRequest)
 		return
@@ -36,7 +36,7 @@ func configCommonRoutes() {
 	})
 
 	http.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
-		RenderDataJson(w, g.Config())
+		RenderDataJson(w, g.RedactedConfig())
 	})
 
 	http.HandleFunc("/config/reload", func(w http.ResponseWriter, r *http.Request) {
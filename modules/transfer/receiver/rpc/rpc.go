@@ -15,11 +15,13 @@
 package rpc
 
 import (
-	"github.com/open-falcon/falcon-plus/modules/transfer/g"
+	"crypto/tls"
 	"log"
 	"net"
 	"net/rpc"
 	"net/rpc/jsonrpc"
+
+	"github.com/open-falcon/falcon-plus/modules/transfer/g"
 )
 
 func StartRpc() {
@@ -40,6 +42,11 @@ func StartRpc() {
 		log.Println("rpc listening", addr)
 	}
 
+	tlsConfig, err := g.Config().Rpc.Tls.ServerConfig()
+	if err != nil {
+		log.Fatalf("load rpc tls config fail: %s", err)
+	}
+
 	server := rpc.NewServer()
 	server.Register(new(Transfer))
 
@@ -51,6 +58,10 @@ func StartRpc() {
 		}
 
 		conn.SetKeepAlive(true)
+		if tlsConfig != nil {
+			go server.ServeCodec(jsonrpc.NewServerCodec(tls.Server(conn, tlsConfig)))
+			continue
+		}
 		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
 	}
 }
@@ -52,6 +52,15 @@ func (t *Transfer) Update(args []*cmodel.MetricValue, reply *cmodel.TransferResp
 	return RecvMetricValues(args, reply, "rpc")
 }
 
+// UpdateBatch 接收字典编码并压缩的一批数据, 格式见model.TransferBatch
+func (t *Transfer) UpdateBatch(args *cmodel.TransferBatch, reply *cmodel.TransferResponse) error {
+	metrics, err := cmodel.DecodeTransferBatch(args)
+	if err != nil {
+		return fmt.Errorf("decode batch fail: %v", err)
+	}
+	return RecvMetricValues(metrics, reply, "rpc")
+}
+
 // process new metric values
 func RecvMetricValues(args []*cmodel.MetricValue, reply *cmodel.TransferResponse, from string) error {
 	start := time.Now()
@@ -15,7 +15,11 @@
 package sender
 
 import (
+	"crypto/tls"
+	"log"
+
 	backend "github.com/open-falcon/falcon-plus/common/backend_pool"
+	"github.com/open-falcon/falcon-plus/common/utils"
 	"github.com/open-falcon/falcon-plus/modules/transfer/g"
 	nset "github.com/toolkits/container/set"
 )
@@ -28,8 +32,8 @@ func initConnPools() {
 	for _, instance := range cfg.Judge.Cluster {
 		judgeInstances.Add(instance)
 	}
-	JudgeConnPools = backend.CreateSafeRpcConnPools(cfg.Judge.MaxConns, cfg.Judge.MaxIdle,
-		cfg.Judge.ConnTimeout, cfg.Judge.CallTimeout, judgeInstances.ToSlice())
+	JudgeConnPools = backend.CreateSafeRpcConnPoolsWithTls(cfg.Judge.MaxConns, cfg.Judge.MaxIdle,
+		cfg.Judge.ConnTimeout, cfg.Judge.CallTimeout, judgeInstances.ToSlice(), clientTlsConfig("judge", cfg.Judge.Tls))
 
 	// tsdb
 	if cfg.Tsdb.Enabled {
@@ -43,8 +47,8 @@ func initConnPools() {
 			graphInstances.Add(addr)
 		}
 	}
-	GraphConnPools = backend.CreateSafeRpcConnPools(cfg.Graph.MaxConns, cfg.Graph.MaxIdle,
-		cfg.Graph.ConnTimeout, cfg.Graph.CallTimeout, graphInstances.ToSlice())
+	GraphConnPools = backend.CreateSafeRpcConnPoolsWithTls(cfg.Graph.MaxConns, cfg.Graph.MaxIdle,
+		cfg.Graph.ConnTimeout, cfg.Graph.CallTimeout, graphInstances.ToSlice(), clientTlsConfig("graph", cfg.Graph.Tls))
 
 	// transfer
 	if cfg.Transfer.Enabled {
@@ -54,9 +58,17 @@ func initConnPools() {
 			TransferMap[hn] = instance
 			transferInstances.Add(instance)
 		}
-		TransferConnPools = backend.CreateSafeJsonrpcConnPools(cfg.Transfer.MaxConns, cfg.Transfer.MaxIdle,
-			cfg.Transfer.ConnTimeout, cfg.Transfer.CallTimeout, transferInstances.ToSlice())
+		TransferConnPools = backend.CreateSafeJsonrpcConnPoolsWithTls(cfg.Transfer.MaxConns, cfg.Transfer.MaxIdle,
+			cfg.Transfer.ConnTimeout, cfg.Transfer.CallTimeout, transferInstances.ToSlice(), clientTlsConfig("transfer", cfg.Transfer.Tls))
+	}
+}
+
+func clientTlsConfig(name string, cfg *utils.TlsConfig) *tls.Config {
+	tlsConfig, err := cfg.ClientConfig()
+	if err != nil {
+		log.Fatalf("load %s tls config fail: %v", name, err)
 	}
+	return tlsConfig
 }
 
 func DestroyConnPools() {
@@ -228,3 +228,53 @@ CREATE TABLE alert_link
   DEFAULT CHARSET =utf8
   COLLATE =utf8_unicode_ci;
 
+
+/**
+ * agent upgrade
+ */
+DROP TABLE IF EXISTS agent_upgrade;
+CREATE TABLE `agent_upgrade` (
+  `id`          INT(10) UNSIGNED NOT NULL AUTO_INCREMENT,
+  `grp_id`      INT(10) UNSIGNED NOT NULL,
+  `version`     VARCHAR(16)      NOT NULL,
+  `percent`     TINYINT UNSIGNED NOT NULL DEFAULT 100 COMMENT 'percentage of hosts in the group to upgrade',
+  `create_user` VARCHAR(64)      NOT NULL DEFAULT '',
+  `create_at`   TIMESTAMP        NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_agent_upgrade_grp_id` (`grp_id`)
+)
+  ENGINE =InnoDB
+  DEFAULT CHARSET =utf8
+  COLLATE =utf8_unicode_ci;
+
+DROP TABLE IF EXISTS agent_upgrade_host;
+CREATE TABLE `agent_upgrade_host` (
+  `hostname`     VARCHAR(255) NOT NULL,
+  `from_version` VARCHAR(16)  NOT NULL DEFAULT '',
+  `to_version`   VARCHAR(16)  NOT NULL DEFAULT '',
+  `status`       VARCHAR(16)  NOT NULL DEFAULT '' COMMENT 'success, rollback or failed',
+  `message`      VARCHAR(255) NOT NULL DEFAULT '',
+  `update_at`    TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`hostname`)
+)
+  ENGINE =InnoDB
+  DEFAULT CHARSET =utf8
+  COLLATE =utf8_unicode_ci;
+
+/**
+ * agent发现的监听服务, hbs在服务变化时整体替换一台机器的记录
+ */
+DROP TABLE IF EXISTS host_service;
+CREATE TABLE `host_service` (
+  `id`        INT(10) UNSIGNED  NOT NULL AUTO_INCREMENT,
+  `hostname`  VARCHAR(255)      NOT NULL,
+  `proto`     VARCHAR(8)        NOT NULL DEFAULT 'tcp',
+  `port`      SMALLINT UNSIGNED NOT NULL,
+  `name`      VARCHAR(64)       NOT NULL DEFAULT '' COMMENT 'process name, empty if unknown',
+  `update_at` TIMESTAMP         NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_host_service` (`hostname`, `proto`, `port`, `name`)
+)
+  ENGINE =InnoDB
+  DEFAULT CHARSET =utf8
+  COLLATE =utf8_unicode_ci;
@@ -41,3 +41,19 @@ CREATE TABLE `graph`.`tag_endpoint` (
   UNIQUE KEY `idx_tag_endpoint_id` (`tag`, `endpoint_id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
+DROP TABLE if exists `graph`.`counter_tag`;
+CREATE TABLE `graph`.`counter_tag` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `counter_id` int(10) unsigned NOT NULL COMMENT 'endpoint_counter.id',
+  `endpoint_id` int(10) unsigned NOT NULL,
+  `metric` varchar(255) NOT NULL DEFAULT '',
+  `tag_key` varchar(128) NOT NULL DEFAULT '' COMMENT 'empty for counters without tags',
+  `tag_value` varchar(255) NOT NULL DEFAULT '',
+  `ts` int(11) DEFAULT NULL,
+  `t_create` DATETIME NOT NULL COMMENT 'create time',
+  `t_modify` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'last modify time',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_counter_id_tag_key` (`counter_id`, `tag_key`),
+  KEY `idx_tag_key_tag_value` (`tag_key`, `tag_value`),
+  KEY `idx_metric` (`metric`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;